@@ -0,0 +1,430 @@
+// Package abi decodes the input data of contract calls with the json ABIs of
+// the contracts
+package abi
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/passwizards/eth-parser/types"
+	"golang.org/x/crypto/sha3"
+)
+
+type kind int
+
+const (
+	kindUint kind = iota
+	kindInt
+	kindAddress
+	kindBool
+	kindFixedBytes
+	kindBytes
+	kindString
+	kindSlice
+	kindArray
+	kindTuple
+)
+
+// An ABI type, e.g. uint256, bytes32, address[] or a tuple
+type Type struct {
+	kind kind
+	// bits of ints, bytes of fixed bytes, length of arrays
+	size       int
+	elem       *Type
+	components []*Argument
+}
+
+// A named input of a function or an event, or a tuple component
+type Argument struct {
+	Name string
+	Type *Type
+	// an event input stored in a topic rather than the data
+	Indexed bool
+}
+
+// A function of a contract with its 4 byte selector
+type Method struct {
+	Name     string
+	Inputs   []*Argument
+	Selector string
+}
+
+// The json form of a function or tuple input
+type jsonArgument struct {
+	Name       string          `json:"name"`
+	Type       string          `json:"type"`
+	Components []*jsonArgument `json:"components"`
+	Indexed    bool            `json:"indexed"`
+}
+
+type jsonEntry struct {
+	Type      string          `json:"type"`
+	Name      string          `json:"name"`
+	Inputs    []*jsonArgument `json:"inputs"`
+	Anonymous bool            `json:"anonymous"`
+}
+
+// the functions of a json ABI, either the array of entries or a build
+// artifact with it under "abi"
+func ParseMethods(data []byte) (map[string]*Method, error) {
+	methods, _, err := parseAbi(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("abi has no functions")
+	}
+	return methods, nil
+}
+
+// the functions by selector and the events by topic of a json ABI, anonymous
+// events have no topic to be found by and are left out
+func parseAbi(data []byte) (map[string]*Method, map[string]*Event, error) {
+	var entries []*jsonEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		var artifact struct {
+			Abi []*jsonEntry `json:"abi"`
+		}
+		if err := json.Unmarshal(data, &artifact); err != nil {
+			return nil, nil, fmt.Errorf("invalid abi, err %v", err)
+		}
+		if artifact.Abi == nil {
+			return nil, nil, errors.New("invalid abi, expected an array of entries or an object with one under abi")
+		}
+		entries = artifact.Abi
+	}
+	methods := make(map[string]*Method)
+	events := make(map[string]*Event)
+	for _, entry := range entries {
+		if entry.Type != "function" && (entry.Type != "event" || entry.Anonymous) {
+			continue
+		}
+		var inputs []*Argument
+		for _, input := range entry.Inputs {
+			arg, err := parseArgument(input)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid input of %s, err %v", entry.Name, err)
+			}
+			arg.Indexed = input.Indexed && entry.Type == "event"
+			inputs = append(inputs, arg)
+		}
+		if entry.Type == "event" {
+			event := &Event{Name: entry.Name, Inputs: inputs}
+			event.Topic = EventTopic(event.Signature())
+			events[event.Topic] = event
+			continue
+		}
+		method := &Method{Name: entry.Name, Inputs: inputs}
+		method.Selector = selector(method.Signature())
+		methods[method.Selector] = method
+	}
+	return methods, events, nil
+}
+
+func parseArgument(arg *jsonArgument) (*Argument, error) {
+	t, err := parseType(arg.Type, arg.Components)
+	if err != nil {
+		return nil, err
+	}
+	return &Argument{Name: arg.Name, Type: t}, nil
+}
+
+// parse the type, the last array suffix is the outermost one so uint256[2][]
+// is a slice of uint256[2]
+func parseType(name string, components []*jsonArgument) (*Type, error) {
+	if strings.HasSuffix(name, "]") {
+		open := strings.LastIndex(name, "[")
+		if open < 0 {
+			return nil, fmt.Errorf("unknown type %q", name)
+		}
+		elem, err := parseType(name[:open], components)
+		if err != nil {
+			return nil, err
+		}
+		length := name[open+1 : len(name)-1]
+		if length == "" {
+			return &Type{kind: kindSlice, elem: elem}, nil
+		}
+		n, err := strconv.Atoi(length)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid array length in %q", name)
+		}
+		return &Type{kind: kindArray, size: n, elem: elem}, nil
+	}
+	switch {
+	case name == "address":
+		return &Type{kind: kindAddress}, nil
+	case name == "bool":
+		return &Type{kind: kindBool}, nil
+	case name == "string":
+		return &Type{kind: kindString}, nil
+	case name == "bytes":
+		return &Type{kind: kindBytes}, nil
+	case name == "function":
+		// an address and a selector
+		return &Type{kind: kindFixedBytes, size: 24}, nil
+	case name == "tuple":
+		t := &Type{kind: kindTuple}
+		for _, component := range components {
+			arg, err := parseArgument(component)
+			if err != nil {
+				return nil, err
+			}
+			t.components = append(t.components, arg)
+		}
+		return t, nil
+	case strings.HasPrefix(name, "bytes"):
+		n, err := strconv.Atoi(name[len("bytes"):])
+		if err != nil || n < 1 || n > 32 {
+			return nil, fmt.Errorf("unknown type %q", name)
+		}
+		return &Type{kind: kindFixedBytes, size: n}, nil
+	case strings.HasPrefix(name, "uint"), strings.HasPrefix(name, "int"):
+		t := &Type{kind: kindInt, size: 256}
+		bits := strings.TrimPrefix(name, "int")
+		if strings.HasPrefix(name, "uint") {
+			t.kind, bits = kindUint, strings.TrimPrefix(name, "uint")
+		}
+		if bits != "" {
+			n, err := strconv.Atoi(bits)
+			if err != nil || n < 8 || n > 256 || n%8 != 0 {
+				return nil, fmt.Errorf("unknown type %q", name)
+			}
+			t.size = n
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("unknown type %q", name)
+}
+
+// the canonical name the selector is hashed from, tuples spelled out
+func (t *Type) String() string {
+	switch t.kind {
+	case kindUint:
+		return "uint" + strconv.Itoa(t.size)
+	case kindInt:
+		return "int" + strconv.Itoa(t.size)
+	case kindAddress:
+		return "address"
+	case kindBool:
+		return "bool"
+	case kindFixedBytes:
+		if t.size == 24 {
+			return "function"
+		}
+		return "bytes" + strconv.Itoa(t.size)
+	case kindBytes:
+		return "bytes"
+	case kindString:
+		return "string"
+	case kindSlice:
+		return t.elem.String() + "[]"
+	case kindArray:
+		return t.elem.String() + "[" + strconv.Itoa(t.size) + "]"
+	default:
+		names := make([]string, len(t.components))
+		for i, c := range t.components {
+			names[i] = c.Type.String()
+		}
+		return "(" + strings.Join(names, ",") + ")"
+	}
+}
+
+// whether the value is encoded behind an offset
+func (t *Type) dynamic() bool {
+	switch t.kind {
+	case kindBytes, kindString, kindSlice:
+		return true
+	case kindArray:
+		return t.elem.dynamic()
+	case kindTuple:
+		for _, c := range t.components {
+			if c.Type.dynamic() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bytes of a static value in the head of its tuple
+func (t *Type) headSize() int {
+	switch {
+	case t.dynamic():
+		return 32
+	case t.kind == kindArray:
+		return t.size * t.elem.headSize()
+	case t.kind == kindTuple:
+		size := 0
+		for _, c := range t.components {
+			size += c.Type.headSize()
+		}
+		return size
+	}
+	return 32
+}
+
+// e.g. transfer(address,uint256)
+func (m *Method) Signature() string {
+	names := make([]string, len(m.Inputs))
+	for i, input := range m.Inputs {
+		names[i] = input.Type.String()
+	}
+	return m.Name + "(" + strings.Join(names, ",") + ")"
+}
+
+// the 0x prefixed first 4 bytes of the keccak256 of the signature
+func selector(signature string) string {
+	return keccak(signature)[:10]
+}
+
+// the 0x prefixed keccak256 of the text
+func keccak(text string) string {
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write([]byte(text))
+	return "0x" + hex.EncodeToString(hasher.Sum(nil))
+}
+
+// decode the arguments of the call data, selector included
+func (m *Method) Decode(input []byte) (*types.DecodedCall, error) {
+	if len(input) < 4 {
+		return nil, errors.New("input shorter than a selector")
+	}
+	ts := make([]*Type, len(m.Inputs))
+	for i, arg := range m.Inputs {
+		ts[i] = arg.Type
+	}
+	values, err := decodeTuple(input[4:], ts)
+	if err != nil {
+		return nil, err
+	}
+	call := &types.DecodedCall{Method: m.Name, Signature: m.Signature()}
+	for i, arg := range m.Inputs {
+		call.Args = append(call.Args, &types.DecodedArg{Name: arg.Name, Type: arg.Type.String(), Value: values[i]})
+	}
+	return call, nil
+}
+
+// decode the values of the types encoded one after another, dynamic ones at
+// the offset in their head slot
+func decodeTuple(data []byte, ts []*Type) ([]interface{}, error) {
+	values := make([]interface{}, len(ts))
+	pos := 0
+	for i, t := range ts {
+		at := pos
+		if t.dynamic() {
+			offset, err := readLength(data, pos)
+			if err != nil {
+				return nil, err
+			}
+			at = offset
+		}
+		if at > len(data) {
+			return nil, fmt.Errorf("offset %d past the data", at)
+		}
+		value, err := decodeValue(data[at:], t)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+		pos += t.headSize()
+	}
+	return values, nil
+}
+
+func decodeValue(data []byte, t *Type) (interface{}, error) {
+	switch t.kind {
+	case kindSlice, kindArray:
+		n := t.size
+		if t.kind == kindSlice {
+			length, err := readLength(data, 0)
+			if err != nil {
+				return nil, err
+			}
+			// every element takes at least a word, a larger length is corrupt
+			if length > (len(data)-32)/32 {
+				return nil, fmt.Errorf("slice length %d past the data", length)
+			}
+			n, data = length, data[32:]
+		}
+		elems := make([]*Type, n)
+		for i := range elems {
+			elems[i] = t.elem
+		}
+		return decodeTuple(data, elems)
+	case kindTuple:
+		ts := make([]*Type, len(t.components))
+		for i, c := range t.components {
+			ts[i] = c.Type
+		}
+		values, err := decodeTuple(data, ts)
+		if err != nil {
+			return nil, err
+		}
+		fields := make(map[string]interface{}, len(values))
+		for i, c := range t.components {
+			name := c.Name
+			if name == "" {
+				name = strconv.Itoa(i)
+			}
+			fields[name] = values[i]
+		}
+		return fields, nil
+	case kindBytes, kindString:
+		length, err := readLength(data, 0)
+		if err != nil {
+			return nil, err
+		}
+		if length > len(data)-32 {
+			return nil, fmt.Errorf("length %d past the data", length)
+		}
+		if t.kind == kindString {
+			return string(data[32 : 32+length]), nil
+		}
+		return "0x" + hex.EncodeToString(data[32:32+length]), nil
+	}
+	if len(data) < 32 {
+		return nil, errors.New("value past the data")
+	}
+	word := data[:32]
+	switch t.kind {
+	case kindUint:
+		return new(big.Int).SetBytes(word).String(), nil
+	case kindInt:
+		v := new(big.Int).SetBytes(word)
+		if word[0]&0x80 != 0 {
+			v.Sub(v, new(big.Int).Lsh(big.NewInt(1), 256))
+		}
+		return v.String(), nil
+	case kindAddress:
+		return "0x" + hex.EncodeToString(word[12:]), nil
+	case kindBool:
+		return word[31] == 1, nil
+	default:
+		return "0x" + hex.EncodeToString(word[:t.size]), nil
+	}
+}
+
+// a word holding an offset or a length, bounded so it fits an int
+func readLength(data []byte, pos int) (int, error) {
+	if pos+32 > len(data) {
+		return 0, errors.New("value past the data")
+	}
+	word := data[pos : pos+32]
+	for _, b := range word[:24] {
+		if b != 0 {
+			return 0, errors.New("offset or length too large")
+		}
+	}
+	n := binary.BigEndian.Uint64(word[24:])
+	if n > uint64(len(data)) {
+		return 0, fmt.Errorf("offset or length %d past the data", n)
+	}
+	return int(n), nil
+}
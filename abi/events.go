@@ -0,0 +1,79 @@
+package abi
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// An event of a contract with its topic
+type Event struct {
+	Name   string
+	Inputs []*Argument
+	Topic  string
+}
+
+// the topic0 of the logs of an event, the keccak256 of its signature, e.g.
+// Transfer(address,address,uint256), spaces dropped
+func EventTopic(signature string) string {
+	return keccak(strings.ReplaceAll(signature, " ", ""))
+}
+
+// e.g. Transfer(address,address,uint256)
+func (e *Event) Signature() string {
+	names := make([]string, len(e.Inputs))
+	for i, input := range e.Inputs {
+		names[i] = input.Type.String()
+	}
+	return e.Name + "(" + strings.Join(names, ",") + ")"
+}
+
+// decode the arguments of a log of the event, the indexed ones from the
+// topics after the first and the others from the data; an indexed value of a
+// dynamic type, an array or a tuple is only stored as its hash, the topic
+func (e *Event) Decode(topics []string, data []byte) (*types.DecodedEvent, error) {
+	var ts []*Type
+	for _, arg := range e.Inputs {
+		if !arg.Indexed {
+			ts = append(ts, arg.Type)
+		}
+	}
+	values, err := decodeTuple(data, ts)
+	if err != nil {
+		return nil, err
+	}
+	event := &types.DecodedEvent{Name: e.Name, Signature: e.Signature()}
+	topic := 1
+	for _, arg := range e.Inputs {
+		var value interface{}
+		if arg.Indexed {
+			if topic >= len(topics) {
+				return nil, errors.New("fewer topics than indexed inputs")
+			}
+			if value, err = decodeTopic(topics[topic], arg.Type); err != nil {
+				return nil, err
+			}
+			topic++
+		} else {
+			value, values = values[0], values[1:]
+		}
+		event.Args = append(event.Args, &types.DecodedArg{Name: arg.Name, Type: arg.Type.String(), Value: value})
+	}
+	return event, nil
+}
+
+// the value of an indexed input, the topic itself when only its hash is there
+func decodeTopic(topic string, t *Type) (interface{}, error) {
+	word, err := hex.DecodeString(strings.TrimPrefix(topic, "0x"))
+	if err != nil || len(word) != 32 {
+		return nil, fmt.Errorf("invalid topic %q", topic)
+	}
+	switch t.kind {
+	case kindBytes, kindString, kindSlice, kindArray, kindTuple:
+		return strings.ToLower(topic), nil
+	}
+	return decodeValue(word, t)
+}
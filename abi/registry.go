@@ -0,0 +1,217 @@
+package abi
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// A contract ABI with its functions by selector and its events by topic
+type contract struct {
+	abi     []byte
+	methods map[string]*Method
+	events  map[string]*Event
+}
+
+// The ABIs registered per contract address, persisted as <address>.json in
+// the directory when one is set
+type Registry struct {
+	dir       string
+	contracts map[string]*contract
+	// bumped by every registration
+	revision int
+	sync.RWMutex
+}
+
+// a registry loading the ABIs saved in the directory, an empty dir keeps
+// them in memory only
+func NewRegistry(dir string) (*Registry, error) {
+	r := &Registry{dir: dir, contracts: make(map[string]*contract)}
+	if dir == "" {
+		return r, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".json" {
+			continue
+		}
+		address, err := types.NormalizeAddress(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		c, err := parseContract(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load abi %s, err %v", name, err)
+		}
+		r.contracts[address] = c
+	}
+	return r, nil
+}
+
+// register the ABI of the contract, replacing the previous one
+func (r *Registry) Register(address string, abi []byte) error {
+	address, err := types.NormalizeAddress(address)
+	if err != nil {
+		return err
+	}
+	c, err := parseContract(abi)
+	if err != nil {
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.dir != "" {
+		if err := os.WriteFile(filepath.Join(r.dir, address+".json"), abi, 0644); err != nil {
+			return fmt.Errorf("failed to save abi, err %v", err)
+		}
+	}
+	r.contracts[address] = c
+	r.revision++
+	return nil
+}
+
+// a contract with functions or events to decode
+func parseContract(abi []byte) (*contract, error) {
+	methods, events, err := parseAbi(abi)
+	if err != nil {
+		return nil, err
+	}
+	if len(methods) == 0 && len(events) == 0 {
+		return nil, errors.New("abi has no functions or events")
+	}
+	return &contract{abi: abi, methods: methods, events: events}, nil
+}
+
+// how many ABIs were registered since the registry was created, decoded
+// inputs may differ once it changed
+func (r *Registry) Revision() int {
+	r.RLock()
+	defer r.RUnlock()
+	return r.revision
+}
+
+// the registered ABI json of the contract, nil when there is none
+func (r *Registry) Get(address string) []byte {
+	r.RLock()
+	defer r.RUnlock()
+	if c := r.contracts[strings.ToLower(address)]; c != nil {
+		return c.abi
+	}
+	return nil
+}
+
+// the contract addresses with a registered ABI, sorted
+func (r *Registry) Addresses() []string {
+	r.RLock()
+	defer r.RUnlock()
+	addresses := make([]string, 0, len(r.contracts))
+	for address := range r.contracts {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// the call of the hex input to the contract, nil when its ABI isn't
+// registered, lacks the method or the input doesn't decode
+func (r *Registry) Decode(address, input string) *types.DecodedCall {
+	if len(input) < 10 {
+		return nil
+	}
+	r.RLock()
+	c := r.contracts[strings.ToLower(address)]
+	r.RUnlock()
+	if c == nil {
+		return nil
+	}
+	method := c.methods[strings.ToLower(input[:10])]
+	if method == nil {
+		return nil
+	}
+	data, err := hex.DecodeString(strings.TrimPrefix(input, "0x"))
+	if err != nil {
+		return nil
+	}
+	call, err := method.Decode(data)
+	if err != nil {
+		return nil
+	}
+	return call
+}
+
+// the event of the log with the ABI of its contract, nil when the ABI isn't
+// registered, lacks the event or the log doesn't decode
+func (r *Registry) DecodeLog(log *types.Log) *types.DecodedEvent {
+	if len(log.Topics) == 0 {
+		return nil
+	}
+	r.RLock()
+	c := r.contracts[strings.ToLower(log.Address)]
+	r.RUnlock()
+	if c == nil {
+		return nil
+	}
+	event := c.events[strings.ToLower(log.Topics[0])]
+	if event == nil {
+		return nil
+	}
+	data, err := hex.DecodeString(strings.TrimPrefix(log.Data, "0x"))
+	if err != nil {
+		return nil
+	}
+	decoded, err := event.Decode(log.Topics, data)
+	if err != nil {
+		return nil
+	}
+	return decoded
+}
+
+// the signatures of the events in the ABI of the contract, sorted
+func (r *Registry) Events(address string) []string {
+	r.RLock()
+	defer r.RUnlock()
+	c := r.contracts[strings.ToLower(address)]
+	if c == nil {
+		return nil
+	}
+	signatures := make([]string, 0, len(c.events))
+	for _, event := range c.events {
+		signatures = append(signatures, event.Signature())
+	}
+	sort.Strings(signatures)
+	return signatures
+}
+
+// the signatures of the functions in the ABI of the contract, sorted
+func (r *Registry) Methods(address string) []string {
+	r.RLock()
+	defer r.RUnlock()
+	c := r.contracts[strings.ToLower(address)]
+	if c == nil {
+		return nil
+	}
+	signatures := make([]string, 0, len(c.methods))
+	for _, method := range c.methods {
+		signatures = append(signatures, method.Signature())
+	}
+	sort.Strings(signatures)
+	return signatures
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/passwizards/eth-parser/abi"
+	"github.com/passwizards/eth-parser/types"
+)
+
+// largest ABI accepted, build artifacts with bytecode included fit
+const maxAbiSize = 8 << 20
+
+// serve POST and GET /abis/{address} and GET /abis with the registry the
+// parsers decode inputs with, before the server is used
+func (s *HttpServer) SetAbis(registry *abi.Registry) {
+	s.mux.HandleFunc("GET /abis", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		resp := &AbisResponse{Contracts: []*AbiResponse{}}
+		for _, address := range registry.Addresses() {
+			resp.Contracts = append(resp.Contracts, &AbiResponse{Address: types.ChecksumAddress(address), Methods: registry.Methods(address), Events: registry.Events(address)})
+		}
+		writeAsJson(w, resp)
+	})
+	s.mux.HandleFunc("GET /abis/{address}", func(w http.ResponseWriter, r *http.Request) {
+		address, ok := pathAddress(w, r)
+		if !ok {
+			return
+		}
+		data := registry.Get(address)
+		if data == nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no abi registered for %s", types.ChecksumAddress(address)))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+	s.mux.HandleFunc("POST /abis/{address}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		address, ok := pathAddress(w, r)
+		if !ok {
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAbiSize))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid body, err %v", err))
+			return
+		}
+		if err := registry.Register(address, data); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		slog.Info("Registered abi", "remote", r.RemoteAddr, "address", address)
+		writeAsJson(w, &AbiResponse{Address: types.ChecksumAddress(address), Methods: registry.Methods(address), Events: registry.Events(address)})
+	})
+}
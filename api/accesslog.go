@@ -0,0 +1,124 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The share of the requests written to the access log
+type accessLog struct {
+	// 0 logs none, 1 all of them
+	sample float64
+	sync.RWMutex
+}
+
+// What the handlers tell the access log about a request
+type accessEntry struct {
+	// the route that served it, e.g. /v1/GetTransactions/{address}, empty
+	// for the ones outside the api
+	route  string
+	tenant string
+}
+
+type accessEntryKey struct{}
+
+// write a structured log line per request with its method, path, route,
+// status, latency, reply size, client and tenant, for a share of them from 0,
+// none, to 1, all; server errors are logged whatever the share; also while
+// the server is used, e.g. when the config is reloaded
+func (s *HttpServer) SetAccessLog(sample float64) {
+	s.accessLog.Lock()
+	defer s.accessLog.Unlock()
+	s.accessLog.sample = sample
+}
+
+// the entry of the access log the request fills in, nil when it isn't logged
+func requestAccessEntry(r *http.Request) *accessEntry {
+	entry, _ := r.Context().Value(accessEntryKey{}).(*accessEntry)
+	return entry
+}
+
+// time the requests and log them once replied, the size of the reply as sent,
+// after compression; websockets and streams are logged once they close
+func (s *HttpServer) logAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.accessLog.RLock()
+		sample := s.accessLog.sample
+		s.accessLog.RUnlock()
+		if sample <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		entry := &accessEntry{}
+		aw := &accessWriter{ResponseWriter: w}
+		next.ServeHTTP(aw, r.WithContext(context.WithValue(r.Context(), accessEntryKey{}, entry)))
+		if aw.status == 0 {
+			aw.status = http.StatusOK
+		}
+		if aw.status < http.StatusInternalServerError && rand.Float64() >= sample {
+			return
+		}
+		attrs := []any{"method", r.Method, "path", r.URL.Path, "status", aw.status,
+			"latency", time.Since(start), "bytes", aw.size, "client", clientIp(r)}
+		if entry.route != "" {
+			attrs = append(attrs, "route", entry.route)
+		}
+		if entry.tenant != "" {
+			attrs = append(attrs, "tenant", entry.tenant)
+		}
+		slog.Info("Request", attrs...)
+	})
+}
+
+// A reply recording its status and how many bytes of body it sent
+type accessWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (aw *accessWriter) WriteHeader(status int) {
+	if aw.status == 0 {
+		aw.status = status
+	}
+	aw.ResponseWriter.WriteHeader(status)
+}
+
+func (aw *accessWriter) Write(p []byte) (int, error) {
+	if aw.status == 0 {
+		aw.status = http.StatusOK
+	}
+	n, err := aw.ResponseWriter.Write(p)
+	aw.size += int64(n)
+	return n, err
+}
+
+func (aw *accessWriter) FlushError() error {
+	return http.NewResponseController(aw.ResponseWriter).Flush()
+}
+
+func (aw *accessWriter) Flush() {
+	aw.FlushError()
+}
+
+// the websocket upgrade takes the connection over, logged as switching
+// protocols
+func (aw *accessWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(aw.ResponseWriter).Hijack()
+	if err == nil {
+		aw.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// lets a ResponseController reach the connection, e.g. for write deadlines
+func (aw *accessWriter) Unwrap() http.ResponseWriter {
+	return aw.ResponseWriter
+}
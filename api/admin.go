@@ -0,0 +1,307 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strconv"
+
+	"github.com/passwizards/eth-parser/parser"
+	"github.com/passwizards/eth-parser/storage"
+	"github.com/passwizards/eth-parser/types"
+)
+
+// A storage exported and imported whole, e.g. the mem storage
+type Snapshots interface {
+	Export(w io.Writer) error
+	Import(r io.Reader) error
+}
+
+// serve GET /admin/export and POST /admin/import with the snapshots of the
+// main chain, before the server is used
+func (s *HttpServer) SetSnapshots(snapshots Snapshots) {
+	s.mux.HandleFunc("GET /admin/export", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="eth-parser-snapshot.json"`)
+		if err := snapshots.Export(w); err != nil {
+			// the status is out already, the client sees a truncated body
+			slog.Error("Failed to export snapshot", "remote", r.RemoteAddr, "err", err)
+		}
+	})
+	s.mux.HandleFunc("POST /admin/import", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := snapshots.Import(r.Body); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		current, err := s.parser.GetCurrentBlock(r.Context())
+		if err != nil {
+			writeStorageError(w, r, err)
+			return
+		}
+		slog.Info("Imported snapshot", "remote", r.RemoteAddr, "block", current)
+		writeAsJson(w, &CurrentBlockResponse{CurrentBlock: current})
+	})
+}
+
+// serve POST /admin/reload, re-reading the config and applying what can be
+// changed while running, replies 400 with the error when it can't be
+// applied, before the server is used
+func (s *HttpServer) SetReload(reload func() error) {
+	s.mux.HandleFunc("POST /admin/reload", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := reload(); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		slog.Info("Reloaded config", "remote", r.RemoteAddr)
+		writeAsJson(w, &ReloadResponse{Success: true})
+	})
+}
+
+// move the parser cursor, POST /admin/setCurrentBlock and under each chain
+func (s *HttpServer) HandleSetCurrentBlock(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req SetCurrentBlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid body, err %v", err))
+		return
+	}
+	if req.Block == nil {
+		writeError(w, http.StatusBadRequest, errors.New("missing block"))
+		return
+	}
+	err := s.parser.SetCurrentBlock(r.Context(), *req.Block, req.Clear)
+	if errors.Is(err, parser.ErrReadOnly) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if errors.As(err, new(*parser.StorageError)) {
+		writeStorageError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	slog.Info("Set current block", "remote", r.RemoteAddr, "block", *req.Block, "clear", req.Clear)
+	current, err := s.parser.GetCurrentBlock(r.Context())
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	writeAsJson(w, &CurrentBlockResponse{CurrentBlock: current})
+}
+
+// stop parsing new blocks for a maintenance, the apis keep serving what is
+// stored, POST /admin/pause and under each chain
+func (s *HttpServer) HandlePause(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	paused := s.parser.PauseParsing()
+	if paused {
+		slog.Info("Paused parser", "remote", r.RemoteAddr)
+	}
+	writeAsJson(w, &PauseResponse{Paused: true, Success: paused})
+}
+
+// parse again after /admin/pause, POST /admin/resume and under each chain
+func (s *HttpServer) HandleResume(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	resumed := s.parser.ResumeParsing()
+	if resumed {
+		slog.Info("Resumed parser", "remote", r.RemoteAddr)
+	}
+	writeAsJson(w, &PauseResponse{Paused: false, Success: resumed})
+}
+
+// the blocks the parser gave up on, GET /admin/failedBlocks and under each
+// chain
+func (s *HttpServer) HandleFailedBlocks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	writeAsJson(w, &FailedBlocksResponse{Blocks: s.parser.GetFailedBlocks()})
+}
+
+// parse a failed block again, moving the parser back before it, POST
+// /admin/retryBlock and under each chain
+func (s *HttpServer) HandleRetryBlock(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req RetryBlockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid body, err %v", err))
+		return
+	}
+	if req.Block == nil {
+		writeError(w, http.StatusBadRequest, errors.New("missing block"))
+		return
+	}
+	retried, err := s.parser.RetryFailedBlock(r.Context(), *req.Block)
+	if errors.Is(err, parser.ErrReadOnly) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if errors.As(err, new(*parser.StorageError)) {
+		writeStorageError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if retried {
+		slog.Info("Retrying failed block", "remote", r.RemoteAddr, "block", *req.Block)
+	}
+	writeAsJson(w, &RetryBlockResponse{Block: *req.Block, Success: retried})
+}
+
+// queue fetching and saving a range of parsed blocks again, POST
+// /admin/reprocess and under each chain, replies 202 with the job to follow
+// on /admin/jobs/{id}
+func (s *HttpServer) HandleReprocess(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req ReprocessRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid body, err %v", err))
+		return
+	}
+	if req.From == nil || req.To == nil {
+		writeError(w, http.StatusBadRequest, errors.New("missing from or to"))
+		return
+	}
+	job, err := s.parser.Reprocess(r.Context(), *req.From, *req.To)
+	if errors.Is(err, parser.ErrReadOnly) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if errors.As(err, new(*parser.StorageError)) {
+		writeStorageError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	slog.Info("Reprocessing blocks", "remote", r.RemoteAddr, "job", job.Id, "from", job.From, "to", job.To)
+	body, ok := marshalResponse(w, &JobResponse{Job: job})
+	if !ok {
+		return
+	}
+	// relative, the jobs of a chain are under its prefix too
+	w.Header().Set("Location", "jobs/"+strconv.Itoa(job.Id))
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(body)
+}
+
+// the status of a reprocess job, GET /admin/jobs/{id} and under each chain
+func (s *HttpServer) HandleJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid job id %q", r.PathValue("id")))
+		return
+	}
+	job := s.parser.GetReprocessJob(id)
+	if job == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %d not found", id))
+		return
+	}
+	writeAsJson(w, &JobResponse{Job: job})
+}
+
+// the subscriptions of every tenant as a json or csv file by ?format, GET
+// /admin/subscriptions/export and under each chain; only for the keys of the
+// default tenant, like every admin endpoint
+func (s *HttpServer) HandleExportSubscriptions(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = storage.SubscriptionsJson
+	}
+	if format != storage.SubscriptionsJson && format != storage.SubscriptionsCsv {
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid format %q, expected json or csv", format))
+		return
+	}
+	records, err := s.parser.ExportSubscriptions(r.Context())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		writeStorageError(w, r, err)
+		return
+	}
+	if format == storage.SubscriptionsCsv {
+		w.Header().Set("Content-Type", "text/csv")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="eth-parser-subscriptions.`+format+`"`)
+	if err := storage.WriteSubscriptions(w, format, records); err != nil {
+		slog.Error("Failed to export subscriptions", "remote", r.RemoteAddr, "err", err)
+	}
+}
+
+// subscribe to the addresses of a json or csv file, csv by ?format=csv or a
+// text/csv body, keeping the subscriptions already there, into the tenants
+// the records name; POST /admin/subscriptions/import and under each chain,
+// only for the keys of the default tenant
+func (s *HttpServer) HandleImportSubscriptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = storage.SubscriptionsFormat(r.Header.Get("Content-Type"))
+	}
+	if format != storage.SubscriptionsJson && format != storage.SubscriptionsCsv {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid format %q, expected json or csv", format))
+		return
+	}
+	records, err := storage.ReadSubscriptions(r.Body, format)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	added, err := s.parser.ImportSubscriptions(r.Context(), records)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	slog.Info("Imported subscriptions", "remote", r.RemoteAddr, "subscriptions", len(records), "added", added)
+	writeAsJson(w, &ImportSubscriptionsResponse{Subscriptions: len(records), Added: added})
+}
+
+// the whale threshold of the parser in ether, GET /admin/whaleThreshold and
+// under each chain
+func (s *HttpServer) HandleGetWhaleThreshold(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	writeAsJson(w, &WhaleThresholdResponse{Threshold: types.FormatUnits(s.parser.GetWhaleThreshold(), types.EtherDecimals)})
+}
+
+// replace the whale threshold of the parser until the config is reloaded, an
+// empty one stops the alerts, POST /admin/whaleThreshold and under each chain
+func (s *HttpServer) HandleSetWhaleThreshold(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req WhaleThresholdRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid body, err %v", err))
+		return
+	}
+	if req.Threshold == nil {
+		writeError(w, http.StatusBadRequest, errors.New("missing threshold"))
+		return
+	}
+	var threshold *big.Int
+	if *req.Threshold != "" {
+		var err error
+		threshold, err = types.ParseUnits(*req.Threshold, types.EtherDecimals)
+		if err == nil && threshold.Sign() < 0 {
+			err = fmt.Errorf("invalid threshold %q, expected ether", *req.Threshold)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	s.parser.SetWhaleThreshold(threshold)
+	slog.Info("Set whale threshold", "remote", r.RemoteAddr, "threshold", *req.Threshold)
+	writeAsJson(w, &WhaleThresholdResponse{Threshold: types.FormatUnits(threshold, types.EtherDecimals)})
+}
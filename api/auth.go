@@ -0,0 +1,201 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the header clients pass their api key in
+const ApiKeyHeader = "X-API-Key"
+
+// The api keys allowed to call the server, each with its own request budget
+// and the tenant whose subscriptions it sees
+type apiKeys struct {
+	keys map[string]*apiKey
+	// requests per second of every key, 0 is unlimited
+	rate float64
+	sync.RWMutex
+}
+
+type apiKey struct {
+	tenant  string
+	limiter *keyLimiter
+}
+
+// A token bucket refilled at rate requests per second up to burst of them, a
+// second worth for api keys, nil when unlimited
+type keyLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	sync.Mutex
+}
+
+// paths served without an api key, so probes and client generators work
+// unauthenticated, on every chain
+var publicPaths = map[string]bool{
+	"/healthz":      true,
+	"/readyz":       true,
+	"/openapi.json": true,
+}
+
+// require one of the keys in the X-API-Key header and limit every key to rate
+// requests per second, 0 is unlimited, no keys leave the server open; the
+// keys are of the default tenant, before the server is used
+func (s *HttpServer) SetApiKeys(keys []string, rate float64) {
+	s.apiKeys.keys = make(map[string]*apiKey)
+	s.apiKeys.rate = rate
+	s.SetTenantApiKeys("", keys)
+}
+
+// let the keys in too, each seeing only the subscriptions and notifications
+// of the tenant, limited like the ones of SetApiKeys; after SetApiKeys and
+// before the server is used
+func (s *HttpServer) SetTenantApiKeys(tenant string, keys []string) {
+	if s.apiKeys.keys == nil {
+		s.apiKeys.keys = make(map[string]*apiKey)
+	}
+	for _, key := range keys {
+		s.apiKeys.keys[key] = &apiKey{tenant: tenant, limiter: newKeyLimiter(s.apiKeys.rate)}
+	}
+}
+
+// The context key of the tenant of a request
+type tenantKey struct{}
+
+// the tenant of the api key of the request, the default one "" when the
+// server is open
+func requestTenant(r *http.Request) string {
+	tenant, _ := r.Context().Value(tenantKey{}).(string)
+	return tenant
+}
+
+func newKeyLimiter(rate float64) *keyLimiter {
+	return newBurstLimiter(rate, math.Ceil(rate))
+}
+
+// a token bucket of rate requests per second, burst of them at once
+func newBurstLimiter(rate, burst float64) *keyLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &keyLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// take a token, otherwise how long until the next one
+func (l *keyLimiter) allow() (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.Lock()
+	defer l.Unlock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// limit every key to rate requests per second from now on, 0 is unlimited,
+// e.g. when the config is reloaded, while the server is used
+func (s *HttpServer) SetApiKeyRate(rate float64) {
+	s.apiKeys.Lock()
+	defer s.apiKeys.Unlock()
+	s.apiKeys.rate = rate
+	for _, key := range s.apiKeys.keys {
+		key.limiter = newKeyLimiter(rate)
+	}
+}
+
+// the tenant and limiter of the key, not found when unknown, constant time so keys
+// can't be guessed by timing
+func (k *apiKeys) lookup(key string) (tenant string, limiter *keyLimiter, found bool) {
+	k.RLock()
+	defer k.RUnlock()
+	for known, entry := range k.keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(known)) == 1 {
+			tenant, limiter, found = entry.tenant, entry.limiter, true
+		}
+	}
+	return
+}
+
+// reject requests without a configured api key with 401, the ones of tenant
+// keys to the admin and other global endpoints with 403 and the ones over the budget of their
+// key with 429
+func (s *HttpServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(s.apiKeys.keys) == 0 || s.isPublic(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tenant, limiter, ok := s.apiKeys.lookup(r.Header.Get(ApiKeyHeader))
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			writeError(w, http.StatusUnauthorized, errors.New("missing or unknown api key"))
+			return
+		}
+		if tenant != "" && s.isAdmin(r) {
+			w.Header().Set("Content-Type", "application/json")
+			writeError(w, http.StatusForbidden, errors.New("the endpoint acts on every tenant and needs an api key of the default tenant"))
+			return
+		}
+		if entry := requestAccessEntry(r); entry != nil {
+			entry.tenant = tenant
+		}
+		if ok, wait := limiter.allow(); !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, errors.New("rate limit of the api key exceeded"))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant)))
+	})
+}
+
+// whether the path is public, directly or under a chain
+func (s *HttpServer) isPublic(path string) bool {
+	if publicPaths[path] {
+		return true
+	}
+	chain, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return s.chains[chain] != nil && publicPaths["/"+rest]
+}
+
+// the paths of the endpoints acting on every tenant besides the admin ones,
+// after the chain and the version, e.g. the event subscriptions and their
+// stored events are shared
+var globalPrefixes = []string{"/admin/", "/SubscribeEvent/", "/UnsubscribeEvent/", "/debug/"}
+
+// whether the request is to an admin endpoint or another one acting on every
+// tenant, directly or under a chain
+func (s *HttpServer) isAdmin(r *http.Request) bool {
+	path := r.URL.Path
+	if chain, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/"); s.chains[chain] != nil {
+		path = "/" + rest
+	}
+	if rest, ok := strings.CutPrefix(path, "/v1/"); ok {
+		path = "/" + rest
+	}
+	// the abis registered are decoded for every tenant, reading them isn't
+	if r.Method == http.MethodPost && strings.HasPrefix(path, "/abis/") {
+		return true
+	}
+	for _, prefix := range globalPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
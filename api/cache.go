@@ -0,0 +1,88 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// The GetTransactions replies of hot addresses, kept with the revision of
+// the address they were built at and rebuilt once it changed
+type responseCache struct {
+	size    int
+	entries map[string]*cachedResponse
+	sync.Mutex
+}
+
+type cachedResponse struct {
+	revision string
+	body     []byte
+}
+
+// a cache of up to size replies, nil when size is 0
+func newResponseCache(size int) *responseCache {
+	if size <= 0 {
+		return nil
+	}
+	return &responseCache{size: size, entries: make(map[string]*cachedResponse)}
+}
+
+// the reply cached under the key at the revision, nil when there is none
+func (c *responseCache) get(key, revision string) []byte {
+	if c == nil {
+		return nil
+	}
+	c.Lock()
+	defer c.Unlock()
+	entry := c.entries[key]
+	if entry == nil {
+		return nil
+	}
+	if entry.revision != revision {
+		delete(c.entries, key)
+		return nil
+	}
+	return entry.body
+}
+
+// cache the reply, a random one is evicted when full
+func (c *responseCache) put(key, revision string, body []byte) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.size {
+		for evicted := range c.entries {
+			delete(c.entries, evicted)
+			break
+		}
+	}
+	c.entries[key] = &cachedResponse{revision: revision, body: body}
+}
+
+// keep up to size GetTransactions replies in memory per chain, rebuilt once
+// the transactions or the label of their address change, 0 disables, before
+// the server is used
+func (s *HttpServer) SetResponseCache(size int) {
+	s.responses = newResponseCache(size)
+	for _, chain := range s.chains {
+		chain.responses = newResponseCache(size)
+	}
+}
+
+// reply 304 when the If-None-Match header of the request lists the etag,
+// set on the reply either way
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	// clients keep the reply but check it is current before reusing it
+	w.Header().Set("Cache-Control", "no-cache")
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// replies shorter than this are sent as they are, compressing them saves
+// less than it costs
+const compressMinSize = 1024
+
+// the content types worth compressing, json and protocol buffers replies and
+// exports; server-sent event streams are flushed an event at a time and sent
+// as they are, protocol buffers ones are compressed a flush at a time
+var compressibleTypes = []string{"application/json", "application/x-ndjson", "text/csv", "text/plain", protobufType}
+
+// A streaming compressor, reset for every reply it is reused for
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// the compressors by encoding, zstd keeps a 1MB window so a reply holds a
+// bounded amount of memory however long it is
+var encoders = map[string]*sync.Pool{
+	"zstd": {New: func() interface{} {
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(1<<20))
+		return enc
+	}},
+	"gzip": {New: func() interface{} { return gzip.NewWriter(nil) }},
+}
+
+// the encoding of the Accept-Encoding header replies are compressed with,
+// zstd over gzip when both are accepted as much, empty when neither is
+func acceptedEncoding(header string) string {
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+	best, bestQ := "", 0.0
+	for _, encoding := range []string{"zstd", "gzip"} {
+		q, ok := accepted[encoding]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compress the replies of compressible types with the encoding the client
+// accepts, streamed through the encoder so a long history never sits in
+// memory; websocket upgrades pass through as they hijack the connection
+func compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// A reply compressed once it turns out long enough and of a compressible
+// type, its start is held back until then
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	buf      []byte
+	// whether the headers are out, through the encoder when there is one
+	started bool
+	enc     encoder
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.started {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.started {
+		compressible := cw.compressible()
+		if compressible && len(cw.buf)+len(p) < compressMinSize {
+			cw.buf = append(cw.buf, p...)
+			return len(p), nil
+		}
+		if err := cw.start(compressible); err != nil {
+			return 0, err
+		}
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// whether the reply has a body of a type worth compressing, not encoded yet
+func (cw *compressWriter) compressible() bool {
+	h := cw.Header()
+	if cw.status == http.StatusNoContent || cw.status == http.StatusNotModified || h.Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return slices.Contains(compressibleTypes, mediaType)
+}
+
+// send the headers and the held back start of the reply, through an encoder
+// when compressing
+func (cw *compressWriter) start(compress bool) error {
+	cw.started = true
+	if compress {
+		h := cw.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		// the encoded bytes differ from the ones the etag was computed over
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.enc = encoders[cw.encoding].Get().(encoder)
+		cw.enc.Reset(cw.ResponseWriter)
+	}
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// push what was written to the client, compressing the reply from here on
+// when it is compressible however short, streams flush as they go
+func (cw *compressWriter) FlushError() error {
+	if !cw.started {
+		if err := cw.start(cw.compressible()); err != nil {
+			return err
+		}
+	}
+	if cw.enc != nil {
+		if err := cw.enc.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressWriter) Flush() {
+	cw.FlushError()
+}
+
+// lets a ResponseController reach the connection, e.g. for write deadlines
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// end the reply, sent as it is when it stayed short, and return the encoder
+// to its pool
+func (cw *compressWriter) close() {
+	if !cw.started {
+		cw.start(false)
+	}
+	if cw.enc != nil {
+		cw.enc.Close()
+		cw.enc.Reset(nil)
+		encoders[cw.encoding].Put(cw.enc)
+		cw.enc = nil
+	}
+}
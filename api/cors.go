@@ -0,0 +1,93 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// The origins browsers may call the server from, with the methods they may
+// use, shared with the servers of the other chains
+type corsPolicy struct {
+	// allowed origins, "*" allows any
+	origins []string
+	methods string
+}
+
+// methods allowed cross-origin when none are configured
+var defaultCorsMethods = []string{http.MethodGet, http.MethodPost}
+
+// request headers allowed cross-origin on top of the simple ones, and the
+// reply headers scripts may read
+const (
+	corsAllowHeaders  = "Content-Type, " + ApiKeyHeader + ", If-None-Match, Last-Event-ID"
+	corsExposeHeaders = "ETag, Retry-After"
+	// seconds browsers may reuse a preflight for
+	corsMaxAge = "600"
+)
+
+// let browsers on the origins call the server, e.g. https://dashboard.example.com
+// or "*" for any, with the methods, GET and POST when none; no origins keep
+// cross-origin calls blocked, before the server is used
+func (s *HttpServer) SetCors(origins, methods []string) {
+	if len(methods) == 0 {
+		methods = defaultCorsMethods
+	}
+	s.cors.origins = origins
+	s.cors.methods = strings.Join(methods, ", ")
+}
+
+func (p *corsPolicy) allows(origin string) bool {
+	return slices.Contains(p.origins, "*") || slices.Contains(p.origins, origin)
+}
+
+// add the security headers to every reply and the cors ones to the replies
+// to allowed origins, preflights are answered here so they never reach a
+// handler
+func (s *HttpServer) secure(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "no-referrer")
+		// json only, nothing to load or frame
+		h.Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+		if r.TLS != nil {
+			h.Set("Strict-Transport-Security", "max-age=31536000")
+		}
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h.Add("Vary", "Origin")
+		allowed := s.cors.allows(origin)
+		if allowed {
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if allowed {
+				h.Set("Access-Control-Allow-Methods", s.cors.methods)
+				h.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+				h.Set("Access-Control-Max-Age", corsMaxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// whether a websocket may be opened from the origin of the request, the
+// host of the server itself or an allowed origin, browsers don't preflight
+// websockets
+func (s *HttpServer) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || s.cors.allows(origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
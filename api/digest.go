@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// the transactions an address lists in a digest, the others are only counted
+const digestMaxTransactions = 100
+
+// the digest periods by name, they end at midnight utc, the weekly ones on
+// mondays
+var DigestPeriods = map[string]time.Duration{
+	"daily":  24 * time.Hour,
+	"weekly": 7 * 24 * time.Hour,
+}
+
+// The digest of an address in the period so far, its values summed as
+// numbers
+type addressDigest struct {
+	*types.AddressDigest
+	transactions int
+	valueIn      *big.Int
+	valueOut     *big.Int
+}
+
+// post a summary of the matched transactions to the urls at the end of
+// every daily or weekly period instead of, or besides, the webhooks; none
+// stops collecting them, what the current period collected so far is still
+// posted to the previous urls
+func (n *Notifier) SetDigest(urls []string, period string) {
+	n.Lock()
+	defer n.Unlock()
+	n.digestUrls = urls
+	if _, ok := DigestPeriods[period]; ok {
+		n.digestPeriod = period
+	}
+}
+
+// post the digest of every period when it ends
+func (n *Notifier) RunDigests() {
+	for {
+		n.RLock()
+		period := DigestPeriods[n.digestPeriod]
+		n.RUnlock()
+		// truncated since the zero time, a monday at midnight utc
+		end := time.Now().Truncate(period).Add(period)
+		time.Sleep(time.Until(end))
+		n.postDigest(end)
+	}
+}
+
+// add a mined transaction the webhooks are posted to the digest of its
+// address
+func (n *Notifier) addToDigest(event *types.TransactionEvent) {
+	address := strings.ToLower(event.Address)
+	tx := event.Transaction
+	relative := tx.RelativeTo(address)
+	value := types.ParseQuantity(tx.Value)
+	if value == nil {
+		value = new(big.Int)
+	}
+	n.Lock()
+	defer n.Unlock()
+	if len(n.digestUrls) == 0 {
+		return
+	}
+	digest := n.digests[address]
+	if digest == nil {
+		digest = &addressDigest{
+			AddressDigest: &types.AddressDigest{Address: event.Address, FirstBlock: event.Block, Transactions: []*types.DigestTransaction{}},
+			valueIn:       new(big.Int),
+			valueOut:      new(big.Int),
+		}
+		n.digests[address] = digest
+	}
+	digest.transactions++
+	digest.FirstBlock, digest.LastBlock = min(digest.FirstBlock, event.Block), max(digest.LastBlock, event.Block)
+	in := event.Direction == types.DirectionIn || event.Direction == types.DirectionSelf
+	out := event.Direction == types.DirectionOut || event.Direction == types.DirectionSelf
+	if in {
+		digest.Incoming++
+	}
+	if out {
+		digest.Outgoing++
+	}
+	// the address is neither side of its token transfers, their ether isn't
+	// its own
+	if tx.Failed() {
+		digest.Failed++
+	} else if relative.Direction != "" {
+		if in {
+			digest.valueIn.Add(digest.valueIn, value)
+		}
+		if out {
+			digest.valueOut.Add(digest.valueOut, value)
+		}
+	}
+	if len(digest.Transactions) == digestMaxTransactions {
+		digest.Truncated = true
+		return
+	}
+	digest.Transactions = append(digest.Transactions, &types.DigestTransaction{
+		Hash:         tx.Hash,
+		Block:        event.Block,
+		Direction:    event.Direction,
+		Counterparty: relative.Counterparty,
+		Value:        value.String(),
+		Failed:       tx.Failed(),
+	})
+}
+
+// post the digests collected until the end of the period and start the next
+// one, nothing is posted for a period without transactions
+func (n *Notifier) postDigest(end time.Time) {
+	n.Lock()
+	urls, period, from, digests := n.digestUrls, n.digestPeriod, n.digestFrom, n.digests
+	n.digestFrom, n.digests = end, make(map[string]*addressDigest)
+	n.Unlock()
+	if len(urls) == 0 || len(digests) == 0 {
+		return
+	}
+	event := &types.DigestEvent{Tenant: n.tenant, Period: period, From: from.UTC(), To: end.UTC(), Addresses: []*types.AddressDigest{}}
+	for address, digest := range digests {
+		// the current label, it may have changed during the period
+		if label, err := n.labels.GetAddressLabel(context.Background(), n.tenant, address); err != nil {
+			slog.Warn("Failed to read address label for digest", "address", digest.Address, "err", err)
+		} else if label != nil {
+			digest.Label = label.Label
+		}
+		digest.ValueIn, digest.ValueOut = digest.valueIn.String(), digest.valueOut.String()
+		event.Transactions += digest.transactions
+		event.Addresses = append(event.Addresses, digest.AddressDigest)
+	}
+	slices.SortFunc(event.Addresses, func(a, b *types.AddressDigest) int { return strings.Compare(a.Address, b.Address) })
+	event.Summary = fmt.Sprintf("%d transactions of %d addresses from %s to %s", event.Transactions, len(event.Addresses), event.From.Format(time.DateOnly), event.To.Format(time.DateOnly))
+	data, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Failed to marshal digest", "tenant", n.tenant, "err", err)
+		return
+	}
+	for _, url := range urls {
+		n.deliver(url, data)
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// The codes of the error envelope by http status, stable for clients to
+// branch on
+var errorCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusConflict:            "conflict",
+	http.StatusTooManyRequests:     "rate_limited",
+	http.StatusInternalServerError: "internal",
+	http.StatusBadGateway:          "upstream_failed",
+	http.StatusServiceUnavailable:  "unavailable",
+}
+
+// reply the error envelope with the status
+func writeError(w http.ResponseWriter, status int, err error) {
+	code, ok := errorCodes[status]
+	if !ok {
+		code = "error"
+	}
+	bytes, _ := json.Marshal(&ErrorResponse{Error: &ApiError{Code: code, Message: err.Error()}})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
+
+// reply 500 to a failed storage call, e.g. a lost database connection, the
+// details are logged rather than replied
+func writeStorageError(w http.ResponseWriter, r *http.Request, err error) {
+	if r.Context().Err() != nil {
+		// the client went away and its query was cancelled with it, no one
+		// reads the reply
+		slog.Debug("Request abandoned", "path", r.URL.Path, "err", err)
+		return
+	}
+	slog.Error("Storage failed", "path", r.URL.Path, "err", err)
+	writeError(w, http.StatusInternalServerError, errors.New("the storage failed, try again later"))
+}
+
+func writeAsJson(w http.ResponseWriter, v interface{}) {
+	if bytes, ok := marshalResponse(w, v); ok {
+		w.Write(bytes)
+	}
+}
+
+// the json of the reply, replies 500 instead when it fails to encode
+func marshalResponse(w http.ResponseWriter, v interface{}) ([]byte, bool) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("Failed to marshal response", "type", fmt.Sprintf("%T", v), "err", err)
+		writeError(w, http.StatusInternalServerError, errors.New("failed to encode the response"))
+		return nil, false
+	}
+	return bytes, true
+}
+
+// reply 404 in the error envelope to paths no endpoint serves
+func handleNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, fmt.Errorf("no endpoint at %s", r.URL.Path))
+}
+
+// turn a panicking handler into a 500 instead of taking the connection down
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			slog.Error("Handler panicked", "path", r.URL.Path, "err", err, "stack", string(debug.Stack()))
+			// a reply already under way is cut short instead
+			writeError(w, http.StatusInternalServerError, errors.New("internal server error"))
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/passwizards/eth-parser/abi"
+	"github.com/passwizards/eth-parser/types"
+)
+
+var (
+	topicParam = queryParam{"topic", "topic0 of the event, the keccak256 of its signature", &openApiSchema{Type: "string"}}
+	eventParam = queryParam{"event", "signature of the event instead of its topic, e.g. Transfer(address,address,uint256)", &openApiSchema{Type: "string"}}
+)
+
+// the topic0 of ?topic or else the hash of the ?event signature, empty when
+// neither is set
+func parseEventTopic(query url.Values) (string, error) {
+	if topic := query.Get("topic"); topic != "" {
+		return types.NormalizeHash(topic)
+	}
+	event := query.Get("event")
+	if event == "" {
+		return "", nil
+	}
+	if !strings.HasSuffix(event, ")") || strings.Index(event, "(") < 1 {
+		return "", fmt.Errorf("invalid event %q, expected a signature like Transfer(address,address,uint256)", event)
+	}
+	return abi.EventTopic(event), nil
+}
+
+// the contract of the request path and the event topic of its query, replies
+// 400 when either is malformed or missing
+func pathEvent(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	contract, ok := pathAddress(w, r)
+	if !ok {
+		return "", "", false
+	}
+	topic, err := parseEventTopic(r.URL.Query())
+	if err == nil && topic == "" {
+		err = errors.New("missing topic or event")
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return "", "", false
+	}
+	return contract, topic, true
+}
+
+func (s *HttpServer) HandleSubscribeEvent(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	contract, topic, ok := pathEvent(w, r)
+	if !ok {
+		return
+	}
+	subscribed, err := s.parser.SubscribeEvent(r.Context(), contract, topic)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	writeAsJson(w, &EventSubscribeResponse{Contract: types.ChecksumAddress(contract), Topic: topic, Success: subscribed})
+}
+
+func (s *HttpServer) HandleUnsubscribeEvent(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	contract, topic, ok := pathEvent(w, r)
+	if !ok {
+		return
+	}
+	unsubscribed, err := s.parser.UnsubscribeEvent(r.Context(), contract, topic)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	writeAsJson(w, &EventSubscribeResponse{Contract: types.ChecksumAddress(contract), Topic: topic, Success: unsubscribed})
+}
+
+func (s *HttpServer) HandleEventSubscriptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	subscriptions, err := s.parser.GetEventSubscriptions(r.Context())
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	resp := &EventSubscriptionsResponse{Subscriptions: []*EventSubscriptionResponse{}}
+	for _, subscription := range subscriptions {
+		resp.Subscriptions = append(resp.Subscriptions, &EventSubscriptionResponse{
+			Contract: types.ChecksumAddress(subscription.Contract),
+			Topic:    subscription.Topic,
+		})
+	}
+	writeAsJson(w, resp)
+}
+
+// the stored events of the contract, only those of the ?topic or ?event and
+// the block range when set
+func (s *HttpServer) HandleGetEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	contract, ok := pathAddress(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	topic, err := parseEventTopic(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	filter, err := parseTransactionFilter(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	events, err := s.parser.GetEvents(r.Context(), contract)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	matched := []*types.Event{}
+	for _, e := range events {
+		block := types.BlockNumber(e.BlockNumber)
+		if topic != "" && !strings.EqualFold(e.Topics[0], topic) ||
+			block < filter.FromBlock || filter.ToBlock > 0 && block > filter.ToBlock {
+			continue
+		}
+		matched = append(matched, e)
+	}
+	matched = matched[min(filter.Offset, len(matched)):]
+	if filter.Limit > 0 && len(matched) > filter.Limit {
+		matched = matched[:filter.Limit]
+	}
+	writeAsJson(w, &EventsResponse{Contract: types.ChecksumAddress(contract), Events: matched})
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// transactions written between two flushes of an export
+const exportFlushEvery = 500
+
+var formatParam = queryParam{"format", "json replies in the envelope, csv and ndjson stream a row or a json line per transaction for spreadsheets and pipelines",
+	&openApiSchema{Type: "string", Enum: []string{"json", "csv", "ndjson"}}}
+
+// the columns of a csv export, in the units the transactions were converted to
+var csvColumns = []struct {
+	name  string
+	value func(tx *types.Transaction) string
+}{
+	{"hash", func(tx *types.Transaction) string { return tx.Hash }},
+	{"blockNumber", func(tx *types.Transaction) string { return tx.BlockNumber }},
+	{"blockTimestamp", func(tx *types.Transaction) string { return tx.BlockTimestamp }},
+	{"blockHash", func(tx *types.Transaction) string { return tx.BlockHash }},
+	{"transactionIndex", func(tx *types.Transaction) string { return tx.TransactionIndex }},
+	{"kind", func(tx *types.Transaction) string { return tx.Kind }},
+	{"direction", func(tx *types.Transaction) string { return tx.Direction }},
+	{"from", func(tx *types.Transaction) string { return tx.From }},
+	{"to", func(tx *types.Transaction) string { return tx.To }},
+	{"contractAddress", func(tx *types.Transaction) string { return tx.ContractAddress }},
+	{"counterparty", func(tx *types.Transaction) string { return tx.Counterparty }},
+	{"value", func(tx *types.Transaction) string { return tx.Value }},
+	{"gas", func(tx *types.Transaction) string { return tx.Gas }},
+	{"gasPrice", func(tx *types.Transaction) string { return tx.GasPrice }},
+	{"gasUsed", func(tx *types.Transaction) string { return tx.GasUsed }},
+	{"effectiveGasPrice", func(tx *types.Transaction) string { return tx.EffectiveGasPrice }},
+	{"baseFeePerGas", func(tx *types.Transaction) string { return tx.BaseFeePerGas }},
+	{"priorityFeePerGas", feeColumn(func(fees *types.Fees) string { return fees.PriorityFeePerGas })},
+	{"burnedFee", feeColumn(func(fees *types.Fees) string { return fees.BurnedFee })},
+	{"blobFee", feeColumn(func(fees *types.Fees) string { return fees.BlobFee })},
+	{"totalFee", feeColumn(func(fees *types.Fees) string { return fees.TotalFee })},
+	{"status", func(tx *types.Transaction) string { return tx.Status }},
+	{"nonce", func(tx *types.Transaction) string { return tx.Nonce }},
+	{"method", func(tx *types.Transaction) string {
+		if tx.Decoded == nil {
+			return ""
+		}
+		return tx.Decoded.Signature
+	}},
+	{"input", func(tx *types.Transaction) string { return tx.Input }},
+}
+
+// a fee column, empty without the fees
+func feeColumn(fee func(fees *types.Fees) string) func(tx *types.Transaction) string {
+	return func(tx *types.Transaction) string {
+		if tx.Fees == nil {
+			return ""
+		}
+		return fee(tx.Fees)
+	}
+}
+
+// parse ?format, json when absent
+func parseFormat(query url.Values) (string, error) {
+	switch format := query.Get("format"); format {
+	case "", "json":
+		return "json", nil
+	case "csv", "ndjson":
+		return format, nil
+	default:
+		return "", fmt.Errorf("invalid format %q, expected json, csv or ndjson", format)
+	}
+}
+
+// the content type of the format
+func formatContentType(format string) string {
+	switch format {
+	case "csv":
+		return "text/csv; charset=utf-8"
+	case "ndjson":
+		return "application/x-ndjson"
+	}
+	return "application/json"
+}
+
+// reply the matching transactions of the address in the export format
+func (s *HttpServer) exportTransactions(w http.ResponseWriter, r *http.Request, format, address string, filter types.TransactionFilter, ether bool) {
+	txs, err := s.parser.QueryTransactions(r.Context(), address, filter)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	txs = relativeTransactions(address, txs)
+	if ether {
+		txs = humanTransactions(txs)
+	}
+	w.Header().Set("Content-Type", formatContentType(format))
+	writeExport(w, r, format, address, txs)
+}
+
+// stream the transactions as csv rows under a header or as json lines,
+// flushed every exportFlushEvery transactions so the reply is chunked, a
+// failure halfway cuts the reply short as the status is out already
+func writeExport(w http.ResponseWriter, r *http.Request, format, address string, txs []*types.Transaction) {
+	if format == "csv" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, types.ChecksumAddress(address)))
+	}
+	rc := http.NewResponseController(w)
+	buf := bufio.NewWriter(w)
+	var write func(tx *types.Transaction) error
+	var flush func() error
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(buf)
+		row := make([]string, len(csvColumns))
+		for i, column := range csvColumns {
+			row[i] = column.name
+		}
+		cw.Write(row)
+		write = func(tx *types.Transaction) error {
+			for i, column := range csvColumns {
+				row[i] = column.value(tx)
+			}
+			return cw.Write(row)
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		enc := json.NewEncoder(buf)
+		write = func(tx *types.Transaction) error { return enc.Encode(tx) }
+		flush = func() error { return nil }
+	}
+	failed := func(err error) {
+		if r.Context().Err() != nil {
+			slog.Debug("Export abandoned", "remote", r.RemoteAddr, "format", format, "err", err)
+			return
+		}
+		slog.Warn("Failed to export transactions", "remote", r.RemoteAddr, "format", format, "err", err)
+	}
+	for i, tx := range txs {
+		// stop encoding for a client that went away
+		err := r.Context().Err()
+		if err == nil {
+			err = write(tx)
+		}
+		if err == nil && (i+1)%exportFlushEvery == 0 {
+			err = flushExport(rc, buf, flush)
+		}
+		if err != nil {
+			failed(err)
+			return
+		}
+	}
+	if err := flushExport(rc, buf, flush); err != nil {
+		failed(err)
+	}
+}
+
+// push what the encoder and the buffer hold to the client
+func flushExport(rc *http.ResponseController, buf *bufio.Writer, flush func() error) error {
+	if err := flush(); err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
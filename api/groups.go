@@ -0,0 +1,153 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// the group name of the request path, replies 400 when invalid
+func pathGroup(w http.ResponseWriter, r *http.Request) (string, bool) {
+	group := r.PathValue("group")
+	if err := types.ValidateGroupName(group); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return "", false
+	}
+	return group, true
+}
+
+func (s *HttpServer) HandleCreateGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	group, ok := pathGroup(w, r)
+	if !ok {
+		return
+	}
+	created, err := s.parser.CreateGroup(r.Context(), requestTenant(r), group)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	writeAsJson(w, &GroupResponse{Group: group, Success: created})
+}
+
+func (s *HttpServer) HandleDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	group, ok := pathGroup(w, r)
+	if !ok {
+		return
+	}
+	deleted, err := s.parser.DeleteGroup(r.Context(), requestTenant(r), group)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	writeAsJson(w, &GroupResponse{Group: group, Success: deleted})
+}
+
+// the group and the address of the request path, replies 400 when either is
+// malformed and 404 when the group doesn't exist
+func (s *HttpServer) pathGroupAddress(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	group, ok := pathGroup(w, r)
+	if !ok {
+		return "", "", false
+	}
+	address, ok := pathAddress(w, r)
+	if !ok {
+		return "", "", false
+	}
+	existing, err := s.parser.GetGroup(r.Context(), requestTenant(r), group)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return "", "", false
+	}
+	if existing == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("group %s not found", group))
+		return "", "", false
+	}
+	return group, address, true
+}
+
+func (s *HttpServer) HandleAddToGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	group, address, ok := s.pathGroupAddress(w, r)
+	if !ok {
+		return
+	}
+	added, err := s.parser.AddGroupAddress(r.Context(), requestTenant(r), group, address)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	writeAsJson(w, &GroupAddressResponse{Group: group, Address: types.ChecksumAddress(address), Success: added})
+}
+
+func (s *HttpServer) HandleRemoveFromGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	group, address, ok := s.pathGroupAddress(w, r)
+	if !ok {
+		return
+	}
+	removed, err := s.parser.RemoveGroupAddress(r.Context(), requestTenant(r), group, address)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	writeAsJson(w, &GroupAddressResponse{Group: group, Address: types.ChecksumAddress(address), Success: removed})
+}
+
+func (s *HttpServer) HandleGroups(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	groups, err := s.parser.GetGroups(r.Context(), requestTenant(r))
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	resp := &GroupsResponse{Groups: []*GroupMembersResponse{}}
+	for _, group := range groups {
+		members := &GroupMembersResponse{Group: group.Name, Addresses: make([]string, len(group.Addresses))}
+		for i, address := range group.Addresses {
+			members.Addresses[i] = types.ChecksumAddress(address)
+		}
+		resp.Groups = append(resp.Groups, members)
+	}
+	writeAsJson(w, resp)
+}
+
+// the transactions of the addresses of the group in one reply, filtered and
+// paged like those of a single address
+func (s *HttpServer) HandleGetGroupTransactions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	group, ok := pathGroup(w, r)
+	if !ok {
+		return
+	}
+	filter, err := parseTransactionFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ether, err := parseUnits(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	events, err := s.parser.QueryGroupTransactions(r.Context(), requestTenant(r), group, filter)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if events == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("group %s not found", group))
+		return
+	}
+	for _, event := range events {
+		tx := *event.Transaction
+		tx.Fees = event.Transaction.ComputeFees()
+		event.Transaction = &tx
+		if ether {
+			event.Transaction = humanTransaction(event.Transaction)
+		}
+	}
+	writeAsJson(w, &GroupTransactionsResponse{Group: group, Transactions: events})
+}
@@ -0,0 +1,308 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+
+	"github.com/passwizards/eth-parser/api/grpcapi"
+	"github.com/passwizards/eth-parser/parser"
+	"github.com/passwizards/eth-parser/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const grpcWatchQueueSize = 256
+
+// The gRPC server, serves the same parser as the http endpoints, with the
+// subscriptions of the default tenant
+type GrpcServer struct {
+	grpcapi.UnimplementedEthParserServer
+	parser parser.Parser
+	hub    *GrpcHub
+	server *grpc.Server
+}
+
+// The gRPC hub, fans matched transactions out to the WatchTransactions
+// streams watching the address
+type GrpcHub struct {
+	watchers map[string]map[chan *types.TransactionEvent]struct{}
+	sync.RWMutex
+}
+
+func NewGrpcHub() *GrpcHub {
+	return &GrpcHub{watchers: make(map[string]map[chan *types.TransactionEvent]struct{})}
+}
+
+func NewGrpcServer(parser parser.Parser, hub *GrpcHub) *GrpcServer {
+	s := &GrpcServer{
+		parser: parser,
+		hub:    hub,
+		server: grpc.NewServer(),
+	}
+	grpcapi.RegisterEthParserServer(s.server, s)
+	return s
+}
+
+// serve until Shutdown is called
+func (s *GrpcServer) Serve(addr string) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		panic(fmt.Errorf("failed to listen grpc, err %v", err))
+	}
+	if err := s.server.Serve(lis); err != nil {
+		panic(fmt.Errorf("failed to serve grpc, err %v", err))
+	}
+}
+
+// stop accepting calls and wait for the running ones, watch streams never end
+// on their own so they are cut once the context is done
+func (s *GrpcServer) Shutdown(ctx context.Context) {
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.server.Stop()
+	}
+}
+
+// push matched transactions to the streams watching the address, drops them
+// for streams that can't keep up
+func (h *GrpcHub) Notify(matches []*types.MatchedTransaction) {
+	h.RLock()
+	defer h.RUnlock()
+	for _, m := range matches {
+		for ch := range h.watchers[m.Address] {
+			select {
+			case ch <- types.NewTransactionEvent(m):
+			default:
+				slog.Warn("gRPC watcher too slow, dropping event", "hash", m.Transaction.Hash)
+			}
+		}
+	}
+}
+
+// the status of a failed storage call, the details are logged rather than
+// returned
+func storageStatus(method string, err error) error {
+	slog.Error("Storage failed", "method", method, "err", err)
+	return status.Error(codes.Internal, "the storage failed, try again later")
+}
+
+func (s *GrpcServer) GetCurrentBlock(ctx context.Context, _ *grpcapi.GetCurrentBlockRequest) (*grpcapi.GetCurrentBlockResponse, error) {
+	current, err := s.parser.GetCurrentBlock(ctx)
+	if err != nil {
+		return nil, storageStatus("GetCurrentBlock", err)
+	}
+	return &grpcapi.GetCurrentBlockResponse{CurrentBlock: int64(current)}, nil
+}
+
+func (s *GrpcServer) Subscribe(ctx context.Context, req *grpcapi.SubscribeRequest) (*grpcapi.SubscribeResponse, error) {
+	address, err := types.NormalizeAddress(req.Address)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	subscribed, err := s.parser.Subscribe(ctx, "", address)
+	if err != nil {
+		return nil, storageStatus("Subscribe", err)
+	}
+	return &grpcapi.SubscribeResponse{
+		Address: types.ChecksumAddress(address),
+		Success: subscribed,
+	}, nil
+}
+
+func (s *GrpcServer) Unsubscribe(ctx context.Context, req *grpcapi.SubscribeRequest) (*grpcapi.SubscribeResponse, error) {
+	address, err := types.NormalizeAddress(req.Address)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	unsubscribed, err := s.parser.Unsubscribe(ctx, "", address)
+	if err != nil {
+		return nil, storageStatus("Unsubscribe", err)
+	}
+	return &grpcapi.SubscribeResponse{
+		Address: types.ChecksumAddress(address),
+		Success: unsubscribed,
+	}, nil
+}
+
+func (s *GrpcServer) GetSubscriptions(ctx context.Context, _ *grpcapi.GetSubscriptionsRequest) (*grpcapi.GetSubscriptionsResponse, error) {
+	subscriptions, err := s.parser.GetSubscriptions(ctx, "")
+	if err != nil {
+		return nil, storageStatus("GetSubscriptions", err)
+	}
+	resp := &grpcapi.GetSubscriptionsResponse{}
+	for _, subscription := range subscriptions {
+		resp.Subscriptions = append(resp.Subscriptions, &grpcapi.Subscription{
+			Address:          types.ChecksumAddress(subscription.Address),
+			TransactionCount: int64(subscription.TransactionCount),
+			FirstBlock:       int64(subscription.FirstBlock),
+			LastBlock:        int64(subscription.LastBlock),
+		})
+	}
+	return resp, nil
+}
+
+func (s *GrpcServer) GetTransactions(ctx context.Context, req *grpcapi.GetTransactionsRequest) (*grpcapi.GetTransactionsResponse, error) {
+	address, err := types.NormalizeAddress(req.Address)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.Direction != "" && req.Direction != types.DirectionIn && req.Direction != types.DirectionOut {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid direction %q, expected in or out", req.Direction)
+	}
+	if req.FromBlock < 0 || req.ToBlock < 0 || req.Offset < 0 || req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "negative block range or page")
+	}
+	txs, err := s.parser.QueryTransactions(ctx, address, types.TransactionFilter{
+		Direction: req.Direction,
+		FromBlock: int(req.FromBlock),
+		ToBlock:   int(req.ToBlock),
+		Offset:    int(req.Offset),
+		Limit:     int(req.Limit),
+	})
+	if err != nil {
+		return nil, storageStatus("GetTransactions", err)
+	}
+	resp := &grpcapi.GetTransactionsResponse{Address: types.ChecksumAddress(address)}
+	for _, tx := range txs {
+		resp.Transactions = append(resp.Transactions, toGrpcTransaction(tx))
+	}
+	label, err := s.parser.GetAddressLabel(ctx, "", address)
+	if err != nil {
+		return nil, storageStatus("GetTransactions", err)
+	}
+	if label != nil {
+		resp.Label = label.Label
+	}
+	return resp, nil
+}
+
+func (s *GrpcServer) GetBalance(ctx context.Context, req *grpcapi.GetBalanceRequest) (*grpcapi.GetBalanceResponse, error) {
+	address, err := types.NormalizeAddress(req.Address)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	balance, err := s.parser.GetBalance(ctx, address)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to read balance, err %v", err)
+	}
+	return &grpcapi.GetBalanceResponse{
+		Address:          types.ChecksumAddress(address),
+		Balance:          balance.Balance,
+		TransactionCount: balance.TransactionCount,
+	}, nil
+}
+
+func (s *GrpcServer) WatchTransactions(req *grpcapi.WatchTransactionsRequest, stream grpcapi.EthParser_WatchTransactionsServer) error {
+	var addresses []string
+	for _, address := range req.Addresses {
+		address, err := types.NormalizeAddress(address)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		addresses = append(addresses, address)
+	}
+
+	ctx := stream.Context()
+	events := make(chan *types.TransactionEvent, grpcWatchQueueSize)
+	for _, address := range addresses {
+		if _, err := s.parser.Subscribe(ctx, "", address); err != nil {
+			return storageStatus("WatchTransactions", err)
+		}
+		// register before replaying so nothing parsed meanwhile is missed
+		s.hub.watch(address, events)
+		defer s.hub.unwatch(address, events)
+	}
+	for _, address := range addresses {
+		txs, err := s.parser.GetTransactions(ctx, address)
+		if err != nil {
+			return storageStatus("WatchTransactions", err)
+		}
+		for _, tx := range txs {
+			if err := stream.Send(toGrpcEvent(storedTransactionEvent(address, tx))); err != nil {
+				return err
+			}
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-events:
+			if err := stream.Send(toGrpcEvent(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (h *GrpcHub) watch(address string, events chan *types.TransactionEvent) {
+	h.Lock()
+	defer h.Unlock()
+	if h.watchers[address] == nil {
+		h.watchers[address] = make(map[chan *types.TransactionEvent]struct{})
+	}
+	h.watchers[address][events] = struct{}{}
+}
+
+func (h *GrpcHub) unwatch(address string, events chan *types.TransactionEvent) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.watchers[address], events)
+	if len(h.watchers[address]) == 0 {
+		delete(h.watchers, address)
+	}
+}
+
+func toGrpcEvent(event *types.TransactionEvent) *grpcapi.TransactionEvent {
+	return &grpcapi.TransactionEvent{
+		Address:     event.Address,
+		Direction:   event.Direction,
+		Block:       int64(event.Block),
+		Transaction: toGrpcTransaction(event.Transaction),
+		Pending:     event.Pending,
+	}
+}
+
+func toGrpcTransaction(tx *types.Transaction) *grpcapi.Transaction {
+	var traceAddress []int32
+	for _, i := range tx.TraceAddress {
+		traceAddress = append(traceAddress, int32(i))
+	}
+	return &grpcapi.Transaction{
+		BlockHash:            tx.BlockHash,
+		BlockNumber:          tx.BlockNumber,
+		From:                 tx.From,
+		Gas:                  tx.Gas,
+		GasPrice:             tx.GasPrice,
+		MaxFeePerGas:         tx.MaxFeePerGas,
+		MaxPriorityFeePerGas: tx.MaxPriorityFeePerGas,
+		Hash:                 tx.Hash,
+		Input:                tx.Input,
+		Nonce:                tx.Nonce,
+		To:                   tx.To,
+		TransactionIndex:     tx.TransactionIndex,
+		Value:                tx.Value,
+		Type:                 tx.Type,
+		ChainId:              tx.ChainId,
+		V:                    tx.V,
+		R:                    tx.R,
+		S:                    tx.S,
+		YParity:              tx.YParity,
+		Status:               tx.Status,
+		GasUsed:              tx.GasUsed,
+		EffectiveGasPrice:    tx.EffectiveGasPrice,
+		Kind:                 tx.Kind,
+		TraceAddress:         traceAddress,
+		ContractAddress:      tx.ContractAddress,
+	}
+}
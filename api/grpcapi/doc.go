@@ -0,0 +1,4 @@
+// Package grpcapi holds the generated gRPC service of the parser
+package grpcapi
+
+//go:generate buf generate
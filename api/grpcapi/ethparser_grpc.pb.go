@@ -0,0 +1,384 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: ethparser.proto
+
+package grpcapi
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	EthParser_GetCurrentBlock_FullMethodName   = "/ethparser.EthParser/GetCurrentBlock"
+	EthParser_Subscribe_FullMethodName         = "/ethparser.EthParser/Subscribe"
+	EthParser_Unsubscribe_FullMethodName       = "/ethparser.EthParser/Unsubscribe"
+	EthParser_GetSubscriptions_FullMethodName  = "/ethparser.EthParser/GetSubscriptions"
+	EthParser_GetTransactions_FullMethodName   = "/ethparser.EthParser/GetTransactions"
+	EthParser_WatchTransactions_FullMethodName = "/ethparser.EthParser/WatchTransactions"
+	EthParser_GetBalance_FullMethodName        = "/ethparser.EthParser/GetBalance"
+)
+
+// EthParserClient is the client API for EthParser service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// The parser API, mirrors the http endpoints
+type EthParserClient interface {
+	// last parsed block
+	GetCurrentBlock(ctx context.Context, in *GetCurrentBlockRequest, opts ...grpc.CallOption) (*GetCurrentBlockResponse, error)
+	// add address to observer
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (*SubscribeResponse, error)
+	// remove address from observer
+	Unsubscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (*SubscribeResponse, error)
+	// observed addresses with their transaction count and activity
+	GetSubscriptions(ctx context.Context, in *GetSubscriptionsRequest, opts ...grpc.CallOption) (*GetSubscriptionsResponse, error)
+	// page of the transactions for an address matching the filter
+	GetTransactions(ctx context.Context, in *GetTransactionsRequest, opts ...grpc.CallOption) (*GetTransactionsResponse, error)
+	// stored transactions of the addresses followed by new ones as they are parsed
+	WatchTransactions(ctx context.Context, in *WatchTransactionsRequest, opts ...grpc.CallOption) (EthParser_WatchTransactionsClient, error)
+	// live balance and transaction count of an address, read from the node
+	GetBalance(ctx context.Context, in *GetBalanceRequest, opts ...grpc.CallOption) (*GetBalanceResponse, error)
+}
+
+type ethParserClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEthParserClient(cc grpc.ClientConnInterface) EthParserClient {
+	return &ethParserClient{cc}
+}
+
+func (c *ethParserClient) GetCurrentBlock(ctx context.Context, in *GetCurrentBlockRequest, opts ...grpc.CallOption) (*GetCurrentBlockResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetCurrentBlockResponse)
+	err := c.cc.Invoke(ctx, EthParser_GetCurrentBlock_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ethParserClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (*SubscribeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SubscribeResponse)
+	err := c.cc.Invoke(ctx, EthParser_Subscribe_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ethParserClient) Unsubscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (*SubscribeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SubscribeResponse)
+	err := c.cc.Invoke(ctx, EthParser_Unsubscribe_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ethParserClient) GetSubscriptions(ctx context.Context, in *GetSubscriptionsRequest, opts ...grpc.CallOption) (*GetSubscriptionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetSubscriptionsResponse)
+	err := c.cc.Invoke(ctx, EthParser_GetSubscriptions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ethParserClient) GetTransactions(ctx context.Context, in *GetTransactionsRequest, opts ...grpc.CallOption) (*GetTransactionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetTransactionsResponse)
+	err := c.cc.Invoke(ctx, EthParser_GetTransactions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ethParserClient) WatchTransactions(ctx context.Context, in *WatchTransactionsRequest, opts ...grpc.CallOption) (EthParser_WatchTransactionsClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &EthParser_ServiceDesc.Streams[0], EthParser_WatchTransactions_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &ethParserWatchTransactionsClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type EthParser_WatchTransactionsClient interface {
+	Recv() (*TransactionEvent, error)
+	grpc.ClientStream
+}
+
+type ethParserWatchTransactionsClient struct {
+	grpc.ClientStream
+}
+
+func (x *ethParserWatchTransactionsClient) Recv() (*TransactionEvent, error) {
+	m := new(TransactionEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *ethParserClient) GetBalance(ctx context.Context, in *GetBalanceRequest, opts ...grpc.CallOption) (*GetBalanceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetBalanceResponse)
+	err := c.cc.Invoke(ctx, EthParser_GetBalance_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// EthParserServer is the server API for EthParser service.
+// All implementations must embed UnimplementedEthParserServer
+// for forward compatibility
+//
+// The parser API, mirrors the http endpoints
+type EthParserServer interface {
+	// last parsed block
+	GetCurrentBlock(context.Context, *GetCurrentBlockRequest) (*GetCurrentBlockResponse, error)
+	// add address to observer
+	Subscribe(context.Context, *SubscribeRequest) (*SubscribeResponse, error)
+	// remove address from observer
+	Unsubscribe(context.Context, *SubscribeRequest) (*SubscribeResponse, error)
+	// observed addresses with their transaction count and activity
+	GetSubscriptions(context.Context, *GetSubscriptionsRequest) (*GetSubscriptionsResponse, error)
+	// page of the transactions for an address matching the filter
+	GetTransactions(context.Context, *GetTransactionsRequest) (*GetTransactionsResponse, error)
+	// stored transactions of the addresses followed by new ones as they are parsed
+	WatchTransactions(*WatchTransactionsRequest, EthParser_WatchTransactionsServer) error
+	// live balance and transaction count of an address, read from the node
+	GetBalance(context.Context, *GetBalanceRequest) (*GetBalanceResponse, error)
+	mustEmbedUnimplementedEthParserServer()
+}
+
+// UnimplementedEthParserServer must be embedded to have forward compatible implementations.
+type UnimplementedEthParserServer struct {
+}
+
+func (UnimplementedEthParserServer) GetCurrentBlock(context.Context, *GetCurrentBlockRequest) (*GetCurrentBlockResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCurrentBlock not implemented")
+}
+func (UnimplementedEthParserServer) Subscribe(context.Context, *SubscribeRequest) (*SubscribeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedEthParserServer) Unsubscribe(context.Context, *SubscribeRequest) (*SubscribeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Unsubscribe not implemented")
+}
+func (UnimplementedEthParserServer) GetSubscriptions(context.Context, *GetSubscriptionsRequest) (*GetSubscriptionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSubscriptions not implemented")
+}
+func (UnimplementedEthParserServer) GetTransactions(context.Context, *GetTransactionsRequest) (*GetTransactionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTransactions not implemented")
+}
+func (UnimplementedEthParserServer) WatchTransactions(*WatchTransactionsRequest, EthParser_WatchTransactionsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchTransactions not implemented")
+}
+func (UnimplementedEthParserServer) GetBalance(context.Context, *GetBalanceRequest) (*GetBalanceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBalance not implemented")
+}
+func (UnimplementedEthParserServer) mustEmbedUnimplementedEthParserServer() {}
+
+// UnsafeEthParserServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to EthParserServer will
+// result in compilation errors.
+type UnsafeEthParserServer interface {
+	mustEmbedUnimplementedEthParserServer()
+}
+
+func RegisterEthParserServer(s grpc.ServiceRegistrar, srv EthParserServer) {
+	s.RegisterService(&EthParser_ServiceDesc, srv)
+}
+
+func _EthParser_GetCurrentBlock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCurrentBlockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EthParserServer).GetCurrentBlock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EthParser_GetCurrentBlock_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EthParserServer).GetCurrentBlock(ctx, req.(*GetCurrentBlockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EthParser_Subscribe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubscribeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EthParserServer).Subscribe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EthParser_Subscribe_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EthParserServer).Subscribe(ctx, req.(*SubscribeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EthParser_Unsubscribe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubscribeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EthParserServer).Unsubscribe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EthParser_Unsubscribe_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EthParserServer).Unsubscribe(ctx, req.(*SubscribeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EthParser_GetSubscriptions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSubscriptionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EthParserServer).GetSubscriptions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EthParser_GetSubscriptions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EthParserServer).GetSubscriptions(ctx, req.(*GetSubscriptionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EthParser_GetTransactions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTransactionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EthParserServer).GetTransactions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EthParser_GetTransactions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EthParserServer).GetTransactions(ctx, req.(*GetTransactionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _EthParser_WatchTransactions_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchTransactionsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(EthParserServer).WatchTransactions(m, &ethParserWatchTransactionsServer{ServerStream: stream})
+}
+
+type EthParser_WatchTransactionsServer interface {
+	Send(*TransactionEvent) error
+	grpc.ServerStream
+}
+
+type ethParserWatchTransactionsServer struct {
+	grpc.ServerStream
+}
+
+func (x *ethParserWatchTransactionsServer) Send(m *TransactionEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _EthParser_GetBalance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBalanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EthParserServer).GetBalance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EthParser_GetBalance_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EthParserServer).GetBalance(ctx, req.(*GetBalanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// EthParser_ServiceDesc is the grpc.ServiceDesc for EthParser service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var EthParser_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ethparser.EthParser",
+	HandlerType: (*EthParserServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetCurrentBlock",
+			Handler:    _EthParser_GetCurrentBlock_Handler,
+		},
+		{
+			MethodName: "Subscribe",
+			Handler:    _EthParser_Subscribe_Handler,
+		},
+		{
+			MethodName: "Unsubscribe",
+			Handler:    _EthParser_Unsubscribe_Handler,
+		},
+		{
+			MethodName: "GetSubscriptions",
+			Handler:    _EthParser_GetSubscriptions_Handler,
+		},
+		{
+			MethodName: "GetTransactions",
+			Handler:    _EthParser_GetTransactions_Handler,
+		},
+		{
+			MethodName: "GetBalance",
+			Handler:    _EthParser_GetBalance_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchTransactions",
+			Handler:       _EthParser_WatchTransactions_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "ethparser.proto",
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+const (
+	// how long the parser loop may go without a round before it counts as
+	// wedged, longer than any poll, new heads or backoff wait
+	livenessTimeout = 5 * time.Minute
+	// how long without reading the chain head before the rpc counts as
+	// unreachable
+	readinessHeadTimeout = 2 * time.Minute
+	// a couple of minutes of mainnet blocks
+	defaultMaxReadyLag = 10
+)
+
+// how many blocks the parsers may lag behind the head and still take
+// traffic, before the server is used
+func (s *HttpServer) SetMaxReadyLag(blocks int) {
+	s.maxReadyLag = blocks
+	for _, chain := range s.chains {
+		chain.maxReadyLag = blocks
+	}
+}
+
+// liveness, fails when a parser loop is wedged so it gets restarted
+func (s *HttpServer) HandleHealthz(w http.ResponseWriter, _ *http.Request) {
+	s.writeHealth(w, func(status *types.Status, _ int) (problems []string) {
+		if time.Since(status.Heartbeat) > livenessTimeout {
+			problems = append(problems, "parser loop stalled")
+		}
+		return
+	})
+}
+
+// readiness, fails while the rpc is unreachable or a parser lags behind the
+// chain head
+func (s *HttpServer) HandleReadyz(w http.ResponseWriter, _ *http.Request) {
+	s.writeHealth(w, func(status *types.Status, maxLag int) (problems []string) {
+		if status.EndpointsUp == 0 || time.Since(status.LastHeadTime) > readinessHeadTimeout {
+			problems = append(problems, "rpc unreachable")
+		}
+		if status.Lag > maxLag {
+			problems = append(problems, "lagging behind the chain head")
+		}
+		return
+	})
+}
+
+// check the status of the parser and the ones of the other chains, 503 with
+// the problems found
+func (s *HttpServer) writeHealth(w http.ResponseWriter, check func(status *types.Status, maxLag int) []string) {
+	w.Header().Set("Content-Type", "application/json")
+	// a parser paused by an operator is neither wedged nor behind
+	checkRunning := func(status *types.Status, maxLag int) []string {
+		if status.Paused != nil {
+			return nil
+		}
+		return check(status, maxLag)
+	}
+	status := publicStatus(s.parser.GetStatus())
+	problems := checkRunning(status, s.maxReadyLag)
+	chains := make(map[string]*types.Status)
+	for name, chain := range s.chains {
+		chains[name] = publicStatus(chain.parser.GetStatus())
+		for _, problem := range checkRunning(chains[name], chain.maxReadyLag) {
+			problems = append(problems, name+": "+problem)
+		}
+	}
+	if len(problems) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	resp := &HealthResponse{Ok: len(problems) == 0, Problems: problems, Status: status}
+	if len(chains) > 0 {
+		resp.Chains = chains
+	}
+	writeAsJson(w, resp)
+}
+
+// the status without the error of the last rpc call, the health endpoints
+// need no api key and the rpc errors may tell about the endpoints
+func publicStatus(status *types.Status) *types.Status {
+	status.LastError = ""
+	return status
+}
@@ -0,0 +1,1024 @@
+// Package api exposes a parser over http, websockets and gRPC and pushes its
+// matched transactions to webhooks
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/passwizards/eth-parser/parser"
+	"github.com/passwizards/eth-parser/storage"
+	"github.com/passwizards/eth-parser/types"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// the last blocks /GasPrice covers without ?blocks, a few minutes of mainnet
+const defaultGasPriceBlocks = 20
+
+type HttpServer struct {
+	parser parser.Parser
+	hub    *WsHub
+	server *http.Server
+	// blocks behind the head /readyz still passes with
+	maxReadyLag int
+	mux         *http.ServeMux
+	// the servers of the other chains, by name
+	chains map[string]*HttpServer
+	// keys required to call the server, open when empty
+	apiKeys apiKeys
+	// requests per second of every client ip, unlimited by default
+	clientLimits clientLimits
+	// GetTransactions replies by address and query, nil disables
+	responses *responseCache
+	// origins allowed to call the server from a browser
+	cors *corsPolicy
+	// the share of the requests logged, none by default
+	accessLog accessLog
+}
+
+func (s *HttpServer) HandleGetCurrentBlock(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	current, err := s.parser.GetCurrentBlock(r.Context())
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	writeAsJson(w, &CurrentBlockResponse{CurrentBlock: current})
+}
+
+func (s *HttpServer) HandleSubscribe(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	address, name, ok := s.pathAddressOrName(w, r)
+	if !ok {
+		return
+	}
+	var req SubscribeRequest
+	if r.Method == http.MethodPost && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid subscribe body, err %v", err))
+			return
+		}
+		if req.Rule != nil {
+			if err := req.Rule.Normalize(); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid rule, err %v", err))
+				return
+			}
+		}
+	}
+	ttl, err := parseTtl(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	subscribed, err := s.parser.Subscribe(r.Context(), requestTenant(r), address)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	resp := &SubscribeResponse{
+		Address: types.ChecksumAddress(address),
+		Name:    name,
+		Success: subscribed,
+	}
+	if req.Label != "" || req.Metadata != nil || req.Rule != nil {
+		label := &types.AddressLabel{Label: req.Label, Metadata: req.Metadata, Rule: req.Rule}
+		// labelling an address already observed changes it too
+		labelled, err := s.parser.SetAddressLabel(r.Context(), requestTenant(r), address, label)
+		if err != nil {
+			writeStorageError(w, r, err)
+			return
+		}
+		resp.Success = labelled || resp.Success
+	}
+	if ttl != nil {
+		// as does a ttl, renewing or clearing its expiry
+		set, err := s.parser.SetSubscriptionTtl(r.Context(), requestTenant(r), address, *ttl)
+		if err != nil {
+			writeStorageError(w, r, err)
+			return
+		}
+		resp.Success = set || resp.Success
+	}
+	label, err := s.parser.GetAddressLabel(r.Context(), requestTenant(r), address)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if label != nil {
+		resp.Label, resp.Metadata, resp.Rule, resp.Expires = label.Label, label.Metadata, label.Rule, label.Expires
+	}
+	writeAsJson(w, resp)
+}
+
+func (s *HttpServer) HandleUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	address, ok := pathAddress(w, r)
+	if !ok {
+		return
+	}
+	unsubscribed, err := s.parser.Unsubscribe(r.Context(), requestTenant(r), address)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	writeAsJson(w, &SubscribeResponse{
+		Address: types.ChecksumAddress(address),
+		Success: unsubscribed,
+	})
+}
+
+func (s *HttpServer) HandleSubscriptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	subscriptions, err := s.parser.GetSubscriptions(r.Context(), requestTenant(r))
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	resp := &SubscriptionsResponse{Subscriptions: []*SubscriptionResponse{}}
+	for _, subscription := range subscriptions {
+		resp.Subscriptions = append(resp.Subscriptions, &SubscriptionResponse{
+			Address:          types.ChecksumAddress(subscription.Address),
+			TransactionCount: subscription.TransactionCount,
+			FirstBlock:       subscription.FirstBlock,
+			LastBlock:        subscription.LastBlock,
+			Label:            subscription.Label,
+			Metadata:         subscription.Metadata,
+			Rule:             subscription.Rule,
+			Expires:          subscription.Expires,
+		})
+	}
+	writeAsJson(w, resp)
+}
+
+// the ?ttl of a subscription, nil when not set
+func parseTtl(query url.Values) (*time.Duration, error) {
+	value := query.Get("ttl")
+	if value == "" {
+		return nil, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		return nil, fmt.Errorf("invalid ttl %q, expected a duration like 24h or 0 to never expire", value)
+	}
+	return &ttl, nil
+}
+
+// the normalized address of the request path, replies 400 when malformed
+func pathAddress(w http.ResponseWriter, r *http.Request) (string, bool) {
+	address, err := types.NormalizeAddress(r.PathValue("address"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return "", false
+	}
+	return address, true
+}
+
+// the address in the path, or the one its ENS name resolves to along with
+// the name
+func (s *HttpServer) pathAddressOrName(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	if !types.IsEnsName(r.PathValue("address")) {
+		address, ok := pathAddress(w, r)
+		return address, "", ok
+	}
+	name, err := types.NormalizeEnsName(r.PathValue("address"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return "", "", false
+	}
+	address, err := s.parser.ResolveName(r.Context(), name)
+	switch {
+	case errors.Is(err, parser.ErrEnsDisabled):
+		writeError(w, http.StatusBadRequest, err)
+		return "", "", false
+	case err != nil:
+		writeError(w, http.StatusBadGateway, fmt.Errorf("failed to resolve %s, err %v", name, err))
+		return "", "", false
+	case address == "":
+		writeError(w, http.StatusNotFound, fmt.Errorf("ens name %s not found", name))
+		return "", "", false
+	}
+	return address, name, true
+}
+
+// the primary ENS names of the counterparties of the transactions that have
+// one, by checksummed address; the ones failing to resolve are left out
+func (s *HttpServer) counterpartyNames(ctx context.Context, txs []*types.Transaction) (map[string]string, error) {
+	names := make(map[string]string)
+	looked := make(map[string]bool)
+	for _, tx := range txs {
+		if tx.Counterparty == "" || looked[tx.Counterparty] {
+			continue
+		}
+		looked[tx.Counterparty] = true
+		name, err := s.parser.LookupAddress(ctx, tx.Counterparty)
+		if errors.Is(err, parser.ErrEnsDisabled) {
+			return nil, err
+		}
+		if name != "" {
+			names[types.ChecksumAddress(tx.Counterparty)] = name
+		}
+	}
+	return names, nil
+}
+
+// whether ?names asks for the ENS names of the counterparties
+func parseNames(query url.Values) (bool, error) {
+	value := query.Get("names")
+	if value == "" {
+		return false, nil
+	}
+	names, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid names %q, expected true or false", value)
+	}
+	return names, nil
+}
+
+func (s *HttpServer) HandleBackfill(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	address, ok := pathAddress(w, r)
+	if !ok {
+		return
+	}
+	filter, err := parseTransactionFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// the parser stops at the current block too, resolved here to report it
+	current, err := s.parser.GetCurrentBlock(r.Context())
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if filter.ToBlock == 0 || filter.ToBlock > current {
+		filter.ToBlock = current
+	}
+	queued, err := s.parser.Backfill(r.Context(), address, filter.FromBlock, filter.ToBlock)
+	if errors.Is(err, parser.ErrReadOnly) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	writeAsJson(w, &BackfillResponse{
+		Address:   types.ChecksumAddress(address),
+		Success:   queued,
+		FromBlock: filter.FromBlock,
+		ToBlock:   filter.ToBlock,
+	})
+}
+
+func (s *HttpServer) HandleGetTransactions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	address, name, ok := s.pathAddressOrName(w, r)
+	if !ok {
+		return
+	}
+	names, err := parseNames(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	filter, err := parseTransactionFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ether, err := parseUnits(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	format, err := parseFormat(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !s.applyTimeRange(w, r, &filter) {
+		return
+	}
+	v2 := isV2(r)
+	// read before the transactions, a write in between only makes the reply
+	// look older than it is
+	revision, err := s.parser.GetTransactionsRevision(r.Context(), address)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	// the reply holds the label the tenant gave the address
+	tenant := requestTenant(r)
+	if tenant != "" {
+		revision += "-" + tenant
+	}
+	// the same transactions are replied as json or protocol buffers
+	w.Header().Add("Vary", "Accept")
+	protobuf := format == "json" && !v2 && acceptsProtobuf(r.Header.Get("Accept"))
+	if protobuf {
+		revision += "-pb"
+	}
+	if v2 {
+		revision += "-v2"
+	}
+	if name != "" {
+		revision += "-" + name
+	}
+	// the names of the counterparties change without the transactions
+	if !names && notModified(w, r, `"`+revision+`"`) {
+		return
+	}
+	if format != "json" {
+		s.exportTransactions(w, r, format, address, filter, ether)
+		return
+	}
+	key := tenant + "/" + address + "?" + r.URL.RawQuery
+	if protobuf {
+		w.Header().Set("Content-Type", protobufType)
+		key += "#pb"
+	}
+	if v2 {
+		key += "#v2"
+	}
+	if name != "" {
+		key += "#" + name
+	}
+	if body := s.responses.get(key, revision); !names && body != nil {
+		w.Write(body)
+		return
+	}
+	txs, err := s.parser.QueryTransactions(r.Context(), address, filter)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	txs = relativeTransactions(address, txs)
+	if ether {
+		txs = humanTransactions(txs)
+	}
+	resp := &TransactionsResponse{
+		Address:      types.ChecksumAddress(address),
+		Name:         name,
+		Transactions: txs,
+	}
+	if names && !protobuf {
+		if resp.Names, err = s.counterpartyNames(r.Context(), txs); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	label, err := s.parser.GetAddressLabel(r.Context(), requestTenant(r), address)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if label != nil {
+		resp.Label, resp.Metadata = label.Label, label.Metadata
+	}
+	var body []byte
+	if protobuf {
+		body = marshalProtobuf(toProtobufTransactions(resp))
+	} else if v2 {
+		if body, ok = marshalResponse(w, toTransactionsV2Response(resp)); !ok {
+			return
+		}
+	} else if body, ok = marshalResponse(w, resp); !ok {
+		return
+	}
+	if !names {
+		s.responses.put(key, revision, body)
+	}
+	w.Write(body)
+}
+
+func (s *HttpServer) HandleGetTransaction(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	hash, err := types.NormalizeHash(r.PathValue("hash"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ether, err := parseUnits(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	tx, err := s.parser.GetTransaction(r.Context(), hash)
+	if errors.As(err, new(*parser.StorageError)) {
+		writeStorageError(w, r, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("failed to read transaction, err %v", err))
+		return
+	}
+	if tx == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("transaction %s not found", hash))
+		return
+	}
+	withFees := *tx
+	withFees.Fees = tx.ComputeFees()
+	tx = &withFees
+	if isV2(r) {
+		writeAsJson(w, &TransactionV2Response{Transaction: toTransactionV2(tx)})
+		return
+	}
+	if ether {
+		tx = humanTransaction(tx)
+	}
+	writeAsJson(w, &TransactionResponse{Transaction: tx})
+}
+
+// the stored transactions of every address matching ?hash, ?from, ?to,
+// ?block and ?minValue, for looking into what was stored whichever
+// subscription matched it; tenant keys see only the ones of their
+// subscriptions
+func (s *HttpServer) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
+	search, err := parseTransactionSearch(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ether, err := parseUnits(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	txs, err := s.parser.SearchTransactions(r.Context(), search)
+	if errors.Is(err, storage.ErrUnindexedSearch) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if txs, err = s.tenantTransactions(r, txs); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	for i, tx := range txs {
+		withFees := *tx
+		withFees.Fees = tx.ComputeFees()
+		txs[i] = &withFees
+	}
+	if isV2(r) {
+		writeAsJson(w, &SearchV2Response{Transactions: toTransactionsV2(txs)})
+		return
+	}
+	if ether {
+		txs = humanTransactions(txs)
+	}
+	writeAsJson(w, &SearchResponse{Transactions: txs})
+}
+
+// the transactions sent or received by an address the tenant of the request
+// subscribed to, all of them for the default tenant
+func (s *HttpServer) tenantTransactions(r *http.Request, txs []*types.Transaction) ([]*types.Transaction, error) {
+	tenant := requestTenant(r)
+	if tenant == "" {
+		return txs, nil
+	}
+	subscribed := make(map[string]bool)
+	isSubscribed := func(address string) (bool, error) {
+		if known, ok := subscribed[address]; ok {
+			return known, nil
+		}
+		ok, err := s.parser.IsSubscribed(r.Context(), tenant, address)
+		subscribed[address] = ok
+		return ok, err
+	}
+	filtered := make([]*types.Transaction, 0, len(txs))
+	for _, tx := range txs {
+		from, err := isSubscribed(tx.From)
+		if err != nil {
+			return nil, err
+		}
+		to, err := isSubscribed(tx.To)
+		if err != nil {
+			return nil, err
+		}
+		if from || to {
+			filtered = append(filtered, tx)
+		}
+	}
+	return filtered, nil
+}
+
+// parse ?hash, ?from, ?to, ?minValue, ?block and ?limit
+func parseTransactionSearch(query url.Values) (*types.TransactionSearch, error) {
+	search := &types.TransactionSearch{}
+	var err error
+	if hash := query.Get("hash"); hash != "" {
+		if search.Hash, err = types.NormalizeHash(hash); err != nil {
+			return nil, err
+		}
+	}
+	for name, field := range map[string]*string{"from": &search.From, "to": &search.To} {
+		if address := query.Get(name); address != "" {
+			if *field, err = types.NormalizeAddress(address); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if value := query.Get("minValue"); value != "" {
+		min, ok := new(big.Int).SetString(value, 10)
+		if !ok || min.Sign() < 0 {
+			return nil, fmt.Errorf("invalid minValue %q, expected decimal wei", value)
+		}
+		search.MinValue = min
+	}
+	for name, field := range map[string]*int{"block": &search.Block, "limit": &search.Limit} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 0, 0)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s %q", name, value)
+		}
+		*field = int(n)
+	}
+	return search, nil
+}
+
+// the stored sweeps of a deposit address into the hot wallets, or into a
+// hot wallet when it is subscribed too
+func (s *HttpServer) HandleGetSweeps(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	address, ok := pathAddress(w, r)
+	if !ok {
+		return
+	}
+	filter, err := parseTransactionFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ether, err := parseUnits(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	filter.Sweeps = true
+	txs, err := s.parser.QueryTransactions(r.Context(), address, filter)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	txs = relativeTransactions(address, txs)
+	if ether {
+		txs = humanTransactions(txs)
+	}
+	writeAsJson(w, &TransactionsResponse{
+		Address:      types.ChecksumAddress(address),
+		Transactions: txs,
+	})
+}
+
+func (s *HttpServer) HandleGetPendingTransactions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	address, ok := pathAddress(w, r)
+	if !ok {
+		return
+	}
+	ether, err := parseUnits(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	txs := relativeTransactions(address, s.parser.GetPendingTransactions(address))
+	if ether {
+		txs = humanTransactions(txs)
+	}
+	resp := &TransactionsResponse{
+		Address:      types.ChecksumAddress(address),
+		Transactions: txs,
+	}
+	if isV2(r) {
+		writeAsJson(w, toTransactionsV2Response(resp))
+		return
+	}
+	writeAsJson(w, resp)
+}
+
+// copies of the transactions with their direction and counterparty relative
+// to the lowercase address
+func relativeTransactions(address string, txs []*types.Transaction) []*types.Transaction {
+	relative := make([]*types.Transaction, len(txs))
+	for i, tx := range txs {
+		relative[i] = tx.RelativeTo(address)
+		relative[i].Fees = tx.ComputeFees()
+	}
+	return relative
+}
+
+// parse ?limit, ?offset, ?direction, ?status, ?blobs, ?fromBlock and ?toBlock
+func parseTransactionFilter(query url.Values) (filter types.TransactionFilter, err error) {
+	filter.Direction = query.Get("direction")
+	if filter.Direction != "" && filter.Direction != types.DirectionIn && filter.Direction != types.DirectionOut {
+		return filter, fmt.Errorf("invalid direction %q, expected in or out", filter.Direction)
+	}
+	switch status := query.Get("status"); status {
+	case "", "all":
+	case types.TransactionStatusSuccess, types.TransactionStatusFailed:
+		filter.Status = status
+	default:
+		return filter, fmt.Errorf("invalid status %q, expected success, failed or all", status)
+	}
+	if value := query.Get("blobs"); value != "" {
+		if filter.Blobs, err = strconv.ParseBool(value); err != nil {
+			return filter, fmt.Errorf("invalid blobs %q, expected true or false", value)
+		}
+	}
+	for name, field := range map[string]*int{
+		"limit":     &filter.Limit,
+		"offset":    &filter.Offset,
+		"fromBlock": &filter.FromBlock,
+		"toBlock":   &filter.ToBlock,
+	} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 0, 0)
+		if err != nil || n < 0 {
+			return filter, fmt.Errorf("invalid %s %q", name, value)
+		}
+		*field = int(n)
+	}
+	return filter, nil
+}
+
+// the time of the query parameter, a day like 2024-01-01 in UTC, an RFC 3339
+// time or unix seconds, zero when missing
+func parseQueryTime(query url.Values, name string) (time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds >= 0 {
+		return time.Unix(seconds, 0), nil
+	}
+	for _, layout := range []string{time.DateOnly, time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid %s %q, expected a day like 2024-01-01, an RFC 3339 time or unix seconds", name, value)
+}
+
+// narrow the block range of the filter to the blocks mined from ?since and
+// before ?until, looked up by their timestamps; false when it failed and was
+// replied
+func (s *HttpServer) applyTimeRange(w http.ResponseWriter, r *http.Request, filter *types.TransactionFilter) bool {
+	since, err := parseQueryTime(r.URL.Query(), "since")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+	until, err := parseQueryTime(r.URL.Query(), "until")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+	blockAt := func(t time.Time) (int, bool) {
+		block, err := s.parser.BlockAtTime(r.Context(), t)
+		if errors.As(err, new(*parser.StorageError)) {
+			writeStorageError(w, r, err)
+			return 0, false
+		}
+		if err != nil {
+			writeError(w, http.StatusBadGateway, fmt.Errorf("failed to find the block at %s, err %v", t.UTC().Format(time.RFC3339), err))
+			return 0, false
+		}
+		return block, true
+	}
+	if !since.IsZero() {
+		from, ok := blockAt(since)
+		if !ok {
+			return false
+		}
+		filter.FromBlock = max(filter.FromBlock, from)
+	}
+	if !until.IsZero() {
+		after, ok := blockAt(until)
+		if !ok {
+			return false
+		}
+		// a zero ToBlock has no upper bound, the genesis block has no
+		// transactions to leave out
+		if after <= 1 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid until %q, before the first blocks", r.URL.Query().Get("until")))
+			return false
+		}
+		if filter.ToBlock == 0 || filter.ToBlock > after-1 {
+			filter.ToBlock = after - 1
+		}
+	}
+	return true
+}
+
+func (s *HttpServer) HandleGetTokenTransfers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	address, ok := pathAddress(w, r)
+	if !ok {
+		return
+	}
+	transfers, err := s.parser.GetTokenTransfers(r.Context(), address)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	writeAsJson(w, &TokenTransfersResponse{
+		Address:   types.ChecksumAddress(address),
+		Transfers: transfers,
+	})
+}
+
+func (s *HttpServer) HandleGetTokenMetadata(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	token, ok := pathAddress(w, r)
+	if !ok {
+		return
+	}
+	metadata, err := s.parser.GetTokenMetadata(r.Context(), token)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("failed to read token metadata, err %v", err))
+		return
+	}
+	writeAsJson(w, &TokenMetadataResponse{
+		Token:    types.ChecksumAddress(token),
+		Symbol:   metadata.Symbol,
+		Name:     metadata.Name,
+		Decimals: metadata.Decimals,
+	})
+}
+
+func (s *HttpServer) HandleGetStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	address, ok := pathAddress(w, r)
+	if !ok {
+		return
+	}
+	ether, err := parseUnits(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	stats, err := s.parser.GetAddressStats(r.Context(), address)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	resp := &StatsResponse{
+		Address:         types.ChecksumAddress(address),
+		TotalReceived:   stats.TotalReceived,
+		TotalSent:       stats.TotalSent,
+		IncomingCount:   stats.IncomingCount,
+		OutgoingCount:   stats.OutgoingCount,
+		FirstBlock:      stats.FirstBlock,
+		LastBlock:       stats.LastBlock,
+		AverageGasPrice: stats.AverageGasPrice,
+	}
+	if ether {
+		resp.TotalReceived = types.FormatQuantity(stats.TotalReceived, types.EtherDecimals)
+		resp.TotalSent = types.FormatQuantity(stats.TotalSent, types.EtherDecimals)
+		if resp.AverageGasPrice != "" {
+			resp.AverageGasPrice = types.FormatQuantity(stats.AverageGasPrice, types.GweiDecimals)
+		}
+	}
+	writeAsJson(w, resp)
+}
+
+func (s *HttpServer) HandleGetBalanceHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	address, ok := pathAddress(w, r)
+	if !ok {
+		return
+	}
+	ether, err := parseUnits(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	filter, err := parseTransactionFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if filter.ToBlock > 0 && filter.FromBlock > filter.ToBlock {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid block range %d..%d", filter.FromBlock, filter.ToBlock))
+		return
+	}
+	changes, err := s.parser.GetBalanceHistory(r.Context(), address, filter.FromBlock, filter.ToBlock)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if ether {
+		for i, change := range changes {
+			changes[i] = humanBalanceChange(change)
+		}
+	}
+	writeAsJson(w, &BalanceHistoryResponse{
+		Address: types.ChecksumAddress(address),
+		Changes: changes,
+	})
+}
+
+func (s *HttpServer) HandleGetBlock(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	number, err := strconv.ParseInt(r.PathValue("number"), 0, 0)
+	if err != nil || number < 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid block number %q", r.PathValue("number")))
+		return
+	}
+	ether, err := parseUnits(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	block, err := s.parser.GetBlock(r.Context(), int(number))
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	if block == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("block %d not found", number))
+		return
+	}
+	if ether {
+		block = humanBlock(block)
+	}
+	writeAsJson(w, &BlockResponse{Block: block})
+}
+
+// the percentiles of the gas prices paid in the last ?blocks parsed and
+// their base fees, for fee estimation
+func (s *HttpServer) HandleGasPrice(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
+	blocks := defaultGasPriceBlocks
+	if value := query.Get("blocks"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > parser.GasPriceBlocks {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid blocks %q, expected 1 to %d", value, parser.GasPriceBlocks))
+			return
+		}
+		blocks = n
+	}
+	ether, err := parseUnits(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	gasPrice := s.parser.GetGasPrice(blocks)
+	if gasPrice == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("no block parsed yet"))
+		return
+	}
+	if ether {
+		gasPrice = humanGasPrice(gasPrice)
+	}
+	writeAsJson(w, &GasPriceResponse{GasPrice: gasPrice})
+}
+
+func (s *HttpServer) HandleGetNftTransfers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	address, ok := pathAddress(w, r)
+	if !ok {
+		return
+	}
+	transfers, err := s.parser.GetNftTransfers(r.Context(), address)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	writeAsJson(w, &NftTransfersResponse{
+		Address:   types.ChecksumAddress(address),
+		Transfers: transfers,
+	})
+}
+
+func (s *HttpServer) HandleGetBalance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	address, ok := pathAddress(w, r)
+	if !ok {
+		return
+	}
+	ether, err := parseUnits(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	balance, err := s.parser.GetBalance(r.Context(), address)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("failed to read balance, err %v", err))
+		return
+	}
+	if ether {
+		balance.Balance = types.FormatQuantity(balance.Balance, types.EtherDecimals)
+		balance.TransactionCount = types.FormatQuantity(balance.TransactionCount, 0)
+	}
+	writeAsJson(w, &BalanceResponse{
+		Address:          types.ChecksumAddress(address),
+		Balance:          balance.Balance,
+		TransactionCount: balance.TransactionCount,
+	})
+}
+
+func NewHttpServer(parser parser.Parser, hub *WsHub, addr string) *HttpServer {
+	s := &HttpServer{parser: parser, hub: hub, maxReadyLag: defaultMaxReadyLag, chains: make(map[string]*HttpServer),
+		cors: &corsPolicy{methods: strings.Join(defaultCorsMethods, ", ")}}
+	s.mux = s.routes()
+	s.mux.HandleFunc("/openapi.json", s.HandleOpenApi)
+	s.mux.Handle("/debug/vars", expvar.Handler())
+	s.mux.HandleFunc("POST /admin/setCurrentBlock", s.HandleSetCurrentBlock)
+	s.mux.HandleFunc("POST /admin/pause", s.HandlePause)
+	s.mux.HandleFunc("POST /admin/resume", s.HandleResume)
+	s.mux.HandleFunc("GET /admin/failedBlocks", s.HandleFailedBlocks)
+	s.mux.HandleFunc("POST /admin/retryBlock", s.HandleRetryBlock)
+	s.mux.HandleFunc("POST /admin/reprocess", s.HandleReprocess)
+	s.mux.HandleFunc("GET /admin/jobs/{id}", s.HandleJob)
+	s.mux.HandleFunc("GET /admin/subscriptions/export", s.HandleExportSubscriptions)
+	s.mux.HandleFunc("POST /admin/subscriptions/import", s.HandleImportSubscriptions)
+	s.mux.HandleFunc("GET /admin/whaleThreshold", s.HandleGetWhaleThreshold)
+	s.mux.HandleFunc("POST /admin/whaleThreshold", s.HandleSetWhaleThreshold)
+	// a span per request, continuing the trace of the caller
+	handler := otelhttp.NewHandler(s.logAccess(compress(recoverPanics(s.secure(s.limitClients(s.authenticate(s.mux)))))), "http",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string { return r.Method }))
+	s.server = &http.Server{Addr: addr, Handler: handler}
+	return s
+}
+
+// the endpoints of the parser, under their api version
+func (s *HttpServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, route := range s.endpoints() {
+		handleRoute(mux, route)
+	}
+	mux.HandleFunc("/", handleNotFound)
+	return mux
+}
+
+// name the span of the request after the route rather than the path, which
+// holds addresses and hashes, and log the route with it
+func tracedRoute(path string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		trace.SpanFromContext(r.Context()).SetName(r.Method + " " + path)
+		if entry := requestAccessEntry(r); entry != nil {
+			entry.route = path
+		}
+		handler(w, r)
+	}
+}
+
+// serve the endpoints of the parser of another chain under /{chain}/, e.g.
+// /polygon/GetTransactions/{address}, before the server is used
+func (s *HttpServer) AddChain(name string, parser parser.Parser, hub *WsHub) {
+	chain := &HttpServer{parser: parser, hub: hub, maxReadyLag: s.maxReadyLag, cors: s.cors}
+	if s.responses != nil {
+		chain.responses = newResponseCache(s.responses.size)
+	}
+	s.chains[name] = chain
+	s.mux.Handle("/"+name+"/", http.StripPrefix("/"+name, chain.routes()))
+	s.mux.HandleFunc("POST /"+name+"/admin/setCurrentBlock", chain.HandleSetCurrentBlock)
+	s.mux.HandleFunc("POST /"+name+"/admin/pause", chain.HandlePause)
+	s.mux.HandleFunc("POST /"+name+"/admin/resume", chain.HandleResume)
+	s.mux.HandleFunc("GET /"+name+"/admin/failedBlocks", chain.HandleFailedBlocks)
+	s.mux.HandleFunc("POST /"+name+"/admin/retryBlock", chain.HandleRetryBlock)
+	s.mux.HandleFunc("POST /"+name+"/admin/reprocess", chain.HandleReprocess)
+	s.mux.HandleFunc("GET /"+name+"/admin/jobs/{id}", chain.HandleJob)
+	s.mux.HandleFunc("GET /"+name+"/admin/subscriptions/export", chain.HandleExportSubscriptions)
+	s.mux.HandleFunc("POST /"+name+"/admin/subscriptions/import", chain.HandleImportSubscriptions)
+	s.mux.HandleFunc("GET /"+name+"/admin/whaleThreshold", chain.HandleGetWhaleThreshold)
+	s.mux.HandleFunc("POST /"+name+"/admin/whaleThreshold", chain.HandleSetWhaleThreshold)
+}
+
+// serve until Shutdown is called
+func (s *HttpServer) Serve() {
+	err := s.server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		panic(fmt.Errorf("failed to serve http, err %v", err))
+	}
+}
+
+// stop accepting requests and wait for the in-flight ones, websocket
+// connections are hijacked and not waited for
+func (s *HttpServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+const (
+	notifierQueueSize      = 1024
+	notifierMaxAttempts    = 5
+	notifierInitialBackoff = time.Second
+	notifierMaxBackoff     = 30 * time.Second
+)
+
+// Where the notifier reads the subscriptions and rules of its tenant from,
+// the parser or its storage
+type AddressLabels interface {
+	IsSubscribed(ctx context.Context, tenant, address string) (bool, error)
+	GetAddressLabel(ctx context.Context, tenant, address string) (*types.AddressLabel, error)
+}
+
+// The webhook notifier of a tenant, posts the matched transactions of the
+// addresses the tenant observes to the configured urls when they pass the
+// notification rule of their address, their daily or weekly digests to the
+// digest urls and the whale alerts to the whale urls
+type Notifier struct {
+	tenant string
+	urls   []string
+	labels AddressLabels
+	client *http.Client
+	queue  chan *types.TransactionEvent
+
+	// the digest urls, the name of the period and the digests of the period
+	// so far by lowercase address
+	digestUrls   []string
+	digestPeriod string
+	digestFrom   time.Time
+	digests      map[string]*addressDigest
+
+	// the urls the whale alerts are posted to and their queue
+	whaleUrls []string
+	whales    chan *types.WhaleEvent
+	// guards the urls and the digests, the urls replaced when the config is
+	// reloaded
+	sync.RWMutex
+}
+
+// a notifier of the tenant, "" being the default one
+func NewNotifier(tenant string, urls []string, labels AddressLabels) *Notifier {
+	return &Notifier{
+		tenant: tenant,
+		urls:   urls,
+		labels: labels,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan *types.TransactionEvent, notifierQueueSize),
+
+		digestPeriod: "daily",
+		digestFrom:   time.Now(),
+		digests:      make(map[string]*addressDigest),
+
+		whales: make(chan *types.WhaleEvent, notifierQueueSize),
+	}
+}
+
+// post the events to the urls from now on, none drops them, the ones being
+// delivered still go to the previous urls
+func (n *Notifier) SetUrls(urls []string) {
+	n.Lock()
+	defer n.Unlock()
+	n.urls = urls
+}
+
+// queue matched transactions for delivery, never blocks the parser
+func (n *Notifier) Notify(matches []*types.MatchedTransaction) {
+	for _, m := range matches {
+		select {
+		case n.queue <- types.NewTransactionEvent(m):
+		default:
+			slog.Warn("Webhook queue full, dropping event", "hash", m.Transaction.Hash)
+		}
+	}
+}
+
+// deliver the queued events
+func (n *Notifier) Run() {
+	for event := range n.queue {
+		n.RLock()
+		urls, digesting := n.urls, len(n.digestUrls) > 0
+		n.RUnlock()
+		if len(urls) == 0 && !digesting || !n.passes(event) {
+			continue
+		}
+		if digesting && !event.Pending {
+			n.addToDigest(event)
+		}
+		if len(urls) == 0 {
+			continue
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			slog.Error("Failed to marshal webhook event", "hash", event.Transaction.Hash, "err", err)
+			continue
+		}
+		for _, url := range urls {
+			n.deliver(url, data)
+		}
+	}
+}
+
+// whether the tenant observes the address of the event and it passes the
+// rule of the address, read when delivered so the parser isn't slowed down,
+// the event is posted when the rule can't be read
+func (n *Notifier) passes(event *types.TransactionEvent) bool {
+	ctx := context.Background()
+	address := strings.ToLower(event.Address)
+	subscribed, err := n.labels.IsSubscribed(ctx, n.tenant, address)
+	if err != nil {
+		// never posted to a tenant that may not observe the address
+		slog.Warn("Failed to read subscription, dropping event", "tenant", n.tenant, "address", event.Address, "err", err)
+		return false
+	}
+	if !subscribed {
+		return false
+	}
+	label, err := n.labels.GetAddressLabel(ctx, n.tenant, address)
+	if err != nil {
+		slog.Warn("Failed to read notification rule, posting anyway", "address", event.Address, "err", err)
+		return true
+	}
+	return label == nil || label.Rule.Match(event.Direction, event.Transaction)
+}
+
+func (n *Notifier) deliver(url string, data []byte) {
+	backoff := notifierInitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := n.post(url, data)
+		if err == nil {
+			return
+		}
+		if attempt == notifierMaxAttempts {
+			slog.Error("Webhook failed, dropping event", "url", url, "attempts", attempt, "err", err)
+			return
+		}
+		slog.Warn("Webhook failed, retrying", "url", url, "err", err, "wait", backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, notifierMaxBackoff)
+	}
+}
+
+func (n *Notifier) post(url string, data []byte) error {
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,441 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/passwizards/eth-parser/parser"
+	"github.com/passwizards/eth-parser/types"
+)
+
+// version of the http api in the OpenAPI document
+const apiVersion = "1.0.0"
+
+// An endpoint of the parser, routed and described in the OpenAPI document
+type route struct {
+	path    string
+	summary string
+	handler http.HandlerFunc
+	query   []queryParam
+	// a value of the json reply type, nil for the websocket and streams
+	response interface{}
+	// a value of the optional json body type, accepted over POST too
+	request interface{}
+	// replies with server-sent events
+	events bool
+	// also replies the api/grpcapi messages to Accept: application/x-protobuf,
+	// length-delimited for streams
+	protobuf bool
+	// other statuses replied with by their description, with the response
+	// type when failure is set, an ErrorResponse otherwise
+	statuses map[int]string
+	failure  bool
+	// the api version the endpoint is served under, v1 when 0
+	version int
+	// served at the path alone, e.g. the probes
+	unversioned bool
+}
+
+type queryParam struct {
+	name        string
+	description string
+	schema      *openApiSchema
+}
+
+var (
+	unitsParam = queryParam{"units", "hex quantities, or decimal ones with the value in ether and gas prices in gwei",
+		&openApiSchema{Type: "string", Enum: []string{"hex", "ether"}}}
+	intSchema  = &openApiSchema{Type: "integer", Minimum: new(int)}
+	sinceParam = queryParam{"since", "only the blocks mined from the time, a day like 2024-01-01 in UTC, an RFC 3339 time or unix seconds, " +
+		"narrowing fromBlock", &openApiSchema{Type: "string"}}
+	untilParam  = queryParam{"until", "only the blocks mined before the time, like since, narrowing toBlock", &openApiSchema{Type: "string"}}
+	statusParam = queryParam{"status", "only the transactions that succeeded or failed by their receipt, the ones without a receipt count as succeeded",
+		&openApiSchema{Type: "string", Enum: []string{types.TransactionStatusSuccess, types.TransactionStatusFailed, "all"}}}
+	namesParam = queryParam{"names", "add the ENS names of the counterparties, with the parser run with ens", &openApiSchema{Type: "boolean"}}
+	blobsParam = queryParam{"blobs", "only the transactions carrying blobs, e.g. the batches an L2 posts", &openApiSchema{Type: "boolean"}}
+
+	searchParams = []queryParam{
+		{"hash", "the transaction hash", &openApiSchema{Type: "string"}},
+		{"from", "the sender address", &openApiSchema{Type: "string"}},
+		{"to", "the recipient address", &openApiSchema{Type: "string"}},
+		{"block", "the block number", intSchema},
+		{"minValue", "decimal wei the value must reach", &openApiSchema{Type: "string"}},
+		{"limit", "max matches returned, oldest first, 0 returns all", intSchema},
+	}
+)
+
+// the endpoints of the parser, the same on every chain
+func (s *HttpServer) endpoints() []route {
+	return []route{
+		{path: "/GetCurrentBlock", summary: "Last parsed block", handler: s.HandleGetCurrentBlock,
+			response: &CurrentBlockResponse{}},
+		{path: "/Subscribe/{address}", summary: "Watch the transactions of the address, or the one an ENS name like vitalik.eth resolves to, labelled with the body of a POST, " +
+			"which also sets the rule the webhook notifications of the address pass, replacing the previous label and rule", handler: s.HandleSubscribe,
+			request:  &SubscribeRequest{},
+			response: &SubscribeResponse{}, query: []queryParam{
+				{"ttl", "unwatch the address and drop its data after the duration, e.g. 24h, 0 never expires", &openApiSchema{Type: "string"}},
+			}, statuses: map[int]string{http.StatusNotFound: "the ENS name doesn't resolve", http.StatusBadGateway: "the node failed to resolve the ENS name"}},
+		{path: "/Unsubscribe/{address}", summary: "Stop watching the address", handler: s.HandleUnsubscribe,
+			response: &SubscribeResponse{}},
+		{path: "/Subscriptions", summary: "Watched addresses with their stored activity", handler: s.HandleSubscriptions,
+			response: &SubscriptionsResponse{}},
+		{path: "/CreateGroup/{group}", summary: "Create an empty group of addresses queried together, e.g. the deposit addresses of a customer", handler: s.HandleCreateGroup,
+			response: &GroupResponse{}},
+		{path: "/DeleteGroup/{group}", summary: "Delete the group, its addresses stay watched", handler: s.HandleDeleteGroup,
+			response: &GroupResponse{}},
+		{path: "/AddToGroup/{group}/{address}", summary: "Add the address to the group, watching it when it isn't", handler: s.HandleAddToGroup,
+			response: &GroupAddressResponse{}, statuses: map[int]string{http.StatusNotFound: "the group doesn't exist"}},
+		{path: "/RemoveFromGroup/{group}/{address}", summary: "Take the address out of the group, it stays watched", handler: s.HandleRemoveFromGroup,
+			response: &GroupAddressResponse{}, statuses: map[int]string{http.StatusNotFound: "the group doesn't exist"}},
+		{path: "/Groups", summary: "Groups with their addresses, ordered by name", handler: s.HandleGroups,
+			response: &GroupsResponse{}},
+		{path: "/GetGroupTransactions/{group}", summary: "Stored transactions of the addresses of the group, ordered by block and index in the block, " +
+			"a transaction between two members comes once for each", handler: s.HandleGetGroupTransactions,
+			response: &GroupTransactionsResponse{}, query: []queryParam{
+				{"direction", "only inbound or outbound transactions of the members", &openApiSchema{Type: "string", Enum: []string{types.DirectionIn, types.DirectionOut}}},
+				statusParam,
+				{"fromBlock", "first block of the range", intSchema},
+				{"toBlock", "last block of the range, 0 has no upper bound", intSchema},
+				{"offset", "matches skipped", intSchema},
+				{"limit", "max matches returned, 0 returns all", intSchema},
+				unitsParam,
+			},
+			statuses: map[int]string{http.StatusNotFound: "the group doesn't exist"}},
+		{path: "/Backfill/{address}", summary: "Queue a scan of past blocks for transactions of the subscribed address, stored as they are found", handler: s.HandleBackfill,
+			response: &BackfillResponse{}, query: []queryParam{
+				{"fromBlock", "first block of the range", intSchema},
+				{"toBlock", "last block of the range, 0 or past the current block stops at the current block", intSchema},
+			}, statuses: map[int]string{http.StatusConflict: "another instance sharing the storage parses, read-only or leading"}},
+		{path: "/GetTransactions/{address}", summary: "Stored transactions of the address or ENS name, oldest first, " +
+			"with an ETag replied 304 in the If-None-Match of a request until they change", handler: s.HandleGetTransactions,
+			response: &TransactionsResponse{}, protobuf: true, query: []queryParam{
+				{"direction", "only inbound or outbound transactions", &openApiSchema{Type: "string", Enum: []string{types.DirectionIn, types.DirectionOut}}},
+				statusParam,
+				blobsParam,
+				{"fromBlock", "first block of the range", intSchema},
+				{"toBlock", "last block of the range, 0 has no upper bound", intSchema},
+				sinceParam,
+				untilParam,
+				{"offset", "matches skipped", intSchema},
+				{"limit", "max matches returned, 0 returns all", intSchema},
+				unitsParam,
+				formatParam,
+				namesParam,
+			}, statuses: map[int]string{http.StatusNotFound: "the ENS name doesn't resolve", http.StatusBadGateway: "the node failed to answer the timestamp of a block or the ENS name"}},
+		{path: "/GetTransaction/{hash}", summary: "A transaction by hash, from storage or else the node with its receipt", handler: s.HandleGetTransaction,
+			response: &TransactionResponse{}, query: []queryParam{unitsParam},
+			statuses: map[int]string{http.StatusNotFound: "neither the storage nor the node knows the transaction", http.StatusBadGateway: "the node failed to answer"}},
+		{path: "/Search", summary: "Stored transactions of every watched address matching all the given fields, each once whichever addresses it matched, " +
+			"looked up by the hash, from, to and block indexes of the storage so one of them is needed, only the ones of its subscriptions for a tenant key", handler: s.HandleSearch,
+			response: &SearchResponse{}, query: append(searchParams, unitsParam),
+			statuses: map[int]string{http.StatusBadRequest: "none of hash, from, to or block is given"}},
+		{path: "/GetPendingTransactions/{address}", summary: "Transactions of the address seen in the mempool and not parsed in a block yet, oldest first", handler: s.HandleGetPendingTransactions,
+			response: &TransactionsResponse{}, query: []queryParam{unitsParam}},
+		{path: "/GetTransactions/{address}", version: apiV2, summary: "Stored transactions of the address like the v1 GetTransactions, " +
+			"with camelCase keys, counters as numbers, wei amounts as decimal strings and missing fields left out", handler: s.HandleGetTransactions,
+			response: &TransactionsV2Response{}, query: []queryParam{
+				{"direction", "only inbound or outbound transactions", &openApiSchema{Type: "string", Enum: []string{types.DirectionIn, types.DirectionOut}}},
+				statusParam,
+				blobsParam,
+				{"fromBlock", "first block of the range", intSchema},
+				{"toBlock", "last block of the range, 0 has no upper bound", intSchema},
+				sinceParam,
+				untilParam,
+				{"offset", "matches skipped", intSchema},
+				{"limit", "max matches returned, 0 returns all", intSchema},
+				namesParam,
+			}, statuses: map[int]string{http.StatusNotFound: "the ENS name doesn't resolve", http.StatusBadGateway: "the node failed to answer the timestamp of a block or the ENS name"}},
+		{path: "/GetTransaction/{hash}", version: apiV2, summary: "A transaction by hash like the v1 GetTransaction, in the shape of /v2/GetTransactions", handler: s.HandleGetTransaction,
+			response: &TransactionV2Response{},
+			statuses: map[int]string{http.StatusNotFound: "neither the storage nor the node knows the transaction", http.StatusBadGateway: "the node failed to answer"}},
+		{path: "/Search", version: apiV2, summary: "Stored transactions matching the search like the v1 Search, in the shape of /v2/GetTransactions", handler: s.HandleSearch,
+			response: &SearchV2Response{}, query: searchParams,
+			statuses: map[int]string{http.StatusBadRequest: "none of hash, from, to or block is given"}},
+		{path: "/GetPendingTransactions/{address}", version: apiV2, summary: "Mempool transactions of the address like the v1 GetPendingTransactions, in the shape of /v2/GetTransactions", handler: s.HandleGetPendingTransactions,
+			response: &TransactionsV2Response{}},
+		{path: "/GetSweeps/{address}", summary: "Stored sweeps of the deposit address into the hot wallets, transfers moving nearly its whole balance, oldest first; " +
+			"into the hot wallet from every deposit address when it is subscribed too", handler: s.HandleGetSweeps,
+			response: &TransactionsResponse{}, query: []queryParam{
+				{"fromBlock", "first block of the range", intSchema},
+				{"toBlock", "last block of the range, 0 has no upper bound", intSchema},
+				{"offset", "matches skipped", intSchema},
+				{"limit", "max matches returned, 0 returns all", intSchema},
+				unitsParam,
+			}},
+		{path: "/GetTokenTransfers/{address}", summary: "Stored ERC-20 transfers of the address, with the name and decimals of their token and the amount in whole tokens", handler: s.HandleGetTokenTransfers,
+			response: &TokenTransfersResponse{}},
+		{path: "/GetTokenMetadata/{address}", summary: "Symbol, name and decimals of the token contract, read from the node once and cached", handler: s.HandleGetTokenMetadata,
+			response: &TokenMetadataResponse{}, statuses: map[int]string{http.StatusBadGateway: "the node failed to answer"}},
+		{path: "/GetNFTTransfers/{address}", summary: "Stored ERC-721 and ERC-1155 transfers of the address, one per token of a batch", handler: s.HandleGetNftTransfers,
+			response: &NftTransfersResponse{}},
+		{path: "/GetStats/{address}", summary: "Totals received and sent, transaction counts per direction, first and last block and average gas price paid, " +
+			"computed by the storage over the stored transactions of the address", handler: s.HandleGetStats,
+			response: &StatsResponse{}, query: []queryParam{unitsParam}},
+		{path: "/GetBalanceHistory/{address}", summary: "Balance changes of the address per block with a stored transaction, oldest first, " +
+			"rebuilt from the stored transactions and the fees of their receipts; it matches the chain once they go back to the first one of the address", handler: s.HandleGetBalanceHistory,
+			response: &BalanceHistoryResponse{}, query: []queryParam{
+				{"fromBlock", "first block of the range, the changes before it still add up to the balance", intSchema},
+				{"toBlock", "last block of the range, 0 has no upper bound", intSchema},
+				unitsParam,
+			}},
+		{path: "/SubscribeEvent/{address}", summary: "Watch the events of the contract with the topic0, " +
+			"their logs are stored as blocks are parsed and decoded when the ABI of the contract is registered", handler: s.HandleSubscribeEvent,
+			response: &EventSubscribeResponse{}, query: []queryParam{topicParam, eventParam}},
+		{path: "/UnsubscribeEvent/{address}", summary: "Stop watching the events of the contract with the topic0, dropping the stored ones", handler: s.HandleUnsubscribeEvent,
+			response: &EventSubscribeResponse{}, query: []queryParam{topicParam, eventParam}},
+		{path: "/EventSubscriptions", summary: "Watched contract events", handler: s.HandleEventSubscriptions,
+			response: &EventSubscriptionsResponse{}},
+		{path: "/GetEvents/{address}", summary: "Stored events of the contract, oldest first", handler: s.HandleGetEvents,
+			response: &EventsResponse{}, query: []queryParam{
+				topicParam,
+				eventParam,
+				{"fromBlock", "first block of the range", intSchema},
+				{"toBlock", "last block of the range, 0 has no upper bound", intSchema},
+				{"offset", "events skipped", intSchema},
+				{"limit", "max events returned, 0 returns all", intSchema},
+			}},
+		{path: "/GetBlock/{number}", summary: "Metadata of a parsed block, its timestamp and base fee included", handler: s.HandleGetBlock,
+			response: &BlockResponse{}, query: []queryParam{unitsParam},
+			statuses: map[int]string{http.StatusNotFound: "the block wasn't parsed or was pruned"}},
+		{path: "/GasPrice", summary: "Percentiles of the effective gas prices and priority fees paid in the last parsed blocks " +
+			"and their base fees, for fee estimation; the blocks parsed since the start are kept", handler: s.HandleGasPrice,
+			response: &GasPriceResponse{}, query: []queryParam{
+				{"blocks", "how many of the last blocks, 1 to " + strconv.Itoa(parser.GasPriceBlocks) + ", defaults to " + strconv.Itoa(defaultGasPriceBlocks), intSchema},
+				unitsParam,
+			},
+			statuses: map[int]string{http.StatusServiceUnavailable: "no block was parsed yet"}},
+		{path: "/GetBalance/{address}", summary: "Live balance and nonce of the address, read from the node", handler: s.HandleGetBalance,
+			response: &BalanceResponse{}, query: []queryParam{unitsParam},
+			statuses: map[int]string{http.StatusBadGateway: "the node failed to answer"}},
+		{path: "/Stream/{address}", summary: "Stream the transactions of the address as server-sent TransactionEvent messages, " +
+			"the event ids are blocks and a reconnecting client resumes after the block in its Last-Event-ID, " +
+			"mempool transactions come as pending events without an id", handler: s.HandleStream,
+			events: true, protobuf: true, query: []queryParam{
+				{"lastEventId", "resume after the block on the first connection, later ones send the Last-Event-ID header", intSchema},
+			}},
+		{path: "/ws", summary: "Stream the transactions of the addresses subscribed over the websocket as TransactionEvent messages, " +
+			`commands are {"action": "subscribe" or "unsubscribe", "address": ...}`, handler: s.HandleWebSocket},
+		{path: "/healthz", unversioned: true, summary: "Liveness, fails while a parser loop is wedged", handler: s.HandleHealthz,
+			response: &HealthResponse{}, statuses: map[int]string{http.StatusServiceUnavailable: "a parser loop is wedged"}, failure: true},
+		{path: "/readyz", unversioned: true, summary: "Readiness, fails while the rpc is unreachable or a parser lags behind", handler: s.HandleReadyz,
+			response: &HealthResponse{}, statuses: map[int]string{http.StatusServiceUnavailable: "not ready"}, failure: true},
+	}
+}
+
+// The subset of OpenAPI 3 the parser api is described with
+type openApiDocument struct {
+	OpenApi    string                                  `json:"openapi"`
+	Info       openApiInfo                             `json:"info"`
+	Paths      map[string]map[string]*openApiOperation `json:"paths"`
+	Components struct {
+		Schemas         map[string]*openApiSchema         `json:"schemas"`
+		SecuritySchemes map[string]*openApiSecurityScheme `json:"securitySchemes"`
+	} `json:"components"`
+	// alternatives, the empty one for servers without api keys
+	Security []map[string][]string `json:"security"`
+}
+
+type openApiSecurityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type openApiInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+}
+
+type openApiOperation struct {
+	Summary     string                      `json:"summary"`
+	Parameters  []*openApiParameter         `json:"parameters,omitempty"`
+	RequestBody *openApiRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openApiResponse `json:"responses"`
+}
+
+type openApiRequestBody struct {
+	Required bool                         `json:"required,omitempty"`
+	Content  map[string]*openApiMediaType `json:"content"`
+}
+
+type openApiParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *openApiSchema `json:"schema"`
+}
+
+type openApiResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*openApiMediaType `json:"content,omitempty"`
+}
+
+type openApiMediaType struct {
+	Schema *openApiSchema `json:"schema"`
+}
+
+type openApiSchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Enum                 []string                  `json:"enum,omitempty"`
+	Minimum              *int                      `json:"minimum,omitempty"`
+	Items                *openApiSchema            `json:"items,omitempty"`
+	Properties           map[string]*openApiSchema `json:"properties,omitempty"`
+	AdditionalProperties *openApiSchema            `json:"additionalProperties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+}
+
+var pathParamPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+var pathParamDescriptions = map[string]string{
+	"address": "hex address, checksummed or lowercase",
+	"group":   "name of the group, up to 64 letters, digits, '_', '.' or '-'",
+	"hash":    "hex transaction hash",
+	"number":  "block number, decimal or 0x prefixed hex",
+}
+
+// the OpenAPI document of the endpoints, built once from the routes and the
+// reply types
+var openApiSpec = sync.OnceValue(func() *openApiDocument {
+	doc := &openApiDocument{
+		OpenApi: "3.0.3",
+		Info: openApiInfo{
+			Title: "eth-parser",
+			Description: "Follows the chain and stores the transactions of the subscribed addresses. " +
+				"The v1 endpoints are also served at their unversioned legacy paths, e.g. /GetTransactions/{address}. " +
+				"The endpoints of other chains are served the same under /{chain}/, e.g. /polygon/v1/GetTransactions/{address}.",
+			Version: apiVersion,
+		},
+		Paths: make(map[string]map[string]*openApiOperation),
+	}
+	doc.Components.Schemas = make(map[string]*openApiSchema)
+	doc.Components.SecuritySchemes = map[string]*openApiSecurityScheme{
+		"apiKey": {Type: "apiKey", In: "header", Name: ApiKeyHeader,
+			Description: "required when the server is run with api keys, except by the probes and this document"},
+	}
+	doc.Security = []map[string][]string{{"apiKey": {}}, {}}
+	// streamed over the websocket, not the reply of any endpoint
+	schemaOf(reflect.TypeOf(types.TransactionEvent{}), doc.Components.Schemas)
+	errorSchema := schemaOf(reflect.TypeOf(ErrorResponse{}), doc.Components.Schemas)
+
+	for _, route := range (&HttpServer{}).endpoints() {
+		op := &openApiOperation{Summary: route.summary, Responses: make(map[string]*openApiResponse)}
+		path := route.versionedPath()
+		for _, match := range pathParamPattern.FindAllStringSubmatch(route.path, -1) {
+			op.Parameters = append(op.Parameters, &openApiParameter{Name: match[1], In: "path", Required: true,
+				Description: pathParamDescriptions[match[1]], Schema: &openApiSchema{Type: "string"}})
+		}
+		for _, param := range route.query {
+			op.Parameters = append(op.Parameters, &openApiParameter{Name: param.name, In: "query",
+				Description: param.description, Schema: param.schema})
+		}
+		body := errorSchema
+		switch {
+		case route.events:
+			op.Responses["200"] = &openApiResponse{Description: "transaction events, stored ones first", Content: map[string]*openApiMediaType{
+				"text/event-stream": {Schema: &openApiSchema{Type: "string"}},
+			}}
+		case route.response == nil:
+			op.Responses["101"] = &openApiResponse{Description: "switching to the websocket protocol"}
+		default:
+			schema := schemaOf(reflect.TypeOf(route.response), doc.Components.Schemas)
+			op.Responses["200"] = jsonResponse("ok", schema)
+			if route.failure {
+				body = schema
+			}
+		}
+		if route.protobuf {
+			op.Responses["200"].Content[protobufType] = &openApiMediaType{Schema: &openApiSchema{Type: "string", Format: "binary"}}
+		}
+		if len(op.Parameters) > 0 {
+			op.Responses["400"] = jsonResponse("malformed path or query", errorSchema)
+		}
+		if !publicPaths[route.path] {
+			op.Responses["401"] = jsonResponse("missing or unknown api key", errorSchema)
+			op.Responses["429"] = jsonResponse("rate limit of the api key exceeded", errorSchema)
+		}
+		op.Responses["500"] = jsonResponse("the server failed, e.g. its storage", errorSchema)
+		for status, description := range route.statuses {
+			op.Responses[strconv.Itoa(status)] = jsonResponse(description, body)
+		}
+		doc.Paths[path] = map[string]*openApiOperation{"get": op}
+		if route.request != nil {
+			post := *op
+			post.RequestBody = &openApiRequestBody{Content: map[string]*openApiMediaType{
+				"application/json": {Schema: schemaOf(reflect.TypeOf(route.request), doc.Components.Schemas)},
+			}}
+			doc.Paths[path]["post"] = &post
+		}
+	}
+	return doc
+})
+
+func jsonResponse(description string, schema *openApiSchema) *openApiResponse {
+	return &openApiResponse{Description: description, Content: map[string]*openApiMediaType{
+		"application/json": {Schema: schema},
+	}}
+}
+
+// the schema of the json encoding of t, structs are added to the components
+// by their name and referenced
+func schemaOf(t reflect.Type, components map[string]*openApiSchema) *openApiSchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return &openApiSchema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Struct:
+		ref := &openApiSchema{Ref: "#/components/schemas/" + t.Name()}
+		if _, ok := components[t.Name()]; ok {
+			return ref
+		}
+		schema := &openApiSchema{Type: "object", Properties: make(map[string]*openApiSchema)}
+		// registered before the fields so recursive types end
+		components[t.Name()] = schema
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			schema.Properties[name] = schemaOf(field.Type, components)
+			if !strings.Contains(opts, "omitempty") {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+		return ref
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return &openApiSchema{Type: "array", Items: schemaOf(t.Elem(), components)}
+	case t.Kind() == reflect.Map:
+		return &openApiSchema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), components)}
+	case t.Kind() == reflect.String:
+		return &openApiSchema{Type: "string"}
+	case t.Kind() == reflect.Bool:
+		return &openApiSchema{Type: "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return &openApiSchema{Type: "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return &openApiSchema{Type: "number"}
+	}
+	// any json value, e.g. interface{}
+	return &openApiSchema{}
+}
+
+// the OpenAPI 3 document of the http api
+func (s *HttpServer) HandleOpenApi(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	writeAsJson(w, openApiSpec())
+}
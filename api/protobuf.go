@@ -0,0 +1,67 @@
+package api
+
+import (
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/passwizards/eth-parser/api/grpcapi"
+	"github.com/passwizards/eth-parser/types"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// the content type of the protocol buffers replies, the messages are the ones
+// of the gRPC service in api/grpcapi/ethparser.proto; streams send them
+// varint length-delimited
+const protobufType = "application/x-protobuf"
+
+// whether the Accept header prefers protocol buffers to json, they win when
+// accepted as much; a missing header or a wildcard keeps json
+func acceptsProtobuf(header string) bool {
+	protobufQ, jsonQ := 0.0, 0.0
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if parsed, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			q = parsed
+		}
+		switch mediaType {
+		case protobufType, "application/protobuf":
+			protobufQ = max(protobufQ, q)
+		case "application/json", "application/*", "*/*":
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	return protobufQ > 0 && protobufQ >= jsonQ
+}
+
+// the protocol buffers of the message, panics like json.Marshal of a reply
+// type can't fail
+func marshalProtobuf(msg proto.Message) []byte {
+	bytes, err := proto.Marshal(msg)
+	if err != nil {
+		panic(fmt.Errorf("failed to marshal value, err %v", err))
+	}
+	return bytes
+}
+
+// the message of the transactions reply
+func toProtobufTransactions(resp *TransactionsResponse) *grpcapi.GetTransactionsResponse {
+	msg := &grpcapi.GetTransactionsResponse{Address: resp.Address, Label: resp.Label}
+	for _, tx := range resp.Transactions {
+		msg.Transactions = append(msg.Transactions, toGrpcTransaction(tx))
+	}
+	return msg
+}
+
+// append the event length-delimited, for streams of protocol buffers
+func appendProtobufEvent(buf []byte, event *types.TransactionEvent) []byte {
+	msg := marshalProtobuf(toGrpcEvent(event))
+	buf = protowire.AppendVarint(buf, uint64(len(msg)))
+	return append(buf, msg...)
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// how often the buckets of clients gone quiet are dropped
+const clientSweepInterval = time.Minute
+
+// The request budget of every client ip, for a server exposed publicly
+// without a gateway in front of it
+type clientLimits struct {
+	// requests per second of every client, 0 is unlimited
+	rate  float64
+	burst float64
+	// buckets by client ip, an ipv6 client by its /64
+	clients map[string]*keyLimiter
+	swept   time.Time
+	sync.Mutex
+}
+
+// limit every client ip to rate requests per second, burst of them at once,
+// 0 a second worth, with 429 and Retry-After past it; 0 is unlimited; ipv6
+// clients share the budget of their /64, which a single host usually gets
+// whole; also while the server is used, e.g. when the config is reloaded
+func (s *HttpServer) SetClientRateLimit(rate float64, burst int) {
+	s.clientLimits.Lock()
+	defer s.clientLimits.Unlock()
+	s.clientLimits.rate, s.clientLimits.burst = rate, float64(burst)
+	if burst <= 0 {
+		s.clientLimits.burst = math.Ceil(rate)
+	}
+	s.clientLimits.clients = make(map[string]*keyLimiter)
+}
+
+// the bucket of the client, nil when unlimited
+func (l *clientLimits) limiter(client string) *keyLimiter {
+	l.Lock()
+	defer l.Unlock()
+	if l.rate <= 0 {
+		return nil
+	}
+	now := time.Now()
+	if now.Sub(l.swept) >= clientSweepInterval {
+		l.sweep(now)
+	}
+	limiter, ok := l.clients[client]
+	if !ok {
+		limiter = newBurstLimiter(l.rate, l.burst)
+		l.clients[client] = limiter
+	}
+	return limiter
+}
+
+// drop the buckets refilled since, a new one is as full; locked by the caller
+func (l *clientLimits) sweep(now time.Time) {
+	l.swept = now
+	for client, limiter := range l.clients {
+		limiter.Lock()
+		full := limiter.tokens+now.Sub(limiter.last).Seconds()*limiter.rate >= limiter.burst
+		limiter.Unlock()
+		if full {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// the ip the request came from, the /64 of an ipv6 one; forwarding headers
+// are ignored as anyone can set them without a proxy in front
+func clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip.To4() == nil {
+		return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	}
+	return ip.String()
+}
+
+// reject the requests over the budget of their client ip with 429, before
+// their api key is checked; the public paths are never limited
+func (s *HttpServer) limitClients(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.isPublic(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := s.clientLimits.limiter(clientIp(r)).allow(); !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, errors.New("rate limit of the client ip exceeded"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,308 @@
+package api
+
+import (
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// The http responses, addresses are checksummed
+
+type CurrentBlockResponse struct {
+	CurrentBlock int `json:"currentBlock"`
+}
+
+// The optional body of a Subscribe POST, labelling the address and setting
+// the rule its webhook notifications pass
+type SubscribeRequest struct {
+	Label    string                  `json:"label"`
+	Metadata map[string]interface{}  `json:"metadata,omitempty"`
+	Rule     *types.NotificationRule `json:"rule,omitempty"`
+}
+
+// The body of /admin/setCurrentBlock, clear drops what is stored past the
+// block instead of keeping it
+type SetCurrentBlockRequest struct {
+	Block *int `json:"block"`
+	Clear bool `json:"clear"`
+}
+
+// The body of /admin/retryBlock, a block listed on /admin/failedBlocks
+type RetryBlockRequest struct {
+	Block *int `json:"block"`
+}
+
+// The reply to /admin/retryBlock, success is false when the block isn't a
+// failed one
+type RetryBlockResponse struct {
+	Block   int  `json:"block"`
+	Success bool `json:"success"`
+}
+
+type ReloadResponse struct {
+	Success bool `json:"success"`
+}
+
+type FailedBlocksResponse struct {
+	Blocks []*types.FailedBlock `json:"blocks"`
+}
+
+// The body of /admin/reprocess, a range of parsed blocks
+type ReprocessRequest struct {
+	From *int `json:"from"`
+	To   *int `json:"to"`
+}
+
+// The reply to /admin/subscriptions/import, the subscriptions in the file
+// and how many of them were new
+type ImportSubscriptionsResponse struct {
+	Subscriptions int `json:"subscriptions"`
+	Added         int `json:"added"`
+}
+
+// The reply to /admin/pause and /admin/resume, Success is false when the
+// parser already was
+type PauseResponse struct {
+	Paused  bool `json:"paused"`
+	Success bool `json:"success"`
+}
+
+// The body of POST /admin/whaleThreshold, in ether
+type WhaleThresholdRequest struct {
+	Threshold *string `json:"threshold"`
+}
+
+// The whale threshold in ether, empty when the alerts are disabled
+type WhaleThresholdResponse struct {
+	Threshold string `json:"threshold"`
+}
+
+// The reply to /admin/reprocess and /admin/jobs/{id}
+type JobResponse struct {
+	Job *types.ReprocessJob `json:"job"`
+}
+
+// A contract with a registered ABI and the signatures of its functions
+type AbiResponse struct {
+	Address string   `json:"address"`
+	Methods []string `json:"methods"`
+	Events  []string `json:"events"`
+}
+
+type AbisResponse struct {
+	Contracts []*AbiResponse `json:"contracts"`
+}
+
+// The reply to Backfill, success is false when the address isn't subscribed
+// or the range is empty
+type BackfillResponse struct {
+	Address   string `json:"address"`
+	Success   bool   `json:"success"`
+	FromBlock int    `json:"fromBlock"`
+	ToBlock   int    `json:"toBlock"`
+}
+
+// The reply to Subscribe and Unsubscribe, success is false when nothing
+// changed
+type SubscribeResponse struct {
+	Address  string                  `json:"address"`
+	Success  bool                    `json:"success"`
+	Label    string                  `json:"label,omitempty"`
+	Metadata map[string]interface{}  `json:"metadata,omitempty"`
+	Rule     *types.NotificationRule `json:"rule,omitempty"`
+	// when the address is unwatched, set by a ttl
+	Expires *time.Time `json:"expires,omitempty"`
+	// the ENS name the address was resolved from
+	Name string `json:"name,omitempty"`
+}
+
+type SubscriptionsResponse struct {
+	Subscriptions []*SubscriptionResponse `json:"subscriptions"`
+}
+
+type SubscriptionResponse struct {
+	Address          string `json:"address"`
+	TransactionCount int    `json:"transactionCount"`
+	// blocks of the first and the last stored transaction, 0 without any
+	FirstBlock int                     `json:"firstBlock"`
+	LastBlock  int                     `json:"lastBlock"`
+	Label      string                  `json:"label,omitempty"`
+	Metadata   map[string]interface{}  `json:"metadata,omitempty"`
+	Rule       *types.NotificationRule `json:"rule,omitempty"`
+	Expires    *time.Time              `json:"expires,omitempty"`
+}
+
+// The reply to CreateGroup and DeleteGroup, success is false when nothing
+// changed
+type GroupResponse struct {
+	Group   string `json:"group"`
+	Success bool   `json:"success"`
+}
+
+// The reply to AddToGroup and RemoveFromGroup, success is false when nothing
+// changed
+type GroupAddressResponse struct {
+	Group   string `json:"group"`
+	Address string `json:"address"`
+	Success bool   `json:"success"`
+}
+
+type GroupsResponse struct {
+	Groups []*GroupMembersResponse `json:"groups"`
+}
+
+type GroupMembersResponse struct {
+	Group     string   `json:"group"`
+	Addresses []string `json:"addresses"`
+}
+
+// The transactions of the addresses of the group ordered by block, each with
+// the member it matched and its direction relative to it
+type GroupTransactionsResponse struct {
+	Group        string                    `json:"group"`
+	Transactions []*types.TransactionEvent `json:"transactions"`
+}
+
+// The transactions of the address, with its label when it has one
+type TransactionsResponse struct {
+	Address      string                 `json:"address"`
+	Label        string                 `json:"label,omitempty"`
+	Metadata     map[string]interface{} `json:"metadata,omitempty"`
+	Transactions []*types.Transaction   `json:"transactions"`
+	// the ENS name the address was resolved from
+	Name string `json:"name,omitempty"`
+	// the ENS names of the counterparties with one, on ?names=true
+	Names map[string]string `json:"names,omitempty"`
+}
+
+// The transaction with its receipt fields, they are missing while it is
+// pending or for stored ones parsed without receipts
+type TransactionResponse struct {
+	Transaction *types.Transaction `json:"transaction"`
+}
+
+// The transactions of the address in the v2 shape, with its label when it
+// has one
+type TransactionsV2Response struct {
+	Address      string                 `json:"address"`
+	Label        string                 `json:"label,omitempty"`
+	Metadata     map[string]interface{} `json:"metadata,omitempty"`
+	Transactions []*TransactionV2       `json:"transactions"`
+	// the ENS name the address was resolved from
+	Name string `json:"name,omitempty"`
+	// the ENS names of the counterparties with one, on ?names=true
+	Names map[string]string `json:"names,omitempty"`
+}
+
+// The transaction in the v2 shape
+type TransactionV2Response struct {
+	Transaction *TransactionV2 `json:"transaction"`
+}
+
+// The stored transactions matching a search, oldest first
+type SearchResponse struct {
+	Transactions []*types.Transaction `json:"transactions"`
+}
+
+// The stored transactions matching a search in the v2 shape
+type SearchV2Response struct {
+	Transactions []*TransactionV2 `json:"transactions"`
+}
+
+// The aggregates of the stored transactions of the address, quantities in
+// the units asked for
+type StatsResponse struct {
+	Address       string `json:"address"`
+	TotalReceived string `json:"totalReceived"`
+	TotalSent     string `json:"totalSent"`
+	IncomingCount int    `json:"incomingCount"`
+	OutgoingCount int    `json:"outgoingCount"`
+	// blocks of the first and the last stored transaction, 0 without any
+	FirstBlock int `json:"firstBlock"`
+	LastBlock  int `json:"lastBlock"`
+	// of the outbound transactions, empty without any
+	AverageGasPrice string `json:"averageGasPrice,omitempty"`
+}
+
+// The balance changes of the address per block of its stored transactions,
+// oldest first, quantities in the units asked for
+type BalanceHistoryResponse struct {
+	Address string                 `json:"address"`
+	Changes []*types.BalanceChange `json:"changes"`
+}
+
+// The metadata of a parsed block
+type BlockResponse struct {
+	Block *types.BlockMetadata `json:"block"`
+}
+
+// The gas prices paid in the last parsed blocks
+type GasPriceResponse struct {
+	GasPrice *types.GasPrice `json:"gasPrice"`
+}
+
+type TokenTransfersResponse struct {
+	Address   string                 `json:"address"`
+	Transfers []*types.TokenTransfer `json:"transfers"`
+}
+
+type TokenMetadataResponse struct {
+	Token    string `json:"token"`
+	Symbol   string `json:"symbol"`
+	Name     string `json:"name"`
+	Decimals *int   `json:"decimals,omitempty"`
+}
+
+type NftTransfersResponse struct {
+	Address   string               `json:"address"`
+	Transfers []*types.NftTransfer `json:"transfers"`
+}
+
+// The reply to SubscribeEvent and UnsubscribeEvent, success is false when
+// the event was already watched or wasn't
+type EventSubscribeResponse struct {
+	Contract string `json:"contract"`
+	Topic    string `json:"topic"`
+	Success  bool   `json:"success"`
+}
+
+type EventSubscriptionsResponse struct {
+	Subscriptions []*EventSubscriptionResponse `json:"subscriptions"`
+}
+
+type EventSubscriptionResponse struct {
+	Contract string `json:"contract"`
+	Topic    string `json:"topic"`
+}
+
+type EventsResponse struct {
+	Contract string         `json:"contract"`
+	Events   []*types.Event `json:"events"`
+}
+
+// The live balance, hex quantities or decimal ones with ?units=ether
+type BalanceResponse struct {
+	Address          string `json:"address"`
+	Balance          string `json:"balance"`
+	TransactionCount string `json:"transactionCount"`
+}
+
+// The reply to /healthz and /readyz, chains only with other chains parsed
+type HealthResponse struct {
+	Ok       bool                     `json:"ok"`
+	Problems []string                 `json:"problems"`
+	Status   *types.Status            `json:"status"`
+	Chains   map[string]*types.Status `json:"chains,omitempty"`
+}
+
+// The reply of failed requests, with a status other than 200
+type ErrorResponse struct {
+	Error *ApiError `json:"error"`
+}
+
+// What failed, the code is stable for clients to branch on, e.g. not_found,
+// the message is meant for humans
+type ApiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
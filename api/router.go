@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// The versions of the http api, a breaking change of a reply ships under a
+// new one while the endpoints of the older ones stay as they are
+const (
+	apiV1 = 1
+	apiV2 = 2
+)
+
+// The context key of the api version of a request
+type apiVersionKey struct{}
+
+// the api version of the endpoint, v1 for the legacy paths
+func requestVersion(r *http.Request) int {
+	version, _ := r.Context().Value(apiVersionKey{}).(int)
+	return max(version, apiV1)
+}
+
+// the version of the endpoint, v1 when unset
+func (r route) servedVersion() int {
+	return max(r.version, apiV1)
+}
+
+// the path the endpoint is served and documented at, e.g.
+// /v1/GetTransactions/{address}
+func (r route) versionedPath() string {
+	if r.unversioned {
+		return r.path
+	}
+	return fmt.Sprintf("/v%d%s", r.servedVersion(), r.path)
+}
+
+// serve the endpoint at its versioned path, a v1 one also at its legacy
+// unversioned path; the handler sees the version with requestVersion
+func handleRoute(mux *http.ServeMux, route route) {
+	version := route.servedVersion()
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if version == apiV2 && !checkV2Query(w, r) {
+			return
+		}
+		route.handler(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, version)))
+	}
+	path := route.versionedPath()
+	mux.HandleFunc(path, tracedRoute(path, handler))
+	if version == apiV1 && !route.unversioned {
+		mux.HandleFunc(route.path, tracedRoute(route.path, handler))
+	}
+}
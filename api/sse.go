@@ -0,0 +1,141 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+const (
+	sseSendQueueSize     = 256
+	sseWriteTimeout      = 10 * time.Second
+	sseHeartbeatInterval = 15 * time.Second
+	// how long browsers wait before reconnecting a dropped stream
+	sseRetry = 3 * time.Second
+)
+
+// A server-sent events client, its events are written by the handler
+type sseClient struct {
+	remote string
+	send   chan *types.TransactionEvent
+}
+
+// push an event to the client, drops it if the client can't keep up
+func (c *sseClient) push(v interface{}) {
+	select {
+	case c.send <- v.(*types.TransactionEvent):
+	default:
+		slog.Warn("SSE client too slow, dropping message", "remote", c.remote)
+	}
+}
+
+// stream the transactions of the address as server-sent events, the id of an
+// event is its block so a reconnecting client resumes after the block in its
+// Last-Event-ID, or ?lastEventId on the first connection, a new client gets
+// the stored transactions first; pending transactions come as pending events
+// without an id; with Accept: application/x-protobuf the events come as
+// length-delimited TransactionEvent messages, an empty one being a heartbeat
+func (s *HttpServer) HandleStream(w http.ResponseWriter, r *http.Request) {
+	address, ok := pathAddress(w, r)
+	if !ok {
+		return
+	}
+	var filter types.TransactionFilter
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("lastEventId")
+	}
+	if lastEventId != "" {
+		block, err := strconv.ParseInt(lastEventId, 0, 0)
+		if err != nil || block < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid last event id %q, expected a block number", lastEventId))
+			return
+		}
+		filter.FromBlock = int(block) + 1
+	}
+
+	if _, err := s.parser.Subscribe(r.Context(), requestTenant(r), address); err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+	c := &sseClient{remote: r.RemoteAddr, send: make(chan *types.TransactionEvent, sseSendQueueSize)}
+	// register before replaying so nothing parsed meanwhile is missed
+	s.hub.add(address, c)
+	defer s.hub.remove(address, c)
+	txs, err := s.parser.QueryTransactions(r.Context(), address, filter)
+	if err != nil {
+		writeStorageError(w, r, err)
+		return
+	}
+
+	// protocol buffers clients get the events length-delimited instead, they
+	// resume from the block of the last event too
+	protobuf := acceptsProtobuf(r.Header.Get("Accept"))
+	w.Header().Set("Content-Type", "text/event-stream")
+	if protobuf {
+		w.Header().Set("Content-Type", protobufType)
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Add("Vary", "Accept")
+	// stop nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	rc := http.NewResponseController(w)
+
+	write := func(data []byte) bool {
+		rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+		if _, err := w.Write(data); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	writeEvent := func(event *types.TransactionEvent) bool {
+		if protobuf {
+			return write(appendProtobufEvent(nil, event))
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			panic(fmt.Errorf("failed to marshal value, err %v", err))
+		}
+		if event.Pending {
+			// no id, resuming replays stored transactions only
+			return write(fmt.Appendf(nil, "event: pending\ndata: %s\n\n", data))
+		}
+		return write(fmt.Appendf(nil, "id: %d\nevent: transaction\ndata: %s\n\n", event.Block, data))
+	}
+	// a comment, keeps proxies from closing the idle connection
+	heartbeat := []byte(": heartbeat\n\n")
+	if protobuf {
+		// an empty message, its zero length
+		heartbeat = []byte{0}
+	}
+
+	if !protobuf && !write(fmt.Appendf(nil, "retry: %d\n\n", sseRetry.Milliseconds())) {
+		return
+	}
+	for _, tx := range txs {
+		if !writeEvent(storedTransactionEvent(address, tx)) {
+			return
+		}
+	}
+	ticker := time.NewTicker(sseHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-c.send:
+			if !writeEvent(event) {
+				return
+			}
+		case <-ticker.C:
+			if !write(heartbeat) {
+				return
+			}
+		}
+	}
+}
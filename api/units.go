@@ -0,0 +1,111 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// whether ?units=ether asks for decimal values instead of hex quantities
+func parseUnits(query url.Values) (bool, error) {
+	switch units := query.Get("units"); units {
+	case "", "hex":
+		return false, nil
+	case "ether":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid units %q, expected hex or ether", units)
+	}
+}
+
+// a copy of the transaction with decimal quantities, the value and the fees
+// in ether, gas prices in gwei and counters as plain integers
+func humanTransaction(tx *types.Transaction) *types.Transaction {
+	human := *tx
+	human.Value = types.FormatQuantity(tx.Value, types.EtherDecimals)
+	if tx.Fees != nil {
+		fees := *tx.Fees
+		human.Fees = &fees
+		for _, fee := range []*string{&fees.PriorityFee, &fees.BurnedFee, &fees.BlobFee, &fees.TotalFee} {
+			if *fee != "" {
+				*fee = types.FormatQuantity(*fee, types.EtherDecimals)
+			}
+		}
+		for _, price := range []*string{&fees.EffectiveGasPrice, &fees.PriorityFeePerGas} {
+			if *price != "" {
+				*price = types.FormatQuantity(*price, types.GweiDecimals)
+			}
+		}
+	}
+	for _, price := range []*string{&human.GasPrice, &human.MaxFeePerGas, &human.MaxPriorityFeePerGas, &human.EffectiveGasPrice, &human.BaseFeePerGas,
+		&human.MaxFeePerBlobGas, &human.BlobGasPrice} {
+		if *price != "" {
+			*price = types.FormatQuantity(*price, types.GweiDecimals)
+		}
+	}
+	for _, counter := range []*string{&human.BlockNumber, &human.BlockTimestamp, &human.Gas, &human.GasUsed, &human.Nonce, &human.TransactionIndex, &human.ChainId, &human.BlobGasUsed} {
+		if *counter != "" {
+			*counter = types.FormatQuantity(*counter, 0)
+		}
+	}
+	return &human
+}
+
+// a copy of the balance change in ether, the timestamp a plain integer
+func humanBalanceChange(change *types.BalanceChange) *types.BalanceChange {
+	human := *change
+	for _, quantity := range []*string{&human.Received, &human.Sent, &human.Fees, &human.Change, &human.Balance} {
+		// the change and the balance may be signed
+		negative, sign := strings.CutPrefix(*quantity, "-")
+		*quantity = types.FormatQuantity(negative, types.EtherDecimals)
+		if sign && *quantity != "0" {
+			*quantity = "-" + *quantity
+		}
+	}
+	if human.Timestamp != "" {
+		human.Timestamp = types.FormatQuantity(change.Timestamp, 0)
+	}
+	return &human
+}
+
+// a copy of the block metadata with decimal quantities, the base fee in gwei
+func humanBlock(block *types.BlockMetadata) *types.BlockMetadata {
+	human := *block
+	human.Number = types.FormatQuantity(block.Number, 0)
+	human.Timestamp = types.FormatQuantity(block.Timestamp, 0)
+	if human.BaseFeePerGas != "" {
+		human.BaseFeePerGas = types.FormatQuantity(block.BaseFeePerGas, types.GweiDecimals)
+	}
+	return &human
+}
+
+func humanTransactions(txs []*types.Transaction) []*types.Transaction {
+	human := make([]*types.Transaction, len(txs))
+	for i, tx := range txs {
+		human[i] = humanTransaction(tx)
+	}
+	return human
+}
+
+// a copy of the gas prices in gwei
+func humanGasPrice(gasPrice *types.GasPrice) *types.GasPrice {
+	human := *gasPrice
+	human.BaseFee = types.FormatQuantity(gasPrice.BaseFee, types.GweiDecimals)
+	human.BaseFees = make([]string, len(gasPrice.BaseFees))
+	for i, baseFee := range gasPrice.BaseFees {
+		human.BaseFees[i] = types.FormatQuantity(baseFee, types.GweiDecimals)
+	}
+	for _, percentiles := range []**types.GasPercentiles{&human.EffectiveGasPrice, &human.PriorityFee} {
+		if *percentiles == nil {
+			continue
+		}
+		p := **percentiles
+		for _, price := range []*string{&p.P10, &p.P25, &p.P50, &p.P75, &p.P90} {
+			*price = types.FormatQuantity(*price, types.GweiDecimals)
+		}
+		*percentiles = &p
+	}
+	return &human
+}
@@ -0,0 +1,228 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// The transaction as the v2 api replies it, camelCase keys, counters as
+// numbers, wei amounts and prices as decimal strings and the fields it
+// doesn't have left out, e.g. the block ones while pending or the receipt
+// ones without receipts
+type TransactionV2 struct {
+	Hash        string `json:"hash"`
+	BlockHash   string `json:"blockHash,omitempty"`
+	BlockNumber *int64 `json:"blockNumber,omitempty"`
+	// unix seconds
+	BlockTimestamp   *int64 `json:"blockTimestamp,omitempty"`
+	TransactionIndex *int64 `json:"transactionIndex,omitempty"`
+	From             string `json:"from"`
+	// empty for contract creations, which have the ContractAddress
+	To              string `json:"to,omitempty"`
+	ContractAddress string `json:"contractAddress,omitempty"`
+	// in wei
+	Value string `json:"value"`
+	Nonce *int64 `json:"nonce,omitempty"`
+	// 0 legacy, 1 access list, 2 dynamic fee, 3 blob
+	Type    *int64 `json:"type,omitempty"`
+	ChainId *int64 `json:"chainId,omitempty"`
+	Gas     *int64 `json:"gas,omitempty"`
+	// wei per gas
+	GasPrice             string        `json:"gasPrice,omitempty"`
+	MaxFeePerGas         string        `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas string        `json:"maxPriorityFeePerGas,omitempty"`
+	BaseFeePerGas        string        `json:"baseFeePerGas,omitempty"`
+	Input                string        `json:"input,omitempty"`
+	AccessList           []interface{} `json:"accessList,omitempty"`
+	V                    string        `json:"v,omitempty"`
+	R                    string        `json:"r,omitempty"`
+	S                    string        `json:"s,omitempty"`
+	YParity              string        `json:"yParity,omitempty"`
+	// of the type 3 transactions carrying blobs
+	BlobVersionedHashes []string `json:"blobVersionedHashes,omitempty"`
+	MaxFeePerBlobGas    string   `json:"maxFeePerBlobGas,omitempty"`
+	// from the receipt, 1 succeeded and 0 failed
+	Status            *int64   `json:"status,omitempty"`
+	GasUsed           *int64   `json:"gasUsed,omitempty"`
+	EffectiveGasPrice string   `json:"effectiveGasPrice,omitempty"`
+	Logs              []*LogV2 `json:"logs,omitempty"`
+	Fees              *FeesV2  `json:"fees,omitempty"`
+	BlobGasUsed       *int64   `json:"blobGasUsed,omitempty"`
+	BlobGasPrice      string   `json:"blobGasPrice,omitempty"`
+	// internal for the value transfers of contracts, at the trace address in
+	// the call tree of the transaction
+	Kind         string         `json:"kind,omitempty"`
+	TraceAddress []int          `json:"traceAddress,omitempty"`
+	Decoded      *DecodedCallV2 `json:"decoded,omitempty"`
+	Direction    string         `json:"direction,omitempty"`
+	Counterparty string         `json:"counterparty,omitempty"`
+	// sent by a deposit address into a hot wallet, moving nearly its whole
+	// balance
+	Sweep bool `json:"sweep,omitempty"`
+}
+
+// The fees of a mined transaction in wei
+type FeesV2 struct {
+	EffectiveGasPrice string `json:"effectiveGasPrice"`
+	PriorityFeePerGas string `json:"priorityFeePerGas,omitempty"`
+	PriorityFee       string `json:"priorityFee,omitempty"`
+	BurnedFee         string `json:"burnedFee,omitempty"`
+	BlobFee           string `json:"blobFee,omitempty"`
+	TotalFee          string `json:"totalFee"`
+}
+
+// A log of the receipt, its block and transaction are the ones of the
+// transaction it is in
+type LogV2 struct {
+	Address  string   `json:"address"`
+	Topics   []string `json:"topics"`
+	Data     string   `json:"data,omitempty"`
+	LogIndex *int64   `json:"logIndex,omitempty"`
+	Removed  bool     `json:"removed,omitempty"`
+}
+
+// The input decoded with the ABI of the called contract
+type DecodedCallV2 struct {
+	Method    string          `json:"method"`
+	Signature string          `json:"signature"`
+	Args      []*DecodedArgV2 `json:"args"`
+}
+
+type DecodedArgV2 struct {
+	Name  string      `json:"name,omitempty"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// query parameters of v1 the v2 api has no use for, its shape is fixed
+var v1OnlyParams = []string{"units", "format"}
+
+// reject the v1 query parameters, true when there are none
+func checkV2Query(w http.ResponseWriter, r *http.Request) bool {
+	query := r.URL.Query()
+	for _, param := range v1OnlyParams {
+		if query.Has(param) {
+			w.Header().Set("Content-Type", "application/json")
+			writeError(w, http.StatusBadRequest, fmt.Errorf("query parameter %s isn't supported by the v2 api", param))
+			return false
+		}
+	}
+	return true
+}
+
+// whether the request came in on a v2 endpoint
+func isV2(r *http.Request) bool {
+	return requestVersion(r) == apiV2
+}
+
+// the hex quantity as a number, nil when empty or malformed
+func quantityInt(hex string) *int64 {
+	v := types.ParseQuantity(hex)
+	if v == nil || !v.IsInt64() {
+		return nil
+	}
+	n := v.Int64()
+	return &n
+}
+
+// the hex quantity as a decimal string, empty when empty or malformed
+func quantityDecimal(hex string) string {
+	v := types.ParseQuantity(hex)
+	if v == nil {
+		return ""
+	}
+	return v.String()
+}
+
+func toTransactionV2(tx *types.Transaction) *TransactionV2 {
+	v2 := &TransactionV2{
+		Hash:                 tx.Hash,
+		BlockHash:            tx.BlockHash,
+		BlockNumber:          quantityInt(tx.BlockNumber),
+		BlockTimestamp:       quantityInt(tx.BlockTimestamp),
+		TransactionIndex:     quantityInt(tx.TransactionIndex),
+		From:                 tx.From,
+		To:                   tx.To,
+		ContractAddress:      tx.ContractAddress,
+		Value:                quantityDecimal(tx.Value),
+		Nonce:                quantityInt(tx.Nonce),
+		Type:                 quantityInt(tx.Type),
+		ChainId:              quantityInt(tx.ChainId),
+		Gas:                  quantityInt(tx.Gas),
+		GasPrice:             quantityDecimal(tx.GasPrice),
+		MaxFeePerGas:         quantityDecimal(tx.MaxFeePerGas),
+		MaxPriorityFeePerGas: quantityDecimal(tx.MaxPriorityFeePerGas),
+		BaseFeePerGas:        quantityDecimal(tx.BaseFeePerGas),
+		AccessList:           tx.AccessList,
+		V:                    tx.V,
+		R:                    tx.R,
+		S:                    tx.S,
+		YParity:              tx.YParity,
+		BlobVersionedHashes:  tx.BlobVersionedHashes,
+		MaxFeePerBlobGas:     quantityDecimal(tx.MaxFeePerBlobGas),
+		Status:               quantityInt(tx.Status),
+		GasUsed:              quantityInt(tx.GasUsed),
+		EffectiveGasPrice:    quantityDecimal(tx.EffectiveGasPrice),
+		BlobGasUsed:          quantityInt(tx.BlobGasUsed),
+		BlobGasPrice:         quantityDecimal(tx.BlobGasPrice),
+		Kind:                 tx.Kind,
+		TraceAddress:         tx.TraceAddress,
+		Direction:            tx.Direction,
+		Counterparty:         tx.Counterparty,
+		Sweep:                tx.Sweep,
+	}
+	if tx.Input != "0x" {
+		v2.Input = tx.Input
+	}
+	if v2.Value == "" {
+		v2.Value = "0"
+	}
+	for _, log := range tx.Logs {
+		v2.Logs = append(v2.Logs, &LogV2{
+			Address:  log.Address,
+			Topics:   log.Topics,
+			Data:     log.Data,
+			LogIndex: quantityInt(log.LogIndex),
+			Removed:  log.Removed,
+		})
+	}
+	if fees := tx.Fees; fees != nil {
+		v2.Fees = &FeesV2{
+			EffectiveGasPrice: quantityDecimal(fees.EffectiveGasPrice),
+			PriorityFeePerGas: quantityDecimal(fees.PriorityFeePerGas),
+			PriorityFee:       quantityDecimal(fees.PriorityFee),
+			BurnedFee:         quantityDecimal(fees.BurnedFee),
+			BlobFee:           quantityDecimal(fees.BlobFee),
+			TotalFee:          quantityDecimal(fees.TotalFee),
+		}
+	}
+	if decoded := tx.Decoded; decoded != nil {
+		v2.Decoded = &DecodedCallV2{Method: decoded.Method, Signature: decoded.Signature, Args: []*DecodedArgV2{}}
+		for _, arg := range decoded.Args {
+			v2.Decoded.Args = append(v2.Decoded.Args, &DecodedArgV2{Name: arg.Name, Type: arg.Type, Value: arg.Value})
+		}
+	}
+	return v2
+}
+
+func toTransactionsV2(txs []*types.Transaction) []*TransactionV2 {
+	v2 := make([]*TransactionV2, len(txs))
+	for i, tx := range txs {
+		v2[i] = toTransactionV2(tx)
+	}
+	return v2
+}
+
+// the reply of the transactions of an address in the v2 shape
+func toTransactionsV2Response(resp *TransactionsResponse) *TransactionsV2Response {
+	return &TransactionsV2Response{
+		Address:      resp.Address,
+		Name:         resp.Name,
+		Label:        resp.Label,
+		Metadata:     resp.Metadata,
+		Transactions: toTransactionsV2(resp.Transactions),
+		Names:        resp.Names,
+	}
+}
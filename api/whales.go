@@ -0,0 +1,50 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// post the whale alerts of the parser, the transactions of any address
+// moving at least its whale threshold, to the urls; none stops posting them
+func (n *Notifier) SetWhaleUrls(urls []string) {
+	n.Lock()
+	defer n.Unlock()
+	n.whaleUrls = urls
+}
+
+// queue whale alerts for delivery, never blocks the parser
+func (n *Notifier) NotifyWhales(events []*types.WhaleEvent) {
+	n.RLock()
+	posting := len(n.whaleUrls) > 0
+	n.RUnlock()
+	if !posting {
+		return
+	}
+	for _, event := range events {
+		select {
+		case n.whales <- event:
+		default:
+			slog.Warn("Whale webhook queue full, dropping event", "hash", event.Transaction.Hash)
+		}
+	}
+}
+
+// deliver the queued whale alerts
+func (n *Notifier) RunWhales() {
+	for event := range n.whales {
+		n.RLock()
+		urls := n.whaleUrls
+		n.RUnlock()
+		data, err := json.Marshal(event)
+		if err != nil {
+			slog.Error("Failed to marshal whale event", "hash", event.Transaction.Hash, "err", err)
+			continue
+		}
+		for _, url := range urls {
+			n.deliver(url, data)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/passwizards/eth-parser/types"
+)
+
+const (
+	wsSendQueueSize = 256
+	wsWriteTimeout  = 10 * time.Second
+	wsPingInterval  = 30 * time.Second
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// The websocket client command
+type WsCommand struct {
+	Action  string `json:"action"`
+	Address string `json:"address"`
+}
+
+// The websocket hub, fans matched transactions out to the websocket and
+// server-sent events clients watching the address
+type WsHub struct {
+	clients map[string]map[hubClient]struct{}
+	sync.RWMutex
+}
+
+// A client of the hub, pushed the events of the addresses it watches
+type hubClient interface {
+	push(v interface{})
+}
+
+type wsClient struct {
+	conn *websocket.Conn
+	send chan interface{}
+	// closed once the writer is gone, guards sends to the queue
+	done chan struct{}
+	// events go as binary TransactionEvent messages, errors stay json
+	protobuf bool
+}
+
+func NewWsHub() *WsHub {
+	return &WsHub{clients: make(map[string]map[hubClient]struct{})}
+}
+
+func (h *WsHub) Notify(matches []*types.MatchedTransaction) {
+	h.RLock()
+	defer h.RUnlock()
+	for _, m := range matches {
+		for c := range h.clients[m.Address] {
+			c.push(types.NewTransactionEvent(m))
+		}
+	}
+}
+
+func (h *WsHub) add(address string, c hubClient) {
+	h.Lock()
+	defer h.Unlock()
+	if h.clients[address] == nil {
+		h.clients[address] = make(map[hubClient]struct{})
+	}
+	h.clients[address][c] = struct{}{}
+}
+
+func (h *WsHub) remove(address string, c hubClient) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.clients[address], c)
+	if len(h.clients[address]) == 0 {
+		delete(h.clients, address)
+	}
+}
+
+// push a message to the client, drops it if the client can't keep up
+func (c *wsClient) push(v interface{}) {
+	select {
+	case c.send <- v:
+	case <-c.done:
+	default:
+		slog.Warn("Websocket client too slow, dropping message", "remote", c.conn.RemoteAddr())
+	}
+}
+
+func (c *wsClient) writeLoop() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	defer close(c.done)
+	for {
+		select {
+		case v := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			var err error
+			if event, ok := v.(*types.TransactionEvent); ok && c.protobuf {
+				err = c.conn.WriteMessage(websocket.BinaryMessage, marshalProtobuf(toGrpcEvent(event)))
+			} else {
+				err = c.conn.WriteJSON(v)
+			}
+			if err != nil {
+				c.conn.Close()
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (s *HttpServer) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	upgrader := wsUpgrader
+	upgrader.CheckOrigin = s.checkOrigin
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already replied with an error
+		return
+	}
+	c := &wsClient{
+		conn: conn,
+		send: make(chan interface{}, wsSendQueueSize),
+		done: make(chan struct{}),
+		// negotiated on the upgrade request
+		protobuf: acceptsProtobuf(r.Header.Get("Accept")),
+	}
+	go c.writeLoop()
+
+	watched := map[string]struct{}{}
+	defer func() {
+		for address := range watched {
+			s.hub.remove(address, c)
+		}
+		conn.Close()
+	}()
+
+	for {
+		var cmd WsCommand
+		if err := conn.ReadJSON(&cmd); err != nil {
+			return
+		}
+		address, err := types.NormalizeAddress(cmd.Address)
+		if err != nil && (cmd.Action == "subscribe" || cmd.Action == "unsubscribe") {
+			c.push(map[string]interface{}{
+				"error": err.Error(),
+			})
+			continue
+		}
+		switch cmd.Action {
+		case "subscribe":
+			if _, ok := watched[address]; ok {
+				continue
+			}
+			if _, err := s.parser.Subscribe(r.Context(), requestTenant(r), address); err != nil {
+				c.push(wsStorageError(err))
+				continue
+			}
+			// register before replaying so nothing parsed meanwhile is missed
+			s.hub.add(address, c)
+			watched[address] = struct{}{}
+			txs, err := s.parser.GetTransactions(r.Context(), address)
+			if err != nil {
+				c.push(wsStorageError(err))
+				continue
+			}
+			for _, tx := range txs {
+				c.push(storedTransactionEvent(address, tx))
+			}
+		case "unsubscribe":
+			s.hub.remove(address, c)
+			delete(watched, address)
+		default:
+			c.push(map[string]interface{}{
+				"error": fmt.Sprintf("unknown action %q", cmd.Action),
+			})
+		}
+	}
+}
+
+// the message of a failed storage call, the details are logged rather than
+// sent
+func wsStorageError(err error) map[string]interface{} {
+	slog.Error("Storage failed", "path", "/ws", "err", err)
+	return map[string]interface{}{
+		"error": "the storage failed, try again later",
+	}
+}
+
+// rebuild the event of a transaction from storage
+func storedTransactionEvent(address string, tx *types.Transaction) *types.TransactionEvent {
+	direction := types.DirectionIn
+	if strings.ToLower(tx.From) == address {
+		direction = types.DirectionOut
+	}
+	block, _ := strconv.ParseInt(tx.BlockNumber, 0, 0)
+	return &types.TransactionEvent{
+		Address:     types.ChecksumAddress(address),
+		Direction:   direction,
+		Block:       int(block),
+		Transaction: tx,
+	}
+}
@@ -0,0 +1,232 @@
+// Package client calls the http and websocket api of an eth-parser server
+// from go, with typed replies and retries
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+	// attempts of a call after the first one, and the wait before the first
+	// of them, doubled for the next ones
+	defaultRetries = 3
+	defaultBackoff = 500 * time.Millisecond
+	maxBackoff     = 30 * time.Second
+)
+
+// The client of a server, safe for concurrent use
+type Client struct {
+	baseUrl string
+	http    *http.Client
+	// sent in the X-API-Key header when set
+	apiKey  string
+	retries int
+	backoff time.Duration
+}
+
+type Option func(*Client)
+
+// authenticate with the api key, of the default tenant or another one
+func WithApiKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// call through the http client instead of one with a 30s timeout, e.g. with
+// another transport
+func WithHttpClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.http = client
+	}
+}
+
+// try failed calls again up to retries times, waiting backoff before the
+// first retry and twice as long before every next one, unless the server
+// asks for longer; 0 retries fails on the first error
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries, c.backoff = retries, backoff
+	}
+}
+
+// a client of the server at the base url, e.g. http://localhost:8888 or
+// http://localhost:8888/polygon for another chain
+func New(baseUrl string, opts ...Option) *Client {
+	c := &Client{
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		http:    &http.Client{Timeout: defaultTimeout},
+		retries: defaultRetries,
+		backoff: defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// The error the server replied with, or the status alone when the reply
+// wasn't one of its errors
+type Error struct {
+	Status int
+	// stable for branching on, e.g. not_found or bad_request
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("unexpected status %d", e.Status)
+	}
+	return fmt.Sprintf("status %d, %s: %s", e.Status, e.Code, e.Message)
+}
+
+// whether the call failed because what it asked for doesn't exist, e.g. an
+// unknown transaction
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound
+}
+
+// the last parsed block
+func (c *Client) GetCurrentBlock(ctx context.Context) (int, error) {
+	var resp CurrentBlockResponse
+	if err := c.get(ctx, "/GetCurrentBlock", nil, &resp); err != nil {
+		return 0, err
+	}
+	return resp.CurrentBlock, nil
+}
+
+// watch the address, Success is false when it already was
+func (c *Client) Subscribe(ctx context.Context, address string) (*SubscribeResponse, error) {
+	var resp SubscribeResponse
+	if err := c.get(ctx, "/Subscribe/"+url.PathEscape(address), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// stop watching the address, Success is false when it wasn't
+func (c *Client) Unsubscribe(ctx context.Context, address string) (*SubscribeResponse, error) {
+	var resp SubscribeResponse
+	if err := c.get(ctx, "/Unsubscribe/"+url.PathEscape(address), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// the stored transactions of the address passing the filter, oldest first,
+// only its sweeps into the hot wallets with Sweeps and the ones carrying
+// blobs with Blobs; a zero filter returns all of them
+func (c *Client) GetTransactions(ctx context.Context, address string, filter types.TransactionFilter) (*TransactionsResponse, error) {
+	query := url.Values{}
+	if filter.Direction != "" {
+		query.Set("direction", filter.Direction)
+	}
+	if filter.Status != "" {
+		query.Set("status", filter.Status)
+	}
+	if filter.Blobs {
+		query.Set("blobs", "true")
+	}
+	for name, value := range map[string]int{"fromBlock": filter.FromBlock, "toBlock": filter.ToBlock, "offset": filter.Offset, "limit": filter.Limit} {
+		if value != 0 {
+			query.Set(name, strconv.Itoa(value))
+		}
+	}
+	path := "/GetTransactions/"
+	if filter.Sweeps {
+		path = "/GetSweeps/"
+	}
+	var resp TransactionsResponse
+	if err := c.get(ctx, path+url.PathEscape(address), query, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// a transaction by hash, stored or else read from the node by the server;
+// an Error that IsNotFound when neither knows it
+func (c *Client) GetTransaction(ctx context.Context, hash string) (*types.Transaction, error) {
+	var resp TransactionResponse
+	if err := c.get(ctx, "/GetTransaction/"+url.PathEscape(hash), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Transaction, nil
+}
+
+// GET the path and decode the json reply into result, retrying the failures
+// that may pass: the network ones, 429 and the 5xx statuses
+func (c *Client) get(ctx context.Context, path string, query url.Values, result interface{}) error {
+	u := c.baseUrl + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		wait, err := c.call(ctx, u, result)
+		if err == nil || wait < 0 || attempt == c.retries {
+			return err
+		}
+		wait = max(wait, backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// one attempt of a call, with how long the server asked to wait before the
+// next one when it may pass, -1 when it won't
+func (c *Client) call(ctx context.Context, u string, result interface{}) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return -1, ctx.Err()
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if err := json.Unmarshal(body, result); err != nil {
+			return -1, fmt.Errorf("failed to decode reply of %s, err %v", req.URL.Path, err)
+		}
+		return 0, nil
+	}
+	apiErr := &Error{Status: resp.StatusCode}
+	var reply ErrorResponse
+	if json.Unmarshal(body, &reply) == nil && reply.Error != nil {
+		apiErr.Code, apiErr.Message = reply.Error.Code, reply.Error.Message
+	}
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return -1, apiErr
+	}
+	seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+	return time.Duration(seconds) * time.Second, apiErr
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// The replies of the server, the fields the client reads of the ones of the
+// api package, which it doesn't import to stay free of the server side
+
+type CurrentBlockResponse struct {
+	CurrentBlock int `json:"currentBlock"`
+}
+
+type SubscribeResponse struct {
+	Address  string                  `json:"address"`
+	Success  bool                    `json:"success"`
+	Label    string                  `json:"label,omitempty"`
+	Metadata map[string]interface{}  `json:"metadata,omitempty"`
+	Rule     *types.NotificationRule `json:"rule,omitempty"`
+	// when the address is unwatched, set by a ttl
+	Expires *time.Time `json:"expires,omitempty"`
+	// the ENS name the address was resolved from
+	Name string `json:"name,omitempty"`
+}
+
+// The transactions of the address, with the label the tenant gave it, set
+// relative to it: their Direction and Counterparty are filled in
+type TransactionsResponse struct {
+	Address      string                 `json:"address"`
+	Label        string                 `json:"label,omitempty"`
+	Metadata     map[string]interface{} `json:"metadata,omitempty"`
+	Transactions []*types.Transaction   `json:"transactions"`
+	Name         string                 `json:"name,omitempty"`
+}
+
+type TransactionResponse struct {
+	Transaction *types.Transaction `json:"transaction"`
+}
+
+type ErrorResponse struct {
+	Error *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/passwizards/eth-parser/types"
+)
+
+// A message of the websocket, an event or the error of a command
+type streamMessage struct {
+	types.TransactionEvent
+	Error string `json:"error"`
+}
+
+// call handle with the transactions of the addresses as the server parses
+// them, over the websocket, until the context is done; the stored ones of
+// every address come first, again after each reconnect, so handle should
+// skip the hashes it already saw. The connection is dialled again with
+// growing waits when it fails, unless the server refuses it, e.g. without a
+// valid api key; handle runs on the reading goroutine
+func (c *Client) Stream(ctx context.Context, addresses []string, handle func(event *types.TransactionEvent)) error {
+	for _, address := range addresses {
+		if _, err := types.NormalizeAddress(address); err != nil {
+			return err
+		}
+	}
+	backoff := c.backoff
+	for {
+		connected, err := c.stream(ctx, addresses, handle)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var apiErr *Error
+		if errors.As(err, &apiErr) {
+			return err
+		}
+		if connected {
+			backoff = c.backoff
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// one connection of Stream, whether it got to subscribe and the error it
+// ended with
+func (c *Client) stream(ctx context.Context, addresses []string, handle func(event *types.TransactionEvent)) (bool, error) {
+	u := "ws" + strings.TrimPrefix(c.baseUrl, "http") + "/ws"
+	header := http.Header{}
+	if c.apiKey != "" {
+		header.Set("X-API-Key", c.apiKey)
+	}
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, u, header)
+	if err != nil {
+		if resp != nil && resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return false, &Error{Status: resp.StatusCode}
+		}
+		return false, err
+	}
+	defer conn.Close()
+	// unblock the read once the context is done
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	for _, address := range addresses {
+		if err := conn.WriteJSON(map[string]string{"action": "subscribe", "address": address}); err != nil {
+			return false, err
+		}
+	}
+	for {
+		var message streamMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			return true, err
+		}
+		if message.Error != "" {
+			// the addresses are valid, the storage of the server failed to
+			// subscribe or replay one
+			return true, errors.New(message.Error)
+		}
+		handle(&message.TransactionEvent)
+	}
+}
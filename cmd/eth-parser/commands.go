@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"syscall"
+
+	"github.com/passwizards/eth-parser/abi"
+	"github.com/passwizards/eth-parser/parser"
+	"github.com/passwizards/eth-parser/storage"
+)
+
+// set at build time, go build -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// print the error and exit with 1
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+// the storage of the main chain, or of the configured chain with the name
+func openStorage(cfg *Config, chain string) (storage.StorageProvider, error) {
+	if chain != "" && chainConfig(cfg, chain) == nil {
+		return nil, fmt.Errorf("unknown chain %q", chain)
+	}
+	if cfg.StorageBackend == "mem" && cfg.Snapshot == "" {
+		return nil, errors.New("the mem storage backend keeps nothing between runs, set -snapshot or another -storage")
+	}
+	return newStorage(cfg, chain)
+}
+
+func chainConfig(cfg *Config, name string) *ChainConfig {
+	for i := range cfg.Chains {
+		if cfg.Chains[i].Name == name {
+			return &cfg.Chains[i]
+		}
+	}
+	return nil
+}
+
+// whether nothing was parsed or subscribed yet
+func isEmpty(ctx context.Context, s storage.StorageProvider) (bool, error) {
+	current, err := s.GetCurrentBlock(ctx)
+	if err != nil || current != 0 {
+		return false, err
+	}
+	tenants, err := s.GetTenants(ctx)
+	return len(tenants) == 0, err
+}
+
+// the addresses observed by any tenant, sorted
+func subscribedAddresses(ctx context.Context, s storage.StorageProvider) ([]string, error) {
+	tenants, err := s.GetTenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var addresses []string
+	for _, tenant := range tenants {
+		subscriptions, err := s.GetSubscriptions(ctx, tenant)
+		if err != nil {
+			return nil, err
+		}
+		for _, subscription := range subscriptions {
+			addresses = append(addresses, subscription.Address)
+		}
+	}
+	slices.Sort(addresses)
+	return slices.Compact(addresses), nil
+}
+
+// scan the blocks from..to for past transactions of the subscribed addresses
+// and exit, the storage must persist between runs
+func backfill(args []string) {
+	fs := newFlagSet("backfill", "backfill [flags] <from> <to>, to 0 stops at the current block")
+	var addresses []string
+	fs.Var(&listFlag{list: &addresses}, "address", "subscribed address to backfill, can be repeated, defaults to every address a tenant subscribed")
+	chain := fs.String("chain", "", "name of the configured chain to backfill, the main chain when empty")
+	cfg := loadConfig(fs, args)
+	slog.SetDefault(newLogger(cfg, os.Stdout))
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	from, err := strconv.ParseInt(fs.Arg(0), 0, 0)
+	if err != nil {
+		fatal(fmt.Errorf("invalid from block %q", fs.Arg(0)))
+	}
+	to, err := strconv.ParseInt(fs.Arg(1), 0, 0)
+	if err != nil {
+		fatal(fmt.Errorf("invalid to block %q", fs.Arg(1)))
+	}
+
+	s, err := openStorage(cfg, *chain)
+	if err != nil {
+		fatal(fmt.Errorf("failed to open storage, err %v", err))
+	}
+	abis, err := abi.NewRegistry(cfg.AbiDir)
+	if err != nil {
+		fatal(fmt.Errorf("failed to load abis, err %v", err))
+	}
+	rpcUrls, explorerUrl := cfg.RpcUrls, cfg.ExplorerUrl
+	opts := sharedOptions(cfg, abis)
+	if *chain != "" {
+		chainCfg := chainConfig(cfg, *chain)
+		rpcUrls, explorerUrl = chainCfg.RpcUrls, chainCfg.ExplorerUrl
+		opts = append(opts, parser.WithLogger(slog.With("chain", *chain)), parser.WithRateLimit(cfg.chainRpcRate(chainCfg)))
+		if len(chainCfg.RpcAuth) > 0 {
+			opts = append(opts, parser.WithRpcAuth(cfg.chainRpcAuth(chainCfg)))
+		}
+	}
+	opts = append(opts, parser.WithEndpoints(rpcUrls[1:]...))
+	if explorerUrl != "" {
+		opts = append(opts, parser.WithExplorer(explorerUrl, cfg.ExplorerApiKey))
+	}
+	p := parser.NewEthParser(rpcUrls[0], s, opts...)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if len(addresses) == 0 {
+		if addresses, err = subscribedAddresses(ctx, s); err != nil {
+			fatal(fmt.Errorf("failed to read subscriptions, err %v", err))
+		}
+	}
+
+	failed := false
+	for _, address := range addresses {
+		added, ok, err := p.RunBackfill(ctx, address, int(from), int(to))
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			slog.Error("Failed to backfill", "address", address, "from", from, "to", to, "err", err)
+			failed = true
+			continue
+		}
+		if !ok {
+			slog.Error("Failed to backfill, the address isn't subscribed or the range is empty", "address", address, "from", from, "to", to)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s %d\n", address, added)
+	}
+	if ms, ok := s.(*storage.MemStorage); ok {
+		if err := saveSnapshot(ms, chainDbPath(cfg.Snapshot, *chain)); err != nil {
+			slog.Error("Failed to export snapshot", "err", err)
+			failed = true
+		}
+	}
+	if err := s.Close(); err != nil {
+		slog.Error("Failed to close storage", "err", err)
+	}
+	if failed || ctx.Err() != nil {
+		os.Exit(1)
+	}
+}
+
+// write the storage as a mem snapshot to the file or stdout, logging to
+// stderr so the snapshot can be piped
+func exportSnapshot(args []string) {
+	fs := newFlagSet("export", "export [flags] [file]")
+	chain := fs.String("chain", "", "name of the configured chain to export, the main chain when empty")
+	cfg := loadConfig(fs, args)
+	slog.SetDefault(newLogger(cfg, os.Stderr))
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	s, err := openStorage(cfg, *chain)
+	if err != nil {
+		fatal(fmt.Errorf("failed to open storage, err %v", err))
+	}
+	defer s.Close()
+	ms, ok := s.(*storage.MemStorage)
+	if !ok {
+		ms = storage.NewMemStorage()
+		if err := storage.Copy(context.Background(), ms, s, cfg.ReorgDepth); err != nil {
+			fatal(fmt.Errorf("failed to export snapshot, err %v", err))
+		}
+	}
+	if fs.NArg() == 0 {
+		err = ms.Export(os.Stdout)
+	} else {
+		err = saveSnapshot(ms, fs.Arg(0))
+	}
+	if err != nil {
+		fatal(fmt.Errorf("failed to export snapshot, err %v", err))
+	}
+}
+
+// load a snapshot into the storage, which must be empty so nothing is merged
+// twice
+func importSnapshot(args []string) {
+	fs := newFlagSet("import", "import [flags] <file>")
+	chain := fs.String("chain", "", "name of the configured chain to import into, the main chain when empty")
+	cfg := loadConfig(fs, args)
+	slog.SetDefault(newLogger(cfg, os.Stdout))
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	snapshot := storage.NewMemStorage()
+	err = snapshot.Import(f)
+	f.Close()
+	if err != nil {
+		fatal(err)
+	}
+
+	s, err := openStorage(cfg, *chain)
+	if err != nil {
+		fatal(fmt.Errorf("failed to open storage, err %v", err))
+	}
+	defer s.Close()
+	ctx := context.Background()
+	empty, err := isEmpty(ctx, s)
+	if err != nil {
+		fatal(fmt.Errorf("failed to read storage, err %v", err))
+	}
+	if !empty {
+		fatal(errors.New("the storage is not empty, import into a fresh one"))
+	}
+	if _, ok := s.(*storage.MemStorage); ok {
+		err = saveSnapshot(snapshot, chainDbPath(cfg.Snapshot, *chain))
+	} else {
+		err = storage.Copy(ctx, s, snapshot, cfg.ReorgDepth)
+	}
+	if err != nil {
+		fatal(fmt.Errorf("failed to import snapshot, err %v", err))
+	}
+	// the mem storage never fails
+	block, _ := snapshot.GetCurrentBlock(ctx)
+	addresses, _ := subscribedAddresses(ctx, snapshot)
+	slog.Info("Imported snapshot", "path", fs.Arg(0), "block", block, "addresses", len(addresses))
+}
+
+// the -format of a subscriptions command, else by the extension of the file,
+// json without one
+func subscriptionsFormat(format, path string) (string, error) {
+	switch format {
+	case "":
+		return storage.SubscriptionsFormat(path), nil
+	case storage.SubscriptionsJson, storage.SubscriptionsCsv:
+		return format, nil
+	}
+	return "", fmt.Errorf("invalid format %q, expected json or csv", format)
+}
+
+// write the subscriptions of every tenant to the file or stdout, logging to
+// stderr so they can be piped
+func exportSubscriptions(args []string) {
+	fs := newFlagSet("export-subscriptions", "export-subscriptions [flags] [file]")
+	chain := fs.String("chain", "", "name of the configured chain to export from, the main chain when empty")
+	format := fs.String("format", "", "json or csv, defaults to the extension of the file, json on stdout")
+	cfg := loadConfig(fs, args)
+	slog.SetDefault(newLogger(cfg, os.Stderr))
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	f, err := subscriptionsFormat(*format, fs.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	s, err := openStorage(cfg, *chain)
+	if err != nil {
+		fatal(fmt.Errorf("failed to open storage, err %v", err))
+	}
+	defer s.Close()
+	records, err := storage.ExportSubscriptions(context.Background(), s)
+	if err != nil {
+		fatal(fmt.Errorf("failed to read subscriptions, err %v", err))
+	}
+	out := os.Stdout
+	if fs.NArg() == 1 {
+		if out, err = os.Create(fs.Arg(0)); err != nil {
+			fatal(err)
+		}
+	}
+	err = storage.WriteSubscriptions(out, f, records)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fatal(fmt.Errorf("failed to export subscriptions, err %v", err))
+	}
+	slog.Info("Exported subscriptions", "path", fs.Arg(0), "subscriptions", len(records))
+}
+
+// subscribe to the addresses of the file, keeping the subscriptions already
+// in the storage, without backfilling them
+func importSubscriptions(args []string) {
+	fs := newFlagSet("import-subscriptions", "import-subscriptions [flags] <file>")
+	chain := fs.String("chain", "", "name of the configured chain to import into, the main chain when empty")
+	format := fs.String("format", "", "json or csv, defaults to the extension of the file")
+	cfg := loadConfig(fs, args)
+	slog.SetDefault(newLogger(cfg, os.Stdout))
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	f, err := subscriptionsFormat(*format, fs.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	records, err := storage.ReadSubscriptions(file, f)
+	file.Close()
+	if err != nil {
+		fatal(err)
+	}
+
+	s, err := openStorage(cfg, *chain)
+	if err != nil {
+		fatal(fmt.Errorf("failed to open storage, err %v", err))
+	}
+	defer s.Close()
+	added, err := storage.ImportSubscriptions(context.Background(), s, records)
+	if err == nil {
+		if ms, ok := s.(*storage.MemStorage); ok {
+			err = saveSnapshot(ms, chainDbPath(cfg.Snapshot, *chain))
+		}
+	}
+	if err != nil {
+		fatal(fmt.Errorf("failed to import subscriptions, err %v", err))
+	}
+	slog.Info("Imported subscriptions", "path", fs.Arg(0), "subscriptions", len(records), "added", added)
+}
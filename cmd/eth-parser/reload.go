@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"sync"
+	"syscall"
+
+	"github.com/passwizards/eth-parser/api"
+	"github.com/passwizards/eth-parser/parser"
+)
+
+// Applies the config file again while serving, on SIGHUP or POST
+// /admin/reload: the rpc endpoints and their auth, the rpc, api key and
+// client ip rate limits, the access log share, the webhooks, the digest urls
+// and period and the whale urls and threshold change in place, the parsers
+// keep running and their state
+type reloader struct {
+	args []string
+	// the config served since the start, what reload can't apply stays so
+	started *Config
+	parser  *parser.EthParser
+	chains  []*chainParser
+	server  *api.HttpServer
+	// the notifier of every configured tenant, "" being the default one
+	notifiers map[string]*api.Notifier
+	sync.Mutex
+}
+
+// read the config again and apply what changed, nothing is applied when it
+// is invalid
+func (r *reloader) reload() error {
+	r.Lock()
+	defer r.Unlock()
+	cfg, err := reloadConfig(r.args)
+	if err != nil {
+		return err
+	}
+	r.parser.SetEndpoints(cfg.RpcUrls...)
+	r.parser.SetRpcAuth(cfg.rpcAuth())
+	r.parser.SetRateLimit(cfg.RpcRate, cfg.RpcBurst)
+	r.parser.SetWhaleThreshold(cfg.whaleThreshold())
+	for _, chain := range r.chains {
+		chainCfg := chainConfig(cfg, chain.name)
+		if chainCfg == nil {
+			// removed, it runs until restarted like an added one waits
+			continue
+		}
+		chain.parser.SetEndpoints(chainCfg.RpcUrls...)
+		chain.parser.SetRpcAuth(cfg.chainRpcAuth(chainCfg))
+		chain.parser.SetRateLimit(cfg.chainRpcRate(chainCfg))
+		chain.parser.SetWhaleThreshold(cfg.whaleThreshold())
+	}
+	r.server.SetApiKeyRate(cfg.ApiKeyRate)
+	r.server.SetClientRateLimit(cfg.IpRate, cfg.IpBurst)
+	r.server.SetAccessLog(cfg.AccessLog)
+	r.notifiers[""].SetUrls(cfg.Webhooks)
+	r.notifiers[""].SetDigest(cfg.DigestWebhooks, cfg.DigestPeriod)
+	r.notifiers[""].SetWhaleUrls(cfg.WhaleWebhooks)
+	for _, tenant := range cfg.Tenants {
+		if notifier := r.notifiers[tenant.Name]; notifier != nil {
+			notifier.SetUrls(tenant.Webhooks)
+			notifier.SetDigest(tenant.DigestWebhooks, cfg.DigestPeriod)
+			notifier.SetWhaleUrls(tenant.WhaleWebhooks)
+		}
+	}
+	if !reloadable(r.started, cfg) {
+		slog.Warn("Some changed settings only apply once restarted, only rpc urls and auth, rate limits, the access log, webhooks, digests and whale alerts are reloaded")
+	}
+	return nil
+}
+
+// whether the configs differ only by the settings reload applies
+func reloadable(old, cfg *Config) bool {
+	copied := *cfg
+	copied.RpcUrls, copied.RpcAuth, copied.RpcRate, copied.RpcBurst = old.RpcUrls, old.RpcAuth, old.RpcRate, old.RpcBurst
+	copied.ApiKeyRate, copied.Webhooks = old.ApiKeyRate, old.Webhooks
+	copied.DigestWebhooks, copied.DigestPeriod = old.DigestWebhooks, old.DigestPeriod
+	copied.WhaleWebhooks, copied.WhaleThreshold = old.WhaleWebhooks, old.WhaleThreshold
+	copied.IpRate, copied.IpBurst = old.IpRate, old.IpBurst
+	copied.AccessLog = old.AccessLog
+	copied.Chains = slices.Clone(cfg.Chains)
+	for i := range copied.Chains {
+		if previous := chainConfig(old, copied.Chains[i].Name); previous != nil {
+			copied.Chains[i].RpcUrls, copied.Chains[i].RpcAuth = previous.RpcUrls, previous.RpcAuth
+			copied.Chains[i].RpcRate, copied.Chains[i].RpcBurst = previous.RpcRate, previous.RpcBurst
+		}
+	}
+	copied.Tenants = slices.Clone(cfg.Tenants)
+	for i := range copied.Tenants {
+		for _, previous := range old.Tenants {
+			if previous.Name == copied.Tenants[i].Name {
+				copied.Tenants[i].Webhooks = previous.Webhooks
+				copied.Tenants[i].DigestWebhooks = previous.DigestWebhooks
+				copied.Tenants[i].WhaleWebhooks = previous.WhaleWebhooks
+			}
+		}
+	}
+	return reflect.DeepEqual(old, &copied)
+}
+
+// reload on every SIGHUP until the context is done
+func (r *reloader) watchHangups(ctx context.Context) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+		}
+		if err := r.reload(); err != nil {
+			slog.Error("Failed to reload config, keeping the previous one", "err", err)
+			continue
+		}
+		slog.Info("Reloaded config")
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// install the tracer provider exporting the spans to the otlp/http
+// collector, the returned func flushes the spans left and stops it; nothing
+// is traced without a collector
+func setupTracing(cfg *Config) func(ctx context.Context) error {
+	if cfg.OtlpEndpoint == "" {
+		return func(context.Context) error { return nil }
+	}
+	// a base url like $OTEL_EXPORTER_OTLP_ENDPOINT, the traces have their path
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(strings.TrimSuffix(cfg.OtlpEndpoint, "/")+"/v1/traces"))
+	if err != nil {
+		panic(fmt.Errorf("failed to create trace exporter, err %v", err))
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName("eth-parser"), semconv.ServiceVersion(version))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TraceSampleRate))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown
+}
@@ -0,0 +1,183 @@
+// Package eventbus publishes matched transactions to Kafka or NATS so
+// consumers don't have to poll the apis
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+const (
+	busQueueSize      = 4096
+	busMaxBatch       = 256
+	busMaxAttempts    = 5
+	busInitialBackoff = time.Second
+	busMaxBackoff     = 30 * time.Second
+	busPublishTimeout = 10 * time.Second
+	// the default topic of every event
+	DefaultTopic = "eth-parser.{chain}.transactions"
+)
+
+// A message to publish, the key partitions kafka topics by address
+type Message struct {
+	Topic string
+	Key   []byte
+	Value []byte
+}
+
+// The broker the events are published to, e.g. kafka or nats
+type Publisher interface {
+	// publish the messages, all of them are delivered once it returns nil
+	Publish(ctx context.Context, messages []*Message) error
+	// flush and release the connection
+	Close() error
+}
+
+// connect the publisher of the url, kafka://broker1:9092,broker2:9092 or
+// nats://host:4222
+func NewPublisher(raw string) (Publisher, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "kafka":
+		return NewKafkaPublisher(strings.Split(u.Host, ","))
+	case "nats", "tls":
+		return NewNatsPublisher(raw)
+	}
+	return nil, fmt.Errorf("unknown event bus %q, expected kafka or nats", raw)
+}
+
+// The topics events are published to, templates where {chain}, {address}
+// and {direction} are replaced with the ones of the event
+type Topics struct {
+	// for every event not routed otherwise, DefaultTopic when empty
+	Default string
+	// by chain name, overriding the default
+	Chains map[string]string
+	// by lowercase address, overriding the chain
+	Addresses map[string]string
+}
+
+// the topic of the event of the chain
+func (t *Topics) topic(chain string, event *types.TransactionEvent) string {
+	template := t.Default
+	if template == "" {
+		template = DefaultTopic
+	}
+	if topic, ok := t.Chains[chain]; ok {
+		template = topic
+	}
+	if topic, ok := t.Addresses[strings.ToLower(event.Address)]; ok {
+		template = topic
+	}
+	return strings.NewReplacer(
+		"{chain}", chain,
+		"{address}", strings.ToLower(event.Address),
+		"{direction}", event.Direction,
+	).Replace(template)
+}
+
+// The event bus, queues the matched transactions of every chain and publishes
+// them in batches
+type Bus struct {
+	publisher Publisher
+	topics    Topics
+	queue     chan *Message
+	done      chan struct{}
+}
+
+func NewBus(publisher Publisher, topics Topics) *Bus {
+	return &Bus{
+		publisher: publisher,
+		topics:    topics,
+		queue:     make(chan *Message, busQueueSize),
+		done:      make(chan struct{}),
+	}
+}
+
+// the listener of the named chain to pass to its parser
+func (b *Bus) Listener(chain string) *ChainListener {
+	return &ChainListener{bus: b, chain: chain}
+}
+
+// The matched transactions of a chain, published on the bus
+type ChainListener struct {
+	bus   *Bus
+	chain string
+}
+
+// queue matched transactions for publishing, never blocks the parser
+func (l *ChainListener) Notify(matches []*types.MatchedTransaction) {
+	for _, m := range matches {
+		event := types.NewTransactionEvent(m)
+		data, err := json.Marshal(event)
+		if err != nil {
+			slog.Error("Failed to marshal bus event", "hash", m.Transaction.Hash, "err", err)
+			continue
+		}
+		message := &Message{Topic: l.bus.topics.topic(l.chain, event), Key: []byte(m.Address), Value: data}
+		select {
+		case l.bus.queue <- message:
+		default:
+			slog.Warn("Event bus queue full, dropping event", "hash", m.Transaction.Hash)
+		}
+	}
+}
+
+// publish the queued events until Close
+func (b *Bus) Run() {
+	defer close(b.done)
+	for message := range b.queue {
+		batch := []*Message{message}
+		// take along whatever queued up meanwhile
+	BATCH:
+		for len(batch) < busMaxBatch {
+			select {
+			case message, ok := <-b.queue:
+				if !ok {
+					break BATCH
+				}
+				batch = append(batch, message)
+			default:
+				break BATCH
+			}
+		}
+		b.publish(batch)
+	}
+}
+
+func (b *Bus) publish(batch []*Message) {
+	backoff := busInitialBackoff
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), busPublishTimeout)
+		err := b.publisher.Publish(ctx, batch)
+		cancel()
+		if err == nil {
+			return
+		}
+		if attempt == busMaxAttempts {
+			slog.Error("Event bus publish failed, dropping events", "events", len(batch), "attempts", attempt, "err", err)
+			return
+		}
+		slog.Warn("Event bus publish failed, retrying", "events", len(batch), "err", err, "wait", backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, busMaxBackoff)
+	}
+}
+
+// publish the queued events and close the publisher, once the parsers
+// stopped
+func (b *Bus) Close() error {
+	close(b.queue)
+	<-b.done
+	return b.publisher.Close()
+}
@@ -0,0 +1,38 @@
+package eventbus
+
+import (
+	"context"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Publishes to kafka, topics are created on first use when the brokers allow
+// it
+type KafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+func NewKafkaPublisher(brokers []string) (*KafkaPublisher, error) {
+	return &KafkaPublisher{writer: &kafka.Writer{
+		Addr: kafka.TCP(brokers...),
+		// the same address always lands on the same partition, in order
+		Balancer:               &kafka.Hash{},
+		RequiredAcks:           kafka.RequireAll,
+		AllowAutoTopicCreation: true,
+		// the bus batches already, don't wait for more
+		BatchTimeout: 10 * time.Millisecond,
+	}}, nil
+}
+
+func (p *KafkaPublisher) Publish(ctx context.Context, messages []*Message) error {
+	kafkaMessages := make([]kafka.Message, len(messages))
+	for i, m := range messages {
+		kafkaMessages[i] = kafka.Message{Topic: m.Topic, Key: m.Key, Value: m.Value}
+	}
+	return p.writer.WriteMessages(ctx, kafkaMessages...)
+}
+
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
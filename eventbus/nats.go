@@ -0,0 +1,37 @@
+package eventbus
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Publishes to nats subjects, the topics, with the key in the Key header
+type NatsPublisher struct {
+	conn *nats.Conn
+}
+
+// connect to the nats url, reconnecting on its own when the server goes away
+func NewNatsPublisher(url string) (*NatsPublisher, error) {
+	conn, err := nats.Connect(url, nats.Name("eth-parser"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	return &NatsPublisher{conn: conn}, nil
+}
+
+func (p *NatsPublisher) Publish(ctx context.Context, messages []*Message) error {
+	for _, m := range messages {
+		msg := &nats.Msg{Subject: m.Topic, Data: m.Value, Header: nats.Header{}}
+		msg.Header.Set("Key", string(m.Key))
+		if err := p.conn.PublishMsg(msg); err != nil {
+			return err
+		}
+	}
+	// wait for the server to have them
+	return p.conn.FlushWithContext(ctx)
+}
+
+func (p *NatsPublisher) Close() error {
+	return p.conn.Drain()
+}
@@ -0,0 +1,45 @@
+package parser
+
+import (
+	"context"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// decode the input of the transactions of the blocks touching target
+// addresses, when the called contract has a registered ABI
+func (p *EthParser) decodeInputs(ctx context.Context, blocks []*types.Block) error {
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			target, err := p.isTarget(ctx, tx.From, tx.To)
+			if err != nil {
+				return err
+			}
+			if target {
+				tx.Decoded = p.abis.Decode(tx.To, tx.Input)
+			}
+		}
+	}
+	return nil
+}
+
+// the transactions with their input decoded, copies for the ones saved before
+// the ABI of their contract was registered so the stored ones stay untouched
+func (p *EthParser) withDecoded(txs []*types.Transaction) []*types.Transaction {
+	if p.abis == nil {
+		return txs
+	}
+	decoded := make([]*types.Transaction, len(txs))
+	for i, tx := range txs {
+		decoded[i] = tx
+		if tx.Decoded != nil {
+			continue
+		}
+		if call := p.abis.Decode(tx.To, tx.Input); call != nil {
+			copied := *tx
+			copied.Decoded = call
+			decoded[i] = &copied
+		}
+	}
+	return decoded
+}
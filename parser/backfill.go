@@ -0,0 +1,341 @@
+package parser
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// A historical block range to scan for the transactions of an address
+type backfillJob struct {
+	address string
+	from    int
+	to      int
+	// blocks per eth_getLogs range of a logs backfill, halved when a range
+	// fails as providers cap the logs returned at once
+	logsRange int
+}
+
+// The backfills waiting for the worker, in the order they were requested
+type backfillQueue struct {
+	jobs []*backfillJob
+	wake chan struct{}
+	sync.Mutex
+}
+
+func (q *backfillQueue) push(job *backfillJob) {
+	q.Lock()
+	q.jobs = append(q.jobs, job)
+	q.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// the oldest job, nil when there is none
+func (q *backfillQueue) pop() *backfillJob {
+	q.Lock()
+	defer q.Unlock()
+	if len(q.jobs) == 0 {
+		return nil
+	}
+	job := q.jobs[0]
+	q.jobs = q.jobs[1:]
+	return job
+}
+
+// queue a scan of the blocks from..to for past transactions of an observed
+// address, to 0 or past the current block stops at the current block, false
+// when the address isn't observed or the range is empty
+func (p *EthParser) Backfill(ctx context.Context, address string, from, to int) (bool, error) {
+	if p.following() {
+		return false, ErrReadOnly
+	}
+	job, err := p.newBackfillJob(ctx, address, from, to)
+	if job == nil {
+		return false, err
+	}
+	p.backfills.push(job)
+	p.log.Info("Queued backfill", "address", job.address, "from", job.from, "to", job.to)
+	return true, nil
+}
+
+// scan the blocks like Backfill but right away, returning once done with how
+// many transactions were added, for one-off runs without Start; false when
+// the address isn't observed, the range is empty or the context was cancelled
+func (p *EthParser) RunBackfill(ctx context.Context, address string, from, to int) (int, bool, error) {
+	job, err := p.newBackfillJob(ctx, address, from, to)
+	if job == nil {
+		return 0, false, err
+	}
+	added := p.runBackfill(ctx, job)
+	return added, ctx.Err() == nil, nil
+}
+
+// the job of a backfill, nil when the address isn't observed or the range is
+// empty once capped at the current block
+func (p *EthParser) newBackfillJob(ctx context.Context, address string, from, to int) (*backfillJob, error) {
+	address, err := types.NormalizeAddress(address)
+	if err != nil {
+		return nil, nil
+	}
+	if target, err := p.storage.HasTargetAddress(ctx, address); !target {
+		return nil, err
+	}
+	// later blocks are parsed with the address already observed
+	current, err := p.storage.GetCurrentBlock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if to == 0 || to > current {
+		to = current
+	}
+	if from < 0 || from > to {
+		return nil, nil
+	}
+	return &backfillJob{address: address, from: from, to: to, logsRange: p.logsRange}, nil
+}
+
+// run the queued backfills one after another until the context is cancelled
+func (p *EthParser) runBackfills(ctx context.Context) {
+	for ctx.Err() == nil {
+		job := p.backfills.pop()
+		if job == nil {
+			select {
+			case <-ctx.Done():
+			case <-p.backfills.wake:
+			}
+			continue
+		}
+		p.runBackfill(ctx, job)
+	}
+}
+
+// scan the blocks of the job, retrying failures until the context is
+// cancelled, returns how many transactions were added
+func (p *EthParser) runBackfill(ctx context.Context, job *backfillJob) int {
+	retry := backoff{initial: p.retry.initial, max: p.retry.max}
+	added := 0
+	for from := job.from; from <= job.to && ctx.Err() == nil; {
+		target, err := p.storage.HasTargetAddress(ctx, job.address)
+		if err == nil && !target {
+			p.log.Info("Backfill dropped, address unsubscribed", "address", job.address, "block", from)
+			break
+		}
+		next, n := from, 0
+		if err == nil {
+			next, n, err = p.backfillStep(ctx, job, from)
+		}
+		if err != nil {
+			wait := retry.next(err)
+			p.log.Warn("Backfill failed, backing off", "address", job.address, "block", from, "err", err, "wait", wait.Round(time.Millisecond))
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+			continue
+		}
+		retry.reset()
+		from, added = next, added+n
+	}
+	if ctx.Err() == nil {
+		p.log.Info("Backfill done", "address", job.address, "from", job.from, "to", job.to, "transactions", added)
+	}
+	return added
+}
+
+// backfill the next blocks of the job from the explorer, or else the node,
+// returns the block to continue from and how many transactions were added
+func (p *EthParser) backfillStep(ctx context.Context, job *backfillJob, from int) (_ int, _ int, err error) {
+	ctx, end := startSpan(ctx, "backfillStep", attribute.String("address", job.address), attribute.Int("from", from))
+	defer func() { end(err) }()
+	var (
+		txs  []*types.Transaction
+		next int
+	)
+	switch {
+	case p.explorer != nil:
+		txs, next, err = p.explorerHistory(ctx, job, from)
+	case p.logsRange > 0:
+		txs, next, err = p.logsHistory(ctx, job, from)
+	default:
+		txs, next, err = p.nodeHistory(ctx, job, from)
+	}
+	if err != nil {
+		return from, 0, err
+	}
+	var matches []*types.MatchedTransaction
+	for _, tx := range txs {
+		block := types.BlockNumber(tx.BlockNumber)
+		if strings.ToLower(tx.From) == job.address {
+			matches = append(matches, &types.MatchedTransaction{Address: job.address, Direction: types.DirectionOut, Block: block, Transaction: tx})
+		}
+		if strings.ToLower(tx.To) == job.address {
+			matches = append(matches, &types.MatchedTransaction{Address: job.address, Direction: types.DirectionIn, Block: block, Transaction: tx})
+		}
+	}
+	var added int
+	err = traceStorage(ctx, "BackfillTransactions", func() (err error) {
+		added, err = p.saveBackfill(ctx, job.address, matches)
+		return err
+	})
+	if err != nil {
+		return from, 0, err
+	}
+	return next, added, nil
+}
+
+// the transactions of the blocks fetched in one round of the workers
+func (p *EthParser) nodeHistory(ctx context.Context, job *backfillJob, from int) ([]*types.Transaction, int, error) {
+	to := min(from+p.workers*p.batchSize-1, job.to)
+	var txs []*types.Transaction
+	for _, f := range p.fetchBlocks(ctx, from, to, false) {
+		if f.err != nil {
+			return nil, from, f.err
+		}
+		txs = append(txs, f.block.Transactions...)
+	}
+	return txs, to + 1, nil
+}
+
+// a page of explorer transactions, a full page is cut before its last block
+// which the next page starts from, so no block is split between two pages
+func (p *EthParser) explorerHistory(ctx context.Context, job *backfillJob, from int) ([]*types.Transaction, int, error) {
+	txs, err := p.explorer.transactions(ctx, job.address, from, job.to)
+	if err != nil {
+		return nil, from, err
+	}
+	next := job.to + 1
+	if len(txs) == explorerPageSize {
+		if last := types.BlockNumber(txs[len(txs)-1].BlockNumber); last > from {
+			next = last
+			for len(txs) > 0 && types.BlockNumber(txs[len(txs)-1].BlockNumber) == last {
+				txs = txs[:len(txs)-1]
+			}
+		} else {
+			p.log.Warn("Backfill truncated, a block has more explorer transactions than a page", "address", job.address, "block", last)
+			next = last + 1
+		}
+	}
+	if err := p.completeHistory(ctx, txs); err != nil {
+		return nil, from, err
+	}
+	return txs, next, nil
+}
+
+// the transactions of the blocks of the next range with logs of the address,
+// emitted by it or with it in an indexed argument, e.g. the sender of a token
+// transfer; the blocks themselves are never fetched
+func (p *EthParser) logsHistory(ctx context.Context, job *backfillJob, from int) ([]*types.Transaction, int, error) {
+	to := min(from+job.logsRange-1, job.to)
+	// an indexed address is padded to a word
+	topic := "0x" + strings.Repeat("0", 24) + strings.TrimPrefix(job.address, "0x")
+	filters := []struct {
+		contract string
+		topics   []interface{}
+	}{
+		{contract: job.address},
+		{topics: []interface{}{nil, topic}},
+		{topics: []interface{}{nil, nil, topic}},
+		{topics: []interface{}{nil, nil, nil, topic}},
+	}
+	seen := make(map[string]bool)
+	var hashes []string
+	for _, filter := range filters {
+		logs, err := p.client.FetchContractLogs(ctx, from, to, filter.contract, filter.topics)
+		if err != nil {
+			job.logsRange = max(job.logsRange/2, 1)
+			return nil, from, err
+		}
+		for _, log := range logs {
+			if !log.Removed && !seen[log.TransactionHash] {
+				seen[log.TransactionHash] = true
+				hashes = append(hashes, log.TransactionHash)
+			}
+		}
+	}
+	var txs []*types.Transaction
+	for start := 0; start < len(hashes); start += logsTransactionsBatch {
+		batch, err := p.client.FetchTransactions(ctx, hashes[start:min(start+logsTransactionsBatch, len(hashes))])
+		if err != nil {
+			return nil, from, err
+		}
+		txs = append(txs, batch...)
+	}
+	// stored in chain order, the filters found them in their own
+	slices.SortFunc(txs, func(a, b *types.Transaction) int {
+		if n := types.BlockNumber(a.BlockNumber) - types.BlockNumber(b.BlockNumber); n != 0 {
+			return n
+		}
+		return types.BlockNumber(a.TransactionIndex) - types.BlockNumber(b.TransactionIndex)
+	})
+	if err := p.completeHistory(ctx, txs); err != nil {
+		return nil, from, err
+	}
+	return txs, to + 1, nil
+}
+
+// transactions fetched by hash per batch request of a logs backfill
+const logsTransactionsBatch = 100
+
+// add the receipts, the decoded inputs and the addresses of the created
+// contracts to the transactions found without fetching their blocks
+func (p *EthParser) completeHistory(ctx context.Context, txs []*types.Transaction) error {
+	for _, tx := range txs {
+		tx.SetContractAddress()
+	}
+	if p.receipts && len(txs) > 0 {
+		receipts, err := p.client.FetchMatchedReceipts(ctx, txs)
+		if err != nil {
+			return err
+		}
+		for i, tx := range txs {
+			applyReceipt(tx, receipts[i])
+		}
+	}
+	if p.abis != nil {
+		for _, tx := range txs {
+			tx.Decoded = p.abis.Decode(tx.To, tx.Input)
+		}
+	}
+	return nil
+}
+
+// save the matches between two parsed blocks, skipping those of blocks
+// rolled back or reorged since they were fetched
+func (p *EthParser) saveBackfill(ctx context.Context, address string, matches []*types.MatchedTransaction) (int, error) {
+	p.step.Lock()
+	defer p.step.Unlock()
+	current, err := p.storage.GetCurrentBlock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var kept []*types.MatchedTransaction
+	for _, m := range matches {
+		if m.Block > current {
+			continue
+		}
+		hash, err := p.storage.GetBlockHash(ctx, m.Block)
+		if err != nil {
+			return 0, err
+		}
+		if hash != "" && hash != m.Transaction.BlockHash {
+			continue
+		}
+		kept = append(kept, m)
+	}
+	if len(kept) == 0 {
+		return 0, nil
+	}
+	added, err := p.storage.BackfillTransactions(ctx, address, kept)
+	if added > 0 {
+		p.revisions.bump(address)
+	}
+	return added, err
+}
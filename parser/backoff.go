@@ -0,0 +1,30 @@
+package parser
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/passwizards/eth-parser/rpcclient"
+)
+
+// The wait after failed rpc calls, doubling with every failure in a row up
+// to max, jittered so parsers sharing a provider do not retry in lockstep
+type backoff struct {
+	initial  time.Duration
+	max      time.Duration
+	failures int
+}
+
+// the wait before retrying after err, at least what a rate limiting
+// provider asked for with Retry-After
+func (b *backoff) next(err error) time.Duration {
+	wait := min(b.initial<<min(b.failures, 20), b.max)
+	b.failures++
+	// half fixed, half random
+	wait = wait/2 + rand.N(wait/2+1)
+	return max(wait, rpcclient.RetryAfter(err))
+}
+
+func (b *backoff) reset() {
+	b.failures = 0
+}
@@ -0,0 +1,98 @@
+package parser
+
+import (
+	"context"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// the balance changes of the address per block from..to with a stored
+// transaction, oldest first; a zero to has no upper bound. The balance is
+// rebuilt from the stored transactions only, so it is the one on chain when
+// they go back to the first one of the address and it got no mining rewards
+// or withdrawals, and the fees of transactions stored without their receipt
+// are unknown to it
+func (p *EthParser) GetBalanceHistory(ctx context.Context, address string, from, to int) ([]*types.BalanceChange, error) {
+	address = strings.ToLower(address)
+	// the changes before the range add up to its opening balance
+	txs, err := p.QueryTransactions(ctx, address, types.TransactionFilter{ToBlock: to})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(txs, func(i, j int) bool {
+		return types.BlockNumber(txs[i].BlockNumber) < types.BlockNumber(txs[j].BlockNumber)
+	})
+
+	changes := []*types.BalanceChange{}
+	var balance, received, sent, fees big.Int
+	var change *types.BalanceChange
+	// close the change of the block, kept when it is in the range
+	closeBlock := func() {
+		if change == nil {
+			return
+		}
+		balance.Add(&balance, &received)
+		balance.Sub(&balance, &sent)
+		balance.Sub(&balance, &fees)
+		if change.Block >= from {
+			net := new(big.Int).Sub(&received, &sent)
+			change.Received, change.Sent, change.Fees = hexQuantity(&received), hexQuantity(&sent), hexQuantity(&fees)
+			change.Change, change.Balance = hexQuantity(net.Sub(net, &fees)), hexQuantity(&balance)
+			changes = append(changes, change)
+		}
+		received.SetInt64(0)
+		sent.SetInt64(0)
+		fees.SetInt64(0)
+		change = nil
+	}
+	// a self transfer is stored twice, its first copy pays the fee
+	self := make(map[string]bool)
+	for _, tx := range txs {
+		block := types.BlockNumber(tx.BlockNumber)
+		if change != nil && change.Block != block {
+			closeBlock()
+		}
+		if change == nil {
+			change = &types.BalanceChange{Block: block, Timestamp: tx.BlockTimestamp}
+		}
+		// a failed transaction moved no value but still paid for its gas
+		value := tx.ValueWei()
+		if tx.Status == "0x0" || value == nil {
+			value = new(big.Int)
+		}
+		direction := tx.RelativeTo(address).Direction
+		if direction == types.DirectionSelf {
+			key := tx.Key()
+			if direction = types.DirectionOut; self[key] {
+				// the second copy is the inbound one, counted already
+				direction = types.DirectionIn
+				change.Transactions--
+			}
+			self[key] = true
+		}
+		change.Transactions++
+		switch direction {
+		case types.DirectionIn:
+			received.Add(&received, value)
+		case types.DirectionOut:
+			sent.Add(&sent, value)
+			// internal transactions pay no gas of their own
+			if fee := tx.ComputeFees(); fee != nil {
+				fees.Add(&fees, types.ParseQuantity(fee.TotalFee))
+			}
+		}
+	}
+	closeBlock()
+	return changes, nil
+}
+
+// the signed hex quantity, "-0x.." when negative
+func hexQuantity(v *big.Int) string {
+	if v.Sign() < 0 {
+		return "-0x" + new(big.Int).Neg(v).Text(16)
+	}
+	return "0x" + v.Text(16)
+}
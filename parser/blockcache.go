@@ -0,0 +1,128 @@
+package parser
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// a few batches of a fast catch up, mainnet blocks take a few hundred kB
+const defaultBlockCache = 128
+
+// The bodies of the blocks fetched last as the node returned them, so a batch
+// fetched again after a failed save, a block retried alone or the header of
+// a block read for a transaction doesn't cost another rpc call; the least
+// recently used is evicted when full
+type blockCache struct {
+	size int
+	// of *cachedBlock, the most recently used first
+	order  *list.List
+	blocks map[int]*list.Element
+	sync.Mutex
+}
+
+type cachedBlock struct {
+	number int
+	block  *types.Block
+}
+
+// a cache of up to size blocks, nil when size is 0
+func newBlockCache(size int) *blockCache {
+	if size <= 0 {
+		return nil
+	}
+	return &blockCache{size: size, order: list.New(), blocks: make(map[int]*list.Element)}
+}
+
+// a copy of the cached block, nil when it isn't cached
+func (c *blockCache) get(number int) *types.Block {
+	if c == nil {
+		return nil
+	}
+	c.Lock()
+	defer c.Unlock()
+	element := c.blocks[number]
+	if element == nil {
+		return nil
+	}
+	c.order.MoveToFront(element)
+	return copyBlock(element.Value.(*cachedBlock).block)
+}
+
+// cache copies of the blocks as fetched, before the parser fills in their
+// transactions
+func (c *blockCache) put(blocks ...*types.Block) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	for _, block := range blocks {
+		number := types.BlockNumber(block.Number)
+		if element := c.blocks[number]; element != nil {
+			element.Value.(*cachedBlock).block = copyBlock(block)
+			c.order.MoveToFront(element)
+			continue
+		}
+		if c.order.Len() >= c.size {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.blocks, oldest.Value.(*cachedBlock).number)
+		}
+		c.blocks[number] = c.order.PushFront(&cachedBlock{number: number, block: copyBlock(block)})
+	}
+}
+
+// forget the blocks from..to, e.g. reorged away or to be fetched fresh; a
+// zero to has no upper bound
+func (c *blockCache) drop(from, to int) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	for number, element := range c.blocks {
+		if number >= from && (to == 0 || number <= to) {
+			c.order.Remove(element)
+			delete(c.blocks, number)
+		}
+	}
+}
+
+// a copy of the block and its transactions, which the parser fills in
+func copyBlock(block *types.Block) *types.Block {
+	copied := *block
+	copied.Transactions = make([]*types.Transaction, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		txCopy := *tx
+		copied.Transactions[i] = &txCopy
+	}
+	return &copied
+}
+
+// the blocks from..to, the cached ones from the cache and the span of the
+// others with a single call
+func (p *EthParser) cachedBlocks(ctx context.Context, from, to int) ([]*types.Block, error) {
+	blocks := make([]*types.Block, to-from+1)
+	first, last := -1, -1
+	for i := range blocks {
+		if blocks[i] = p.blocks.get(from + i); blocks[i] == nil {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return blocks, nil
+	}
+	fetched, err := p.client.FetchBlocks(ctx, from+first, from+last)
+	if err != nil {
+		return nil, err
+	}
+	p.blocks.put(fetched...)
+	copy(blocks[first:], fetched)
+	return blocks, nil
+}
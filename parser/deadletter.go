@@ -0,0 +1,268 @@
+package parser
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/passwizards/eth-parser/rpcclient"
+	"github.com/passwizards/eth-parser/types"
+)
+
+// A block the parser kept failing to fetch or save, one json line of the dead
+// letter file, with what was fetched of it
+type deadLetter struct {
+	types.FailedBlock
+	Transactions   []*types.Transaction   `json:"transactions,omitempty"`
+	TokenTransfers []*types.TokenTransfer `json:"tokenTransfers,omitempty"`
+	NftTransfers   []*types.NftTransfer   `json:"nftTransfers,omitempty"`
+	Events         []*types.Event         `json:"events,omitempty"`
+}
+
+// The blocks given up on, in block order, rewritten to the file on every
+// change when one is set so they survive restarts
+type deadLetters struct {
+	path string
+	// failures in a row before a block is given up on, 0 retries forever
+	tries int
+	list  []*deadLetter
+	sync.Mutex
+}
+
+// read the dead letters of the file, a missing file has none
+func (d *deadLetters) load() error {
+	if d.path == "" {
+		return nil
+	}
+	file, err := os.Open(d.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open dead letter file, err %v", err)
+	}
+	defer file.Close()
+	d.Lock()
+	defer d.Unlock()
+	scanner := bufio.NewScanner(file)
+	// a line holds the transactions of a whole block
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var letter deadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			return fmt.Errorf("failed to read dead letter file, err %v", err)
+		}
+		d.putLocked(&letter)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read dead letter file, err %v", err)
+	}
+	return nil
+}
+
+// add the letter, replacing the one of the same block
+func (d *deadLetters) putLocked(letter *deadLetter) {
+	i, found := slices.BinarySearchFunc(d.list, letter.Block, func(l *deadLetter, block int) int { return l.Block - block })
+	if found {
+		d.list[i] = letter
+	} else {
+		d.list = slices.Insert(d.list, i, letter)
+	}
+}
+
+func (d *deadLetters) add(letter *deadLetter) error {
+	d.Lock()
+	defer d.Unlock()
+	d.putLocked(letter)
+	return d.saveLocked()
+}
+
+// drop the block once it was parsed, false when it wasn't dead-lettered
+func (d *deadLetters) resolve(block int) (bool, error) {
+	d.Lock()
+	defer d.Unlock()
+	i, found := slices.BinarySearchFunc(d.list, block, func(l *deadLetter, block int) int { return l.Block - block })
+	if !found {
+		return false, nil
+	}
+	d.list = slices.Delete(d.list, i, i+1)
+	return true, d.saveLocked()
+}
+
+func (d *deadLetters) has(block int) bool {
+	d.Lock()
+	defer d.Unlock()
+	_, found := slices.BinarySearchFunc(d.list, block, func(l *deadLetter, block int) int { return l.Block - block })
+	return found
+}
+
+func (d *deadLetters) failedBlocks() []*types.FailedBlock {
+	d.Lock()
+	defer d.Unlock()
+	blocks := make([]*types.FailedBlock, len(d.list))
+	for i, letter := range d.list {
+		failed := letter.FailedBlock
+		blocks[i] = &failed
+	}
+	return blocks
+}
+
+// rewrite the file with the letters, through a temporary file so a crash
+// never leaves it half written
+func (d *deadLetters) saveLocked() error {
+	if d.path == "" {
+		return nil
+	}
+	tmp := d.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to write dead letter file, err %v", err)
+	}
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	for _, letter := range d.list {
+		if err = enc.Encode(letter); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, d.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write dead letter file, err %v", err)
+	}
+	return nil
+}
+
+// The block after the current one the parser last failed to fetch or save,
+// how many times in a row and whether it is dead-lettered already
+type blockFailures struct {
+	block    int
+	attempts int
+	written  bool
+}
+
+// count the failed fetch or save of the block after the current one, it is
+// dead-lettered once it failed as many times as allowed; returns the block to
+// continue after and the error to back off with, nil once the block was
+// dead-lettered
+func (p *EthParser) blockFailed(ctx context.Context, failures *blockFailures, currentBlock int, f *fetchedBlock, cause error) (int, error) {
+	// a provider throttling the parser isn't failing on the block
+	if p.deadLetters.tries == 0 || errors.As(cause, new(*rpcclient.RetryAfterError)) {
+		return currentBlock, cause
+	}
+	if failures.block != currentBlock+1 {
+		*failures = blockFailures{block: currentBlock + 1}
+	}
+	failures.attempts++
+	if failures.attempts < p.deadLetters.tries {
+		return currentBlock, cause
+	}
+	if f.block == nil {
+		// a node that can't report its head is down rather than failing on
+		// the block, it is retried until the node is back
+		if _, err := p.client.GetLatestBlockNumber(ctx); err != nil {
+			return currentBlock, cause
+		}
+	}
+	if !failures.written {
+		if err := p.deadLetters.add(newDeadLetter(currentBlock+1, failures.attempts, f, cause)); err != nil {
+			p.log.Error("Failed to dead-letter block", "block", currentBlock+1, "err", err)
+			return currentBlock, cause
+		}
+		failures.written = true
+	}
+	// retried on its own while the storage can't even move past the block
+	next, err := p.skipBlock(ctx, currentBlock)
+	if err != nil || next == currentBlock {
+		return currentBlock, err
+	}
+	p.log.Error("Dead-lettered block, it kept failing", "block", next, "attempts", failures.attempts, "err", cause)
+	*failures = blockFailures{}
+	return next, nil
+}
+
+func newDeadLetter(block, attempts int, f *fetchedBlock, cause error) *deadLetter {
+	letter := &deadLetter{
+		FailedBlock: types.FailedBlock{
+			Block:    block,
+			Error:    cause.Error(),
+			Attempts: attempts,
+			Time:     time.Now().UTC(),
+		},
+		TokenTransfers: f.transfers,
+		NftTransfers:   f.nfts,
+		Events:         f.events,
+	}
+	// nothing but the error when the fetch failed
+	if f.block != nil {
+		letter.Hash = f.block.Hash
+		letter.Transactions = f.block.Transactions
+	}
+	return letter
+}
+
+// the blocks the parser gave up on, in block order
+func (p *EthParser) GetFailedBlocks() []*types.FailedBlock {
+	return p.deadLetters.failedBlocks()
+}
+
+// parse a dead-lettered block again by moving the parser back before it, the
+// blocks after it are parsed again too and what they stored already skipped;
+// it leaves the dead letters once parsed, false when it isn't dead-lettered
+func (p *EthParser) RetryFailedBlock(ctx context.Context, block int) (bool, error) {
+	if !p.deadLetters.has(block) {
+		return false, nil
+	}
+	current, err := p.storage.GetCurrentBlock(ctx)
+	if err != nil {
+		return false, &StorageError{Err: err}
+	}
+	if current < block {
+		// moved back before it meanwhile, parsed on the way already
+		return true, nil
+	}
+	return true, p.SetCurrentBlock(ctx, block-1, false)
+}
+
+// drop the dead letter of a block once parsed
+func (p *EthParser) resolveDeadLetter(block int) {
+	resolved, err := p.deadLetters.resolve(block)
+	if err != nil {
+		p.log.Warn("Failed to drop the dead letter of a parsed block", "block", block, "err", err)
+	}
+	if resolved {
+		p.log.Info("Dead-lettered block parsed", "block", block)
+	}
+}
+
+// move the current block past the one after it without saving it
+func (p *EthParser) skipBlock(ctx context.Context, currentBlock int) (int, error) {
+	p.step.Lock()
+	defer p.step.Unlock()
+	if p.resync {
+		// the storage was replaced meanwhile, the loop resyncs with it first
+		return currentBlock, nil
+	}
+	err := traceStorage(ctx, "SetCurrentBlock", func() error {
+		return p.storage.SetCurrentBlock(context.WithoutCancel(ctx), currentBlock+1)
+	})
+	if err != nil {
+		return currentBlock, err
+	}
+	p.health.parsed(currentBlock + 1)
+	return currentBlock + 1, nil
+}
@@ -0,0 +1,147 @@
+package parser
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	// the ENS registry, at the same address on mainnet and its testnets
+	ensRegistry = "0x00000000000c2e074ec69a0dfb2997ba6c7d2e1e"
+	// bytes4(keccak256("resolver(bytes32)"))
+	resolverSelector = "0x0178b8bf"
+	// bytes4(keccak256("addr(bytes32)"))
+	addrSelector = "0x3b3b57de"
+	// bytes4(keccak256("name(bytes32)"))
+	ensNameSelector = "0x691f3431"
+	// how long a resolved name or address is kept, records rarely change
+	ensTtl = time.Hour
+)
+
+// ResolveName and LookupAddress without WithEns
+var ErrEnsDisabled = errors.New("ens names aren't enabled")
+
+// The names resolved to addresses and the addresses to their primary name,
+// empty when there is none, kept for ensTtl; failed calls aren't cached
+type ensCache struct {
+	addresses map[string]ensEntry
+	names     map[string]ensEntry
+	sync.Mutex
+}
+
+type ensEntry struct {
+	value   string
+	expires time.Time
+}
+
+func (c *ensCache) get(entries map[string]ensEntry, key string) (string, bool) {
+	c.Lock()
+	defer c.Unlock()
+	entry, ok := entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return "", false
+	}
+	return entry.value, true
+}
+
+func (c *ensCache) put(entries map[string]ensEntry, key, value string) {
+	c.Lock()
+	defer c.Unlock()
+	entries[key] = ensEntry{value: value, expires: time.Now().Add(ensTtl)}
+}
+
+// the lowercase address the ENS name resolves to, empty when it doesn't
+func (p *EthParser) ResolveName(ctx context.Context, name string) (string, error) {
+	if p.ens == nil {
+		return "", ErrEnsDisabled
+	}
+	name, err := types.NormalizeEnsName(name)
+	if err != nil {
+		return "", err
+	}
+	if address, ok := p.ens.get(p.ens.addresses, name); ok {
+		return address, nil
+	}
+	address, err := p.ensRecord(ctx, namehash(name), addrSelector)
+	if err != nil {
+		return "", err
+	}
+	address = wordAddress(address)
+	p.ens.put(p.ens.addresses, name, address)
+	return address, nil
+}
+
+// the primary ENS name of the address, empty without one or when the name
+// doesn't resolve back to the address
+func (p *EthParser) LookupAddress(ctx context.Context, address string) (string, error) {
+	if p.ens == nil {
+		return "", ErrEnsDisabled
+	}
+	address = strings.ToLower(address)
+	if name, ok := p.ens.get(p.ens.names, address); ok {
+		return name, nil
+	}
+	output, err := p.ensRecord(ctx, namehash(strings.TrimPrefix(address, "0x")+".addr.reverse"), ensNameSelector)
+	if err != nil {
+		return "", err
+	}
+	name := ""
+	if claimed, err := types.NormalizeEnsName(decodeAbiString(output)); err == nil {
+		// anyone can claim any name for their address, only its owner can
+		// point it back
+		resolved, err := p.ResolveName(ctx, claimed)
+		if err != nil {
+			return "", err
+		}
+		if resolved == address {
+			name = claimed
+		}
+	}
+	p.ens.put(p.ens.names, address, name)
+	return name, nil
+}
+
+// the output of the record of the node at its resolver, "0x" without one
+func (p *EthParser) ensRecord(ctx context.Context, node []byte, selector string) (string, error) {
+	output, err := p.client.Call(ctx, ensRegistry, resolverSelector+hex.EncodeToString(node))
+	if err != nil {
+		return "", err
+	}
+	resolver := wordAddress(output)
+	if resolver == "" {
+		return "0x", nil
+	}
+	return p.client.Call(ctx, resolver, selector+hex.EncodeToString(node))
+}
+
+// the address in the last 20 bytes of the 32 byte word, empty when it is
+// missing or zero
+func wordAddress(output string) string {
+	output = strings.TrimPrefix(output, "0x")
+	if len(output) < 64 || strings.Trim(output[:64], "0") == "" {
+		return ""
+	}
+	return "0x" + strings.ToLower(output[24:64])
+}
+
+// the EIP-137 namehash of the normalized name
+func namehash(name string) []byte {
+	node := make([]byte, 32)
+	labels := strings.Split(name, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		label := sha3.NewLegacyKeccak256()
+		label.Write([]byte(labels[i]))
+		hasher := sha3.NewLegacyKeccak256()
+		hasher.Write(node)
+		hasher.Write(label.Sum(nil))
+		node = hasher.Sum(nil)
+	}
+	return node
+}
@@ -0,0 +1,101 @@
+package parser
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// watch the events of the contract with the topic0, e.g. the keccak256 of
+// Transfer(address,address,uint256), false when already watched or malformed
+func (p *EthParser) SubscribeEvent(ctx context.Context, contract, topic string) (bool, error) {
+	contract, err := types.NormalizeAddress(contract)
+	if err != nil {
+		return false, nil
+	}
+	if topic, err = types.NormalizeHash(topic); err != nil {
+		return false, nil
+	}
+	return p.storage.AddEventSubscription(ctx, contract, topic)
+}
+
+// stop watching the events of the contract with the topic0, dropping the
+// stored ones, false when they weren't watched
+func (p *EthParser) UnsubscribeEvent(ctx context.Context, contract, topic string) (bool, error) {
+	contract, err := types.NormalizeAddress(contract)
+	if err != nil {
+		return false, nil
+	}
+	if topic, err = types.NormalizeHash(topic); err != nil {
+		return false, nil
+	}
+	return p.storage.RemoveEventSubscription(ctx, contract, topic)
+}
+
+// the watched contract events, sorted by contract and topic
+func (p *EthParser) GetEventSubscriptions(ctx context.Context) ([]*types.EventSubscription, error) {
+	return p.storage.GetEventSubscriptions(ctx)
+}
+
+// the stored events of a contract in chain order, decoded when its ABI is
+// registered, copies for the ones saved before it was so the stored ones
+// stay untouched
+func (p *EthParser) GetEvents(ctx context.Context, contract string) ([]*types.Event, error) {
+	events, err := p.storage.GetEvents(ctx, contract)
+	if err != nil || p.abis == nil {
+		return events, err
+	}
+	decoded := make([]*types.Event, len(events))
+	for i, e := range events {
+		decoded[i] = e
+		if e.Decoded != nil {
+			continue
+		}
+		if event := p.abis.DecodeLog(&e.Log); event != nil {
+			copied := *e
+			copied.Decoded = event
+			decoded[i] = &copied
+		}
+	}
+	return decoded, nil
+}
+
+// the logs of the blocks fromBlock..toBlock matching the watched contract
+// events, read with a single eth_getLogs for all of them, nil when none is
+// watched
+func (p *EthParser) fetchEvents(ctx context.Context, fromBlock, toBlock int) ([]*types.Event, error) {
+	subscriptions, err := p.storage.GetEventSubscriptions(ctx)
+	if err != nil || len(subscriptions) == 0 {
+		return nil, err
+	}
+	watched := make(map[string]bool, len(subscriptions))
+	var contracts, topics []string
+	for _, s := range subscriptions {
+		watched[s.Contract+"/"+s.Topic] = true
+		if !slices.Contains(contracts, s.Contract) {
+			contracts = append(contracts, s.Contract)
+		}
+		if !slices.Contains(topics, s.Topic) {
+			topics = append(topics, s.Topic)
+		}
+	}
+	logs, err := p.client.FetchContractsLogs(ctx, fromBlock, toBlock, contracts, []interface{}{topics})
+	if err != nil {
+		return nil, err
+	}
+	var events []*types.Event
+	for _, log := range logs {
+		// the filter matches every topic of every contract, not the pairs
+		if log.Removed || len(log.Topics) == 0 || !watched[strings.ToLower(log.Address)+"/"+strings.ToLower(log.Topics[0])] {
+			continue
+		}
+		e := &types.Event{Log: *log}
+		if p.abis != nil {
+			e.Decoded = p.abis.DecodeLog(log)
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
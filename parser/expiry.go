@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"context"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// how often the subscriptions past their expiry are dropped
+const expiryInterval = time.Minute
+
+// unwatch an address the tenant observes once the ttl passed, like
+// Unsubscribe, e.g. a one-time deposit address; 0 keeps it watched, false
+// when it isn't observed or malformed
+func (p *EthParser) SetSubscriptionTtl(ctx context.Context, tenant, address string, ttl time.Duration) (bool, error) {
+	address, err := types.NormalizeAddress(address)
+	if err != nil {
+		return false, nil
+	}
+	label, err := p.storage.GetAddressLabel(ctx, tenant, address)
+	if err != nil {
+		return false, err
+	}
+	// the expiry lives on the label, which an address without one gets
+	updated := &types.AddressLabel{}
+	if label != nil {
+		*updated = *label
+	}
+	updated.Expires = nil
+	if ttl > 0 {
+		expires := time.Now().Add(ttl).UTC().Truncate(time.Second)
+		updated.Expires = &expires
+	}
+	if set, err := p.storage.SetAddressLabel(ctx, tenant, address, updated); !set {
+		return false, err
+	}
+	p.revisions.bump(address)
+	return true, nil
+}
+
+// unsubscribe the addresses past their expiry every expiryInterval until the
+// context is cancelled
+func (p *EthParser) expireSubscriptions(ctx context.Context) {
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		tenants, err := p.storage.GetTenants(ctx)
+		if err != nil {
+			// retried on the next tick
+			p.log.Warn("Failed to expire subscriptions", "err", err)
+			continue
+		}
+		for _, tenant := range tenants {
+			p.expireTenantSubscriptions(ctx, tenant)
+		}
+	}
+}
+
+// unsubscribe the addresses of the tenant past their expiry
+func (p *EthParser) expireTenantSubscriptions(ctx context.Context, tenant string) {
+	subscriptions, err := p.storage.GetSubscriptions(ctx, tenant)
+	if err != nil {
+		p.log.Warn("Failed to expire subscriptions", "tenant", tenant, "err", err)
+		return
+	}
+	now := time.Now()
+	for _, subscription := range subscriptions {
+		if subscription.Expires == nil || subscription.Expires.After(now) {
+			continue
+		}
+		removed, err := p.Unsubscribe(ctx, tenant, subscription.Address)
+		if err != nil {
+			p.log.Warn("Failed to expire subscription", "tenant", tenant, "address", subscription.Address, "err", err)
+			continue
+		}
+		if removed {
+			p.log.Info("Subscription expired", "tenant", tenant, "address", subscription.Address, "expires", *subscription.Expires)
+		}
+	}
+}
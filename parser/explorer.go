@@ -0,0 +1,126 @@
+package parser
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// transactions per explorer request, etherscan returns at most 10000 over
+// all pages of a query
+const explorerPageSize = 1000
+
+// An etherscan style explorer api, listing the transactions of an address
+// without scanning every block
+type explorer struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func newExplorer(url, apiKey string) *explorer {
+	return &explorer{url: url, apiKey: apiKey, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+type explorerResponse struct {
+	Status  string          `json:"status"`
+	Message string          `json:"message"`
+	Result  json.RawMessage `json:"result"`
+}
+
+// a txlist entry, quantities are decimal
+type explorerTransaction struct {
+	BlockNumber      string `json:"blockNumber"`
+	BlockHash        string `json:"blockHash"`
+	TimeStamp        string `json:"timeStamp"`
+	Hash             string `json:"hash"`
+	Nonce            string `json:"nonce"`
+	TransactionIndex string `json:"transactionIndex"`
+	From             string `json:"from"`
+	To               string `json:"to"`
+	Value            string `json:"value"`
+	Gas              string `json:"gas"`
+	GasPrice         string `json:"gasPrice"`
+	Input            string `json:"input"`
+}
+
+// the first page of the external transactions of the address in the blocks
+// from..to, oldest first
+func (e *explorer) transactions(ctx context.Context, address string, from, to int) ([]*types.Transaction, error) {
+	u, err := url.Parse(e.url)
+	if err != nil {
+		return nil, err
+	}
+	// keeps parameters of the configured url, e.g. the chainid of etherscan v2
+	query := u.Query()
+	query.Set("module", "account")
+	query.Set("action", "txlist")
+	query.Set("address", address)
+	query.Set("startblock", strconv.Itoa(from))
+	query.Set("endblock", strconv.Itoa(to))
+	query.Set("page", "1")
+	query.Set("offset", strconv.Itoa(explorerPageSize))
+	query.Set("sort", "asc")
+	if e.apiKey != "" {
+		query.Set("apikey", e.apiKey)
+	}
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("explorer replied %s", resp.Status)
+	}
+	var body explorerResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode explorer response, err %v", err)
+	}
+	var entries []*explorerTransaction
+	if err := json.Unmarshal(body.Result, &entries); err != nil {
+		// errors come as a string result, e.g. a rate limit
+		var message string
+		json.Unmarshal(body.Result, &message)
+		return nil, fmt.Errorf("explorer error %s, %s", body.Message, message)
+	}
+	txs := make([]*types.Transaction, len(entries))
+	for i, entry := range entries {
+		txs[i] = &types.Transaction{
+			BlockHash:        entry.BlockHash,
+			BlockNumber:      decimalToHex(entry.BlockNumber),
+			BlockTimestamp:   decimalToHex(entry.TimeStamp),
+			From:             strings.ToLower(entry.From),
+			Gas:              decimalToHex(entry.Gas),
+			GasPrice:         decimalToHex(entry.GasPrice),
+			Hash:             entry.Hash,
+			Input:            entry.Input,
+			Nonce:            decimalToHex(entry.Nonce),
+			To:               strings.ToLower(entry.To),
+			TransactionIndex: decimalToHex(entry.TransactionIndex),
+			Value:            decimalToHex(entry.Value),
+		}
+	}
+	return txs, nil
+}
+
+// the hex quantity of a decimal one, empty stays empty
+func decimalToHex(decimal string) string {
+	v, ok := new(big.Int).SetString(decimal, 10)
+	if !ok {
+		return ""
+	}
+	return "0x" + v.Text(16)
+}
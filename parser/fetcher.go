@@ -0,0 +1,105 @@
+package parser
+
+import (
+	"context"
+	"sync"
+
+	"github.com/passwizards/eth-parser/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// A block fetched ahead of parsing, with its ERC-20 and NFT transfers when
+// tracked and the logs of the watched contract events
+type fetchedBlock struct {
+	block     *types.Block
+	transfers []*types.TokenTransfer
+	nfts      []*types.NftTransfer
+	events    []*types.Event
+	err       error
+}
+
+// fetch the blocks from..to with one worker per batch, the result keeps the
+// block order so they can be saved one after another, transfers are only
+// fetched when asked for
+func (p *EthParser) fetchBlocks(ctx context.Context, from, to int, transfers bool) []*fetchedBlock {
+	fetched := make([]*fetchedBlock, to-from+1)
+	var wg sync.WaitGroup
+	for start := from; start <= to; start += p.batchSize {
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			copy(fetched[start-from:], p.fetchBatch(ctx, start, end, transfers))
+		}(start, min(start+p.batchSize-1, to))
+	}
+	wg.Wait()
+	return fetched
+}
+
+// fetch a batch of blocks, their transfers come from a single eth_getLogs as
+// do their contract events
+func (p *EthParser) fetchBatch(ctx context.Context, from, to int, withTransfers bool) []*fetchedBlock {
+	ctx, end := startSpan(ctx, "fetchBatch", attribute.Int("from", from), attribute.Int("to", to))
+	fetched := make([]*fetchedBlock, to-from+1)
+	var blocks []*types.Block
+	var err error
+	if withTransfers {
+		blocks, err = p.cachedBlocks(ctx, from, to)
+	} else {
+		// backfills scan past blocks once, caching them would only evict the
+		// recent ones
+		blocks, err = p.client.FetchBlocks(ctx, from, to)
+	}
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			tx.BlockTimestamp, tx.BaseFeePerGas = block.Timestamp, block.BaseFeePerGas
+			tx.SetContractAddress()
+		}
+	}
+	if err == nil && p.receipts {
+		err = p.enrichReceipts(ctx, blocks)
+	}
+	if err == nil && p.traces != "" {
+		err = p.addInternalTransactions(ctx, blocks)
+	}
+	if err == nil {
+		err = p.tagSweeps(ctx, blocks)
+	}
+	if err == nil && p.abis != nil {
+		err = p.decodeInputs(ctx, blocks)
+	}
+	var transfers []*types.TokenTransfer
+	var nfts []*types.NftTransfer
+	if err == nil && withTransfers && (p.tokens || p.nfts) {
+		transfers, nfts, err = p.FetchTransfers(ctx, from, to)
+	}
+	var events []*types.Event
+	if err == nil && withTransfers {
+		events, err = p.fetchEvents(ctx, from, to)
+	}
+	end(err)
+	if err != nil {
+		for i := range fetched {
+			fetched[i] = &fetchedBlock{err: err}
+		}
+		return fetched
+	}
+	for i, block := range blocks {
+		fetched[i] = &fetchedBlock{block: block}
+	}
+	for _, transfer := range transfers {
+		if i := types.BlockNumber(transfer.BlockNumber) - from; i >= 0 && i < len(fetched) {
+			fetched[i].transfers = append(fetched[i].transfers, transfer)
+		}
+	}
+	for _, transfer := range nfts {
+		if i := types.BlockNumber(transfer.BlockNumber) - from; i >= 0 && i < len(fetched) {
+			fetched[i].nfts = append(fetched[i].nfts, transfer)
+		}
+	}
+	for _, e := range events {
+		if i := types.BlockNumber(e.BlockNumber) - from; i >= 0 && i < len(fetched) {
+			fetched[i].events = append(fetched[i].events, e)
+		}
+	}
+	return fetched
+}
@@ -0,0 +1,131 @@
+package parser
+
+import (
+	"math/big"
+	"slices"
+	"sync"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// how many of the last parsed blocks the gas prices are kept of
+const GasPriceBlocks = 200
+
+// The fees paid in the last parsed blocks, oldest first
+type gasPrices struct {
+	blocks []*blockFees
+	sync.Mutex
+}
+
+type blockFees struct {
+	number int
+	// nil before London
+	baseFee *big.Int
+	// the effective gas prices and the priority fees per gas of its
+	// transactions, sorted
+	prices []*big.Int
+	tips   []*big.Int
+}
+
+// keep the fees of the parsed block, the ones of the blocks it replaced after
+// a reorg are dropped
+func (g *gasPrices) add(block *types.Block) {
+	fees := &blockFees{number: types.BlockNumber(block.Number), baseFee: types.ParseQuantity(block.BaseFeePerGas)}
+	for _, tx := range block.Transactions {
+		if tx.Kind == types.TransactionKindInternal {
+			continue
+		}
+		price := effectiveGasPrice(tx, fees.baseFee)
+		if price == nil {
+			continue
+		}
+		fees.prices = append(fees.prices, price)
+		if fees.baseFee != nil {
+			fees.tips = append(fees.tips, new(big.Int).Sub(price, fees.baseFee))
+		}
+	}
+	slices.SortFunc(fees.prices, (*big.Int).Cmp)
+	slices.SortFunc(fees.tips, (*big.Int).Cmp)
+
+	g.Lock()
+	defer g.Unlock()
+	for len(g.blocks) > 0 && g.blocks[len(g.blocks)-1].number >= fees.number {
+		g.blocks = g.blocks[:len(g.blocks)-1]
+	}
+	if len(g.blocks) == GasPriceBlocks {
+		g.blocks = slices.Delete(g.blocks, 0, 1)
+	}
+	g.blocks = append(g.blocks, fees)
+}
+
+// the price per gas the transaction paid, what its max fees allow over the
+// base fee for a dynamic fee one, nil when malformed
+func effectiveGasPrice(tx *types.Transaction, baseFee *big.Int) *big.Int {
+	maxFee := types.ParseQuantity(tx.MaxFeePerGas)
+	if maxFee == nil || baseFee == nil {
+		return tx.GasPriceWei()
+	}
+	tip := types.ParseQuantity(tx.MaxPriorityFeePerGas)
+	if tip == nil {
+		return maxFee
+	}
+	price := tip.Add(tip, baseFee)
+	if price.Cmp(maxFee) > 0 {
+		return maxFee
+	}
+	return price
+}
+
+// the gas prices of the last blocks parsed since the start, at most
+// GasPriceBlocks of them, nil before any
+func (p *EthParser) GetGasPrice(blocks int) *types.GasPrice {
+	p.gasPrices.Lock()
+	window := slices.Clone(p.gasPrices.blocks[max(len(p.gasPrices.blocks)-blocks, 0):])
+	p.gasPrices.Unlock()
+	if len(window) == 0 {
+		return nil
+	}
+	gasPrice := &types.GasPrice{FromBlock: window[0].number, ToBlock: window[len(window)-1].number}
+	var prices, tips []*big.Int
+	sum := new(big.Int)
+	for _, fees := range window {
+		prices = append(prices, fees.prices...)
+		tips = append(tips, fees.tips...)
+		if fees.baseFee != nil {
+			gasPrice.BaseFees = append(gasPrice.BaseFees, "0x"+fees.baseFee.Text(16))
+			sum.Add(sum, fees.baseFee)
+		}
+	}
+	gasPrice.Transactions = len(prices)
+	gasPrice.EffectiveGasPrice = gasPercentiles(prices)
+	gasPrice.PriorityFee = gasPercentiles(tips)
+	if last := window[len(window)-1].baseFee; last != nil && len(gasPrice.BaseFees) > 0 {
+		gasPrice.BaseFee = "0x" + last.Text(16)
+		// compared as last*10 with average*10 ± average, in integers
+		average := sum.Div(sum, big.NewInt(int64(len(gasPrice.BaseFees))))
+		last := new(big.Int).Mul(last, big.NewInt(10))
+		tenfold := new(big.Int).Mul(average, big.NewInt(10))
+		switch {
+		case last.Cmp(new(big.Int).Add(tenfold, average)) > 0:
+			gasPrice.BaseFeeTrend = types.GasTrendRising
+		case last.Cmp(new(big.Int).Sub(tenfold, average)) < 0:
+			gasPrice.BaseFeeTrend = types.GasTrendFalling
+		default:
+			gasPrice.BaseFeeTrend = types.GasTrendSteady
+		}
+	}
+	return gasPrice
+}
+
+// the nearest rank percentiles of the prices, nil without any
+func gasPercentiles(prices []*big.Int) *types.GasPercentiles {
+	if len(prices) == 0 {
+		return nil
+	}
+	slices.SortFunc(prices, (*big.Int).Cmp)
+	percentile := func(p int) string {
+		rank := (p*len(prices) + 99) / 100
+		return "0x" + prices[max(rank-1, 0)].Text(16)
+	}
+	return &types.GasPercentiles{P10: percentile(10), P25: percentile(25), P50: percentile(50), P75: percentile(75), P90: percentile(90)}
+}
@@ -0,0 +1,110 @@
+package parser
+
+import (
+	"context"
+	"sort"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// create an empty address group of the tenant, false when it exists or the
+// name is invalid
+func (p *EthParser) CreateGroup(ctx context.Context, tenant, group string) (bool, error) {
+	if types.ValidateGroupName(group) != nil {
+		return false, nil
+	}
+	return p.storage.CreateGroup(ctx, tenant, group)
+}
+
+// delete a group of the tenant, its addresses stay observed
+func (p *EthParser) DeleteGroup(ctx context.Context, tenant, group string) (bool, error) {
+	return p.storage.DeleteGroup(ctx, tenant, group)
+}
+
+// add an address to a group of the tenant, observing it when it wasn't;
+// false when already a member, the group doesn't exist or the address is
+// malformed
+func (p *EthParser) AddGroupAddress(ctx context.Context, tenant, group, address string) (bool, error) {
+	address, err := types.NormalizeAddress(address)
+	if err != nil {
+		return false, nil
+	}
+	existing, err := p.storage.GetGroup(ctx, tenant, group)
+	if existing == nil {
+		return false, err
+	}
+	// an address already observed keeps its label and expiry
+	if _, err := p.Subscribe(ctx, tenant, address); err != nil {
+		return false, err
+	}
+	return p.storage.AddGroupAddress(ctx, tenant, group, address)
+}
+
+// take an address out of a group of the tenant, it stays observed until
+// unsubscribed
+func (p *EthParser) RemoveGroupAddress(ctx context.Context, tenant, group, address string) (bool, error) {
+	address, err := types.NormalizeAddress(address)
+	if err != nil {
+		return false, nil
+	}
+	return p.storage.RemoveGroupAddress(ctx, tenant, group, address)
+}
+
+// a group of the tenant with its addresses, nil when it doesn't exist
+func (p *EthParser) GetGroup(ctx context.Context, tenant, group string) (*types.Group, error) {
+	return p.storage.GetGroup(ctx, tenant, group)
+}
+
+// the groups of the tenant, ordered by name
+func (p *EthParser) GetGroups(ctx context.Context, tenant string) ([]*types.Group, error) {
+	return p.storage.GetGroups(ctx, tenant)
+}
+
+// page of the transactions of the addresses of a group matching the filter,
+// ordered by block and index in the block, nil when the group doesn't exist;
+// a transaction between two members comes once for each, with its direction
+// and counterparty relative to the member
+func (p *EthParser) QueryGroupTransactions(ctx context.Context, tenant, group string, filter types.TransactionFilter) ([]*types.TransactionEvent, error) {
+	existing, err := p.storage.GetGroup(ctx, tenant, group)
+	if existing == nil {
+		return nil, err
+	}
+	// the first offset+limit of every member are enough for the page
+	member := types.TransactionFilter{Direction: filter.Direction, FromBlock: filter.FromBlock, ToBlock: filter.ToBlock, Status: filter.Status}
+	if filter.Limit > 0 {
+		member.Limit = filter.Offset + filter.Limit
+	}
+	events := []*types.TransactionEvent{}
+	for _, address := range existing.Addresses {
+		// a large group stops between members once the caller gave up
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		txs, err := p.QueryTransactions(ctx, address, member)
+		if err != nil {
+			return nil, err
+		}
+		checksummed := types.ChecksumAddress(address)
+		for _, tx := range txs {
+			relative := tx.RelativeTo(address)
+			events = append(events, &types.TransactionEvent{
+				Address:     checksummed,
+				Direction:   relative.Direction,
+				Block:       types.BlockNumber(tx.BlockNumber),
+				Transaction: relative,
+			})
+		}
+	}
+	// stable so the members keep their address order within a transaction
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Block != events[j].Block {
+			return events[i].Block < events[j].Block
+		}
+		return types.BlockNumber(events[i].Transaction.TransactionIndex) < types.BlockNumber(events[j].Transaction.TransactionIndex)
+	})
+	events = events[min(filter.Offset, len(events)):]
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[:filter.Limit]
+	}
+	return events, nil
+}
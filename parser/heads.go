@@ -0,0 +1,43 @@
+package parser
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// how long without a new head before the parser polls the chain head,
+	// a few mainnet slots
+	newHeadsTimeout = 30 * time.Second
+	// resubscribe backoff after the subscription failed
+	newHeadsInitialBackoff = time.Second
+	newHeadsMaxBackoff     = time.Minute
+)
+
+// keep a newHeads subscription up until the context is cancelled, passing
+// the latest head to the parser loop
+func (p *EthParser) watchNewHeads(ctx context.Context) {
+	backoff := newHeadsInitialBackoff
+	for ctx.Err() == nil {
+		subscribed := time.Now()
+		err := p.client.SubscribeNewHeads(ctx, p.newHeadsUrl, func(block int) {
+			backoff = newHeadsInitialBackoff
+			// only the latest head matters, drop the one not picked up yet
+			select {
+			case <-p.heads:
+			default:
+			}
+			p.heads <- block
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		p.log.Warn("New heads subscription failed", "url", p.newHeadsUrl, "err", err, "after", time.Since(subscribed).Round(time.Second), "wait", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, newHeadsMaxBackoff)
+	}
+}
@@ -0,0 +1,156 @@
+package parser
+
+import (
+	"sync"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// The progress of the parser loop, read by the health endpoints
+type health struct {
+	heartbeat    time.Time
+	lastBlock    time.Time
+	lastHead     time.Time
+	currentBlock int
+	latestBlock  int
+	lastErr      error
+	// last time the chain head went up
+	headAdvanced time.Time
+	// when the watchdog started or last recovered, stalls are timed from
+	// there at the earliest
+	watched time.Time
+	// when an operator paused the loop, zero while it runs; resumed is
+	// closed once they resume it
+	paused  time.Time
+	resumed chan struct{}
+	sync.Mutex
+}
+
+// the loop went round, err is the outcome of its last rpc call
+func (h *health) beat(err error) {
+	h.Lock()
+	defer h.Unlock()
+	h.heartbeat = time.Now()
+	h.lastErr = err
+}
+
+// the block the loop continues after
+func (h *health) at(block int) {
+	h.Lock()
+	defer h.Unlock()
+	h.currentBlock = block
+}
+
+func (h *health) parsed(block int) {
+	h.Lock()
+	defer h.Unlock()
+	h.heartbeat, h.lastBlock = time.Now(), time.Now()
+	h.currentBlock = block
+}
+
+func (h *health) head(block int) {
+	h.Lock()
+	defer h.Unlock()
+	h.lastHead = time.Now()
+	if block > h.latestBlock {
+		h.headAdvanced = h.lastHead
+	}
+	h.latestBlock = block
+}
+
+// time stalls from now on
+func (h *health) watch() {
+	h.Lock()
+	defer h.Unlock()
+	h.watched = time.Now()
+}
+
+// pause the loop, false when it was already
+func (h *health) pause() bool {
+	h.Lock()
+	defer h.Unlock()
+	if h.resumed != nil {
+		return false
+	}
+	h.paused, h.resumed = time.Now(), make(chan struct{})
+	return true
+}
+
+// resume the loop, timing stalls from now on, false when it wasn't paused
+func (h *health) resume() bool {
+	h.Lock()
+	defer h.Unlock()
+	if h.resumed == nil {
+		return false
+	}
+	close(h.resumed)
+	h.paused, h.resumed = time.Time{}, nil
+	h.watched = time.Now()
+	return true
+}
+
+// closed once the paused loop is resumed, nil while it runs
+func (h *health) pausedUntil() chan struct{} {
+	h.Lock()
+	defer h.Unlock()
+	return h.resumed
+}
+
+// why the parser looks stuck for longer than stall, empty when it doesn't:
+// the loop stopped going round, the chain head stopped going up or no block
+// was parsed while behind it
+func (h *health) stalled(stall time.Duration) string {
+	h.Lock()
+	defer h.Unlock()
+	if h.resumed != nil {
+		return ""
+	}
+	since := func(t time.Time) bool {
+		if t.Before(h.watched) {
+			t = h.watched
+		}
+		return time.Since(t) > stall
+	}
+	switch {
+	case since(h.heartbeat):
+		return "parser loop wedged"
+	case since(h.headAdvanced):
+		return "chain head not advancing"
+	case h.currentBlock < h.latestBlock && since(h.lastBlock):
+		return "no block parsed while behind the chain head"
+	}
+	return ""
+}
+
+func (p *EthParser) GetStatus() *types.Status {
+	p.health.Lock()
+	defer p.health.Unlock()
+	status := &types.Status{
+		CurrentBlock:  p.health.currentBlock,
+		LatestBlock:   p.health.latestBlock,
+		Heartbeat:     p.health.heartbeat,
+		LastBlockTime: p.health.lastBlock,
+		LastHeadTime:  p.health.lastHead,
+	}
+	if p.health.latestBlock > 0 {
+		status.Lag = max(p.health.latestBlock-p.health.currentBlock, 0)
+	}
+	if p.health.resumed != nil {
+		paused := p.health.paused
+		status.Paused = &paused
+	}
+	if p.health.lastErr != nil {
+		status.LastError = p.health.lastErr.Error()
+	}
+	status.EndpointsUp, status.Endpoints = p.client.EndpointsUp()
+	switch {
+	case p.readOnly:
+		status.Role = types.RoleReplica
+	case p.election != nil && p.election.leading.Load():
+		status.Role = types.RoleLeader
+	case p.election != nil:
+		status.Role = types.RoleFollower
+	}
+	return status
+}
@@ -0,0 +1,87 @@
+package parser
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// Called once a block is stored and checkpointed, with the transactions of
+// the subscribed addresses it matched
+type BlockParsedHook func(block *types.Block, matches []*types.MatchedTransaction)
+
+// Called for every transaction touching a subscribed address, pending ones
+// included when the mempool is watched
+type TransactionMatchedHook func(match *types.MatchedTransaction)
+
+// The callbacks embedding applications registered, run on the parser loop in
+// registration order
+type hooks struct {
+	blockParsed        []BlockParsedHook
+	transactionMatched []TransactionMatchedHook
+	sync.RWMutex
+}
+
+// run the hook after every parsed block, also while the parser runs; it runs
+// on the parser loop so it must return quickly and not move the current
+// block, a panic is logged and the parser goes on
+func (p *EthParser) OnBlockParsed(hook BlockParsedHook) {
+	p.hooks.Lock()
+	defer p.hooks.Unlock()
+	p.hooks.blockParsed = append(p.hooks.blockParsed, hook)
+}
+
+// run the hook for every matched transaction, like OnBlockParsed; it sees
+// the same matches as the listeners, after they were stored
+func (p *EthParser) OnTransactionMatched(hook TransactionMatchedHook) {
+	p.hooks.Lock()
+	defer p.hooks.Unlock()
+	p.hooks.transactionMatched = append(p.hooks.transactionMatched, hook)
+}
+
+// pass the matches to the listeners and the hooks
+func (p *EthParser) notify(matches []*types.MatchedTransaction) {
+	matches = p.notified(matches)
+	for _, listener := range p.listeners {
+		listener.Notify(matches)
+	}
+	p.hooks.RLock()
+	matched := p.hooks.transactionMatched
+	p.hooks.RUnlock()
+	for _, hook := range matched {
+		for _, m := range matches {
+			p.runHook("OnTransactionMatched", func() { hook(m) })
+		}
+	}
+}
+
+// the matches the listeners are told about, the failed ones are left out
+// with skipFailed
+func (p *EthParser) notified(matches []*types.MatchedTransaction) []*types.MatchedTransaction {
+	if !p.skipFailed {
+		return matches
+	}
+	return slices.DeleteFunc(slices.Clone(matches), func(m *types.MatchedTransaction) bool { return m.Transaction.Failed() })
+}
+
+// run the block hooks on the parsed block
+func (p *EthParser) blockParsed(block *types.Block, matches []*types.MatchedTransaction) {
+	p.hooks.RLock()
+	parsed := p.hooks.blockParsed
+	p.hooks.RUnlock()
+	for _, hook := range parsed {
+		p.runHook("OnBlockParsed", func() { hook(block, matches) })
+	}
+}
+
+// a hook failing is the embedding application's bug, it doesn't stop parsing
+func (p *EthParser) runHook(name string, hook func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			p.log.Error("Parser hook panicked", "hook", name, "err", fmt.Sprint(r))
+		}
+	}()
+	hook()
+}
@@ -0,0 +1,125 @@
+package parser
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/passwizards/eth-parser/storage"
+)
+
+// the lease of the parser of a storage, the storages of other chains hold
+// their own
+const leaseName = "parser"
+
+// how long the lease of the leader lasts without being renewed by default
+const defaultLeaseTtl = 15 * time.Second
+
+// The lease electing the one of the parsers sharing the storage that parses,
+// the others follow it like read-only ones until they take it over
+type election struct {
+	leases storage.Leases
+	// tells the parsers apart, e.g. by host and pid
+	holder  string
+	ttl     time.Duration
+	leading atomic.Bool
+}
+
+// take turns parsing with the other parsers sharing the storage through its
+// leases, the holder naming this one; only the one holding the lease parses
+// while the others follow it, taking over once it stopped renewing it for
+// ttl, 15s when 0
+func WithLeaderElection(leases storage.Leases, holder string, ttl time.Duration) EthParserOption {
+	return func(p *EthParser) {
+		if ttl <= 0 {
+			ttl = defaultLeaseTtl
+		}
+		p.election = &election{leases: leases, holder: holder, ttl: ttl}
+	}
+}
+
+// follow the leader until the lease is taken, then parse until it is lost,
+// over and over until the context is cancelled; the lease is given up on the
+// way out for another parser to take over right away
+func (p *EthParser) elect(ctx context.Context) {
+	e := p.election
+	for ctx.Err() == nil {
+		following, stopFollowing := context.WithCancel(ctx)
+		followed := make(chan struct{})
+		go func() {
+			defer close(followed)
+			p.follow(following)
+		}()
+		acquired := e.acquire(ctx, p.log)
+		stopFollowing()
+		<-followed
+		if !acquired {
+			return
+		}
+		p.log.Warn("Took the parser lease, parsing", "holder", e.holder)
+		e.leading.Store(true)
+		leading, stepDown := context.WithCancel(ctx)
+		go func() {
+			defer stepDown()
+			e.renew(leading, p.log)
+		}()
+		p.parse(leading)
+		stepDown()
+		e.leading.Store(false)
+		release, cancel := context.WithTimeout(context.WithoutCancel(ctx), e.ttl)
+		if err := e.leases.ReleaseLease(release, leaseName, e.holder); err != nil {
+			p.log.Warn("Failed to release the parser lease, it expires on its own", "err", err)
+		}
+		cancel()
+	}
+}
+
+// try to take the lease every third of its ttl until it is taken, false once
+// the context is cancelled
+func (e *election) acquire(ctx context.Context, log *slog.Logger) bool {
+	for {
+		acquired, err := e.leases.AcquireLease(ctx, leaseName, e.holder, e.ttl)
+		if err != nil && ctx.Err() == nil {
+			log.Warn("Failed to acquire the parser lease", "err", err)
+		}
+		if acquired {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(e.ttl / 3):
+		}
+	}
+}
+
+// renew the lease every third of its ttl until the context is cancelled,
+// returning once it was lost; when the renewals keep failing for half of the
+// ttl it steps down before another parser may take the lease over, so two
+// never write at once
+func (e *election) renew(ctx context.Context, log *slog.Logger) {
+	renewed := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(e.ttl / 3):
+		}
+		acquired, err := e.leases.AcquireLease(ctx, leaseName, e.holder, e.ttl)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil && time.Since(renewed) >= e.ttl/2:
+			log.Warn("Failed to renew the parser lease, stepping down", "err", err)
+			return
+		case err != nil:
+			log.Warn("Failed to renew the parser lease", "err", err)
+		case !acquired:
+			log.Warn("Lost the parser lease, stepping down")
+			return
+		default:
+			renewed = time.Now()
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package parser
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+const (
+	// bytes4(keccak256("name()"))
+	nameSelector = "0x06fdde03"
+	// bytes4(keccak256("decimals()"))
+	decimalsSelector = "0x313ce567"
+)
+
+// The token metadata cache, read with eth_call once per contract the first
+// time a transfer of it is parsed or read
+type tokenMetadataCache struct {
+	tokens map[string]*types.TokenMetadata
+	sync.Mutex
+}
+
+// symbol, name and decimals of the token contract, empty when the contract
+// doesn't expose them; a failed call isn't cached and is retried next time
+func (p *EthParser) GetTokenMetadata(ctx context.Context, token string) (*types.TokenMetadata, error) {
+	token = strings.ToLower(token)
+	p.metadata.Lock()
+	metadata, ok := p.metadata.tokens[token]
+	p.metadata.Unlock()
+	if ok {
+		return metadata, nil
+	}
+
+	metadata = &types.TokenMetadata{Token: token}
+	var outputs [3]string
+	for i, selector := range []string{symbolSelector, nameSelector, decimalsSelector} {
+		output, err := p.client.Call(ctx, token, selector)
+		if err != nil {
+			return nil, err
+		}
+		outputs[i] = output
+	}
+	metadata.Symbol = decodeAbiString(outputs[0])
+	metadata.Name = decodeAbiString(outputs[1])
+	metadata.Decimals = decodeDecimals(outputs[2])
+
+	p.metadata.Lock()
+	p.metadata.tokens[token] = metadata
+	p.metadata.Unlock()
+	return metadata, nil
+}
+
+// decode the uint8 of decimals(), nil when the output isn't one
+func decodeDecimals(output string) *int {
+	output = strings.TrimPrefix(output, "0x")
+	if len(output) != 64 {
+		return nil
+	}
+	value, ok := new(big.Int).SetString(output, 16)
+	if !ok || !value.IsUint64() || value.Uint64() > 255 {
+		return nil
+	}
+	decimals := int(value.Uint64())
+	return &decimals
+}
+
+// copies of the transfers with the metadata of their token, the ones of a
+// token whose metadata can't be read are left as they are
+func (p *EthParser) withTokenMetadata(ctx context.Context, transfers []*types.TokenTransfer) []*types.TokenTransfer {
+	enriched := make([]*types.TokenTransfer, len(transfers))
+	failed := make(map[string]bool)
+	for i, transfer := range transfers {
+		enriched[i] = transfer
+		if failed[transfer.Token] {
+			continue
+		}
+		metadata, err := p.GetTokenMetadata(ctx, transfer.Token)
+		if err != nil {
+			// read again on the next request
+			p.log.Warn("Failed to read token metadata", "token", transfer.Token, "err", err)
+			failed[transfer.Token] = true
+			continue
+		}
+		copied := *transfer
+		if copied.Symbol == "" {
+			copied.Symbol = metadata.Symbol
+		}
+		copied.Name, copied.Decimals = metadata.Name, metadata.Decimals
+		if amount, ok := new(big.Int).SetString(transfer.Amount, 10); ok && metadata.Decimals != nil {
+			copied.FormattedAmount = types.FormatUnits(amount, *metadata.Decimals)
+		}
+		enriched[i] = &copied
+	}
+	return enriched
+}
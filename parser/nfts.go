@@ -0,0 +1,97 @@
+package parser
+
+import (
+	"encoding/hex"
+	"math/big"
+	"strings"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+const (
+	// keccak256("TransferSingle(address,address,address,uint256,uint256)")
+	TransferSingleTopic = "0xc3d58168c5ae7397731d063d5bbf3d657854427343f4c083240f7aacaa2d0f62"
+	// keccak256("TransferBatch(address,address,address,uint256[],uint256[])")
+	TransferBatchTopic = "0x4a39dc06d4c0dbc64b70af90fd698a233a518aa5d07e595d983b8c0526c8f7fb"
+)
+
+// decode the NFT transfers of an ERC-721 Transfer log, which indexes the token
+// id as a fourth topic, or of an ERC-1155 TransferSingle or TransferBatch log,
+// nil for other logs
+func decodeNftTransfers(log *types.Log) []*types.NftTransfer {
+	if len(log.Topics) != 4 || log.Removed {
+		return nil
+	}
+	transfer := func(standard, operator, from, to string, id, amount *big.Int) *types.NftTransfer {
+		return &types.NftTransfer{
+			BlockHash:       log.BlockHash,
+			BlockNumber:     log.BlockNumber,
+			TransactionHash: log.TransactionHash,
+			LogIndex:        log.LogIndex,
+			Contract:        strings.ToLower(log.Address),
+			Standard:        standard,
+			Operator:        operator,
+			From:            from,
+			To:              to,
+			TokenId:         id.String(),
+			Amount:          amount.String(),
+		}
+	}
+	data, err := hex.DecodeString(strings.TrimPrefix(log.Data, "0x"))
+	if err != nil {
+		return nil
+	}
+	switch log.Topics[0] {
+	case TransferTopic:
+		id, ok := new(big.Int).SetString(strings.TrimPrefix(log.Topics[3], "0x"), 16)
+		if !ok {
+			return nil
+		}
+		from, to := topicToAddress(log.Topics[1]), topicToAddress(log.Topics[2])
+		return []*types.NftTransfer{transfer(types.NftStandardErc721, "", from, to, id, big.NewInt(1))}
+	}
+	// ERC-1155 logs index the operator, from and to
+	operator, from, to := topicToAddress(log.Topics[1]), topicToAddress(log.Topics[2]), topicToAddress(log.Topics[3])
+	switch log.Topics[0] {
+	case TransferSingleTopic:
+		if len(data) != 64 {
+			return nil
+		}
+		id, amount := new(big.Int).SetBytes(data[:32]), new(big.Int).SetBytes(data[32:])
+		return []*types.NftTransfer{transfer(types.NftStandardErc1155, operator, from, to, id, amount)}
+	case TransferBatchTopic:
+		ids, amounts := decodeAbiUintArray(data, 0), decodeAbiUintArray(data, 1)
+		if ids == nil || len(ids) != len(amounts) {
+			return nil
+		}
+		transfers := make([]*types.NftTransfer, len(ids))
+		for i := range ids {
+			transfers[i] = transfer(types.NftStandardErc1155, operator, from, to, ids[i], amounts[i])
+		}
+		return transfers
+	}
+	return nil
+}
+
+// decode the abi encoded uint256[] in the head slot of the data, nil when
+// malformed
+func decodeAbiUintArray(data []byte, slot int) []*big.Int {
+	if len(data) < (slot+1)*32 {
+		return nil
+	}
+	offset := new(big.Int).SetBytes(data[slot*32 : (slot+1)*32])
+	if !offset.IsInt64() || offset.Int64()+32 > int64(len(data)) {
+		return nil
+	}
+	start := int(offset.Int64())
+	length := new(big.Int).SetBytes(data[start : start+32])
+	if !length.IsInt64() || length.Int64() > int64(len(data)/32) || int64(start+32)+length.Int64()*32 > int64(len(data)) {
+		return nil
+	}
+	values := make([]*big.Int, length.Int64())
+	for i := range values {
+		at := start + 32 + i*32
+		values[i] = new(big.Int).SetBytes(data[at : at+32])
+	}
+	return values
+}
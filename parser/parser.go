@@ -0,0 +1,1234 @@
+// Package parser follows the chain and stores the transactions touching the
+// subscribed addresses
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/passwizards/eth-parser/abi"
+	"github.com/passwizards/eth-parser/rpcclient"
+	"github.com/passwizards/eth-parser/storage"
+	"github.com/passwizards/eth-parser/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The Parser interface
+type Parser interface {
+	// last parsed block
+	GetCurrentBlock(ctx context.Context) (int, error)
+
+	// add address to the observer of the tenant, "" being the default one
+	Subscribe(ctx context.Context, tenant, address string) (bool, error)
+
+	// remove address from the observer of the tenant
+	Unsubscribe(ctx context.Context, tenant, address string) (bool, error)
+
+	// whether the tenant observes the address
+	IsSubscribed(ctx context.Context, tenant, address string) (bool, error)
+
+	// attach a label and metadata to an address the tenant observes, false
+	// when it isn't observed
+	SetAddressLabel(ctx context.Context, tenant, address string, label *types.AddressLabel) (bool, error)
+
+	// unwatch an address the tenant observes once the ttl passed, 0 keeps it
+	// watched, false when it isn't observed
+	SetSubscriptionTtl(ctx context.Context, tenant, address string, ttl time.Duration) (bool, error)
+
+	// label the tenant gave an observed address, nil without one
+	GetAddressLabel(ctx context.Context, tenant, address string) (*types.AddressLabel, error)
+
+	// addresses the tenant observes with their transaction count and activity
+	GetSubscriptions(ctx context.Context, tenant string) ([]*types.Subscription, error)
+
+	// the subscriptions of every tenant with their labels, to move them
+	// to another environment
+	ExportSubscriptions(ctx context.Context) ([]*types.SubscriptionRecord, error)
+
+	// subscribe the tenants of the records to their addresses, returning
+	// how many were not subscribed before
+	ImportSubscriptions(ctx context.Context, records []*types.SubscriptionRecord) (int, error)
+
+	// create an empty address group of the tenant, false when it exists
+	CreateGroup(ctx context.Context, tenant, group string) (bool, error)
+
+	// delete a group of the tenant, its addresses stay observed
+	DeleteGroup(ctx context.Context, tenant, group string) (bool, error)
+
+	// add an address to a group of the tenant, observing it, false when
+	// already a member or the group doesn't exist
+	AddGroupAddress(ctx context.Context, tenant, group, address string) (bool, error)
+
+	// take an address out of a group of the tenant, it stays observed
+	RemoveGroupAddress(ctx context.Context, tenant, group, address string) (bool, error)
+
+	// a group of the tenant with its addresses, nil when it doesn't exist
+	GetGroup(ctx context.Context, tenant, group string) (*types.Group, error)
+
+	// the groups of the tenant, ordered by name
+	GetGroups(ctx context.Context, tenant string) ([]*types.Group, error)
+
+	// page of the transactions of the addresses of a group matching the
+	// filter, nil when the group doesn't exist
+	QueryGroupTransactions(ctx context.Context, tenant, group string, filter types.TransactionFilter) ([]*types.TransactionEvent, error)
+
+	// progress of the parser loop and the rpc endpoints, for health checks
+	GetStatus() *types.Status
+
+	// stop parsing new blocks until ResumeParsing, false when already paused
+	PauseParsing() bool
+
+	// parse again after PauseParsing, false when not paused
+	ResumeParsing() bool
+
+	// list of inbound or outbound transactions for an address
+	GetTransactions(ctx context.Context, address string) ([]*types.Transaction, error)
+
+	// page of the transactions for an address matching the filter
+	QueryTransactions(ctx context.Context, address string, filter types.TransactionFilter) ([]*types.Transaction, error)
+
+	// the stored transactions of all addresses matching the search, each once
+	// whichever addresses it matched
+	SearchTransactions(ctx context.Context, search *types.TransactionSearch) ([]*types.Transaction, error)
+
+	// tag of the transactions and the label of an address, changing whenever
+	// they may have
+	GetTransactionsRevision(ctx context.Context, address string) (string, error)
+
+	// list of inbound or outbound ERC-20 transfers for an address, with the
+	// name and decimals of their token
+	GetTokenTransfers(ctx context.Context, address string) ([]*types.TokenTransfer, error)
+
+	// symbol, name and decimals of a token contract, read once per contract
+	GetTokenMetadata(ctx context.Context, token string) (*types.TokenMetadata, error)
+
+	// the address an ENS name resolves to, empty when it doesn't; fails with
+	// ErrEnsDisabled without WithEns
+	ResolveName(ctx context.Context, name string) (string, error)
+
+	// the primary ENS name of an address that resolves back to it, empty
+	// without one
+	LookupAddress(ctx context.Context, address string) (string, error)
+
+	// list of inbound or outbound ERC-721 and ERC-1155 transfers for an address
+	GetNftTransfers(ctx context.Context, address string) ([]*types.NftTransfer, error)
+
+	// totals, counts per direction, first and last block and average gas
+	// price of the stored transactions of an address
+	GetAddressStats(ctx context.Context, address string) (*types.AddressStats, error)
+
+	// balance changes of an address per block of its stored transactions
+	// from..to, rebuilt from them with the fees of their receipts
+	GetBalanceHistory(ctx context.Context, address string, from, to int) ([]*types.BalanceChange, error)
+
+	// watch the events of a contract with a topic0, false when already
+	// watched or malformed
+	SubscribeEvent(ctx context.Context, contract, topic string) (bool, error)
+
+	// stop watching the events of a contract with a topic0, dropping the
+	// stored ones
+	UnsubscribeEvent(ctx context.Context, contract, topic string) (bool, error)
+
+	// the watched contract events
+	GetEventSubscriptions(ctx context.Context) ([]*types.EventSubscription, error)
+
+	// the stored events of a contract, decoded when its ABI is registered
+	GetEvents(ctx context.Context, contract string) ([]*types.Event, error)
+
+	// the metadata of a parsed block, nil when it isn't stored, e.g. parsed
+	// before the start block or pruned
+	GetBlock(ctx context.Context, block int) (*types.BlockMetadata, error)
+
+	// the first parsed block mined at or after the time, the block after the
+	// current one when none was yet
+	BlockAtTime(ctx context.Context, t time.Time) (int, error)
+
+	// a transaction by hash, from storage or else the node with its receipt,
+	// nil when neither knows it, a StorageError when the storage failed
+	GetTransaction(ctx context.Context, hash string) (*types.Transaction, error)
+
+	// matched transactions of an address seen in the mempool and not parsed
+	// in a block yet
+	GetPendingTransactions(address string) []*types.Transaction
+
+	// percentiles of the gas prices paid in the last parsed blocks and
+	// their base fees, nil before any block was parsed
+	GetGasPrice(blocks int) *types.GasPrice
+
+	// live balance and transaction count of an address, read from the node
+	GetBalance(ctx context.Context, address string) (*types.Balance, error)
+
+	// queue a scan of past blocks for transactions of an observed address
+	Backfill(ctx context.Context, address string, from, to int) (bool, error)
+
+	// move the parser to continue after the block, dropping what is stored
+	// past it when clear is set, a StorageError when the storage failed
+	SetCurrentBlock(ctx context.Context, block int, clear bool) error
+
+	// the blocks given up on after they kept failing, in block order
+	GetFailedBlocks() []*types.FailedBlock
+
+	// parse a failed block again, false when it isn't one
+	RetryFailedBlock(ctx context.Context, block int) (bool, error)
+
+	// queue fetching and saving the parsed blocks from..to again
+	Reprocess(ctx context.Context, from, to int) (*types.ReprocessJob, error)
+
+	// a reprocess job by id, nil when unknown
+	GetReprocessJob(id int) *types.ReprocessJob
+
+	// the value in wei from which parsed transactions of any address are
+	// whale alerts, nil when disabled
+	GetWhaleThreshold() *big.Int
+
+	// replace the whale threshold, nil disables the alerts
+	SetWhaleThreshold(wei *big.Int)
+}
+
+// A consumer of matched transactions, e.g. webhooks or live streams
+type TransactionListener interface {
+	Notify(matches []*types.MatchedTransaction)
+}
+
+// The IParser implementation
+type EthParser struct {
+	client    rpcclient.RpcClient
+	storage   storage.StorageProvider
+	listeners []TransactionListener
+	relays    []TransactionListener
+	hooks     hooks
+	whales    whales
+	// scan Transfer logs for ERC-20 transfers
+	tokens bool
+	// scan Transfer, TransferSingle and TransferBatch logs for NFT transfers
+	nfts bool
+	// fetch the receipts of matched transactions
+	receipts bool
+	// leave the transactions that reverted out of the notifications
+	skipFailed bool
+	// trace method internal transactions are read with, empty disables them
+	traces string
+	// the symbol, name and decimals of the token contracts met so far
+	metadata tokenMetadataCache
+	// decodes the input of matched transactions to contracts with a
+	// registered ABI, nil disables
+	abis *abi.Registry
+	// how many blocks back reorgs are detected and rolled back, 0 disables
+	reorgDepth int
+	// blocks the chain head must be past a transaction before queries return
+	// it, 0 returns transactions as soon as they are parsed
+	minConfirmations int
+	// the block tag followed as the chain head, e.g. finalized, latest when
+	// empty
+	headTag string
+	// first block parsed when the storage is empty, 0 starts startLookback
+	// blocks behind the chain head, or from genesis with fullHistory
+	startBlock    int
+	startLookback int
+	fullHistory   bool
+	// how many batches are fetched in parallel while catching up
+	workers int
+	// how many blocks are fetched per batch request
+	batchSize int
+	// how long to wait for a new head once caught up, 0 polls right away
+	pollInterval time.Duration
+	// how long a stall lasts before the watchdog steps in, 0 disables it
+	watchdog time.Duration
+	// websocket endpoint pushing new heads, replaces polling when set
+	newHeadsUrl string
+	heads       chan int
+	// watch the mempool for transactions of target addresses
+	watchMempool bool
+	pending      pendingPool
+	// blocks before the current one backfilled for new subscriptions, 0
+	// disables
+	backfillBlocks int
+	// blocks per eth_getLogs range when backfilling from the logs of the
+	// address instead of scanning blocks, 0 scans blocks
+	logsRange int
+	// lists past transactions instead of scanning blocks when set
+	explorer  *explorer
+	backfills backfillQueue
+	// the block ranges saved again on request, and the last ones done
+	reprocess reprocessQueue
+	// bumped by the writes to the transactions of the addresses
+	revisions revisions
+	// wait after failed rpc calls and storage writes
+	retry backoff
+	// the blocks that kept failing to fetch or save, skipped to move on
+	deadLetters deadLetters
+	// the fees paid in the last parsed blocks
+	gasPrices gasPrices
+	// the blocks fetched last, nil disables
+	blocks *blockCache
+	// the hot wallets the transfers of deposit addresses are sweeps into
+	sweeps sweeps
+	// the resolved ENS names and addresses, nil disables
+	ens *ensCache
+	// serve what another parser writes to the shared storage, Start follows
+	// it instead of parsing
+	readOnly bool
+	// the lease the parsers sharing the storage take turns parsing with, nil
+	// parses right away
+	election *election
+	// progress of the running Start
+	health health
+	log    *slog.Logger
+	// how many transactions per address and how many blocks back the storage
+	// keeps, 0 keeps everything
+	retention struct {
+		maxTransactions int
+		maxAge          int
+	}
+	// held while a block is saved, Pause takes it to run between blocks and
+	// sets resync so the current block is read back from the storage
+	step   sync.Mutex
+	resync bool
+	// stops the running Start, done is closed once it returned
+	run struct {
+		cancel context.CancelFunc
+		done   chan struct{}
+		sync.Mutex
+	}
+}
+
+// start from the chain head instead of a fixed block
+const StartBlockLatest = -1
+
+// a few hours of mainnet blocks, a fresh storage serves some history right
+// away without syncing the whole chain
+const defaultStartLookback = 1000
+
+type EthParserOption func(*EthParser)
+
+// read the chain through the client instead of over http from the url of
+// NewEthParser, e.g. another transport or rpcclient.MockClient in tests; the
+// options setting up the endpoints configure the client they follow, so it
+// goes first
+func WithRpcClient(client rpcclient.RpcClient) EthParserOption {
+	return func(p *EthParser) {
+		p.client = client
+	}
+}
+
+// more rpc endpoints to fail over to and balance the calls with
+func WithEndpoints(urls ...string) EthParserOption {
+	return func(p *EthParser) {
+		p.client.AddEndpoints(urls...)
+	}
+}
+
+// the first block to parse when the storage has no progress yet, either a
+// block number or StartBlockLatest; a resumed storage ignores it
+func WithStartBlock(block int) EthParserOption {
+	return func(p *EthParser) {
+		p.startBlock = block
+	}
+}
+
+// how many blocks behind the chain head parsing starts when the storage has
+// no progress yet and no start block is set
+func WithStartLookback(blocks int) EthParserOption {
+	return func(p *EthParser) {
+		p.startLookback = blocks
+	}
+}
+
+// parse the whole chain from genesis when the storage has no progress yet
+// and no start block is set, instead of the blocks of the start lookback
+func WithFullHistory() EthParserOption {
+	return func(p *EthParser) {
+		p.fullHistory = true
+	}
+}
+
+// wait interval between head polls once caught up with the chain
+func WithPollInterval(interval time.Duration) EthParserOption {
+	return func(p *EthParser) {
+		p.pollInterval = interval
+	}
+}
+
+// how many of the blocks fetched last are kept to save fetching them again,
+// e.g. retrying a batch after a failed save, 0 disables
+func WithBlockCache(size int) EthParserOption {
+	return func(p *EthParser) {
+		p.blocks = newBlockCache(size)
+	}
+}
+
+// resolve ENS names through the registry on the chain, for the mainnet and
+// its testnets
+func WithEns() EthParserOption {
+	return func(p *EthParser) {
+		p.ens = &ensCache{addresses: make(map[string]ensEntry), names: make(map[string]ensEntry)}
+	}
+}
+
+// how many recent block hashes are kept to detect reorgs
+func WithReorgDepth(depth int) EthParserOption {
+	return func(p *EthParser) {
+		p.reorgDepth = depth
+	}
+}
+
+// hold transactions back from queries until the chain head is confirmations
+// blocks past them, they are still stored and notified right away
+func WithMinConfirmations(confirmations int) EthParserOption {
+	return func(p *EthParser) {
+		p.minConfirmations = max(confirmations, 0)
+	}
+}
+
+// follow the block with the tag as the chain head instead of the latest one,
+// rpcclient.BlockTagSafe or rpcclient.BlockTagFinalized, so nothing a reorg
+// can take back is parsed, at the cost of lagging the latest block by a few
+// minutes; new heads then only wake the parser to read the tagged block
+func WithHeadTag(tag string) EthParserOption {
+	return func(p *EthParser) {
+		p.headTag = tag
+	}
+}
+
+// fetch up to size blocks per batch request while catching up
+func WithBatchSize(size int) EthParserOption {
+	return func(p *EthParser) {
+		p.batchSize = max(size, 1)
+	}
+}
+
+// fetch up to workers batches in parallel while catching up
+func WithWorkers(workers int) EthParserOption {
+	return func(p *EthParser) {
+		p.workers = max(workers, 1)
+	}
+}
+
+// limit the calls to the rpc endpoint to rate per second with bursts of up to
+// burst calls, shared by all workers, backfills and mempool fetches
+func WithRateLimit(rate float64, burst int) EthParserOption {
+	return func(p *EthParser) {
+		p.client.SetRateLimit(rate, burst)
+	}
+}
+
+// the headers the rpc endpoints require by their host, e.g. bearer tokens or
+// api keys
+func WithRpcAuth(auth map[string]*rpcclient.EndpointAuth) EthParserOption {
+	return func(p *EthParser) {
+		p.client.SetAuth(auth)
+	}
+}
+
+// how long a single rpc call may take before the endpoint is considered down,
+// 0 waits forever
+func WithRpcTimeout(timeout time.Duration) EthParserOption {
+	return func(p *EthParser) {
+		p.client.SetTimeout(timeout)
+	}
+}
+
+// enrich matched transactions with their receipt status, gas used and logs
+func WithReceipts() EthParserOption {
+	return func(p *EthParser) {
+		p.receipts = true
+	}
+}
+
+// leave the transactions whose receipt says they reverted out of the
+// listeners and hooks, they are still stored; needs WithReceipts to know them
+func WithSkipFailed() EthParserOption {
+	return func(p *EthParser) {
+		p.skipFailed = true
+	}
+}
+
+// wait initial after a failed rpc call, doubling with every failure in a row
+// up to max
+func WithRetryBackoff(initial, max time.Duration) EthParserOption {
+	return func(p *EthParser) {
+		p.retry.initial, p.retry.max = initial, max
+	}
+}
+
+// log with the logger, e.g. one tagged with the chain of the parser
+func WithLogger(logger *slog.Logger) EthParserOption {
+	return func(p *EthParser) {
+		p.log = logger
+	}
+}
+
+// keep at most maxTransactions per address and drop the ones older than
+// maxAge blocks, pruned in the background, 0 disables either limit
+func WithRetention(maxTransactions, maxAge int) EthParserOption {
+	return func(p *EthParser) {
+		p.retention.maxTransactions, p.retention.maxAge = maxTransactions, maxAge
+	}
+}
+
+// wait for new heads pushed by the websocket endpoint instead of polling
+// eth_blockNumber once caught up
+func WithNewHeads(url string) EthParserOption {
+	return func(p *EthParser) {
+		p.newHeadsUrl = url
+	}
+}
+
+// flag the pending transactions of target addresses seen in the mempool,
+// subscribed to on the WithNewHeads endpoint when set, otherwise polled with
+// txpool_content; they are upgraded to confirmed once parsed in a block
+func WithPendingTransactions() EthParserOption {
+	return func(p *EthParser) {
+		p.watchMempool = true
+	}
+}
+
+// capture the value transfers made by contracts with the trace method,
+// rpcclient.TraceMethodDebug or rpcclient.TraceMethodParity
+func WithInternalTransactions(method string) EthParserOption {
+	return func(p *EthParser) {
+		p.traces = method
+	}
+}
+
+// track ERC-20 transfers of target addresses
+func WithTokenTransfers() EthParserOption {
+	return func(p *EthParser) {
+		p.tokens = true
+	}
+}
+
+// track ERC-721 and ERC-1155 transfers of target addresses
+func WithNftTransfers() EthParserOption {
+	return func(p *EthParser) {
+		p.nfts = true
+	}
+}
+
+// backfill the transactions of that many blocks before the current one for
+// every new subscription
+func WithBackfill(blocks int) EthParserOption {
+	return func(p *EthParser) {
+		p.backfillBlocks = blocks
+	}
+}
+
+// backfill from an etherscan style explorer api instead of scanning blocks,
+// receipts still come from the node, internal transactions are left out
+func WithExplorer(url, apiKey string) EthParserOption {
+	return func(p *EthParser) {
+		p.explorer = newExplorer(url, apiKey)
+	}
+}
+
+// backfill from the logs emitted by the address or naming it in an indexed
+// argument, read with eth_getLogs over ranges of up to blocks blocks, instead
+// of fetching every block; only transactions emitting such logs are found,
+// plain ether transfers are missed, an explorer takes precedence
+func WithLogsBackfill(blocks int) EthParserOption {
+	return func(p *EthParser) {
+		p.logsRange = max(blocks, 0)
+	}
+}
+
+// decode the input of transactions to contracts with an ABI in the registry,
+// the registry may be shared between parsers
+func WithAbis(registry *abi.Registry) EthParserOption {
+	return func(p *EthParser) {
+		p.abis = registry
+	}
+}
+
+// rotate the rpc endpoint and reset the connections to it once the chain head
+// hasn't gone up, or no block was parsed while behind it, for the stall time,
+// so the parser recovers from a provider silently serving stale data or
+// hanging, 0 disables
+func WithWatchdog(stall time.Duration) EthParserOption {
+	return func(p *EthParser) {
+		p.watchdog = stall
+	}
+}
+
+// give up on the blocks that failed to fetch or save tries times in a row and
+// move on, listing them until retried; kept in the file as json lines when a
+// path is set, 0 tries retries them forever
+func WithDeadLetter(path string, tries int) EthParserOption {
+	return func(p *EthParser) {
+		p.deadLetters.path, p.deadLetters.tries = path, tries
+	}
+}
+
+// notify the listener about matched transactions
+func WithListener(listener TransactionListener) EthParserOption {
+	return func(p *EthParser) {
+		p.listeners = append(p.listeners, listener)
+	}
+}
+
+func NewEthParser(url string, storage storage.StorageProvider, opts ...EthParserOption) *EthParser {
+	parser := &EthParser{
+		client:    rpcclient.NewClient(url),
+		storage:   storage,
+		metadata:  tokenMetadataCache{tokens: make(map[string]*types.TokenMetadata)},
+		backfills: backfillQueue{wake: make(chan struct{}, 1)},
+		reprocess: reprocessQueue{wake: make(chan struct{}, 1), jobs: make(map[int]*types.ReprocessJob)},
+		revisions: revisions{boot: time.Now().UnixNano(), changed: make(map[string]uint64)},
+		// deeper than any mainnet reorg since the merge
+		reorgDepth: 64,
+		blocks:     newBlockCache(defaultBlockCache),
+		workers:    1,
+		batchSize:  1,
+		retry:      backoff{initial: time.Second, max: 30 * time.Second},
+		log:        slog.Default(),
+
+		startLookback: defaultStartLookback,
+		sweeps:        sweeps{share: defaultSweepShare},
+	}
+	for _, opt := range opts {
+		opt(parser)
+	}
+	if err := parser.deadLetters.load(); err != nil {
+		parser.log.Warn("Failed to load the dead-lettered blocks", "err", err)
+	}
+	return parser
+}
+
+// replace the rpc endpoints while the parser runs, e.g. when the config is
+// reloaded
+func (p *EthParser) SetEndpoints(urls ...string) {
+	p.client.SetEndpoints(urls...)
+}
+
+// replace the headers of the rpc endpoints while the parser runs, like
+// WithRpcAuth, e.g. rotated tokens
+func (p *EthParser) SetRpcAuth(auth map[string]*rpcclient.EndpointAuth) {
+	p.client.SetAuth(auth)
+}
+
+// replace the rate limit of the rpc calls while the parser runs, like
+// WithRateLimit
+func (p *EthParser) SetRateLimit(rate float64, burst int) {
+	p.client.SetRateLimit(rate, burst)
+}
+
+// last parsed block
+func (p *EthParser) GetCurrentBlock(ctx context.Context) (int, error) {
+	return p.storage.GetCurrentBlock(ctx)
+}
+
+// add address to the observer of the tenant, false for malformed addresses;
+// tenants share the stored transactions of the addresses they both observe
+func (p *EthParser) Subscribe(ctx context.Context, tenant, address string) (bool, error) {
+	address, err := types.NormalizeAddress(address)
+	if err != nil {
+		return false, nil
+	}
+	if added, err := p.storage.AddTargetAddress(ctx, tenant, address); !added {
+		return false, err
+	}
+	p.revisions.bump(address)
+	if p.backfillBlocks > 0 && !p.following() {
+		// subscribed all the same when the backfill can't be queued
+		current, err := p.storage.GetCurrentBlock(ctx)
+		if err == nil && current > 0 {
+			_, err = p.Backfill(ctx, address, max(current-p.backfillBlocks+1, 0), current)
+		}
+		if err != nil {
+			p.log.Warn("Failed to queue the backfill of a new subscription", "address", address, "err", err)
+		}
+	}
+	return true, nil
+}
+
+// remove address from the observer of the tenant, its stored transactions
+// are dropped once no tenant observes it
+func (p *EthParser) Unsubscribe(ctx context.Context, tenant, address string) (bool, error) {
+	address, err := types.NormalizeAddress(address)
+	if err != nil {
+		return false, nil
+	}
+	if removed, err := p.storage.RemoveTargetAddress(ctx, tenant, address); !removed {
+		return false, err
+	}
+	p.revisions.bump(address)
+	return true, nil
+}
+
+// attach a label and metadata to an address the tenant observes, false when
+// it isn't observed or malformed; the expiry of the address is kept unless
+// the label sets one
+func (p *EthParser) SetAddressLabel(ctx context.Context, tenant, address string, label *types.AddressLabel) (bool, error) {
+	address, err := types.NormalizeAddress(address)
+	if err != nil {
+		return false, nil
+	}
+	if label.Expires == nil {
+		previous, err := p.storage.GetAddressLabel(ctx, tenant, address)
+		if err != nil {
+			return false, err
+		}
+		if previous != nil && previous.Expires != nil {
+			copied := *label
+			copied.Expires = previous.Expires
+			label = &copied
+		}
+	}
+	if set, err := p.storage.SetAddressLabel(ctx, tenant, address, label); !set {
+		return false, err
+	}
+	p.revisions.bump(address)
+	return true, nil
+}
+
+// label the tenant gave an observed address, nil without one
+func (p *EthParser) GetAddressLabel(ctx context.Context, tenant, address string) (*types.AddressLabel, error) {
+	return p.storage.GetAddressLabel(ctx, tenant, address)
+}
+
+// whether the tenant observes the address, false for malformed addresses
+func (p *EthParser) IsSubscribed(ctx context.Context, tenant, address string) (bool, error) {
+	address, err := types.NormalizeAddress(address)
+	if err != nil {
+		return false, nil
+	}
+	return p.storage.IsSubscribed(ctx, tenant, address)
+}
+
+// addresses the tenant observes with their transaction count and activity
+func (p *EthParser) GetSubscriptions(ctx context.Context, tenant string) ([]*types.Subscription, error) {
+	return p.storage.GetSubscriptions(ctx, tenant)
+}
+
+// the subscriptions of every tenant, ordered by tenant then address
+func (p *EthParser) ExportSubscriptions(ctx context.Context) ([]*types.SubscriptionRecord, error) {
+	return storage.ExportSubscriptions(ctx, p.storage)
+}
+
+// subscribe the tenants of the records to their addresses, without
+// backfilling them, none when an address is invalid; returns how many were
+// not subscribed before
+func (p *EthParser) ImportSubscriptions(ctx context.Context, records []*types.SubscriptionRecord) (int, error) {
+	normalized := make([]*types.SubscriptionRecord, len(records))
+	for i, record := range records {
+		address, err := types.NormalizeAddress(record.Address)
+		if err != nil {
+			return 0, err
+		}
+		copied := *record
+		copied.Address = address
+		normalized[i] = &copied
+	}
+	added, err := storage.ImportSubscriptions(ctx, p.storage, normalized)
+	for _, record := range normalized {
+		p.revisions.bump(record.Address)
+	}
+	return added, err
+}
+
+func (p *EthParser) GetTransactions(ctx context.Context, address string) ([]*types.Transaction, error) {
+	if p.minConfirmations > 0 {
+		return p.QueryTransactions(ctx, address, types.TransactionFilter{})
+	}
+	txs, err := p.storage.GetTransactions(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	return p.withDecoded(txs), nil
+}
+
+// page of the transactions for an address matching the filter, the ones
+// without enough confirmations left out
+func (p *EthParser) QueryTransactions(ctx context.Context, address string, filter types.TransactionFilter) ([]*types.Transaction, error) {
+	if p.minConfirmations > 0 {
+		confirmed, err := p.confirmedBlock(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if confirmed < 1 {
+			return []*types.Transaction{}, nil
+		}
+		if filter.ToBlock == 0 || filter.ToBlock > confirmed {
+			filter.ToBlock = confirmed
+		}
+	}
+	txs, err := p.storage.QueryTransactions(ctx, address, filter)
+	if err != nil {
+		return nil, err
+	}
+	return p.withDecoded(txs), nil
+}
+
+// the stored transactions of all addresses matching the search, confirmed or
+// not
+func (p *EthParser) SearchTransactions(ctx context.Context, search *types.TransactionSearch) ([]*types.Transaction, error) {
+	txs, err := p.storage.SearchTransactions(ctx, search)
+	if err != nil {
+		return nil, err
+	}
+	return p.withDecoded(txs), nil
+}
+
+// the last block minConfirmations blocks behind the chain head, or the last
+// parsed block before the head is known
+func (p *EthParser) confirmedBlock(ctx context.Context) (int, error) {
+	p.health.Lock()
+	head := p.health.latestBlock
+	p.health.Unlock()
+	current, err := p.storage.GetCurrentBlock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return max(head, current) - p.minConfirmations, nil
+}
+
+// list of inbound or outbound ERC-20 transfers for an address, copies with
+// the name and decimals of their token and the amount in whole tokens so the
+// stored ones stay untouched; without them when the node can't tell
+func (p *EthParser) GetTokenTransfers(ctx context.Context, address string) ([]*types.TokenTransfer, error) {
+	transfers, err := p.storage.GetTokenTransfers(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	return p.withTokenMetadata(ctx, transfers), nil
+}
+
+// list of inbound or outbound ERC-721 and ERC-1155 transfers for an address
+func (p *EthParser) GetNftTransfers(ctx context.Context, address string) ([]*types.NftTransfer, error) {
+	return p.storage.GetNftTransfers(ctx, address)
+}
+
+// aggregates of the stored transactions of an address, computed by the storage
+func (p *EthParser) GetAddressStats(ctx context.Context, address string) (*types.AddressStats, error) {
+	return p.storage.GetAddressStats(ctx, address)
+}
+
+// the metadata of a parsed block, nil when it isn't stored
+func (p *EthParser) GetBlock(ctx context.Context, block int) (*types.BlockMetadata, error) {
+	return p.storage.GetBlock(ctx, block)
+}
+
+// whether any of the addresses is observed, the missing To of a contract
+// creation never is
+func (p *EthParser) isTarget(ctx context.Context, addresses ...string) (bool, error) {
+	for _, address := range addresses {
+		if address == "" {
+			continue
+		}
+		if target, err := p.storage.HasTargetAddress(ctx, address); err != nil || target {
+			return target, err
+		}
+	}
+	return false, nil
+}
+
+// matched transactions of an address seen in the mempool and not parsed in a
+// block yet, oldest first
+func (p *EthParser) GetPendingTransactions(address string) []*types.Transaction {
+	return p.withDecoded(p.pending.transactions(strings.ToLower(address)))
+}
+
+func (p *EthParser) GetBalance(ctx context.Context, address string) (*types.Balance, error) {
+	balance, err := p.client.GetBalance(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	count, err := p.client.GetTransactionCount(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	return &types.Balance{Balance: balance, TransactionCount: count}, nil
+}
+
+// Start the parser subscription, blocks until the context is cancelled or
+// Stop is called
+func (p *EthParser) Start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	p.run.Lock()
+	p.run.cancel, p.run.done = cancel, done
+	p.run.Unlock()
+	defer close(done)
+	defer cancel()
+	go p.client.WatchEndpoints(ctx)
+	switch {
+	case p.readOnly:
+		p.follow(ctx)
+	case p.election != nil:
+		p.elect(ctx)
+	default:
+		p.parse(ctx)
+	}
+}
+
+// parse the chain with the background jobs writing to the storage, until the
+// context is cancelled
+func (p *EthParser) parse(ctx context.Context) {
+	if p.newHeadsUrl != "" {
+		if p.heads == nil {
+			p.heads = make(chan int, 1)
+		}
+		go p.watchNewHeads(ctx)
+	}
+	if p.watchMempool {
+		go p.watchPending(ctx)
+	}
+	if p.retention.maxTransactions > 0 || p.retention.maxAge > 0 {
+		go p.pruneStorage(ctx)
+	}
+	go p.runBackfills(ctx)
+	go p.runReprocessJobs(ctx)
+	go p.expireSubscriptions(ctx)
+	if p.watchdog > 0 {
+		go p.watchStalls(ctx)
+	}
+
+	currentBlock, err := p.loadCurrentBlock(ctx)
+	if err != nil {
+		return
+	}
+	var (
+		latestBlock int
+		// nothing parsed yet, jump to the start block once the head is known
+		pendingStart = currentBlock == 0 && (p.startBlock != 0 || !p.fullHistory)
+		failures     blockFailures
+	)
+	p.health.at(currentBlock)
+LOOP:
+	for ctx.Err() == nil {
+		if resumed := p.health.pausedUntil(); resumed != nil {
+			p.log.Warn("Parser paused", "block", currentBlock)
+			select {
+			case <-ctx.Done():
+				break LOOP
+			case <-resumed:
+			}
+			p.log.Warn("Parser resumed", "block", currentBlock)
+		}
+		p.health.beat(err)
+		if err != nil {
+			// backoff errors like ratelimit or a storage outage
+			wait := p.retry.next(err)
+			if errors.As(err, new(*StorageError)) {
+				p.log.Warn("Storage failed, backing off", "err", err, "wait", wait.Round(time.Millisecond))
+			} else {
+				p.log.Warn("RPC call failed, backing off", "err", err, "wait", wait.Round(time.Millisecond))
+			}
+			select {
+			case <-ctx.Done():
+				break LOOP
+			case <-time.After(wait):
+			}
+		} else {
+			p.retry.reset()
+		}
+		if currentBlock, err = p.resyncIdle(ctx, currentBlock); err != nil {
+			continue
+		}
+		// stop between blocks, never halfway through one
+		for currentBlock < latestBlock && ctx.Err() == nil {
+			to := min(currentBlock+p.workers*p.batchSize, latestBlock)
+			if failures.block == currentBlock+1 {
+				// alone so the rest of its batch isn't blamed for it
+				to = currentBlock + 1
+			}
+			fetched := p.fetchBlocks(ctx, currentBlock+1, to, true)
+			for _, f := range fetched {
+				if ctx.Err() != nil || p.health.pausedUntil() != nil {
+					continue LOOP
+				}
+				if err = f.err; err != nil {
+					currentBlock, err = p.blockFailed(ctx, &failures, currentBlock, f, err)
+					continue LOOP
+				}
+				var stale bool
+				if currentBlock, stale, err = p.parseBlock(ctx, currentBlock, f); err != nil {
+					if !stale && ctx.Err() == nil {
+						currentBlock, err = p.blockFailed(ctx, &failures, currentBlock, f, err)
+					}
+					continue LOOP
+				}
+				if stale {
+					break
+				}
+			}
+		}
+		if p.heads != nil && latestBlock > 0 && currentBlock >= latestBlock {
+			// caught up, the subscription tells when the next block is out,
+			// polling only when it went quiet
+			select {
+			case <-ctx.Done():
+				break LOOP
+			case head := <-p.heads:
+				if head > latestBlock && p.followsLatest() {
+					latestBlock = head
+					p.health.head(latestBlock)
+					continue LOOP
+				}
+			case <-time.After(newHeadsTimeout):
+			}
+		} else if p.pollInterval > 0 && latestBlock > 0 && currentBlock >= latestBlock {
+			// caught up, give the chain time to produce the next block
+			select {
+			case <-ctx.Done():
+				break LOOP
+			case <-time.After(p.pollInterval):
+			}
+		}
+		latestBlock, err = p.headBlock(ctx)
+		if err == nil {
+			p.health.head(latestBlock)
+		}
+		if err == nil && pendingStart {
+			currentBlock = p.resolveStartBlock(latestBlock) - 1
+			pendingStart = false
+			p.health.at(currentBlock)
+			p.log.Info("Starting from block", "block", currentBlock+1, "behind", latestBlock-currentBlock)
+		}
+	}
+	p.log.Info("Parser stopped", "block", currentBlock)
+}
+
+// whether the chain head is the latest block rather than a tagged one
+func (p *EthParser) followsLatest() bool {
+	return p.headTag == "" || p.headTag == rpcclient.BlockTagLatest
+}
+
+// the number of the block followed as the chain head
+func (p *EthParser) headBlock(ctx context.Context) (int, error) {
+	if p.followsLatest() {
+		return p.client.GetLatestBlockNumber(ctx)
+	}
+	return p.client.GetTaggedBlockNumber(ctx, p.headTag)
+}
+
+// the current block of the storage, read again after a backoff until it
+// succeeds or the context is cancelled
+func (p *EthParser) loadCurrentBlock(ctx context.Context) (int, error) {
+	for {
+		currentBlock, err := p.storage.GetCurrentBlock(ctx)
+		if err == nil || ctx.Err() != nil {
+			return currentBlock, err
+		}
+		wait := p.retry.next(err)
+		p.log.Warn("Failed to read the current block, backing off", "err", err, "wait", wait.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// save the fetched block on top of the current one, returns the new current
+// block and whether the rest of the batch is stale, after a reorg or when the
+// storage was replaced while paused; a failed write leaves the current block
+// for the block to be saved again, the ones already stored are skipped then
+func (p *EthParser) parseBlock(ctx context.Context, currentBlock int, f *fetchedBlock) (_ int, _ bool, err error) {
+	ctx, end := startSpan(ctx, "parseBlock", attribute.Int("block", currentBlock+1))
+	defer func() { end(err) }()
+	p.step.Lock()
+	defer p.step.Unlock()
+	if p.resync {
+		currentBlock, err := p.resyncLocked(ctx, currentBlock)
+		return currentBlock, true, err
+	}
+	// a block being saved is saved whole even once stopping
+	store := context.WithoutCancel(ctx)
+	block := f.block
+	if p.reorgDepth > 0 {
+		var hash string
+		err := traceStorage(ctx, "GetBlockHash", func() (err error) {
+			hash, err = p.storage.GetBlockHash(store, currentBlock)
+			return err
+		})
+		if err != nil {
+			return currentBlock, false, err
+		}
+		if hash != "" && hash != block.ParentHash {
+			// the rest of the batch builds on the reorged blocks
+			currentBlock, err := p.rollbackReorg(ctx, currentBlock)
+			return currentBlock, true, err
+		}
+	}
+	if p.tokens {
+		if err := traceStorage(ctx, "SaveTokenTransfers", func() error { return p.storage.SaveTokenTransfers(store, f.transfers) }); err != nil {
+			return currentBlock, false, err
+		}
+	}
+	if p.nfts {
+		if err := traceStorage(ctx, "SaveNftTransfers", func() error { return p.storage.SaveNftTransfers(store, f.nfts) }); err != nil {
+			return currentBlock, false, err
+		}
+	}
+	if len(f.events) > 0 {
+		if err := traceStorage(ctx, "SaveEvents", func() error { return p.storage.SaveEvents(store, f.events) }); err != nil {
+			return currentBlock, false, err
+		}
+	}
+	if p.reorgDepth > 0 {
+		if err := traceStorage(ctx, "SaveBlockHash", func() error { return p.storage.SaveBlockHash(store, currentBlock+1, block.Hash) }); err != nil {
+			return currentBlock, false, err
+		}
+	}
+	if err := traceStorage(ctx, "SaveBlock", func() error { return p.storage.SaveBlock(store, block.Metadata()) }); err != nil {
+		return currentBlock, false, err
+	}
+	var matches []*types.MatchedTransaction
+	err = traceStorage(ctx, "SaveTransactions", func() (err error) {
+		matches, err = p.storage.SaveTransactions(store, currentBlock+1, block.Transactions)
+		return err
+	})
+	if err != nil {
+		return currentBlock, false, err
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("transactions", len(block.Transactions)), attribute.Int("matches", len(matches)))
+	if len(matches) > 0 {
+		addresses := make([]string, len(matches))
+		for i, m := range matches {
+			addresses[i] = m.Address
+		}
+		p.revisions.bump(addresses...)
+	}
+	if p.watchMempool {
+		p.confirmPending(currentBlock+1, matches)
+	}
+	p.notify(matches)
+	// the checkpoint comes last, a block cut short before it is parsed again
+	// and the matches already stored aren't notified twice
+	err = traceStorage(ctx, "SetCurrentBlock", func() error { return p.storage.SetCurrentBlock(store, currentBlock+1) })
+	if err != nil {
+		return currentBlock, false, err
+	}
+	currentBlock++
+	p.resolveDeadLetter(currentBlock)
+	if p.reorgDepth > 0 {
+		// the block is saved, stale hashes are pruned after the next one
+		err := traceStorage(ctx, "PruneBlockHashes", func() error { return p.storage.PruneBlockHashes(store, currentBlock-p.reorgDepth) })
+		if err != nil {
+			p.log.Warn("Failed to prune block hashes", "err", err)
+		}
+	}
+	p.health.parsed(currentBlock)
+	p.gasPrices.add(block)
+	p.log.Info("Parsed block", "block", currentBlock, "hash", block.Hash, "transactions", len(block.Transactions), "matches", len(matches))
+	p.notifyWhales(currentBlock, block.Transactions)
+	p.blockParsed(block, matches)
+	return currentBlock, false, nil
+}
+
+// the current block to continue after, read back from the storage when it
+// changed while paused and the loop was idle
+func (p *EthParser) resyncIdle(ctx context.Context, currentBlock int) (int, error) {
+	p.step.Lock()
+	defer p.step.Unlock()
+	if p.resync {
+		return p.resyncLocked(ctx, currentBlock)
+	}
+	return currentBlock, nil
+}
+
+// the current block of the storage changed while paused, with p.step held;
+// the old one is returned when it fails to read, to be read again
+func (p *EthParser) resyncLocked(ctx context.Context, currentBlock int) (int, error) {
+	var stored int
+	err := traceStorage(ctx, "GetCurrentBlock", func() (err error) {
+		stored, err = p.storage.GetCurrentBlock(ctx)
+		return err
+	})
+	if err != nil {
+		return currentBlock, err
+	}
+	p.resync = false
+	p.health.at(stored)
+	p.log.Info("Storage changed while paused, resuming from its block", "block", stored)
+	return stored, nil
+}
+
+// run f between two blocks, e.g. to replace the storage contents, the parser
+// then resumes from the current block of the storage
+func (p *EthParser) Pause(f func() error) error {
+	p.step.Lock()
+	defer p.step.Unlock()
+	// even a failed f may have changed some
+	defer p.revisions.bumpAll()
+	if err := f(); err != nil {
+		return err
+	}
+	p.resync = true
+	return nil
+}
+
+// stop parsing after the block being saved until ResumeParsing, e.g. while
+// the node or the storage is under maintenance, the apis keep serving what
+// is stored; backfills and reprocess jobs go on, false when already paused
+// or following another parser
+func (p *EthParser) PauseParsing() bool {
+	return !p.following() && p.health.pause()
+}
+
+// parse again after PauseParsing, false when not paused
+func (p *EthParser) ResumeParsing() bool {
+	return p.health.resume()
+}
+
+// move the parser between two blocks to continue after the block, to reparse
+// a range or skip ahead; without clear the transactions stored past the block
+// are kept and the ones parsed again are skipped
+func (p *EthParser) SetCurrentBlock(ctx context.Context, block int, clear bool) error {
+	if p.following() {
+		return ErrReadOnly
+	}
+	if block < 0 {
+		return fmt.Errorf("invalid block %d", block)
+	}
+	p.health.Lock()
+	head := p.health.latestBlock
+	p.health.Unlock()
+	if head > 0 && block > head {
+		return fmt.Errorf("block %d is past the chain head %d", block, head)
+	}
+	return p.Pause(func() error {
+		from, err := p.storage.GetCurrentBlock(ctx)
+		if err == nil && clear {
+			err = p.storage.Rollback(ctx, block)
+		} else if err == nil {
+			err = p.storage.SetCurrentBlock(ctx, block)
+		}
+		if err != nil {
+			return &StorageError{Err: err}
+		}
+		p.log.Warn("Current block set", "from", from, "to", block, "clear", clear)
+		return nil
+	})
+}
+
+// stop the running Start and wait for it to return
+func (p *EthParser) Stop() {
+	p.run.Lock()
+	cancel, done := p.run.cancel, p.run.done
+	p.run.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// the configured start block capped to the chain head, the start lookback
+// behind it when none is configured
+func (p *EthParser) resolveStartBlock(latestBlock int) int {
+	switch {
+	case p.startBlock == StartBlockLatest || p.startBlock > latestBlock:
+		return latestBlock
+	case p.startBlock == 0:
+		return max(latestBlock-p.startLookback, 1)
+	}
+	return p.startBlock
+}
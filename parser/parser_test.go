@@ -0,0 +1,202 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/passwizards/eth-parser/rpcclient"
+	"github.com/passwizards/eth-parser/storage"
+	"github.com/passwizards/eth-parser/types"
+)
+
+const (
+	watched = "0x23a50cc8fa9b1b57732010aa24f592cfe8aab47a"
+	other   = "0x28c6c06298d514db089934071355e5743bf21d60"
+)
+
+func TestMain(m *testing.M) {
+	// the storages log every match
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	m.Run()
+}
+
+// the blocks from..to of a fork, each a child of the previous one and the
+// first of the parent; a transfer to the watched address in every block
+func fork(name string, parent string, from, to int) []*types.Block {
+	var blocks []*types.Block
+	for number := from; number <= to; number++ {
+		hash := fmt.Sprintf("0x%s%d", name, number)
+		blocks = append(blocks, &types.Block{
+			Number:     fmt.Sprintf("0x%x", number),
+			Hash:       hash,
+			ParentHash: parent,
+			Transactions: []*types.Transaction{{
+				Hash:        fmt.Sprintf("0x%stx%d", name, number),
+				BlockHash:   hash,
+				BlockNumber: fmt.Sprintf("0x%x", number),
+				From:        other,
+				To:          watched,
+				Value:       "0x1",
+			}},
+		})
+		parent = hash
+	}
+	return blocks
+}
+
+// A listener recording the hashes it was notified of
+type recorder struct {
+	hashes []string
+	sync.Mutex
+}
+
+func (r *recorder) Notify(matches []*types.MatchedTransaction) {
+	r.Lock()
+	defer r.Unlock()
+	for _, m := range matches {
+		r.hashes = append(r.hashes, m.Transaction.Hash)
+	}
+}
+
+func (r *recorder) notified() []string {
+	r.Lock()
+	defer r.Unlock()
+	return append([]string(nil), r.hashes...)
+}
+
+// a parser of the mock from block 1 watching the address, running until the
+// test ends
+func startParser(t *testing.T, client *rpcclient.MockClient, opts ...EthParserOption) *EthParser {
+	t.Helper()
+	opts = append([]EthParserOption{
+		WithRpcClient(client),
+		WithStartBlock(1),
+		WithPollInterval(time.Millisecond),
+		WithRetryBackoff(time.Millisecond, 10*time.Millisecond),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	}, opts...)
+	p := NewEthParser("", storage.NewMemStorage(), opts...)
+	if _, err := p.Subscribe(context.Background(), "", watched); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return p
+}
+
+// wait for the parser to reach the block
+func waitForBlock(t *testing.T, p *EthParser, block int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		current, err := p.GetCurrentBlock(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if current == block {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("parser at block %d, expected %d", current, block)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func storedHashes(t *testing.T, p *EthParser) []string {
+	t.Helper()
+	txs, err := p.GetTransactions(context.Background(), watched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hashes []string
+	for _, tx := range txs {
+		hashes = append(hashes, tx.Hash)
+	}
+	return hashes
+}
+
+func TestReorgRollsBackToTheCommonAncestor(t *testing.T) {
+	client := rpcclient.NewMockClient()
+	main := fork("a", "0x0", 1, 3)
+	client.AddBlocks(main...)
+	p := startParser(t, client)
+	waitForBlock(t, p, 3)
+
+	// block 4 builds on another block 3 than the parsed one
+	client.AddBlocks(fork("b", main[1].Hash, 3, 4)...)
+	waitForBlock(t, p, 4)
+
+	got, want := fmt.Sprint(storedHashes(t, p)), "[0xatx1 0xatx2 0xbtx3 0xbtx4]"
+	if got != want {
+		t.Errorf("stored %s, expected %s", got, want)
+	}
+}
+
+func TestParsingABlockAgainKeepsItsTransactionsOnce(t *testing.T) {
+	client := rpcclient.NewMockClient()
+	client.AddBlocks(fork("a", "0x0", 1, 3)...)
+	listener := &recorder{}
+	p := startParser(t, client, WithListener(listener))
+	waitForBlock(t, p, 3)
+
+	if err := p.SetCurrentBlock(context.Background(), 1, false); err != nil {
+		t.Fatal(err)
+	}
+	client.AddBlocks(fork("a", "0xa3", 4, 4)...)
+	waitForBlock(t, p, 4)
+
+	want := "[0xatx1 0xatx2 0xatx3 0xatx4]"
+	if got := fmt.Sprint(storedHashes(t, p)); got != want {
+		t.Errorf("stored %s, expected %s", got, want)
+	}
+	if got := fmt.Sprint(listener.notified()); got != want {
+		t.Errorf("notified %s, expected %s", got, want)
+	}
+}
+
+func TestFailingBlockIsDeadLetteredAndRetried(t *testing.T) {
+	client := rpcclient.NewMockClient()
+	blocks := fork("a", "0x0", 1, 4)
+	// block 2 can't be fetched while the head is past it
+	client.AddBlocks(blocks[0], blocks[2], blocks[3])
+	p := startParser(t, client, WithDeadLetter("", 3))
+	waitForBlock(t, p, 4)
+
+	failed := p.GetFailedBlocks()
+	if len(failed) != 1 || failed[0].Block != 2 || failed[0].Attempts != 3 {
+		t.Fatalf("failed blocks %+v, expected block 2 after 3 attempts", failed)
+	}
+	if got, want := fmt.Sprint(storedHashes(t, p)), "[0xatx1 0xatx3 0xatx4]"; got != want {
+		t.Errorf("stored %s, expected %s", got, want)
+	}
+
+	client.AddBlocks(blocks[1])
+	if retried, err := p.RetryFailedBlock(context.Background(), 2); err != nil || !retried {
+		t.Fatalf("retried %v, err %v", retried, err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(p.GetFailedBlocks()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("block 2 still dead-lettered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	waitForBlock(t, p, 4)
+	if got, want := fmt.Sprint(storedHashes(t, p)), "[0xatx1 0xatx2 0xatx3 0xatx4]"; got != want {
+		t.Errorf("stored %s, expected %s", got, want)
+	}
+}
@@ -0,0 +1,184 @@
+package parser
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+const (
+	// how often txpool_content is polled without a websocket endpoint
+	pendingPollInterval = 2 * time.Second
+	// how long a pending transaction is kept without showing up in a block,
+	// e.g. when it was dropped or replaced
+	pendingTimeout = time.Hour
+)
+
+// The matched transactions seen in the mempool, until they are parsed in a
+// block or time out
+type pendingPool struct {
+	txs map[string]*pendingTransaction
+	sync.Mutex
+}
+
+type pendingTransaction struct {
+	matches []*types.MatchedTransaction
+	seen    time.Time
+}
+
+// add the matches of a pending transaction, false when it was already known
+func (pool *pendingPool) add(hash string, matches []*types.MatchedTransaction) bool {
+	pool.Lock()
+	defer pool.Unlock()
+	if _, ok := pool.txs[hash]; ok {
+		return false
+	}
+	if pool.txs == nil {
+		pool.txs = make(map[string]*pendingTransaction)
+	}
+	pool.txs[hash] = &pendingTransaction{matches: matches, seen: time.Now()}
+	return true
+}
+
+// remove the transaction once confirmed, returns when it was first seen, zero
+// when it was never pending
+func (pool *pendingPool) confirm(hash string) time.Time {
+	pool.Lock()
+	defer pool.Unlock()
+	pending, ok := pool.txs[hash]
+	if !ok {
+		return time.Time{}
+	}
+	delete(pool.txs, hash)
+	return pending.seen
+}
+
+// drop the transactions seen before the time, returns how many were dropped
+func (pool *pendingPool) expire(before time.Time) int {
+	pool.Lock()
+	defer pool.Unlock()
+	expired := 0
+	for hash, pending := range pool.txs {
+		if pending.seen.Before(before) {
+			delete(pool.txs, hash)
+			expired++
+		}
+	}
+	return expired
+}
+
+// the pending transactions of the lowercase address, oldest first
+func (pool *pendingPool) transactions(address string) []*types.Transaction {
+	pool.Lock()
+	defer pool.Unlock()
+	var matched []*pendingTransaction
+	for _, pending := range pool.txs {
+		for _, m := range pending.matches {
+			if m.Address == address {
+				matched = append(matched, pending)
+				break
+			}
+		}
+	}
+	slices.SortFunc(matched, func(a, b *pendingTransaction) int {
+		return a.seen.Compare(b.seen)
+	})
+	txs := make([]*types.Transaction, len(matched))
+	for i, pending := range matched {
+		txs[i] = pending.matches[0].Transaction
+	}
+	return txs
+}
+
+// follow the mempool until the context is cancelled, over the newPendingTransactions
+// subscription of the websocket endpoint when set, polling txpool_content
+// otherwise
+func (p *EthParser) watchPending(ctx context.Context) {
+	ticker := time.NewTicker(pendingPollInterval)
+	defer ticker.Stop()
+	backoff := newHeadsInitialBackoff
+	for ctx.Err() == nil {
+		var err error
+		if p.newHeadsUrl != "" {
+			subscribed := time.Now()
+			err = p.client.SubscribePendingTransactions(ctx, p.newHeadsUrl, func(tx *types.Transaction) {
+				backoff = newHeadsInitialBackoff
+				p.addPending(ctx, tx)
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			p.log.Warn("Pending transactions subscription failed", "url", p.newHeadsUrl, "err", err, "after", time.Since(subscribed).Round(time.Second), "wait", backoff)
+		} else {
+			var txs []*types.Transaction
+			if txs, err = p.client.FetchTxPool(ctx); err == nil {
+				backoff = newHeadsInitialBackoff
+				for _, tx := range txs {
+					p.addPending(ctx, tx)
+				}
+			} else if ctx.Err() == nil {
+				p.log.Warn("Polling the mempool failed", "err", err, "wait", backoff)
+			}
+		}
+		if expired := p.pending.expire(time.Now().Add(-pendingTimeout)); expired > 0 {
+			p.log.Info("Dropped pending transactions not confirmed in time", "transactions", expired, "timeout", pendingTimeout)
+		}
+		wait := ticker.C
+		if err != nil {
+			wait = time.After(backoff)
+			backoff = min(backoff*2, newHeadsMaxBackoff)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-wait:
+		}
+	}
+}
+
+// flag the transaction if it touches subscribed addresses and notify the
+// listeners and hooks the first time it is seen, it is left out when the storage fails
+// to tell
+func (p *EthParser) addPending(ctx context.Context, tx *types.Transaction) {
+	if tx == nil || tx.BlockNumber != "" {
+		return
+	}
+	tx.SetContractAddress()
+	var matches []*types.MatchedTransaction
+	from, to := strings.ToLower(tx.From), strings.ToLower(tx.To)
+	fromTarget, err := p.storage.HasTargetAddress(ctx, from)
+	toTarget := false
+	if err == nil && to != "" {
+		toTarget, err = p.storage.HasTargetAddress(ctx, to)
+	}
+	if err != nil {
+		p.log.Warn("Failed to match pending transaction", "hash", tx.Hash, "err", err)
+		return
+	}
+	if fromTarget {
+		matches = append(matches, &types.MatchedTransaction{Address: from, Direction: types.DirectionOut, Transaction: tx, Pending: true})
+	}
+	if toTarget {
+		matches = append(matches, &types.MatchedTransaction{Address: to, Direction: types.DirectionIn, Transaction: tx, Pending: true})
+	}
+	if len(matches) == 0 || !p.pending.add(tx.Hash, matches) {
+		return
+	}
+	for _, m := range matches {
+		p.log.Info("New pending transaction", "address", m.Address, "direction", m.Direction, "hash", tx.Hash)
+	}
+	p.notify(matches)
+}
+
+// upgrade the pending transactions among the parsed matches to confirmed
+func (p *EthParser) confirmPending(block int, matches []*types.MatchedTransaction) {
+	for _, m := range matches {
+		if seen := p.pending.confirm(m.Transaction.Hash); !seen.IsZero() {
+			p.log.Info("Pending transaction confirmed", "hash", m.Transaction.Hash, "block", block, "after", time.Since(seen).Round(time.Second))
+		}
+	}
+}
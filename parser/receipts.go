@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"context"
+	"strings"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// copy the receipts onto the transactions of the blocks touching target
+// addresses, the others are left as they are
+func (p *EthParser) enrichReceipts(ctx context.Context, blocks []*types.Block) error {
+	var matched []*types.Transaction
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			target, err := p.isTarget(ctx, tx.From, tx.To)
+			if err != nil {
+				return err
+			}
+			if target {
+				matched = append(matched, tx)
+			}
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+	receipts, err := p.client.FetchMatchedReceipts(ctx, matched)
+	if err != nil {
+		return err
+	}
+	for i, tx := range matched {
+		applyReceipt(tx, receipts[i])
+	}
+	return nil
+}
+
+func applyReceipt(tx *types.Transaction, receipt *types.Receipt) {
+	tx.Status = receipt.Status
+	tx.GasUsed = receipt.GasUsed
+	tx.EffectiveGasPrice = receipt.EffectiveGasPrice
+	tx.Logs = receipt.Logs
+	tx.BlobGasUsed = receipt.BlobGasUsed
+	tx.BlobGasPrice = receipt.BlobGasPrice
+}
+
+// a transaction by hash, from storage or else the node with its receipt and
+// the timestamp and base fee of its block copied on, nil when neither knows
+// it; pending ones come without a receipt
+func (p *EthParser) GetTransaction(ctx context.Context, hash string) (*types.Transaction, error) {
+	hash = strings.ToLower(hash)
+	tx, err := p.storage.GetTransaction(ctx, hash)
+	if err != nil {
+		return nil, &StorageError{Err: err}
+	}
+	if tx != nil && tx.BaseFeePerGas == "" && tx.BlockNumber != "" {
+		// stored without its block, e.g. backfilled, or before base fees were
+		// kept, the metadata of the block has it when parsed
+		block, err := p.storage.GetBlock(ctx, types.BlockNumber(tx.BlockNumber))
+		if err != nil {
+			return nil, &StorageError{Err: err}
+		}
+		if block != nil && block.Hash == tx.BlockHash {
+			copied := *tx
+			copied.BlockTimestamp, copied.BaseFeePerGas = block.Timestamp, block.BaseFeePerGas
+			tx = &copied
+		}
+	}
+	if tx != nil {
+		return p.withDecoded([]*types.Transaction{tx})[0], nil
+	}
+	tx, err = p.client.FetchTransaction(ctx, hash)
+	if tx != nil {
+		tx.SetContractAddress()
+	}
+	if err != nil || tx == nil || tx.BlockNumber == "" {
+		return tx, err
+	}
+	receipt, err := p.client.FetchReceipt(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	applyReceipt(tx, receipt)
+	header := p.blocks.get(types.BlockNumber(tx.BlockNumber))
+	if header == nil || header.Hash != tx.BlockHash {
+		if header, err = p.client.FetchBlockHeader(ctx, types.BlockNumber(tx.BlockNumber)); err != nil {
+			return nil, err
+		}
+	}
+	tx.BlockTimestamp, tx.BaseFeePerGas = header.Timestamp, header.BaseFeePerGas
+	return p.withDecoded([]*types.Transaction{tx})[0], nil
+}
@@ -0,0 +1,50 @@
+package parser
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// walk back from the current block to the last block still on the canonical
+// chain, roll the storage back to it and return it as the new current block
+func (p *EthParser) rollbackReorg(ctx context.Context, currentBlock int) (_ int, err error) {
+	ctx, end := startSpan(ctx, "rollbackReorg", attribute.Int("block", currentBlock))
+	defer func() { end(err) }()
+	ancestor, found := max(currentBlock-p.reorgDepth, 0), false
+	for block := currentBlock - 1; block > ancestor; block-- {
+		var stored string
+		err := traceStorage(ctx, "GetBlockHash", func() (err error) {
+			stored, err = p.storage.GetBlockHash(ctx, block)
+			return err
+		})
+		if err != nil {
+			return currentBlock, err
+		}
+		if stored == "" {
+			// nothing known before this block, assume it is canonical
+			ancestor, found = block, true
+			break
+		}
+		header, err := p.client.FetchBlockHeader(ctx, block)
+		if err != nil {
+			return currentBlock, err
+		}
+		if header.Hash == stored {
+			ancestor, found = block, true
+			break
+		}
+	}
+	if !found {
+		p.log.Warn("Chain reorg deeper than the tracked depth", "depth", p.reorgDepth)
+	}
+	// rolled back whole even once stopping, or not at all
+	err = traceStorage(ctx, "Rollback", func() error { return p.storage.Rollback(context.WithoutCancel(ctx), ancestor) })
+	if err != nil {
+		return currentBlock, err
+	}
+	p.blocks.drop(ancestor+1, 0)
+	p.revisions.bumpAll()
+	p.log.Warn("Chain reorg detected", "block", currentBlock, "ancestor", ancestor)
+	return ancestor, nil
+}
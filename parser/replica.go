@@ -0,0 +1,123 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// how often a read-only parser without a poll interval reads the progress of
+// the writer
+const defaultFollowInterval = time.Second
+
+// the most blocks the writer parsed since the last read whose transactions
+// are relayed to the listeners, the older ones are left out after an outage
+const maxRelayBlocks = 100
+
+// the operations of the parser loop fail with it on a read-only parser, or
+// one following the elected leader
+var ErrReadOnly = errors.New("read-only, another parser sharing the storage writes the blocks")
+
+// serve what another parser sharing the storage writes, e.g. redis or
+// postgres, without parsing: Start follows the progress of the writer and
+// relays the transactions it stores to the relay listeners, moving the
+// parser loop fails with ErrReadOnly, and new subscriptions aren't backfilled
+func WithReadOnly() EthParserOption {
+	return func(p *EthParser) {
+		p.readOnly = true
+	}
+}
+
+// notify the listener about matched transactions, and about the ones another
+// parser sharing the storage stores while following it, e.g. the live streams
+// every api replica serves; the webhooks and the event bus are left to the
+// parser writing the blocks
+func WithRelayListener(listener TransactionListener) EthParserOption {
+	return func(p *EthParser) {
+		p.listeners = append(p.listeners, listener)
+		p.relays = append(p.relays, listener)
+	}
+}
+
+// whether another parser writes to the storage, this one being read-only or
+// not leading
+func (p *EthParser) following() bool {
+	return p.readOnly || p.election != nil && !p.election.leading.Load()
+}
+
+// follow the current block the writer stores until the context is cancelled,
+// instead of parsing; the cached replies of every address are dropped when
+// it moves, since labels, pruning and rollbacks of other processes can't be
+// told apart from the transactions
+func (p *EthParser) follow(ctx context.Context) {
+	interval := p.pollInterval
+	if interval <= 0 {
+		interval = defaultFollowInterval
+	}
+	currentBlock, err := p.loadCurrentBlock(ctx)
+	if err != nil {
+		return
+	}
+	p.health.at(currentBlock)
+	p.log.Info("Following the parser writing to the storage", "block", currentBlock)
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+			continue
+		case <-time.After(interval):
+		}
+		if latestBlock, err := p.headBlock(ctx); err == nil {
+			p.health.head(latestBlock)
+		}
+		stored, err := p.storage.GetCurrentBlock(ctx)
+		p.health.beat(err)
+		if err != nil {
+			p.log.Warn("Failed to read the current block", "err", err)
+			continue
+		}
+		if stored == currentBlock {
+			continue
+		}
+		p.revisions.bumpAll()
+		if stored > currentBlock {
+			p.relay(ctx, max(currentBlock+1, stored-maxRelayBlocks+1), stored)
+			p.health.parsed(stored)
+		} else {
+			p.log.Warn("Storage rolled back by the writer", "from", currentBlock, "to", stored)
+			p.health.at(stored)
+		}
+		currentBlock = stored
+	}
+	p.log.Info("Parser stopped", "block", currentBlock)
+}
+
+// notify the relay listeners of the transactions the writer stored for the
+// blocks from..to, read back through the block index of the storage
+func (p *EthParser) relay(ctx context.Context, from, to int) {
+	for block := from; block <= to && ctx.Err() == nil; block++ {
+		txs, err := p.storage.SearchTransactions(ctx, &types.TransactionSearch{Block: block})
+		if err != nil {
+			p.log.Warn("Failed to read the transactions of a block, not relaying them", "block", block, "err", err)
+			continue
+		}
+		var matches []*types.MatchedTransaction
+		for _, tx := range p.withDecoded(txs) {
+			for _, side := range []struct{ address, direction string }{{strings.ToLower(tx.From), types.DirectionOut}, {strings.ToLower(tx.To), types.DirectionIn}} {
+				target, err := p.isTarget(ctx, side.address)
+				if err != nil {
+					p.log.Warn("Failed to read the subscriptions, not relaying a transaction", "hash", tx.Hash, "err", err)
+				}
+				if target {
+					matches = append(matches, &types.MatchedTransaction{Address: side.address, Direction: side.direction, Block: block, Transaction: tx})
+				}
+			}
+		}
+		matches = p.notified(matches)
+		for _, listener := range p.relays {
+			listener.Notify(matches)
+		}
+	}
+}
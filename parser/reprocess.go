@@ -0,0 +1,259 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// how many finished reprocess jobs stay queryable
+const reprocessJobsKept = 100
+
+// The reprocess jobs by id, the queued ones waiting for the worker in the
+// order they were requested
+type reprocessQueue struct {
+	jobs   map[int]*types.ReprocessJob
+	queued []*types.ReprocessJob
+	// ids of the finished jobs, oldest first
+	done   []int
+	lastId int
+	wake   chan struct{}
+	sync.Mutex
+}
+
+func (q *reprocessQueue) push(from, to int) *types.ReprocessJob {
+	q.Lock()
+	q.lastId++
+	job := &types.ReprocessJob{Id: q.lastId, From: from, To: to, Status: types.JobQueued, Block: from - 1, Created: time.Now().UTC()}
+	q.jobs[job.Id] = job
+	q.queued = append(q.queued, job)
+	copied := *job
+	q.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return &copied
+}
+
+// the oldest queued job, now running, nil when there is none
+func (q *reprocessQueue) pop() *types.ReprocessJob {
+	q.Lock()
+	defer q.Unlock()
+	if len(q.queued) == 0 {
+		return nil
+	}
+	job := q.queued[0]
+	q.queued = q.queued[1:]
+	job.Status = types.JobRunning
+	return job
+}
+
+// put a job cut short back first in line, it resumes after its last block
+func (q *reprocessQueue) requeue(job *types.ReprocessJob) {
+	q.Lock()
+	defer q.Unlock()
+	job.Status = types.JobQueued
+	q.queued = append([]*types.ReprocessJob{job}, q.queued...)
+}
+
+// record the progress of the running job
+func (q *reprocessQueue) progress(job *types.ReprocessJob, block, added int, err error) {
+	q.Lock()
+	defer q.Unlock()
+	job.Block, job.Transactions = block, job.Transactions+added
+	job.Error = ""
+	if err != nil {
+		job.Error = err.Error()
+	}
+}
+
+// mark the job done, dropping the oldest finished one past the kept ones
+func (q *reprocessQueue) finish(job *types.ReprocessJob) {
+	q.Lock()
+	defer q.Unlock()
+	finished := time.Now().UTC()
+	job.Status, job.Finished = types.JobDone, &finished
+	q.done = append(q.done, job.Id)
+	if len(q.done) > reprocessJobsKept {
+		delete(q.jobs, q.done[0])
+		q.done = q.done[1:]
+	}
+}
+
+// a copy of the job, nil when unknown
+func (q *reprocessQueue) get(id int) *types.ReprocessJob {
+	q.Lock()
+	defer q.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil
+	}
+	copied := *job
+	return &copied
+}
+
+// queue fetching and saving the parsed blocks from..to again, e.g. once a
+// provider served bad data for them; saving is idempotent, what is stored
+// already is kept and what was missing is added and notified; the job runs
+// while the parser does
+func (p *EthParser) Reprocess(ctx context.Context, from, to int) (*types.ReprocessJob, error) {
+	if p.following() {
+		return nil, ErrReadOnly
+	}
+	if from < 0 || from > to {
+		return nil, fmt.Errorf("invalid block range %d..%d", from, to)
+	}
+	current, err := p.storage.GetCurrentBlock(ctx)
+	if err != nil {
+		return nil, &StorageError{Err: err}
+	}
+	if to > current {
+		return nil, fmt.Errorf("block %d is past the current block %d", to, current)
+	}
+	job := p.reprocess.push(from, to)
+	p.log.Info("Queued reprocess", "job", job.Id, "from", from, "to", to)
+	return job, nil
+}
+
+// the reprocess job, nil when unknown or dropped since it finished
+func (p *EthParser) GetReprocessJob(id int) *types.ReprocessJob {
+	return p.reprocess.get(id)
+}
+
+// run the queued reprocess jobs one after another until the context is
+// cancelled
+func (p *EthParser) runReprocessJobs(ctx context.Context) {
+	for ctx.Err() == nil {
+		job := p.reprocess.pop()
+		if job == nil {
+			select {
+			case <-ctx.Done():
+			case <-p.reprocess.wake:
+			}
+			continue
+		}
+		p.runReprocessJob(ctx, job)
+	}
+}
+
+// fetch and save the blocks of the job again, retrying failures until the
+// context is cancelled, which queues it again for the next Start
+func (p *EthParser) runReprocessJob(ctx context.Context, job *types.ReprocessJob) {
+	retry := backoff{initial: p.retry.initial, max: p.retry.max}
+	// fetched fresh, the provider may have served bad data the first time
+	p.blocks.drop(job.Block+1, job.To)
+	// only the worker moves the block of the job
+	for from := job.Block + 1; from <= job.To && ctx.Err() == nil; {
+		next, added, err := p.reprocessBlocks(ctx, from, min(from+p.workers*p.batchSize-1, job.To))
+		p.reprocess.progress(job, next-1, added, err)
+		from = next
+		if err != nil {
+			wait := retry.next(err)
+			p.log.Warn("Reprocess failed, backing off", "job", job.Id, "block", next, "err", err, "wait", wait.Round(time.Millisecond))
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+			continue
+		}
+		retry.reset()
+	}
+	if ctx.Err() != nil {
+		p.reprocess.requeue(job)
+		return
+	}
+	p.reprocess.finish(job)
+	p.log.Info("Reprocess done", "job", job.Id, "from", job.From, "to", job.To, "transactions", job.Transactions)
+}
+
+// fetch the blocks from..to and save them again in order, returns the block
+// to continue from and how many transactions were added
+func (p *EthParser) reprocessBlocks(ctx context.Context, from, to int) (int, int, error) {
+	added := 0
+	for _, f := range p.fetchBlocks(ctx, from, to, true) {
+		if f.err != nil {
+			return from, added, f.err
+		}
+		n, err := p.reprocessBlock(ctx, from, f)
+		if err != nil {
+			return from, added, err
+		}
+		from, added = from+1, added+n
+	}
+	return from, added, nil
+}
+
+// save a fetched block again, what is stored already is skipped by the
+// storage; a block rolled back since is left to the parser
+func (p *EthParser) reprocessBlock(ctx context.Context, number int, f *fetchedBlock) (_ int, err error) {
+	ctx, end := startSpan(ctx, "reprocessBlock", attribute.Int("block", number))
+	defer func() { end(err) }()
+	p.step.Lock()
+	defer p.step.Unlock()
+	// a block being saved is saved whole even once stopping
+	store := context.WithoutCancel(ctx)
+	var current int
+	err = traceStorage(ctx, "GetCurrentBlock", func() (err error) {
+		current, err = p.storage.GetCurrentBlock(store)
+		return err
+	})
+	if err != nil || number > current {
+		return 0, err
+	}
+	if p.tokens {
+		if err := traceStorage(ctx, "SaveTokenTransfers", func() error { return p.storage.SaveTokenTransfers(store, f.transfers) }); err != nil {
+			return 0, err
+		}
+	}
+	if p.nfts {
+		if err := traceStorage(ctx, "SaveNftTransfers", func() error { return p.storage.SaveNftTransfers(store, f.nfts) }); err != nil {
+			return 0, err
+		}
+	}
+	if len(f.events) > 0 {
+		if err := traceStorage(ctx, "SaveEvents", func() error { return p.storage.SaveEvents(store, f.events) }); err != nil {
+			return 0, err
+		}
+	}
+	if p.reorgDepth > 0 {
+		// only a hash still kept for reorg checks is replaced
+		var hash string
+		err := traceStorage(ctx, "GetBlockHash", func() (err error) {
+			hash, err = p.storage.GetBlockHash(store, number)
+			return err
+		})
+		if err == nil && hash != "" && hash != f.block.Hash {
+			err = traceStorage(ctx, "SaveBlockHash", func() error { return p.storage.SaveBlockHash(store, number, f.block.Hash) })
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if err := traceStorage(ctx, "SaveBlock", func() error { return p.storage.SaveBlock(store, f.block.Metadata()) }); err != nil {
+		return 0, err
+	}
+	var matches []*types.MatchedTransaction
+	err = traceStorage(ctx, "SaveTransactions", func() (err error) {
+		matches, err = p.storage.SaveTransactions(store, number, f.block.Transactions)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(matches) > 0 {
+		addresses := make([]string, len(matches))
+		for i, m := range matches {
+			addresses[i] = m.Address
+		}
+		p.revisions.bump(addresses...)
+		p.log.Info("Reprocessed block had missing transactions", "block", number, "matches", len(matches))
+	}
+	p.notify(matches)
+	p.resolveDeadLetter(number)
+	return len(matches), nil
+}
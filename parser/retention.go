@@ -0,0 +1,46 @@
+package parser
+
+import (
+	"context"
+	"expvar"
+	"time"
+)
+
+// how often the storage is pruned to the retention
+const pruneInterval = time.Minute
+
+// entries dropped by the retention since start, served on /debug/vars
+var prunedEntries = expvar.NewInt("prunedEntries")
+
+// prune the storage to the retention every pruneInterval until the context
+// is cancelled
+func (p *EthParser) pruneStorage(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		before := 0
+		if p.retention.maxAge > 0 {
+			current, err := p.storage.GetCurrentBlock(ctx)
+			if err != nil {
+				p.log.Warn("Failed to prune storage", "err", err)
+				continue
+			}
+			before = current - p.retention.maxAge + 1
+		}
+		pruned, err := p.storage.Prune(ctx, before, p.retention.maxTransactions)
+		if err != nil {
+			// retried on the next tick
+			p.log.Warn("Failed to prune storage", "err", err)
+		}
+		if pruned > 0 {
+			prunedEntries.Add(int64(pruned))
+			p.revisions.bumpAll()
+			p.log.Info("Pruned storage", "entries", pruned, "before", before, "max", p.retention.maxTransactions)
+		}
+	}
+}
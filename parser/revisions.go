@@ -0,0 +1,68 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// The revisions of the stored transactions of the addresses, bumped by every
+// write of the parser so http caches can tell whether they changed
+type revisions struct {
+	// tells the revisions of this run from the ones of earlier runs
+	boot int64
+	// bumped on every change, an address is at the one it last changed at, or
+	// at floor when it didn't change since all of them did
+	generation uint64
+	floor      uint64
+	changed    map[string]uint64
+	sync.Mutex
+}
+
+// the transactions or the label of the addresses changed
+func (r *revisions) bump(addresses ...string) {
+	r.Lock()
+	defer r.Unlock()
+	r.generation++
+	for _, address := range addresses {
+		r.changed[address] = r.generation
+	}
+}
+
+// the transactions of any address may have changed, e.g. after a rollback
+func (r *revisions) bumpAll() {
+	r.Lock()
+	defer r.Unlock()
+	r.generation++
+	r.floor = r.generation
+	clear(r.changed)
+}
+
+func (r *revisions) of(address string) uint64 {
+	r.Lock()
+	defer r.Unlock()
+	if revision, ok := r.changed[address]; ok {
+		return revision
+	}
+	return r.floor
+}
+
+// tag of what QueryTransactions and GetAddressLabel return for the address,
+// it changes whenever they may have; writes of other processes sharing the
+// storage go unnoticed, until the writer parses a block on a read-only parser
+func (p *EthParser) GetTransactionsRevision(ctx context.Context, address string) (string, error) {
+	revision := fmt.Sprintf("%x-%x", p.revisions.boot, p.revisions.of(strings.ToLower(address)))
+	if p.minConfirmations > 0 {
+		// the transactions confirmed by the new head show up
+		confirmed, err := p.confirmedBlock(ctx)
+		if err != nil {
+			return "", err
+		}
+		revision += fmt.Sprintf("-%x", confirmed)
+	}
+	if p.abis != nil {
+		revision += fmt.Sprintf("-%x", p.abis.Revision())
+	}
+	return revision, nil
+}
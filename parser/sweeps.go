@@ -0,0 +1,83 @@
+package parser
+
+import (
+	"context"
+	"math/big"
+	"strings"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// a transfer into a hot wallet moving this share of the balance the deposit
+// address had is a sweep, what is left over is dust
+const defaultSweepShare = 0.95
+
+// The hot wallets the deposit addresses are swept into by lowercase address,
+// none disables the sweep tags, and the share of its balance a transfer into
+// one must move
+type sweeps struct {
+	hotWallets map[string]bool
+	share      float64
+}
+
+// tag the transfers of subscribed addresses into the hot wallets moving
+// nearly their whole balance as sweeps
+func WithHotWallets(addresses ...string) EthParserOption {
+	return func(p *EthParser) {
+		p.sweeps.hotWallets = make(map[string]bool)
+		for _, address := range addresses {
+			p.sweeps.hotWallets[strings.ToLower(address)] = true
+		}
+	}
+}
+
+// the share of its balance, above 0 and up to 1, a transfer into a hot wallet
+// must move to be a sweep, 0.95 by default and when out of range
+func WithSweepShare(share float64) EthParserOption {
+	return func(p *EthParser) {
+		if !(share > 0 && share <= 1) {
+			share = defaultSweepShare
+		}
+		p.sweeps.share = share
+	}
+}
+
+// set Sweep on the transfers of the subscribed addresses into the hot
+// wallets that left them with less than the sweep share of what they had,
+// by the balance at the end of the block; one whose balance can't be read is
+// left untagged, e.g. an old block on a node without its state
+func (p *EthParser) tagSweeps(ctx context.Context, blocks []*types.Block) error {
+	if len(p.sweeps.hotWallets) == 0 {
+		return nil
+	}
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			value := tx.ValueWei()
+			if tx.Kind != "" || tx.Failed() || !p.sweeps.hotWallets[strings.ToLower(tx.To)] || value == nil || value.Sign() == 0 {
+				continue
+			}
+			deposit, err := p.isTarget(ctx, tx.From)
+			if err != nil {
+				return err
+			}
+			if !deposit {
+				continue
+			}
+			left, err := p.client.GetBalanceAt(ctx, tx.From, types.BlockNumber(block.Number))
+			remaining := types.ParseQuantity(left)
+			if err != nil || remaining == nil {
+				p.log.Warn("Failed to read balance, not checking for a sweep", "address", tx.From, "hash", tx.Hash, "err", err)
+				continue
+			}
+			had := new(big.Int).Add(value, remaining)
+			if fees := tx.ComputeFees(); fees != nil {
+				if fee := types.ParseQuantity(fees.TotalFee); fee != nil {
+					had.Add(had, fee)
+				}
+			}
+			share, _ := new(big.Float).Quo(new(big.Float).SetInt(value), new(big.Float).SetInt(had)).Float64()
+			tx.Sweep = share >= p.sweeps.share
+		}
+	}
+	return nil
+}
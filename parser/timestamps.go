@@ -0,0 +1,61 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// the first parsed block mined at or after the time, the block after the
+// current one when none was yet; a binary search over the stored block
+// timestamps, the ones pruned or parsed before they were stored read from
+// the node
+func (p *EthParser) BlockAtTime(ctx context.Context, t time.Time) (int, error) {
+	current, err := p.storage.GetCurrentBlock(ctx)
+	if err != nil {
+		return 0, &StorageError{Err: err}
+	}
+	var failed error
+	block := sort.Search(current+1, func(number int) bool {
+		if failed != nil {
+			return true
+		}
+		var timestamp int64
+		if timestamp, failed = p.blockTimestamp(ctx, number); failed != nil {
+			return true
+		}
+		return timestamp >= t.Unix()
+	})
+	if failed != nil {
+		return 0, failed
+	}
+	return block, nil
+}
+
+// the unix seconds of the block, from the storage or else the node
+func (p *EthParser) blockTimestamp(ctx context.Context, number int) (int64, error) {
+	block, err := p.storage.GetBlock(ctx, number)
+	if err != nil {
+		return 0, &StorageError{Err: err}
+	}
+	timestamp := ""
+	if block != nil {
+		timestamp = block.Timestamp
+	} else if cached := p.blocks.get(number); cached != nil {
+		timestamp = cached.Timestamp
+	} else {
+		header, err := p.client.FetchBlockHeader(ctx, number)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read timestamp of block %d, err %v", number, err)
+		}
+		timestamp = header.Timestamp
+	}
+	seconds := types.ParseQuantity(timestamp)
+	if seconds == nil || !seconds.IsInt64() {
+		return 0, fmt.Errorf("invalid timestamp %q of block %d", timestamp, number)
+	}
+	return seconds.Int64(), nil
+}
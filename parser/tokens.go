@@ -0,0 +1,136 @@
+package parser
+
+import (
+	"context"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+const (
+	// keccak256("Transfer(address,address,uint256)")
+	TransferTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+	// bytes4(keccak256("symbol()"))
+	symbolSelector = "0x95d89b41"
+)
+
+// the tracked ERC-20 and NFT transfers of the blocks fromBlock..toBlock
+// touching target addresses, read with a single eth_getLogs
+func (p *EthParser) FetchTransfers(ctx context.Context, fromBlock, toBlock int) (tokens []*types.TokenTransfer, nfts []*types.NftTransfer, err error) {
+	var topics []string
+	if p.tokens || p.nfts {
+		// ERC-20 and ERC-721 share the Transfer event
+		topics = append(topics, TransferTopic)
+	}
+	if p.nfts {
+		topics = append(topics, TransferSingleTopic, TransferBatchTopic)
+	}
+	logs, err := p.client.FetchLogs(ctx, fromBlock, toBlock, []interface{}{topics})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, log := range logs {
+		if p.nfts {
+			for _, transfer := range decodeNftTransfers(log) {
+				target, err := p.isTarget(ctx, transfer.From, transfer.To)
+				if err != nil {
+					return nil, nil, err
+				}
+				if target {
+					nfts = append(nfts, transfer)
+				}
+			}
+		}
+		if !p.tokens {
+			continue
+		}
+		transfer := decodeTokenTransfer(log)
+		if transfer == nil {
+			continue
+		}
+		target, err := p.isTarget(ctx, transfer.From, transfer.To)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !target {
+			continue
+		}
+		if transfer.Symbol, err = p.TokenSymbol(ctx, transfer.Token); err != nil {
+			return nil, nil, err
+		}
+		tokens = append(tokens, transfer)
+	}
+	return
+}
+
+// symbol of the token contract, empty if the contract doesn't expose one
+func (p *EthParser) TokenSymbol(ctx context.Context, token string) (string, error) {
+	metadata, err := p.GetTokenMetadata(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	return metadata.Symbol, nil
+}
+
+// decode a Transfer(address,address,uint256) log, nil for ERC-721 transfers
+// which index the token id as a fourth topic
+func decodeTokenTransfer(log *types.Log) *types.TokenTransfer {
+	if len(log.Topics) != 3 || log.Topics[0] != TransferTopic || log.Removed {
+		return nil
+	}
+	amount, ok := new(big.Int).SetString(strings.TrimPrefix(log.Data, "0x"), 16)
+	if !ok {
+		return nil
+	}
+	return &types.TokenTransfer{
+		BlockHash:       log.BlockHash,
+		BlockNumber:     log.BlockNumber,
+		TransactionHash: log.TransactionHash,
+		LogIndex:        log.LogIndex,
+		Token:           strings.ToLower(log.Address),
+		From:            topicToAddress(log.Topics[1]),
+		To:              topicToAddress(log.Topics[2]),
+		Amount:          amount.String(),
+	}
+}
+
+func topicToAddress(topic string) string {
+	topic = strings.TrimPrefix(topic, "0x")
+	if len(topic) < 40 {
+		return ""
+	}
+	return "0x" + strings.ToLower(topic[len(topic)-40:])
+}
+
+// decode an abi encoded string, or the bytes32 used by older tokens
+func decodeAbiString(output string) string {
+	data, err := hex.DecodeString(strings.TrimPrefix(output, "0x"))
+	if err != nil {
+		return ""
+	}
+	if len(data) >= 64 {
+		offset := new(big.Int).SetBytes(data[:32])
+		if offset.IsInt64() && offset.Int64()+32 <= int64(len(data)) {
+			start := int(offset.Int64())
+			length := new(big.Int).SetBytes(data[start : start+32])
+			if length.IsInt64() && int64(start+32)+length.Int64() <= int64(len(data)) {
+				return validSymbol(data[start+32 : start+32+int(length.Int64())])
+			}
+		}
+	}
+	if len(data) == 32 {
+		return validSymbol(data)
+	}
+	return ""
+}
+
+func validSymbol(b []byte) string {
+	s := strings.TrimRight(string(b), "\x00")
+	if !utf8.ValidString(s) {
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,20 @@
+package parser
+
+import (
+	"context"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// append the internal transactions of the blocks after their transactions,
+// so they are matched and saved the same way
+func (p *EthParser) addInternalTransactions(ctx context.Context, blocks []*types.Block) error {
+	for _, block := range blocks {
+		internal, err := p.client.FetchInternalTransactions(ctx, block, p.traces)
+		if err != nil {
+			return err
+		}
+		block.Transactions = append(block.Transactions, internal...)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package parser
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// spans of the fetched and saved blocks, dropped unless a tracer provider is
+// installed
+var tracer = otel.Tracer("github.com/passwizards/eth-parser/parser")
+
+// start a span of the parser, the returned func ends it with the error of
+// the step
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, func(err error)) {
+	ctx, span := tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// A failed storage call, told apart from failed rpc calls
+type StorageError struct {
+	Err error
+}
+
+func (e *StorageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StorageError) Unwrap() error {
+	return e.Err
+}
+
+// run the storage operation in a span, its failure comes back as a
+// StorageError
+func traceStorage(ctx context.Context, operation string, f func() error) error {
+	_, span := tracer.Start(ctx, "storage "+operation)
+	defer span.End()
+	if err := f(); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return &StorageError{Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"time"
+)
+
+// recoveries of stalled parsers since start, served on /debug/vars
+var watchdogRecoveries = expvar.NewInt("watchdogRecoveries")
+
+// the least stall time of a safe or finalized head, they go up once an epoch
+// of 6.4 minutes and a missed one doubles that
+const taggedHeadStall = 15 * time.Minute
+
+// check the progress of the parser until the context is cancelled, once it
+// stalled for the watchdog time the rpc endpoint is rotated and the
+// connections reset, the next check is a whole stall time later
+func (p *EthParser) watchStalls(ctx context.Context) {
+	stall := p.watchdog
+	if !p.followsLatest() {
+		stall = max(stall, taggedHeadStall)
+	}
+	p.health.watch()
+	ticker := time.NewTicker(max(stall/10, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reason := p.health.stalled(stall)
+		if reason == "" {
+			continue
+		}
+		watchdogRecoveries.Add(1)
+		rotated := p.client.RotateEndpoint(errors.New(reason))
+		p.client.ResetConnections()
+		status := p.GetStatus()
+		p.log.Error("Parser stalled, resetting rpc connections", "reason", reason, "after", stall,
+			"rotated", rotated, "block", status.CurrentBlock, "head", status.LatestBlock, "lastError", status.LastError)
+		p.health.watch()
+	}
+}
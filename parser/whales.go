@@ -0,0 +1,77 @@
+package parser
+
+import (
+	"math/big"
+	"sync"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// A consumer of the whale alerts, the transactions of any address moving at
+// least the whale threshold, watched or not
+type WhaleListener interface {
+	NotifyWhales(events []*types.WhaleEvent)
+}
+
+// The value threshold of the whale alerts in wei, nil disables them, and
+// their listeners
+type whales struct {
+	threshold *big.Int
+	listeners []WhaleListener
+	sync.RWMutex
+}
+
+// alert the whale listeners of the parsed transactions moving at least the
+// wei, of every address
+func WithWhaleThreshold(wei *big.Int) EthParserOption {
+	return func(p *EthParser) {
+		p.whales.threshold = wei
+	}
+}
+
+func WithWhaleListener(listener WhaleListener) EthParserOption {
+	return func(p *EthParser) {
+		p.whales.listeners = append(p.whales.listeners, listener)
+	}
+}
+
+// replace the whale threshold while the parser runs, from the next parsed
+// block on; nil stops the alerts
+func (p *EthParser) SetWhaleThreshold(wei *big.Int) {
+	p.whales.Lock()
+	defer p.whales.Unlock()
+	p.whales.threshold = wei
+}
+
+// the whale threshold in wei, nil when disabled
+func (p *EthParser) GetWhaleThreshold() *big.Int {
+	p.whales.RLock()
+	defer p.whales.RUnlock()
+	return p.whales.threshold
+}
+
+// pass the transactions of the parsed block reaching the threshold to the
+// whale listeners, the failed ones moved nothing
+func (p *EthParser) notifyWhales(block int, txs []*types.Transaction) {
+	p.whales.RLock()
+	threshold, listeners := p.whales.threshold, p.whales.listeners
+	p.whales.RUnlock()
+	if threshold == nil || len(listeners) == 0 {
+		return
+	}
+	var events []*types.WhaleEvent
+	for _, tx := range txs {
+		value := tx.ValueWei()
+		if value == nil || value.Cmp(threshold) < 0 || tx.Failed() {
+			continue
+		}
+		events = append(events, &types.WhaleEvent{Block: block, Threshold: threshold.String(), Transaction: tx})
+	}
+	if len(events) == 0 {
+		return
+	}
+	p.log.Info("Whale transactions", "block", block, "transactions", len(events))
+	for _, listener := range listeners {
+		listener.NotifyWhales(events)
+	}
+}
@@ -0,0 +1,93 @@
+package rpcclient
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// The credentials an endpoint requires besides the ones in its url, e.g. a
+// project id in the path or user:password@ for basic auth
+type EndpointAuth struct {
+	// sent with every call, e.g. X-Api-Key
+	Headers map[string]string
+	// sent as Authorization: Bearer
+	BearerToken string
+}
+
+// the headers of the calls to the endpoints by their host, replacing the
+// previous ones, calls in flight keep theirs
+func (c *Client) SetAuth(auth map[string]*EndpointAuth) {
+	byHost := make(map[string]*EndpointAuth, len(auth))
+	for host, a := range auth {
+		byHost[strings.ToLower(host)] = a
+	}
+	c.auth.Store(&byHost)
+}
+
+// the headers of the endpoint, nil when it needs none
+func (c *Client) authHeader(endpoint string) http.Header {
+	auth := c.auth.Load()
+	if auth == nil {
+		return nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil
+	}
+	// a port is optional in the key
+	a := (*auth)[strings.ToLower(u.Host)]
+	if a == nil {
+		a = (*auth)[strings.ToLower(u.Hostname())]
+	}
+	if a == nil {
+		return nil
+	}
+	header := make(http.Header)
+	for name, value := range a.Headers {
+		header.Set(name, value)
+	}
+	if a.BearerToken != "" {
+		header.Set("Authorization", "Bearer "+a.BearerToken)
+	}
+	return header
+}
+
+// path segments long enough to be a project id or api key, like the ones of
+// Infura and Alchemy urls
+var urlKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{20,}$`)
+
+// the endpoint with the password of its basic auth and the keys in its path
+// masked, for logs and errors
+func RedactedUrl(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint
+	}
+	segments := strings.Split(u.Path, "/")
+	masked := false
+	for i, segment := range segments {
+		if urlKeyPattern.MatchString(segment) {
+			segments[i], masked = "xxxxx", true
+		}
+	}
+	if masked {
+		u.Path, u.RawPath = strings.Join(segments, "/"), ""
+	}
+	if u.User == nil && !masked {
+		return endpoint
+	}
+	return u.Redacted()
+}
+
+// the error of a call to the endpoint with its url redacted, the url errors
+// of net/http carry it in full
+func redactedError(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+	return &url.Error{Op: urlErr.Op, URL: RedactedUrl(urlErr.URL), Err: urlErr.Err}
+}
@@ -0,0 +1,699 @@
+// Package rpcclient is the json-rpc client of the ethereum node the parser
+// reads the chain from
+package rpcclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// The rpc client, fails over between its endpoints and spaces its calls to
+// the rate limit
+type Client struct {
+	endpoints *endpointPool
+	// replaced when the rate limit changes, nil when unlimited
+	limiter atomic.Pointer[rateLimiter]
+	// shared by all calls so connections to the endpoints are reused,
+	// replaced when the connections are reset
+	http atomic.Pointer[http.Client]
+	// set once an endpoint rejected eth_getBlockReceipts, receipts are then
+	// fetched per transaction
+	noBlockReceipts atomic.Bool
+	// the headers of the endpoints requiring them, by host
+	auth atomic.Pointer[map[string]*EndpointAuth]
+}
+
+func NewClient(url string) *Client {
+	c := &Client{endpoints: newEndpointPool([]string{url})}
+	c.http.Store(&http.Client{Transport: newTransport(), Timeout: DefaultTimeout})
+	return c
+}
+
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// parallel workers call the same few hosts, keep a connection for each
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	return transport
+}
+
+// drop the pooled connections and continue with new ones, e.g. when the
+// provider silently stopped answering on them, calls in flight finish on
+// the old ones
+func (c *Client) ResetConnections() {
+	old := c.http.Load()
+	c.http.Store(&http.Client{Transport: newTransport(), Timeout: old.Timeout})
+	old.CloseIdleConnections()
+}
+
+// add more endpoints to fail over to and balance the calls with, before the
+// client is used
+func (c *Client) AddEndpoints(urls ...string) {
+	for _, url := range urls {
+		c.endpoints.endpoints = append(c.endpoints.endpoints, &endpoint{url: url})
+	}
+}
+
+// replace the endpoints with the urls, e.g. when the config is reloaded,
+// the calls in flight finish on the ones they started with
+func (c *Client) SetEndpoints(urls ...string) {
+	c.endpoints.replace(urls)
+}
+
+// limit the calls to rate per second, allowing bursts of up to burst calls
+// after a quiet spell, 0 is unlimited, calls waiting for the previous limit
+// still do
+func (c *Client) SetRateLimit(rate float64, burst int) {
+	c.limiter.Store(newRateLimiter(rate, burst))
+}
+
+// how long a single rpc call may take before the endpoint is considered
+// down, 0 waits forever, before the client is used
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.http.Load().Timeout = timeout
+}
+
+// post the rpc request to the endpoint, waiting for the rate limit
+func (c *Client) postJson(ctx context.Context, payload, result interface{}) (err error) {
+	method := rpcMethod(payload)
+	ctx, end := startSpan(ctx, "rpc "+method, attribute.String("rpc.method", method))
+	defer func() { end(err) }()
+	if err := c.limiter.Load().Wait(ctx); err != nil {
+		return err
+	}
+	return c.postFailover(ctx, payload, result)
+}
+
+// post a batch of rpc requests in one round trip, every request counts
+// toward the rate limit
+func (c *Client) postBatch(ctx context.Context, batch []interface{}, result interface{}) (err error) {
+	method := rpcMethod(batch)
+	ctx, end := startSpan(ctx, "rpc "+method, attribute.String("rpc.method", method), attribute.Int("rpc.batch_size", len(batch)))
+	defer func() { end(err) }()
+	if err := c.limiter.Load().WaitN(ctx, len(batch)); err != nil {
+		return err
+	}
+	return c.postFailover(ctx, batch, result)
+}
+
+const (
+	// how long an rpc call may take before the endpoint is considered down
+	DefaultTimeout      = 30 * time.Second
+	maxIdleConnsPerHost = 16
+)
+
+// post the json-rpc request, a slice payload is sent as a batch and the
+// result must then be a slice too; the user and password of the url are
+// sent as basic auth, along with the headers set for its host
+func (c *Client) postJsonFor(ctx context.Context, url string, payload, result interface{}) error {
+	if path, ok := ipcPath(url); ok {
+		return c.postIpc(ctx, path, payload, result)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(data))
+	if err != nil {
+		return redactedError(err)
+	}
+	if user := req.URL.User; user != nil {
+		password, _ := user.Password()
+		req.SetBasicAuth(user.Username(), password)
+		req.URL.User = nil
+	}
+	for name, values := range c.authHeader(url) {
+		req.Header[name] = values
+	}
+	// req.Header.Set("Content-Type", "application/json")
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := c.http.Load().Do(req)
+	if err != nil {
+		return redactedError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// rate limited or down, let the caller fail over
+		if wait := parseRetryAfter(resp.Header.Get("Retry-After")); wait > 0 {
+			return &RetryAfterError{Status: resp.StatusCode, RetryAfter: wait}
+		}
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if respBody, err := io.ReadAll(resp.Body); err != nil {
+		return err
+	} else {
+		return json.Unmarshal(respBody, &result)
+	}
+}
+
+// The endpoint refused the call, typically with 429, and asked to wait
+// before the next one
+type RetryAfterError struct {
+	Status     int
+	RetryAfter time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("unexpected status %d, retry after %v", e.Status, e.RetryAfter)
+}
+
+// how long the endpoint asked to wait when err is a RetryAfterError, 0
+// otherwise
+func RetryAfter(err error) time.Duration {
+	var retryErr *RetryAfterError
+	if errors.As(err, &retryErr) {
+		return retryErr.RetryAfter
+	}
+	return 0
+}
+
+// the Retry-After header, either delay seconds or an http date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+func (c *Client) FetchBlock(ctx context.Context, block int) (b *types.Block, err error) {
+	ctx, end := startSpan(ctx, "FetchBlock", attribute.Int("block", block))
+	defer func() { end(err) }()
+	params := map[string]interface{}{
+		"id":      1,
+		"jsonrpc": "2.0",
+		"method":  "eth_getBlockByNumber",
+		"params":  []interface{}{fmt.Sprintf("0x%x", block), true},
+	}
+	var result struct {
+		Code    int
+		Jsonrpc string
+		Result  *types.Block
+	}
+	err = c.postJson(ctx, params, &result)
+	if err == nil {
+		if result.Code != 0 {
+			err = fmt.Errorf("failed rpc request, code %d", result.Code)
+		} else if result.Result == nil {
+			err = fmt.Errorf("block %d not found", block)
+		} else {
+			b = result.Result
+		}
+	}
+	return
+}
+
+// the blocks from..to in a single batch request, in block order
+func (c *Client) FetchBlocks(ctx context.Context, from, to int) (_ []*types.Block, err error) {
+	ctx, end := startSpan(ctx, "FetchBlocks", attribute.Int("from", from), attribute.Int("to", to))
+	defer func() { end(err) }()
+	if from == to {
+		// not every endpoint accepts batches, keep single blocks plain
+		block, err := c.FetchBlock(ctx, from)
+		if err != nil {
+			return nil, err
+		}
+		return []*types.Block{block}, nil
+	}
+	batch := make([]interface{}, 0, to-from+1)
+	for block := from; block <= to; block++ {
+		batch = append(batch, map[string]interface{}{
+			"id":      block,
+			"jsonrpc": "2.0",
+			"method":  "eth_getBlockByNumber",
+			"params":  []interface{}{fmt.Sprintf("0x%x", block), true},
+		})
+	}
+	var results []struct {
+		Id      int
+		Code    int
+		Jsonrpc string
+		Error   *struct {
+			Code    int
+			Message string
+		}
+		Result *types.Block
+	}
+	if err := c.postBatch(ctx, batch, &results); err != nil {
+		return nil, err
+	}
+	// responses may come back in any order, match them by id
+	blocks := make([]*types.Block, to-from+1)
+	for _, result := range results {
+		if result.Id < from || result.Id > to {
+			continue
+		}
+		if result.Code != 0 {
+			return nil, fmt.Errorf("failed rpc request, code %d", result.Code)
+		}
+		if result.Error != nil {
+			return nil, fmt.Errorf("failed rpc request, code %d, %s", result.Error.Code, result.Error.Message)
+		}
+		blocks[result.Id-from] = result.Result
+	}
+	for i, block := range blocks {
+		if block == nil {
+			return nil, fmt.Errorf("block %d not found", from+i)
+		}
+	}
+	return blocks, nil
+}
+
+func (c *Client) GetLatestBlockNumber(ctx context.Context) (block int, err error) {
+	ctx, end := startSpan(ctx, "GetLatestBlockNumber")
+	defer func() { end(err) }()
+	params := map[string]interface{}{
+		"id":      1,
+		"jsonrpc": "2.0",
+		"method":  "eth_blockNumber",
+		"params":  []interface{}{},
+	}
+	var result struct {
+		Code    int
+		Jsonrpc string
+		Result  string
+	}
+	err = c.postJson(ctx, params, &result)
+	if err == nil {
+		if result.Code != 0 {
+			err = fmt.Errorf("failed rpc request, code %d", result.Code)
+		} else {
+			var blockNumber int64
+			if blockNumber, err = strconv.ParseInt(result.Result, 0, 0); err == nil {
+				block = int(blockNumber)
+			}
+		}
+	}
+	return
+}
+
+// the number of the block with the tag, BlockTagLatest, BlockTagSafe or
+// BlockTagFinalized, read with eth_getBlockByNumber; a node that doesn't
+// know the tag fails, e.g. before the merge
+func (c *Client) GetTaggedBlockNumber(ctx context.Context, tag string) (block int, err error) {
+	if tag == BlockTagLatest {
+		return c.GetLatestBlockNumber(ctx)
+	}
+	ctx, end := startSpan(ctx, "GetTaggedBlockNumber", attribute.String("rpc.block_tag", tag))
+	defer func() { end(err) }()
+	params := map[string]interface{}{
+		"id":      1,
+		"jsonrpc": "2.0",
+		"method":  "eth_getBlockByNumber",
+		"params":  []interface{}{tag, false},
+	}
+	var result struct {
+		Code    int
+		Jsonrpc string
+		Result  *struct {
+			Number string
+		}
+	}
+	err = c.postJson(ctx, params, &result)
+	if err == nil {
+		if result.Code != 0 {
+			err = fmt.Errorf("failed rpc request, code %d", result.Code)
+		} else if result.Result == nil {
+			err = fmt.Errorf("no %s block", tag)
+		} else {
+			var blockNumber int64
+			if blockNumber, err = strconv.ParseInt(result.Result.Number, 0, 0); err == nil {
+				block = int(blockNumber)
+			}
+		}
+	}
+	return
+}
+
+// the block without its transactions
+func (c *Client) FetchBlockHeader(ctx context.Context, block int) (b *types.Block, err error) {
+	params := map[string]interface{}{
+		"id":      1,
+		"jsonrpc": "2.0",
+		"method":  "eth_getBlockByNumber",
+		"params":  []interface{}{fmt.Sprintf("0x%x", block), false},
+	}
+	var result struct {
+		Code    int
+		Jsonrpc string
+		Result  *struct {
+			Number        string
+			Hash          string
+			ParentHash    string
+			Timestamp     string
+			BaseFeePerGas string
+		}
+	}
+	err = c.postJson(ctx, params, &result)
+	if err == nil {
+		if result.Code != 0 {
+			err = fmt.Errorf("failed rpc request, code %d", result.Code)
+		} else if result.Result == nil {
+			err = fmt.Errorf("block %d not found", block)
+		} else {
+			b = &types.Block{
+				Number:        result.Result.Number,
+				Hash:          result.Result.Hash,
+				ParentHash:    result.Result.ParentHash,
+				Timestamp:     result.Result.Timestamp,
+				BaseFeePerGas: result.Result.BaseFeePerGas,
+			}
+		}
+	}
+	return
+}
+
+func (c *Client) FetchLogs(ctx context.Context, fromBlock, toBlock int, topics []interface{}) ([]*types.Log, error) {
+	return c.FetchContractLogs(ctx, fromBlock, toBlock, "", topics)
+}
+
+// the logs of the blocks emitted by the contract and matching the topics,
+// of any contract when empty
+func (c *Client) FetchContractLogs(ctx context.Context, fromBlock, toBlock int, contract string, topics []interface{}) ([]*types.Log, error) {
+	var contracts []string
+	if contract != "" {
+		contracts = []string{contract}
+	}
+	return c.FetchContractsLogs(ctx, fromBlock, toBlock, contracts, topics)
+}
+
+// the logs of the blocks emitted by any of the contracts and matching the
+// topics, of any contract when there are none
+func (c *Client) FetchContractsLogs(ctx context.Context, fromBlock, toBlock int, contracts []string, topics []interface{}) (logs []*types.Log, err error) {
+	filter := map[string]interface{}{
+		"fromBlock": fmt.Sprintf("0x%x", fromBlock),
+		"toBlock":   fmt.Sprintf("0x%x", toBlock),
+		"topics":    topics,
+	}
+	if len(contracts) > 0 {
+		filter["address"] = contracts
+	}
+	params := map[string]interface{}{
+		"id":      1,
+		"jsonrpc": "2.0",
+		"method":  "eth_getLogs",
+		"params":  []interface{}{filter},
+	}
+	var result struct {
+		Code    int
+		Jsonrpc string
+		// e.g. more logs in the range than the provider returns at once
+		Error *struct {
+			Code    int
+			Message string
+		}
+		Result []*types.Log
+	}
+	err = c.postJson(ctx, params, &result)
+	if err == nil {
+		if result.Code != 0 {
+			err = fmt.Errorf("failed rpc request, code %d", result.Code)
+		} else if result.Error != nil {
+			err = fmt.Errorf("failed rpc request, code %d, %s", result.Error.Code, result.Error.Message)
+		} else {
+			logs = result.Result
+		}
+	}
+	return
+}
+
+func (c *Client) Call(ctx context.Context, to, data string) (output string, err error) {
+	params := map[string]interface{}{
+		"id":      1,
+		"jsonrpc": "2.0",
+		"method":  "eth_call",
+		"params":  []interface{}{map[string]interface{}{"to": to, "data": data}, "latest"},
+	}
+	var result struct {
+		Code    int
+		Jsonrpc string
+		Result  string
+	}
+	err = c.postJson(ctx, params, &result)
+	if err == nil {
+		if result.Code != 0 {
+			err = fmt.Errorf("failed rpc request, code %d", result.Code)
+		} else {
+			output = result.Result
+		}
+	}
+	return
+}
+
+// the wei balance of the address at the chain head, as a hex quantity
+func (c *Client) GetBalance(ctx context.Context, address string) (string, error) {
+	return c.accountQuantity(ctx, "eth_getBalance", address, "latest")
+}
+
+// the wei balance of the address at the end of the block, as a hex quantity
+func (c *Client) GetBalanceAt(ctx context.Context, address string, block int) (string, error) {
+	return c.accountQuantity(ctx, "eth_getBalance", address, fmt.Sprintf("0x%x", block))
+}
+
+// the number of transactions sent by the address at the chain head, its
+// nonce, as a hex quantity
+func (c *Client) GetTransactionCount(ctx context.Context, address string) (string, error) {
+	return c.accountQuantity(ctx, "eth_getTransactionCount", address, "latest")
+}
+
+func (c *Client) accountQuantity(ctx context.Context, method, address, block string) (quantity string, err error) {
+	params := map[string]interface{}{
+		"id":      1,
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  []interface{}{address, block},
+	}
+	var result struct {
+		Code    int
+		Jsonrpc string
+		Error   *struct {
+			Code    int
+			Message string
+		}
+		Result string
+	}
+	err = c.postJson(ctx, params, &result)
+	if err == nil {
+		if result.Code != 0 {
+			err = fmt.Errorf("failed rpc request, code %d", result.Code)
+		} else if result.Error != nil {
+			err = fmt.Errorf("failed rpc request, code %d, %s", result.Error.Code, result.Error.Message)
+		} else {
+			quantity = result.Result
+		}
+	}
+	return
+}
+
+func (c *Client) FetchReceipt(ctx context.Context, hash string) (r *types.Receipt, err error) {
+	params := map[string]interface{}{
+		"id":      1,
+		"jsonrpc": "2.0",
+		"method":  "eth_getTransactionReceipt",
+		"params":  []interface{}{hash},
+	}
+	var result struct {
+		Code    int
+		Jsonrpc string
+		Result  *types.Receipt
+	}
+	err = c.postJson(ctx, params, &result)
+	if err == nil {
+		if result.Code != 0 {
+			err = fmt.Errorf("failed rpc request, code %d", result.Code)
+		} else if result.Result == nil {
+			err = fmt.Errorf("receipt of transaction %s not found", hash)
+		} else {
+			r = result.Result
+		}
+	}
+	return
+}
+
+// the receipts of the transactions in a single batch request, in order
+func (c *Client) FetchReceipts(ctx context.Context, hashes []string) ([]*types.Receipt, error) {
+	if len(hashes) == 1 {
+		// not every endpoint accepts batches, keep single receipts plain
+		receipt, err := c.FetchReceipt(ctx, hashes[0])
+		if err != nil {
+			return nil, err
+		}
+		return []*types.Receipt{receipt}, nil
+	}
+	batch := make([]interface{}, 0, len(hashes))
+	for i, hash := range hashes {
+		batch = append(batch, map[string]interface{}{
+			"id":      i,
+			"jsonrpc": "2.0",
+			"method":  "eth_getTransactionReceipt",
+			"params":  []interface{}{hash},
+		})
+	}
+	var results []struct {
+		Id      int
+		Code    int
+		Jsonrpc string
+		Error   *struct {
+			Code    int
+			Message string
+		}
+		Result *types.Receipt
+	}
+	if err := c.postBatch(ctx, batch, &results); err != nil {
+		return nil, err
+	}
+	receipts := make([]*types.Receipt, len(hashes))
+	for _, result := range results {
+		if result.Id < 0 || result.Id >= len(receipts) {
+			continue
+		}
+		if result.Code != 0 {
+			return nil, fmt.Errorf("failed rpc request, code %d", result.Code)
+		}
+		if result.Error != nil {
+			return nil, fmt.Errorf("failed rpc request, code %d, %s", result.Error.Code, result.Error.Message)
+		}
+		receipts[result.Id] = result.Result
+	}
+	for i, receipt := range receipts {
+		if receipt == nil {
+			return nil, fmt.Errorf("receipt of transaction %s not found", hashes[i])
+		}
+	}
+	return receipts, nil
+}
+
+// the json-rpc error code of methods the node doesn't implement
+const methodNotFound = -32601
+
+// whether the rpc error says the method isn't available, providers don't all
+// use the standard code for it
+func unsupportedMethod(code int, message string) bool {
+	message = strings.ToLower(message)
+	return code == methodNotFound || strings.Contains(message, "not supported") ||
+		strings.Contains(message, "does not exist") || strings.Contains(message, "not available")
+}
+
+// The endpoint doesn't implement eth_getBlockReceipts
+var ErrBlockReceiptsUnsupported = errors.New("eth_getBlockReceipts not supported")
+
+// the receipts of the matched transactions, with one eth_getBlockReceipts
+// per block when the endpoint supports it, falling back to one
+// eth_getTransactionReceipt per transaction otherwise
+func (c *Client) FetchMatchedReceipts(ctx context.Context, txs []*types.Transaction) ([]*types.Receipt, error) {
+	hashes := make([]string, len(txs))
+	for i, tx := range txs {
+		hashes[i] = tx.Hash
+	}
+	if c.noBlockReceipts.Load() {
+		return c.FetchReceipts(ctx, hashes)
+	}
+	var blocks []string
+	seen := make(map[string]bool)
+	for _, tx := range txs {
+		if !seen[tx.BlockNumber] {
+			seen[tx.BlockNumber] = true
+			blocks = append(blocks, tx.BlockNumber)
+		}
+	}
+	blockReceipts, err := c.FetchBlockReceipts(ctx, blocks)
+	if errors.Is(err, ErrBlockReceiptsUnsupported) {
+		c.noBlockReceipts.Store(true)
+		slog.Info("Endpoint lacks eth_getBlockReceipts, fetching receipts per transaction")
+		return c.FetchReceipts(ctx, hashes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	byHash := make(map[string]*types.Receipt)
+	for _, receipts := range blockReceipts {
+		for _, receipt := range receipts {
+			byHash[receipt.TransactionHash] = receipt
+		}
+	}
+	receipts := make([]*types.Receipt, len(hashes))
+	for i, hash := range hashes {
+		if receipts[i] = byHash[hash]; receipts[i] == nil {
+			return nil, fmt.Errorf("receipt of transaction %s not found", hash)
+		}
+	}
+	return receipts, nil
+}
+
+// the receipts of all the transactions of the blocks, given as hex numbers,
+// in a single batch request
+func (c *Client) FetchBlockReceipts(ctx context.Context, blocks []string) ([][]*types.Receipt, error) {
+	batch := make([]interface{}, 0, len(blocks))
+	for i, block := range blocks {
+		batch = append(batch, map[string]interface{}{
+			"id":      i,
+			"jsonrpc": "2.0",
+			"method":  "eth_getBlockReceipts",
+			"params":  []interface{}{block},
+		})
+	}
+	type blockResult struct {
+		Id      int
+		Code    int
+		Jsonrpc string
+		Error   *struct {
+			Code    int
+			Message string
+		}
+		Result []*types.Receipt
+	}
+	var results []blockResult
+	var err error
+	if len(batch) == 1 {
+		// not every endpoint accepts batches, keep single blocks plain
+		results = make([]blockResult, 1)
+		err = c.postJson(ctx, batch[0], &results[0])
+	} else {
+		err = c.postBatch(ctx, batch, &results)
+	}
+	if err != nil {
+		return nil, err
+	}
+	receipts := make([][]*types.Receipt, len(blocks))
+	for _, result := range results {
+		if result.Id < 0 || result.Id >= len(receipts) {
+			continue
+		}
+		if result.Code != 0 {
+			return nil, fmt.Errorf("failed rpc request, code %d", result.Code)
+		}
+		if result.Error != nil {
+			if unsupportedMethod(result.Error.Code, result.Error.Message) {
+				return nil, ErrBlockReceiptsUnsupported
+			}
+			return nil, fmt.Errorf("failed rpc request, code %d, %s", result.Error.Code, result.Error.Message)
+		}
+		receipts[result.Id] = result.Result
+	}
+	for i, r := range receipts {
+		if r == nil {
+			return nil, fmt.Errorf("receipts of block %s not found", blocks[i])
+		}
+	}
+	return receipts, nil
+}
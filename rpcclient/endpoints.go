@@ -0,0 +1,216 @@
+package rpcclient
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	endpointInitialBackoff = 5 * time.Second
+	endpointMaxBackoff     = time.Minute
+	endpointCheckInterval  = 30 * time.Second
+)
+
+// The rpc endpoints, calls are spread round robin over the healthy ones and
+// an endpoint failing a call is left out until its backoff runs out
+type endpointPool struct {
+	endpoints []*endpoint
+	next      int
+	// the endpoint that answered the last call
+	last *endpoint
+	sync.Mutex
+}
+
+type endpoint struct {
+	url       string
+	failures  int
+	downUntil time.Time
+}
+
+func newEndpointPool(urls []string) *endpointPool {
+	pool := &endpointPool{}
+	for _, url := range urls {
+		pool.endpoints = append(pool.endpoints, &endpoint{url: url})
+	}
+	return pool
+}
+
+// replace the endpoints with the urls, the ones still listed keep their
+// failures and backoff
+func (pool *endpointPool) replace(urls []string) {
+	pool.Lock()
+	defer pool.Unlock()
+	known := make(map[string]*endpoint, len(pool.endpoints))
+	for _, e := range pool.endpoints {
+		known[e.url] = e
+	}
+	endpoints := make([]*endpoint, 0, len(urls))
+	for _, url := range urls {
+		e := known[url]
+		if e == nil {
+			e = &endpoint{url: url}
+		}
+		endpoints = append(endpoints, e)
+	}
+	pool.endpoints = endpoints
+	pool.next = 0
+}
+
+// how many endpoints there are
+func (pool *endpointPool) size() int {
+	pool.Lock()
+	defer pool.Unlock()
+	return len(pool.endpoints)
+}
+
+// the endpoints to try for the next call, healthy ones first in round robin
+// order, then the failing ones soonest back first
+func (pool *endpointPool) order() []*endpoint {
+	pool.Lock()
+	defer pool.Unlock()
+	now := time.Now()
+	var healthy, down []*endpoint
+	for i := range pool.endpoints {
+		e := pool.endpoints[(pool.next+i)%len(pool.endpoints)]
+		if e.downUntil.After(now) {
+			down = append(down, e)
+		} else {
+			healthy = append(healthy, e)
+		}
+	}
+	pool.next = (pool.next + 1) % len(pool.endpoints)
+	sort.SliceStable(down, func(i, j int) bool {
+		return down[i].downUntil.Before(down[j].downUntil)
+	})
+	return append(healthy, down...)
+}
+
+// record the outcome of a call to the endpoint
+func (pool *endpointPool) report(e *endpoint, err error) {
+	pool.Lock()
+	defer pool.Unlock()
+	if err == nil {
+		if e.failures > 0 {
+			slog.Info("RPC endpoint recovered", "url", RedactedUrl(e.url))
+		}
+		e.failures, e.downUntil = 0, time.Time{}
+		pool.last = e
+		return
+	}
+	e.failures++
+	backoff := min(endpointInitialBackoff<<min(e.failures-1, 10), endpointMaxBackoff)
+	// a rate limited endpoint says itself when it takes calls again
+	backoff = max(backoff, RetryAfter(err))
+	e.downUntil = time.Now().Add(backoff)
+	slog.Warn("RPC endpoint failed, skipping it", "url", RedactedUrl(e.url), "err", err, "wait", backoff)
+}
+
+// leave out the endpoint that answered the last call as if it failed with
+// the error, e.g. when its answers look stale, false when there is no other
+// endpoint to rotate to
+func (c *Client) RotateEndpoint(err error) bool {
+	pool := c.endpoints
+	pool.Lock()
+	e := pool.last
+	pool.last = nil
+	size := len(pool.endpoints)
+	pool.Unlock()
+	if e == nil || size < 2 {
+		return false
+	}
+	pool.report(e, err)
+	return true
+}
+
+// the endpoints currently left out
+func (pool *endpointPool) down() []*endpoint {
+	pool.Lock()
+	defer pool.Unlock()
+	var down []*endpoint
+	for _, e := range pool.endpoints {
+		if e.failures > 0 {
+			down = append(down, e)
+		}
+	}
+	return down
+}
+
+// how many endpoints are not failing their calls, out of all of them
+func (c *Client) EndpointsUp() (up, total int) {
+	down := len(c.endpoints.down())
+	total = c.endpoints.size()
+	return max(total-down, 0), total
+}
+
+// post the request to the endpoints in order until one answers, a call cut
+// by the context isn't held against the endpoint
+func (c *Client) postFailover(ctx context.Context, payload, result interface{}) (err error) {
+	span := trace.SpanFromContext(ctx)
+	for _, e := range c.endpoints.order() {
+		err = c.postJsonFor(ctx, e.url, payload, result)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.endpoints.report(e, err)
+		if err == nil {
+			span.SetAttributes(attribute.String("server.address", endpointHost(e.url)))
+			return nil
+		}
+		span.AddEvent("endpoint failed", trace.WithAttributes(attribute.String("server.address", endpointHost(e.url)), attribute.String("error", err.Error())))
+	}
+	return err
+}
+
+// check the failing endpoints periodically until the context is done, a
+// single endpoint has nothing to fail over to and isn't checked
+func (c *Client) WatchEndpoints(ctx context.Context) {
+	ticker := time.NewTicker(endpointCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if c.endpoints.size() > 1 {
+				c.checkEndpoints(ctx)
+			}
+		}
+	}
+}
+
+// probe the failing endpoints with eth_blockNumber so they rejoin as soon as
+// they are back instead of waiting for their backoff to run out
+func (c *Client) checkEndpoints(ctx context.Context) {
+	params := map[string]interface{}{
+		"id":      1,
+		"jsonrpc": "2.0",
+		"method":  "eth_blockNumber",
+		"params":  []interface{}{},
+	}
+	for _, e := range c.endpoints.down() {
+		// probes count toward the rate limit like any other call
+		if err := c.limiter.Load().Wait(ctx); err != nil {
+			return
+		}
+		var result struct {
+			Code    int
+			Jsonrpc string
+			Result  string
+		}
+		err := c.postJsonFor(ctx, e.url, params, &result)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil && (result.Code != 0 || result.Result == "") {
+			err = fmt.Errorf("failed rpc request, code %d", result.Code)
+		}
+		c.endpoints.report(e, err)
+	}
+}
@@ -0,0 +1,41 @@
+package rpcclient
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"time"
+)
+
+// the socket path of an ipc endpoint of a local node, given as
+// ipc:///path/geth.ipc or the bare path, false for other endpoints
+func ipcPath(endpoint string) (string, bool) {
+	if path, ok := strings.CutPrefix(endpoint, "ipc://"); ok {
+		return path, true
+	}
+	return endpoint, strings.HasPrefix(endpoint, "/")
+}
+
+// send the json-rpc request over the unix socket of the node, one connection
+// per call which on a local socket costs less than the call itself; the
+// timeout of the http calls applies
+func (c *Client) postIpc(ctx context.Context, path string, payload, result interface{}) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if timeout := c.http.Load().Timeout; timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	// unblock the read below on cancel
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+	if err := json.NewEncoder(conn).Encode(payload); err != nil {
+		return err
+	}
+	// the node streams its replies, the first value is the one of the call
+	return json.NewDecoder(conn).Decode(result)
+}
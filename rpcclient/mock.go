@@ -0,0 +1,400 @@
+package rpcclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// The mock has no websocket endpoint to subscribe on
+var ErrMockSubscription = errors.New("the mock client has no subscriptions")
+
+// An in-memory node serving the blocks, logs, receipts and mempool it is
+// given, a test double of Client for unit testing the parser; its single
+// endpoint never fails unless told to
+type MockClient struct {
+	blocks map[int]*types.Block
+	head   int
+	// the numbers of the safe and finalized blocks, the head when unset
+	tags     map[string]int
+	logs     []*types.Log
+	receipts map[string]*types.Receipt
+	pending  []*types.Transaction
+	// eth_call outputs by contract and data
+	outputs map[string]string
+	// the error every call fails with, nil when they succeed
+	err error
+	// the calls made, failed ones included
+	requests int
+	sync.Mutex
+}
+
+func NewMockClient() *MockClient {
+	return &MockClient{
+		blocks:   make(map[int]*types.Block),
+		tags:     make(map[string]int),
+		receipts: make(map[string]*types.Receipt),
+		outputs:  make(map[string]string),
+	}
+}
+
+// serve the blocks, moving the head to the last one when it is past it
+func (m *MockClient) AddBlocks(blocks ...*types.Block) {
+	m.Lock()
+	defer m.Unlock()
+	for _, block := range blocks {
+		number := types.BlockNumber(block.Number)
+		m.blocks[number] = block
+		m.head = max(m.head, number)
+	}
+}
+
+// move the chain head, e.g. back for a reorg, blocks past it are still served
+func (m *MockClient) SetHead(head int) {
+	m.Lock()
+	defer m.Unlock()
+	m.head = head
+}
+
+// answer the block of the tag, e.g. BlockTagFinalized, with the number
+func (m *MockClient) SetTag(tag string, block int) {
+	m.Lock()
+	defer m.Unlock()
+	m.tags[tag] = block
+}
+
+// serve the logs to eth_getLogs calls whose filter they match
+func (m *MockClient) AddLogs(logs ...*types.Log) {
+	m.Lock()
+	defer m.Unlock()
+	m.logs = append(m.logs, logs...)
+}
+
+// serve the receipts by their transaction hash
+func (m *MockClient) AddReceipts(receipts ...*types.Receipt) {
+	m.Lock()
+	defer m.Unlock()
+	for _, receipt := range receipts {
+		m.receipts[strings.ToLower(receipt.TransactionHash)] = receipt
+	}
+}
+
+// serve the transactions as the mempool
+func (m *MockClient) SetPending(txs ...*types.Transaction) {
+	m.Lock()
+	defer m.Unlock()
+	m.pending = txs
+}
+
+// answer eth_call of the data on the contract with the output
+func (m *MockClient) SetCall(to, data, output string) {
+	m.Lock()
+	defer m.Unlock()
+	m.outputs[strings.ToLower(to)+data] = output
+}
+
+// fail every call with the error until set back to nil
+func (m *MockClient) Fail(err error) {
+	m.Lock()
+	defer m.Unlock()
+	m.err = err
+}
+
+// how many calls were made, failed ones included
+func (m *MockClient) Requests() int {
+	m.Lock()
+	defer m.Unlock()
+	return m.requests
+}
+
+// count the call, the error when calls fail; locked by the caller
+func (m *MockClient) call() error {
+	m.requests++
+	return m.err
+}
+
+func (m *MockClient) FetchBlock(ctx context.Context, block int) (*types.Block, error) {
+	m.Lock()
+	defer m.Unlock()
+	if err := m.call(); err != nil {
+		return nil, err
+	}
+	return m.block(block)
+}
+
+// the block up to the head, locked by the caller
+func (m *MockClient) block(block int) (*types.Block, error) {
+	b, ok := m.blocks[block]
+	if !ok || block > m.head {
+		return nil, fmt.Errorf("block %d not found", block)
+	}
+	return b, nil
+}
+
+func (m *MockClient) FetchBlocks(ctx context.Context, from, to int) ([]*types.Block, error) {
+	m.Lock()
+	defer m.Unlock()
+	if err := m.call(); err != nil {
+		return nil, err
+	}
+	blocks := make([]*types.Block, 0, to-from+1)
+	for number := from; number <= to; number++ {
+		block, err := m.block(number)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+func (m *MockClient) FetchBlockHeader(ctx context.Context, block int) (*types.Block, error) {
+	m.Lock()
+	defer m.Unlock()
+	if err := m.call(); err != nil {
+		return nil, err
+	}
+	b, err := m.block(block)
+	if err != nil {
+		return nil, err
+	}
+	return &types.Block{Number: b.Number, Hash: b.Hash, ParentHash: b.ParentHash, Timestamp: b.Timestamp, BaseFeePerGas: b.BaseFeePerGas}, nil
+}
+
+func (m *MockClient) GetLatestBlockNumber(ctx context.Context) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	if err := m.call(); err != nil {
+		return 0, err
+	}
+	return m.head, nil
+}
+
+func (m *MockClient) GetTaggedBlockNumber(ctx context.Context, tag string) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	if err := m.call(); err != nil {
+		return 0, err
+	}
+	if block, ok := m.tags[tag]; ok && tag != BlockTagLatest {
+		return min(block, m.head), nil
+	}
+	return m.head, nil
+}
+
+func (m *MockClient) FetchLogs(ctx context.Context, fromBlock, toBlock int, topics []interface{}) ([]*types.Log, error) {
+	return m.FetchContractsLogs(ctx, fromBlock, toBlock, nil, topics)
+}
+
+func (m *MockClient) FetchContractLogs(ctx context.Context, fromBlock, toBlock int, contract string, topics []interface{}) ([]*types.Log, error) {
+	var contracts []string
+	if contract != "" {
+		contracts = []string{contract}
+	}
+	return m.FetchContractsLogs(ctx, fromBlock, toBlock, contracts, topics)
+}
+
+func (m *MockClient) FetchContractsLogs(ctx context.Context, fromBlock, toBlock int, contracts []string, topics []interface{}) ([]*types.Log, error) {
+	m.Lock()
+	defer m.Unlock()
+	if err := m.call(); err != nil {
+		return nil, err
+	}
+	logs := []*types.Log{}
+	for _, log := range m.logs {
+		block := types.BlockNumber(log.BlockNumber)
+		if block < fromBlock || block > toBlock || block > m.head {
+			continue
+		}
+		if len(contracts) > 0 && !slices.ContainsFunc(contracts, func(contract string) bool { return strings.EqualFold(contract, log.Address) }) {
+			continue
+		}
+		if matchTopics(log, topics) {
+			logs = append(logs, log)
+		}
+	}
+	return logs, nil
+}
+
+// whether the topics of the log match those of an eth_getLogs filter, a nil
+// filter topic matching any and a list any of its values
+func matchTopics(log *types.Log, topics []interface{}) bool {
+	for i, topic := range topics {
+		var values []string
+		switch topic := topic.(type) {
+		case nil:
+			continue
+		case string:
+			values = []string{topic}
+		case []string:
+			values = topic
+		case []interface{}:
+			for _, value := range topic {
+				if value, ok := value.(string); ok {
+					values = append(values, value)
+				}
+			}
+		}
+		if i >= len(log.Topics) || !slices.ContainsFunc(values, func(value string) bool { return strings.EqualFold(value, log.Topics[i]) }) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *MockClient) FetchReceipt(ctx context.Context, hash string) (*types.Receipt, error) {
+	m.Lock()
+	defer m.Unlock()
+	if err := m.call(); err != nil {
+		return nil, err
+	}
+	receipt, ok := m.receipts[strings.ToLower(hash)]
+	if !ok {
+		return nil, fmt.Errorf("receipt of %s not found", hash)
+	}
+	return receipt, nil
+}
+
+func (m *MockClient) FetchMatchedReceipts(ctx context.Context, txs []*types.Transaction) ([]*types.Receipt, error) {
+	receipts := make([]*types.Receipt, len(txs))
+	for i, tx := range txs {
+		receipt, err := m.FetchReceipt(ctx, tx.Hash)
+		if err != nil {
+			return nil, err
+		}
+		receipts[i] = receipt
+	}
+	return receipts, nil
+}
+
+func (m *MockClient) FetchTransaction(ctx context.Context, hash string) (*types.Transaction, error) {
+	m.Lock()
+	defer m.Unlock()
+	if err := m.call(); err != nil {
+		return nil, err
+	}
+	for number, block := range m.blocks {
+		if number > m.head {
+			continue
+		}
+		for _, tx := range block.Transactions {
+			if strings.EqualFold(tx.Hash, hash) {
+				return tx, nil
+			}
+		}
+	}
+	for _, tx := range m.pending {
+		if strings.EqualFold(tx.Hash, hash) {
+			return tx, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *MockClient) FetchTransactions(ctx context.Context, hashes []string) ([]*types.Transaction, error) {
+	txs := make([]*types.Transaction, len(hashes))
+	for i, hash := range hashes {
+		tx, err := m.FetchTransaction(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+		if tx == nil {
+			return nil, fmt.Errorf("transaction %s not found", hash)
+		}
+		txs[i] = tx
+	}
+	return txs, nil
+}
+
+func (m *MockClient) FetchTxPool(ctx context.Context) ([]*types.Transaction, error) {
+	m.Lock()
+	defer m.Unlock()
+	if err := m.call(); err != nil {
+		return nil, err
+	}
+	return slices.Clone(m.pending), nil
+}
+
+// the mock knows no internal transactions
+func (m *MockClient) FetchInternalTransactions(ctx context.Context, block *types.Block, method string) ([]*types.Transaction, error) {
+	m.Lock()
+	defer m.Unlock()
+	return nil, m.call()
+}
+
+// the output set for the call, "0x" otherwise like a node calling an account
+// without code
+func (m *MockClient) Call(ctx context.Context, to, data string) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+	if err := m.call(); err != nil {
+		return "", err
+	}
+	if output, ok := m.outputs[strings.ToLower(to)+data]; ok {
+		return output, nil
+	}
+	return "0x", nil
+}
+
+// every address is empty
+func (m *MockClient) GetBalance(ctx context.Context, address string) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+	return "0x0", m.call()
+}
+
+// every address is empty at every block
+func (m *MockClient) GetBalanceAt(ctx context.Context, address string, block int) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+	return "0x0", m.call()
+}
+
+// every address is unused
+func (m *MockClient) GetTransactionCount(ctx context.Context, address string) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+	return "0x0", m.call()
+}
+
+func (m *MockClient) SubscribeNewHeads(ctx context.Context, url string, head func(block int)) error {
+	return ErrMockSubscription
+}
+
+func (m *MockClient) SubscribePendingTransactions(ctx context.Context, url string, pending func(tx *types.Transaction)) error {
+	return ErrMockSubscription
+}
+
+func (m *MockClient) AddEndpoints(urls ...string) {}
+
+func (m *MockClient) SetEndpoints(urls ...string) {}
+
+func (m *MockClient) SetRateLimit(rate float64, burst int) {}
+
+func (m *MockClient) SetTimeout(timeout time.Duration) {}
+
+func (m *MockClient) SetAuth(auth map[string]*EndpointAuth) {}
+
+// the single endpoint is down while calls fail
+func (m *MockClient) EndpointsUp() (up, total int) {
+	m.Lock()
+	defer m.Unlock()
+	if m.err != nil {
+		return 0, 1
+	}
+	return 1, 1
+}
+
+func (m *MockClient) RotateEndpoint(err error) bool {
+	return false
+}
+
+func (m *MockClient) ResetConnections() {}
+
+func (m *MockClient) WatchEndpoints(ctx context.Context) {}
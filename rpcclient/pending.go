@@ -0,0 +1,133 @@
+package rpcclient
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// the transaction with the hash, nil when the node doesn't know it, pending
+// transactions have no block number
+func (c *Client) FetchTransaction(ctx context.Context, hash string) (tx *types.Transaction, err error) {
+	params := map[string]interface{}{
+		"id":      1,
+		"jsonrpc": "2.0",
+		"method":  "eth_getTransactionByHash",
+		"params":  []interface{}{hash},
+	}
+	var result struct {
+		Code    int
+		Jsonrpc string
+		Error   *struct {
+			Code    int
+			Message string
+		}
+		Result *types.Transaction
+	}
+	err = c.postJson(ctx, params, &result)
+	if err == nil {
+		if result.Code != 0 {
+			err = fmt.Errorf("failed rpc request, code %d", result.Code)
+		} else if result.Error != nil {
+			err = fmt.Errorf("failed rpc request, code %d, %s", result.Error.Code, result.Error.Message)
+		} else {
+			tx = result.Result
+		}
+	}
+	return
+}
+
+// the transactions with the hashes in a single batch request, in order
+func (c *Client) FetchTransactions(ctx context.Context, hashes []string) ([]*types.Transaction, error) {
+	if len(hashes) == 1 {
+		// not every endpoint accepts batches, keep single transactions plain
+		tx, err := c.FetchTransaction(ctx, hashes[0])
+		if err != nil {
+			return nil, err
+		}
+		if tx == nil {
+			return nil, fmt.Errorf("transaction %s not found", hashes[0])
+		}
+		return []*types.Transaction{tx}, nil
+	}
+	batch := make([]interface{}, 0, len(hashes))
+	for i, hash := range hashes {
+		batch = append(batch, map[string]interface{}{
+			"id":      i,
+			"jsonrpc": "2.0",
+			"method":  "eth_getTransactionByHash",
+			"params":  []interface{}{hash},
+		})
+	}
+	var results []struct {
+		Id      int
+		Code    int
+		Jsonrpc string
+		Error   *struct {
+			Code    int
+			Message string
+		}
+		Result *types.Transaction
+	}
+	if err := c.postBatch(ctx, batch, &results); err != nil {
+		return nil, err
+	}
+	txs := make([]*types.Transaction, len(hashes))
+	for _, result := range results {
+		if result.Id < 0 || result.Id >= len(txs) {
+			continue
+		}
+		if result.Code != 0 {
+			return nil, fmt.Errorf("failed rpc request, code %d", result.Code)
+		}
+		if result.Error != nil {
+			return nil, fmt.Errorf("failed rpc request, code %d, %s", result.Error.Code, result.Error.Message)
+		}
+		txs[result.Id] = result.Result
+	}
+	for i, tx := range txs {
+		if tx == nil {
+			return nil, fmt.Errorf("transaction %s not found", hashes[i])
+		}
+	}
+	return txs, nil
+}
+
+// the executable transactions in the mempool of the node, read with
+// txpool_content, queued ones waiting on a nonce gap are left out
+func (c *Client) FetchTxPool(ctx context.Context) (txs []*types.Transaction, err error) {
+	params := map[string]interface{}{
+		"id":      1,
+		"jsonrpc": "2.0",
+		"method":  "txpool_content",
+		"params":  []interface{}{},
+	}
+	var result struct {
+		Code    int
+		Jsonrpc string
+		Error   *struct {
+			Code    int
+			Message string
+		}
+		Result struct {
+			// by sender and nonce
+			Pending map[string]map[string]*types.Transaction
+		}
+	}
+	err = c.postJson(ctx, params, &result)
+	if err == nil {
+		if result.Code != 0 {
+			err = fmt.Errorf("failed rpc request, code %d", result.Code)
+		} else if result.Error != nil {
+			err = fmt.Errorf("failed rpc request, code %d, %s", result.Error.Code, result.Error.Message)
+		} else {
+			for _, nonces := range result.Result.Pending {
+				for _, tx := range nonces {
+					txs = append(txs, tx)
+				}
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,66 @@
+package rpcclient
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// The rpc rate limiter, a token bucket shared by all workers: it refills at
+// rate tokens per second up to burst, and calls beyond the tokens left are
+// spaced evenly
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	// tokens as of last, negative while calls are queued for later ones
+	tokens float64
+	last   time.Time
+	sync.Mutex
+}
+
+// a limiter allowing rate calls per second with bursts of up to burst calls,
+// nil when unlimited
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	burst = max(burst, 1)
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// block until the next call is allowed or the context is done
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// block until n calls are allowed, e.g. the requests of a batch, or the
+// context is done, the tokens are handed back when it is
+func (l *rateLimiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	l.Lock()
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.rate, l.burst)
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.Unlock()
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		l.Lock()
+		l.tokens += float64(n)
+		l.Unlock()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,123 @@
+package rpcclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// The block tags a head can be tracked by, the safe and finalized blocks lag
+// the latest one but a reorg can't take them back, the finalized one never
+const (
+	BlockTagLatest    = "latest"
+	BlockTagSafe      = "safe"
+	BlockTagFinalized = "finalized"
+)
+
+// The calls the parser reads the chain with, made over http by Client;
+// another transport, e.g. a websocket or ipc one, or MockClient in tests
+// plugs into the parser through parser.WithRpcClient
+type RpcClient interface {
+	// the block with its transactions, an error when the node doesn't have
+	// it yet
+	FetchBlock(ctx context.Context, block int) (*types.Block, error)
+
+	// the blocks from..to with their transactions, in block order
+	FetchBlocks(ctx context.Context, from, to int) ([]*types.Block, error)
+
+	// the block without its transactions
+	FetchBlockHeader(ctx context.Context, block int) (*types.Block, error)
+
+	// the number of the chain head
+	GetLatestBlockNumber(ctx context.Context) (int, error)
+
+	// the number of the block with the tag, e.g. the finalized one
+	GetTaggedBlockNumber(ctx context.Context, tag string) (int, error)
+
+	// the logs of the blocks matching the topics, a topic being nil for any,
+	// a value or a list of values
+	FetchLogs(ctx context.Context, fromBlock, toBlock int, topics []interface{}) ([]*types.Log, error)
+
+	// the logs of the blocks emitted by the contract and matching the
+	// topics, of any contract when empty
+	FetchContractLogs(ctx context.Context, fromBlock, toBlock int, contract string, topics []interface{}) ([]*types.Log, error)
+
+	// the logs of the blocks emitted by any of the contracts and matching
+	// the topics, of any contract when there are none
+	FetchContractsLogs(ctx context.Context, fromBlock, toBlock int, contracts []string, topics []interface{}) ([]*types.Log, error)
+
+	// the receipt of the mined transaction with the hash
+	FetchReceipt(ctx context.Context, hash string) (*types.Receipt, error)
+
+	// the receipts of the mined transactions, in order
+	FetchMatchedReceipts(ctx context.Context, txs []*types.Transaction) ([]*types.Receipt, error)
+
+	// the transaction with the hash, nil when the node doesn't know it
+	FetchTransaction(ctx context.Context, hash string) (*types.Transaction, error)
+
+	// the transactions with the hashes, in order, an error when one is
+	// unknown
+	FetchTransactions(ctx context.Context, hashes []string) ([]*types.Transaction, error)
+
+	// the executable transactions in the mempool
+	FetchTxPool(ctx context.Context) ([]*types.Transaction, error)
+
+	// the value transfers made by contracts in the block, read with the
+	// trace method
+	FetchInternalTransactions(ctx context.Context, block *types.Block, method string) ([]*types.Transaction, error)
+
+	// the output of eth_call of the data on the contract at the head
+	Call(ctx context.Context, to, data string) (string, error)
+
+	// the balance in wei of the address at the head, a hex quantity
+	GetBalance(ctx context.Context, address string) (string, error)
+
+	// the balance in wei of the address once the block was mined, a hex
+	// quantity; needs an archive node for old blocks
+	GetBalanceAt(ctx context.Context, address string, block int) (string, error)
+
+	// the nonce of the address at the head, a hex quantity
+	GetTransactionCount(ctx context.Context, address string) (string, error)
+
+	// call head with every new chain head pushed on the websocket endpoint,
+	// blocks until the subscription fails or the context is cancelled
+	SubscribeNewHeads(ctx context.Context, url string, head func(block int)) error
+
+	// call pending with every transaction entering the mempool, like
+	// SubscribeNewHeads
+	SubscribePendingTransactions(ctx context.Context, url string, pending func(tx *types.Transaction)) error
+
+	Endpoints
+}
+
+// The endpoints behind an RpcClient, failed over between and rate limited
+type Endpoints interface {
+	// more endpoints to fail over to
+	AddEndpoints(urls ...string)
+
+	// replace the endpoints while calls go on
+	SetEndpoints(urls ...string)
+
+	// the headers sent to the endpoints by their host, e.g. bearer tokens
+	SetAuth(auth map[string]*EndpointAuth)
+
+	// limit the calls to rate per second with bursts of up to burst calls
+	SetRateLimit(rate float64, burst int)
+
+	// how long a call may take before its endpoint is considered down
+	SetTimeout(timeout time.Duration)
+
+	// how many endpoints are not failing their calls, out of all of them
+	EndpointsUp() (up, total int)
+
+	// leave out the endpoint that answered the last call as if it failed
+	// with the error, false when there is no other one
+	RotateEndpoint(err error) bool
+
+	// drop the pooled connections and continue with new ones
+	ResetConnections()
+
+	// check the failing endpoints until the context is done
+	WatchEndpoints(ctx context.Context)
+}
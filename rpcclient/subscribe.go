@@ -0,0 +1,116 @@
+package rpcclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"github.com/passwizards/eth-parser/types"
+)
+
+// subscribe to newHeads on the websocket endpoint, e.g. wss://host/ws, and
+// call head with the number of every new chain head, blocks until the
+// subscription fails or the context is cancelled
+func (c *Client) SubscribeNewHeads(ctx context.Context, url string, head func(block int)) error {
+	return subscribe(ctx, url, c.authHeader(url), []interface{}{"newHeads"}, func(result json.RawMessage) error {
+		var header struct {
+			Number string
+		}
+		if err := json.Unmarshal(result, &header); err != nil {
+			return fmt.Errorf("failed to decode new head, err %v", err)
+		}
+		head(types.BlockNumber(header.Number))
+		return nil
+	})
+}
+
+// subscribe to newPendingTransactions on the websocket endpoint and call
+// pending with every transaction entering its mempool, blocks until the
+// subscription fails or the context is cancelled; nodes pushing only hashes
+// get each transaction fetched, which is slow on busy chains
+func (c *Client) SubscribePendingTransactions(ctx context.Context, url string, pending func(tx *types.Transaction)) error {
+	// ask for full transactions, nodes not supporting it push hashes
+	return subscribe(ctx, url, c.authHeader(url), []interface{}{"newPendingTransactions", true}, func(result json.RawMessage) error {
+		var hash string
+		if json.Unmarshal(result, &hash) == nil {
+			tx, err := c.FetchTransaction(ctx, hash)
+			if err != nil {
+				return err
+			}
+			// mined or dropped meanwhile
+			if tx != nil && tx.BlockNumber == "" {
+				pending(tx)
+			}
+			return nil
+		}
+		var tx *types.Transaction
+		if err := json.Unmarshal(result, &tx); err != nil {
+			return fmt.Errorf("failed to decode pending transaction, err %v", err)
+		}
+		pending(tx)
+		return nil
+	})
+}
+
+// send eth_subscribe with the params and call notify with the result of every
+// notification of the subscription, blocks until the subscription or notify
+// fails or the context is cancelled
+func subscribe(ctx context.Context, url string, header http.Header, params []interface{}, notify func(result json.RawMessage) error) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, url, header)
+	if err != nil {
+		return redactedError(err)
+	}
+	defer conn.Close()
+	// unblock the read below on cancel
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	err = conn.WriteJSON(map[string]interface{}{
+		"id":      1,
+		"jsonrpc": "2.0",
+		"method":  "eth_subscribe",
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+	var subscription string
+	for {
+		var message struct {
+			Id     int
+			Result json.RawMessage
+			Error  *struct {
+				Code    int
+				Message string
+			}
+			Method string
+			Params struct {
+				Subscription string
+				Result       json.RawMessage
+			}
+		}
+		if err := conn.ReadJSON(&message); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		switch {
+		case message.Error != nil:
+			return fmt.Errorf("failed rpc request, code %d, %s", message.Error.Code, message.Error.Message)
+		case message.Id == 1:
+			if err := json.Unmarshal(message.Result, &subscription); err != nil {
+				return fmt.Errorf("failed to subscribe to %v, err %v", params[0], err)
+			}
+		case message.Method == "eth_subscription" && message.Params.Subscription == subscription:
+			if err := notify(message.Params.Result); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package rpcclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// The trace apis internal transactions can be read from, geth exposes the
+// debug one, erigon, nethermind and reth the parity style one
+const (
+	TraceMethodDebug  = "debug_traceBlockByNumber"
+	TraceMethodParity = "trace_block"
+)
+
+// a call frame of the geth callTracer
+type callFrame struct {
+	Type  string
+	From  string
+	To    string
+	Value string
+	Error string
+	Calls []*callFrame
+}
+
+// a trace of trace_block, one per call with its position in the call tree
+type parityTrace struct {
+	Action struct {
+		CallType      string
+		From          string
+		To            string
+		Value         string
+		Address       string
+		RefundAddress string
+		Balance       string
+	}
+	Result *struct {
+		Address string
+	}
+	TransactionHash     string
+	TransactionPosition int
+	TraceAddress        []int
+	Type                string
+	Error               string
+}
+
+// the value transfers made by contracts in the block, as transactions of
+// TransactionKindInternal sharing the hash of the transaction they belong to
+func (c *Client) FetchInternalTransactions(ctx context.Context, block *types.Block, method string) ([]*types.Transaction, error) {
+	var params []interface{}
+	switch method {
+	case TraceMethodDebug:
+		params = []interface{}{block.Number, map[string]interface{}{"tracer": "callTracer"}}
+	case TraceMethodParity:
+		params = []interface{}{block.Number}
+	default:
+		return nil, fmt.Errorf("unknown trace method %q", method)
+	}
+	payload := map[string]interface{}{
+		"id":      1,
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+	}
+	var result struct {
+		Code    int
+		Jsonrpc string
+		Error   *struct {
+			Code    int
+			Message string
+		}
+		// the shape depends on the method
+		Result json.RawMessage
+	}
+	if err := c.postJson(ctx, payload, &result); err != nil {
+		return nil, err
+	}
+	if result.Code != 0 {
+		return nil, fmt.Errorf("failed rpc request, code %d", result.Code)
+	}
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed rpc request, code %d, %s", result.Error.Code, result.Error.Message)
+	}
+
+	var internal []*types.Transaction
+	add := func(parent *types.Transaction, from, to, value string, traceAddress []int) {
+		if isZeroQuantity(value) {
+			return
+		}
+		internal = append(internal, &types.Transaction{
+			BlockHash:        block.Hash,
+			BlockNumber:      block.Number,
+			BlockTimestamp:   block.Timestamp,
+			Hash:             parent.Hash,
+			TransactionIndex: parent.TransactionIndex,
+			From:             from,
+			To:               to,
+			Value:            value,
+			Kind:             types.TransactionKindInternal,
+			TraceAddress:     traceAddress,
+		})
+	}
+	if method == TraceMethodDebug {
+		var traces []struct {
+			Result *callFrame
+		}
+		if err := json.Unmarshal(result.Result, &traces); err != nil {
+			return nil, err
+		}
+		// one trace per transaction, in block order
+		for i, trace := range traces {
+			if trace.Result == nil || i >= len(block.Transactions) {
+				continue
+			}
+			walkCalls(trace.Result.Calls, nil, func(frame *callFrame, traceAddress []int) {
+				add(block.Transactions[i], frame.From, frame.To, frame.Value, traceAddress)
+			})
+		}
+		return internal, nil
+	}
+
+	var traces []*parityTrace
+	if err := json.Unmarshal(result.Result, &traces); err != nil {
+		return nil, err
+	}
+	// the calls below a failed one are reverted with it
+	var reverted [][]int
+	for _, t := range traces {
+		if t.TransactionHash == "" || t.TransactionPosition >= len(block.Transactions) {
+			// block and uncle rewards
+			continue
+		}
+		if t.Error != "" {
+			reverted = append(reverted, t.TraceAddress)
+		}
+		if len(t.TraceAddress) == 0 || t.Error != "" || hasPrefix(t.TraceAddress, reverted) {
+			// the top level call is the transaction itself
+			continue
+		}
+		parent := block.Transactions[t.TransactionPosition]
+		switch t.Type {
+		case "call":
+			if t.Action.CallType == "call" {
+				add(parent, t.Action.From, t.Action.To, t.Action.Value, t.TraceAddress)
+			}
+		case "create":
+			if t.Result != nil {
+				add(parent, t.Action.From, t.Result.Address, t.Action.Value, t.TraceAddress)
+			}
+		case "suicide":
+			add(parent, t.Action.Address, t.Action.RefundAddress, t.Action.Balance, t.TraceAddress)
+		}
+	}
+	return internal, nil
+}
+
+// visit the frames moving value, skipping failed calls and the calls below them
+func walkCalls(calls []*callFrame, parent []int, visit func(frame *callFrame, traceAddress []int)) {
+	for i, frame := range calls {
+		if frame.Error != "" {
+			continue
+		}
+		traceAddress := append(append([]int{}, parent...), i)
+		switch frame.Type {
+		case "CALL", "CREATE", "CREATE2", "SELFDESTRUCT":
+			visit(frame, traceAddress)
+		}
+		walkCalls(frame.Calls, traceAddress, visit)
+	}
+}
+
+func hasPrefix(traceAddress []int, prefixes [][]int) bool {
+	for _, prefix := range prefixes {
+		if len(prefix) > len(traceAddress) {
+			continue
+		}
+		matched := true
+		for i := range prefix {
+			if prefix[i] != traceAddress[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func isZeroQuantity(value string) bool {
+	return strings.TrimLeft(strings.TrimPrefix(value, "0x"), "0") == ""
+}
@@ -0,0 +1,53 @@
+package rpcclient
+
+import (
+	"context"
+	"net/url"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// spans of the rpc calls, dropped unless a tracer provider is installed
+var tracer = otel.Tracer("github.com/passwizards/eth-parser/rpcclient")
+
+// start a span of the client, the returned func ends it with the error of
+// the call
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, func(err error)) {
+	ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// the method of the request, or of the first request of a batch
+func rpcMethod(payload interface{}) string {
+	if batch, ok := payload.([]interface{}); ok && len(batch) > 0 {
+		payload = batch[0]
+	}
+	if request, ok := payload.(map[string]interface{}); ok {
+		if method, ok := request["method"].(string); ok {
+			return method
+		}
+	}
+	return "unknown"
+}
+
+// the host of the endpoint, its path and query often hold an api key; the
+// socket path of an ipc one
+func endpointHost(endpoint string) string {
+	if path, ok := ipcPath(endpoint); ok {
+		return path
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
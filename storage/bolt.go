@@ -0,0 +1,1198 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	metaBucket         = []byte("meta")
+	addressesBucket    = []byte("addresses")
+	transactionsBucket = []byte("transactions")
+	tokensBucket       = []byte("tokenTransfers")
+	nftsBucket         = []byte("nftTransfers")
+	blockHashesBucket  = []byte("blockHashes")
+	blocksBucket       = []byte("blocks")
+	currentBlockKey    = []byte("currentBlock")
+
+	// the labels of the tenants by address/tenant, the addresses bucket holds
+	// the union of their addresses
+	subscriptionsBucket = []byte("subscriptions")
+
+	// the groups of the tenants by tenant/group, their addresses nested as
+	// keys
+	groupsBucket = []byte("groups")
+
+	// watched contract events by contract/topic, their logs nested by contract
+	eventSubscriptionsBucket = []byte("eventSubscriptions")
+	eventsBucket             = []byte("events")
+
+	// how many transactions of an address have a search key, by key/address
+	searchBucket = []byte("transactionSearch")
+)
+
+// The bolt storage, persists subscribed addresses, the current block and
+// per-address transactions in a single bbolt file
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		// files written before tenants had the labels in the addresses bucket,
+		// or before transactions were indexed for searches
+		migrate, index := tx.Bucket(subscriptionsBucket) == nil, tx.Bucket(searchBucket) == nil
+		for _, name := range [][]byte{metaBucket, addressesBucket, subscriptionsBucket, transactionsBucket, tokensBucket, nftsBucket, eventSubscriptionsBucket, eventsBucket, blockHashesBucket, blocksBucket, groupsBucket, searchBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		if index {
+			if err := indexStoredTransactions(tx); err != nil {
+				return err
+			}
+		}
+		if !migrate {
+			return nil
+		}
+		subscriptions := tx.Bucket(subscriptionsBucket)
+		return tx.Bucket(addressesBucket).ForEach(func(k, v []byte) error {
+			return subscriptions.Put(subscriptionKey("", string(k)), v)
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStorage{db: db}, nil
+}
+
+func (bs *BoltStorage) Close() error {
+	return bs.db.Close()
+}
+
+func (bs *BoltStorage) GetCurrentBlock(ctx context.Context) (int, error) {
+	var block int
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(metaBucket).Get(currentBlockKey); v != nil {
+			block = int(binary.BigEndian.Uint64(v))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read current block, err %v", err)
+	}
+	return block, nil
+}
+
+func (bs *BoltStorage) SetCurrentBlock(ctx context.Context, block int) error {
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put(currentBlockKey, itob(uint64(block)))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set current block %d, err %v", block, err)
+	}
+	return nil
+}
+
+// the key of the subscription of the tenant to the address, the ones of an
+// address are next to each other
+func subscriptionKey(tenant, address string) []byte {
+	return []byte(address + "/" + tenant)
+}
+
+func (bs *BoltStorage) AddTargetAddress(ctx context.Context, tenant, address string) (bool, error) {
+	address = strings.ToLower(address)
+	added := false
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		subscriptions := tx.Bucket(subscriptionsBucket)
+		if subscriptions.Get(subscriptionKey(tenant, address)) != nil {
+			return nil
+		}
+		if err := subscriptions.Put(subscriptionKey(tenant, address), []byte{}); err != nil {
+			return err
+		}
+		added = true
+		addresses := tx.Bucket(addressesBucket)
+		if addresses.Get([]byte(address)) != nil {
+			return nil
+		}
+		if err := addresses.Put([]byte(address), []byte{}); err != nil {
+			return err
+		}
+		_, err := tx.Bucket(transactionsBucket).CreateBucketIfNotExists([]byte(address))
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to add target address %s, err %v", address, err)
+	}
+	return added, nil
+}
+
+func (bs *BoltStorage) RemoveTargetAddress(ctx context.Context, tenant, address string) (bool, error) {
+	address = strings.ToLower(address)
+	removed := false
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		subscriptions := tx.Bucket(subscriptionsBucket)
+		if subscriptions.Get(subscriptionKey(tenant, address)) == nil {
+			return nil
+		}
+		if err := subscriptions.Delete(subscriptionKey(tenant, address)); err != nil {
+			return err
+		}
+		removed = true
+		prefix := []byte(address + "/")
+		if k, _ := subscriptions.Cursor().Seek(prefix); bytes.HasPrefix(k, prefix) {
+			// another tenant is still subscribed
+			return nil
+		}
+		if err := tx.Bucket(addressesBucket).Delete([]byte(address)); err != nil {
+			return err
+		}
+		if bucket := tx.Bucket(transactionsBucket).Bucket([]byte(address)); bucket != nil {
+			unindex := unindexDropped(tx, transactionsBucket, []byte(address))
+			if err := bucket.ForEach(func(_, v []byte) error { return unindex(v) }); err != nil {
+				return err
+			}
+		}
+		for _, name := range [][]byte{transactionsBucket, tokensBucket, nftsBucket} {
+			if err := tx.Bucket(name).DeleteBucket([]byte(address)); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to remove target address %s, err %v", address, err)
+	}
+	return removed, nil
+}
+
+// the label is the json value of the subscription, empty without one
+func (bs *BoltStorage) SetAddressLabel(ctx context.Context, tenant, address string, label *types.AddressLabel) (bool, error) {
+	address = strings.ToLower(address)
+	data, err := json.Marshal(label)
+	if err != nil {
+		return false, fmt.Errorf("failed to set address label of %s, err %v", address, err)
+	}
+	set := false
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		subscriptions := tx.Bucket(subscriptionsBucket)
+		if subscriptions.Get(subscriptionKey(tenant, address)) == nil {
+			return nil
+		}
+		set = true
+		return subscriptions.Put(subscriptionKey(tenant, address), data)
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to set address label of %s, err %v", address, err)
+	}
+	return set, nil
+}
+
+func (bs *BoltStorage) GetAddressLabel(ctx context.Context, tenant, address string) (*types.AddressLabel, error) {
+	address = strings.ToLower(address)
+	var label *types.AddressLabel
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(subscriptionsBucket).Get(subscriptionKey(tenant, address)); len(v) > 0 {
+			return json.Unmarshal(v, &label)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read address label of %s, err %v", address, err)
+	}
+	return label, nil
+}
+
+func (bs *BoltStorage) HasTargetAddress(ctx context.Context, address string) (bool, error) {
+	found := false
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(addressesBucket).Get([]byte(strings.ToLower(address))) != nil
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to read target address %s, err %v", address, err)
+	}
+	return found, nil
+}
+
+func (bs *BoltStorage) IsSubscribed(ctx context.Context, tenant, address string) (bool, error) {
+	found := false
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(subscriptionsBucket).Get(subscriptionKey(tenant, strings.ToLower(address))) != nil
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to read subscription of %s, err %v", address, err)
+	}
+	return found, nil
+}
+
+func (bs *BoltStorage) GetSubscriptions(ctx context.Context, tenant string) ([]*types.Subscription, error) {
+	subscriptions := []*types.Subscription{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		txs := tx.Bucket(transactionsBucket)
+		return tx.Bucket(subscriptionsBucket).ForEach(func(k, v []byte) error {
+			address, subscriber, _ := strings.Cut(string(k), "/")
+			if subscriber != tenant {
+				return nil
+			}
+			subscription := &types.Subscription{Address: address}
+			subscriptions = append(subscriptions, subscription)
+			if len(v) > 0 {
+				var label types.AddressLabel
+				if err := json.Unmarshal(v, &label); err != nil {
+					return err
+				}
+				subscription.Label, subscription.Metadata, subscription.Rule, subscription.Expires = label.Label, label.Metadata, label.Rule, label.Expires
+			}
+			bucket := txs.Bucket([]byte(address))
+			if bucket == nil {
+				return nil
+			}
+			subscription.TransactionCount = bucket.Stats().KeyN
+			c := bucket.Cursor()
+			var err error
+			if _, v := c.First(); v != nil {
+				if subscription.FirstBlock, err = entryBlock(v); err != nil {
+					return err
+				}
+			}
+			if _, v := c.Last(); v != nil {
+				subscription.LastBlock, err = entryBlock(v)
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subscriptions, err %v", err)
+	}
+	return subscriptions, nil
+}
+
+func (bs *BoltStorage) GetTenants(ctx context.Context) ([]string, error) {
+	var tenants []string
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(subscriptionsBucket).ForEach(func(k, _ []byte) error {
+			if _, tenant, _ := strings.Cut(string(k), "/"); !slices.Contains(tenants, tenant) {
+				tenants = append(tenants, tenant)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenants, err %v", err)
+	}
+	slices.Sort(tenants)
+	return tenants, nil
+}
+
+// the key of the group of the tenant, the groups of a tenant are next to
+// each other
+func groupKey(tenant, group string) []byte {
+	return []byte(tenant + "/" + group)
+}
+
+func (bs *BoltStorage) CreateGroup(ctx context.Context, tenant, group string) (bool, error) {
+	created := false
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		groups := tx.Bucket(groupsBucket)
+		if groups.Bucket(groupKey(tenant, group)) != nil {
+			return nil
+		}
+		created = true
+		_, err := groups.CreateBucket(groupKey(tenant, group))
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to create group %s, err %v", group, err)
+	}
+	return created, nil
+}
+
+func (bs *BoltStorage) DeleteGroup(ctx context.Context, tenant, group string) (bool, error) {
+	deleted := false
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(groupsBucket).DeleteBucket(groupKey(tenant, group))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		deleted = err == nil
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete group %s, err %v", group, err)
+	}
+	return deleted, nil
+}
+
+func (bs *BoltStorage) AddGroupAddress(ctx context.Context, tenant, group, address string) (bool, error) {
+	address = strings.ToLower(address)
+	added := false
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		addresses := tx.Bucket(groupsBucket).Bucket(groupKey(tenant, group))
+		if addresses == nil || addresses.Get([]byte(address)) != nil {
+			return nil
+		}
+		added = true
+		return addresses.Put([]byte(address), []byte{})
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to add %s to group %s, err %v", address, group, err)
+	}
+	return added, nil
+}
+
+func (bs *BoltStorage) RemoveGroupAddress(ctx context.Context, tenant, group, address string) (bool, error) {
+	address = strings.ToLower(address)
+	removed := false
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		addresses := tx.Bucket(groupsBucket).Bucket(groupKey(tenant, group))
+		if addresses == nil || addresses.Get([]byte(address)) == nil {
+			return nil
+		}
+		removed = true
+		return addresses.Delete([]byte(address))
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to remove %s from group %s, err %v", address, group, err)
+	}
+	return removed, nil
+}
+
+func (bs *BoltStorage) GetGroup(ctx context.Context, tenant, group string) (*types.Group, error) {
+	var g *types.Group
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		if addresses := tx.Bucket(groupsBucket).Bucket(groupKey(tenant, group)); addresses != nil {
+			g = boltGroup(group, addresses)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read group %s, err %v", group, err)
+	}
+	return g, nil
+}
+
+func (bs *BoltStorage) GetGroups(ctx context.Context, tenant string) ([]*types.Group, error) {
+	groups := []*types.Group{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(groupsBucket)
+		prefix := groupKey(tenant, "")
+		c := bucket.Cursor()
+		for k, _ := c.Seek(prefix); bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			groups = append(groups, boltGroup(string(k[len(prefix):]), bucket.Bucket(k)))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read groups, err %v", err)
+	}
+	return groups, nil
+}
+
+// the group with the addresses of its bucket, in key order
+func boltGroup(group string, addresses *bolt.Bucket) *types.Group {
+	g := &types.Group{Name: group, Addresses: []string{}}
+	addresses.ForEach(func(k, _ []byte) error {
+		g.Addresses = append(g.Addresses, string(k))
+		return nil
+	})
+	return g
+}
+
+func (bs *BoltStorage) SaveTransactions(ctx context.Context, block int, txs []*types.Transaction) (matches []*types.MatchedTransaction, err error) {
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		matches = nil
+		addresses := tx.Bucket(addressesBucket)
+		stored := newStoredEntries(transactionEntry, func(address string) ([]*types.Transaction, error) {
+			return entriesSince(tx, transactionsBucket, address, block, transactionEntry)
+		})
+		for _, t := range txs {
+			from, to := strings.ToLower(t.From), strings.ToLower(t.To)
+			saveFrom, err := stored.isNew(addresses.Get([]byte(from)) != nil, from, t)
+			if err != nil {
+				return err
+			}
+			saveTo, err := stored.isNew(addresses.Get([]byte(to)) != nil, to, t)
+			if err != nil {
+				return err
+			}
+			if saveFrom {
+				slog.Info("New outgoing transaction", "address", from, "block", block, "hash", t.Hash)
+				if err := appendJson(tx, transactionsBucket, from, t); err != nil {
+					return err
+				}
+				if err := indexTransactions(tx, from, []*types.Transaction{t}, 1); err != nil {
+					return err
+				}
+				matches = append(matches, &types.MatchedTransaction{Address: from, Direction: types.DirectionOut, Block: block, Transaction: t})
+			}
+			if saveTo {
+				slog.Info("New incoming transaction", "address", to, "block", block, "hash", t.Hash)
+				if err := appendJson(tx, transactionsBucket, to, t); err != nil {
+					return err
+				}
+				if err := indexTransactions(tx, to, []*types.Transaction{t}, 1); err != nil {
+					return err
+				}
+				matches = append(matches, &types.MatchedTransaction{Address: to, Direction: types.DirectionIn, Block: block, Transaction: t})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save transactions, block %d, err %v", block, err)
+	}
+	return
+}
+
+// rewrites the bucket of the address, its entries keep the block order
+func (bs *BoltStorage) BackfillTransactions(ctx context.Context, address string, matches []*types.MatchedTransaction) (added int, err error) {
+	address = strings.ToLower(address)
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		added = 0
+		if tx.Bucket(addressesBucket).Get([]byte(address)) == nil {
+			return nil
+		}
+		buckets := tx.Bucket(transactionsBucket)
+		var stored []*types.Transaction
+		if bucket := buckets.Bucket([]byte(address)); bucket != nil {
+			err := bucket.ForEach(func(_, v []byte) error {
+				var t types.Transaction
+				if err := json.Unmarshal(v, &t); err != nil {
+					return err
+				}
+				stored = append(stored, &t)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		fresh := newMatches(stored, matches)
+		if len(fresh) == 0 {
+			return nil
+		}
+		added = len(fresh)
+		if stored != nil {
+			if err := buckets.DeleteBucket([]byte(address)); err != nil {
+				return err
+			}
+		}
+		for _, t := range mergeTransactions(stored, fresh) {
+			if err := appendJson(tx, transactionsBucket, address, t); err != nil {
+				return err
+			}
+		}
+		for _, m := range fresh {
+			if err := indexTransactions(tx, address, []*types.Transaction{m.Transaction}, 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to backfill transactions, address %s, err %v", address, err)
+	}
+	return
+}
+
+func (bs *BoltStorage) GetTransactions(ctx context.Context, address string) ([]*types.Transaction, error) {
+	address = strings.ToLower(address)
+	var txs []*types.Transaction
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(transactionsBucket).Bucket([]byte(address))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, v []byte) error {
+			// a long history stops decoding once the reader is gone
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			var t types.Transaction
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			txs = append(txs, &t)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transactions of %s, err %v", address, err)
+	}
+	return txs, nil
+}
+
+func (bs *BoltStorage) QueryTransactions(ctx context.Context, address string, filter types.TransactionFilter) ([]*types.Transaction, error) {
+	txs, err := bs.GetTransactions(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	return types.FilterTransactions(address, txs, filter), nil
+}
+
+func (bs *BoltStorage) GetAddressStats(ctx context.Context, address string) (*types.AddressStats, error) {
+	txs, err := bs.GetTransactions(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	return addressStats(address, txs), nil
+}
+
+// scans the transactions of every address, meant for occasional lookups
+func (bs *BoltStorage) GetTransaction(ctx context.Context, hash string) (*types.Transaction, error) {
+	var found *types.Transaction
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		txs := tx.Bucket(transactionsBucket)
+		return txs.ForEachBucket(func(address []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return txs.Bucket(address).ForEach(func(_, v []byte) error {
+				// skip decoding the transactions of other hashes
+				if !bytes.Contains(v, []byte(hash)) {
+					return nil
+				}
+				var t types.Transaction
+				if err := json.Unmarshal(v, &t); err != nil {
+					return err
+				}
+				if t.Hash == hash && (found == nil || t.Kind == "") {
+					found = &t
+				}
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transaction %s, err %v", hash, err)
+	}
+	return found, nil
+}
+
+func (bs *BoltStorage) SearchTransactions(ctx context.Context, search *types.TransactionSearch) ([]*types.Transaction, error) {
+	keys := searchQueryKeys(search)
+	if len(keys) == 0 {
+		return nil, ErrUnindexedSearch
+	}
+	var addresses []string
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(searchBucket).Cursor()
+		sets := make([]map[string]bool, len(keys))
+		for i, key := range keys {
+			sets[i] = make(map[string]bool)
+			prefix := []byte(key + "/")
+			for k, _ := c.Seek(prefix); bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				sets[i][string(k[len(prefix):])] = true
+			}
+		}
+		addresses = intersectAddresses(sets)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search transactions, err %v", err)
+	}
+	var found []*types.Transaction
+	for _, address := range addresses {
+		txs, err := bs.GetTransactions(ctx, address)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, txs...)
+	}
+	return searchTransactions(found, search), nil
+}
+
+func (bs *BoltStorage) SaveTokenTransfers(ctx context.Context, transfers []*types.TokenTransfer) error {
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		addresses := tx.Bucket(addressesBucket)
+		since := firstBlock(transfers, tokenTransferEntry)
+		stored := newStoredEntries(tokenTransferEntry, func(address string) ([]*types.TokenTransfer, error) {
+			return entriesSince(tx, tokensBucket, address, since, tokenTransferEntry)
+		})
+		for _, t := range transfers {
+			from, to := strings.ToLower(t.From), strings.ToLower(t.To)
+			saveFrom, err := stored.isNew(addresses.Get([]byte(from)) != nil, from, t)
+			if err != nil {
+				return err
+			}
+			saveTo, err := stored.isNew(addresses.Get([]byte(to)) != nil, to, t)
+			if err != nil {
+				return err
+			}
+			if saveFrom {
+				slog.Info("New outgoing token transfer", "address", from, "block", types.BlockNumber(t.BlockNumber), "hash", t.TransactionHash, "token", t.Token)
+				if err := appendJson(tx, tokensBucket, from, t); err != nil {
+					return err
+				}
+			}
+			if saveTo {
+				slog.Info("New incoming token transfer", "address", to, "block", types.BlockNumber(t.BlockNumber), "hash", t.TransactionHash, "token", t.Token)
+				if err := appendJson(tx, tokensBucket, to, t); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save token transfers, err %v", err)
+	}
+	return nil
+}
+
+func (bs *BoltStorage) GetTokenTransfers(ctx context.Context, address string) ([]*types.TokenTransfer, error) {
+	address = strings.ToLower(address)
+	var transfers []*types.TokenTransfer
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(tokensBucket).Bucket([]byte(address))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, v []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			var t types.TokenTransfer
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			transfers = append(transfers, &t)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token transfers of %s, err %v", address, err)
+	}
+	return transfers, nil
+}
+
+func (bs *BoltStorage) SaveNftTransfers(ctx context.Context, transfers []*types.NftTransfer) error {
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		addresses := tx.Bucket(addressesBucket)
+		since := firstBlock(transfers, nftTransferEntry)
+		stored := newStoredEntries(nftTransferEntry, func(address string) ([]*types.NftTransfer, error) {
+			return entriesSince(tx, nftsBucket, address, since, nftTransferEntry)
+		})
+		for _, t := range transfers {
+			from, to := strings.ToLower(t.From), strings.ToLower(t.To)
+			saveFrom, err := stored.isNew(addresses.Get([]byte(from)) != nil, from, t)
+			if err != nil {
+				return err
+			}
+			saveTo, err := stored.isNew(addresses.Get([]byte(to)) != nil, to, t)
+			if err != nil {
+				return err
+			}
+			if saveFrom {
+				slog.Info("New outgoing NFT transfer", "address", from, "block", types.BlockNumber(t.BlockNumber), "hash", t.TransactionHash, "contract", t.Contract, "tokenId", t.TokenId)
+				if err := appendJson(tx, nftsBucket, from, t); err != nil {
+					return err
+				}
+			}
+			if saveTo {
+				slog.Info("New incoming NFT transfer", "address", to, "block", types.BlockNumber(t.BlockNumber), "hash", t.TransactionHash, "contract", t.Contract, "tokenId", t.TokenId)
+				if err := appendJson(tx, nftsBucket, to, t); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save NFT transfers, err %v", err)
+	}
+	return nil
+}
+
+func (bs *BoltStorage) GetNftTransfers(ctx context.Context, address string) ([]*types.NftTransfer, error) {
+	address = strings.ToLower(address)
+	var transfers []*types.NftTransfer
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(nftsBucket).Bucket([]byte(address))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, v []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			var t types.NftTransfer
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			transfers = append(transfers, &t)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read NFT transfers of %s, err %v", address, err)
+	}
+	return transfers, nil
+}
+
+func (bs *BoltStorage) AddEventSubscription(ctx context.Context, contract, topic string) (bool, error) {
+	key := []byte(eventSubscriptionKey(contract, topic))
+	added := false
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		subscriptions := tx.Bucket(eventSubscriptionsBucket)
+		if subscriptions.Get(key) != nil {
+			return nil
+		}
+		added = true
+		return subscriptions.Put(key, []byte{})
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to add event subscription %s, err %v", key, err)
+	}
+	return added, nil
+}
+
+func (bs *BoltStorage) RemoveEventSubscription(ctx context.Context, contract, topic string) (bool, error) {
+	contract, topic = strings.ToLower(contract), strings.ToLower(topic)
+	key := []byte(eventSubscriptionKey(contract, topic))
+	removed := false
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		subscriptions := tx.Bucket(eventSubscriptionsBucket)
+		if subscriptions.Get(key) == nil {
+			return nil
+		}
+		if err := subscriptions.Delete(key); err != nil {
+			return err
+		}
+		removed = true
+		bucket := tx.Bucket(eventsBucket).Bucket([]byte(contract))
+		if bucket == nil {
+			return nil
+		}
+		var stale [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			var e types.Event
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if len(e.Topics) > 0 && strings.ToLower(e.Topics[0]) == topic {
+				stale = append(stale, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range stale {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to remove event subscription %s, err %v", key, err)
+	}
+	return removed, nil
+}
+
+func (bs *BoltStorage) GetEventSubscriptions(ctx context.Context) ([]*types.EventSubscription, error) {
+	subscriptions := []*types.EventSubscription{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		// keys come out sorted
+		return tx.Bucket(eventSubscriptionsBucket).ForEach(func(k, _ []byte) error {
+			subscriptions = append(subscriptions, parseEventSubscriptionKey(string(k)))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event subscriptions, err %v", err)
+	}
+	return subscriptions, nil
+}
+
+func (bs *BoltStorage) SaveEvents(ctx context.Context, events []*types.Event) error {
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		subscriptions := tx.Bucket(eventSubscriptionsBucket)
+		since := firstBlock(events, eventEntry)
+		stored := newStoredEntries(eventEntry, func(contract string) ([]*types.Event, error) {
+			return entriesSince(tx, eventsBucket, contract, since, eventEntry)
+		})
+		for _, e := range events {
+			contract := strings.ToLower(e.Address)
+			watched := len(e.Topics) > 0 && subscriptions.Get([]byte(eventSubscriptionKey(contract, e.Topics[0]))) != nil
+			save, err := stored.isNew(watched, contract, e)
+			if err != nil {
+				return err
+			}
+			if !save {
+				continue
+			}
+			slog.Info("New contract event", "contract", contract, "block", types.BlockNumber(e.BlockNumber), "hash", e.TransactionHash, "topic", e.Topics[0])
+			if err := appendJson(tx, eventsBucket, contract, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save events, err %v", err)
+	}
+	return nil
+}
+
+func (bs *BoltStorage) GetEvents(ctx context.Context, contract string) ([]*types.Event, error) {
+	contract = strings.ToLower(contract)
+	var events []*types.Event
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(eventsBucket).Bucket([]byte(contract))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, v []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			var e types.Event
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			events = append(events, &e)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read events of %s, err %v", contract, err)
+	}
+	return events, nil
+}
+
+func (bs *BoltStorage) SaveBlockHash(ctx context.Context, block int, hash string) error {
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(blockHashesBucket).Put(itob(uint64(block)), []byte(hash))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save block hash, block %d, err %v", block, err)
+	}
+	return nil
+}
+
+func (bs *BoltStorage) GetBlockHash(ctx context.Context, block int) (string, error) {
+	var hash string
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		hash = string(tx.Bucket(blockHashesBucket).Get(itob(uint64(block))))
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read block hash, block %d, err %v", block, err)
+	}
+	return hash, nil
+}
+
+func (bs *BoltStorage) PruneBlockHashes(ctx context.Context, before int) error {
+	if before <= 0 {
+		return nil
+	}
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		return deleteBlocksBefore(tx.Bucket(blockHashesBucket), before)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to prune block hashes, err %v", err)
+	}
+	return nil
+}
+
+// the metadata is the json value of the block in the blocks bucket
+func (bs *BoltStorage) SaveBlock(ctx context.Context, block *types.BlockMetadata) error {
+	number := types.BlockNumber(block.Number)
+	data, err := json.Marshal(block)
+	if err == nil {
+		err = bs.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(blocksBucket).Put(itob(uint64(number)), data)
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save block, block %d, err %v", number, err)
+	}
+	return nil
+}
+
+func (bs *BoltStorage) GetBlock(ctx context.Context, block int) (*types.BlockMetadata, error) {
+	var metadata *types.BlockMetadata
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(blocksBucket).Get(itob(uint64(block))); v != nil {
+			return json.Unmarshal(v, &metadata)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read block %d, err %v", block, err)
+	}
+	return metadata, nil
+}
+
+func (bs *BoltStorage) GetBlocks(ctx context.Context, from, to int) ([]*types.BlockMetadata, error) {
+	var blocks []*types.BlockMetadata
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(blocksBucket).Cursor()
+		for k, v := c.Seek(itob(uint64(max(from, 0)))); k != nil && int(binary.BigEndian.Uint64(k)) <= to; k, v = c.Next() {
+			var block types.BlockMetadata
+			if err := json.Unmarshal(v, &block); err != nil {
+				return err
+			}
+			blocks = append(blocks, &block)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blocks %d to %d, err %v", from, to, err)
+	}
+	return blocks, nil
+}
+
+// delete the entries of a bucket keyed by block before the block
+func deleteBlocksBefore(bucket *bolt.Bucket, before int) error {
+	var stale [][]byte
+	c := bucket.Cursor()
+	// keys are big endian so the cursor walks blocks in order
+	for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) < uint64(before); k, _ = c.Next() {
+		stale = append(stale, k)
+	}
+	for _, k := range stale {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bs *BoltStorage) Rollback(ctx context.Context, block int) error {
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{transactionsBucket, tokensBucket, nftsBucket, eventsBucket} {
+			err := tx.Bucket(name).ForEachBucket(func(address []byte) error {
+				return truncateAfter(tx.Bucket(name).Bucket(address), block, unindexDropped(tx, name, address))
+			})
+			if err != nil {
+				return err
+			}
+		}
+		for _, name := range [][]byte{blockHashesBucket, blocksBucket} {
+			c := tx.Bucket(name).Cursor()
+			for k, _ := c.Seek(itob(uint64(block + 1))); k != nil; k, _ = c.Seek(itob(uint64(block + 1))) {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Bucket(metaBucket).Put(currentBlockKey, itob(uint64(block)))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to roll back to block %d, err %v", block, err)
+	}
+	return nil
+}
+
+func (bs *BoltStorage) Prune(ctx context.Context, before, max int) (pruned int, err error) {
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		pruned = 0
+		for _, name := range [][]byte{transactionsBucket, tokensBucket, nftsBucket, eventsBucket} {
+			buckets := tx.Bucket(name)
+			err := buckets.ForEachBucket(func(k []byte) error {
+				n, err := truncateBefore(buckets.Bucket(k), before, max, unindexDropped(tx, name, k))
+				pruned += n
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if before > 0 {
+			return deleteBlocksBefore(tx.Bucket(blocksBucket), before)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune storage, err %v", err)
+	}
+	return
+}
+
+// delete the leading entries of an address bucket recorded before the block
+// and all but the last max ones, passing them to dropped unless nil
+func truncateBefore(bucket *bolt.Bucket, before, max int, dropped func(v []byte) error) (int, error) {
+	kept := bucket.Stats().KeyN
+	var stale [][]byte
+	c := bucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		b, err := entryBlock(v)
+		if err != nil {
+			return 0, err
+		}
+		if b >= before && (max == 0 || kept <= max) {
+			break
+		}
+		if dropped != nil {
+			if err := dropped(v); err != nil {
+				return 0, err
+			}
+		}
+		stale = append(stale, k)
+		kept--
+	}
+	for _, k := range stale {
+		if err := bucket.Delete(k); err != nil {
+			return 0, err
+		}
+	}
+	return len(stale), nil
+}
+
+// delete the trailing entries of an address bucket recorded after the block,
+// passing them to dropped unless nil
+func truncateAfter(bucket *bolt.Bucket, block int, dropped func(v []byte) error) error {
+	var stale [][]byte
+	c := bucket.Cursor()
+	for k, v := c.Last(); k != nil; k, v = c.Prev() {
+		b, err := entryBlock(v)
+		if err != nil {
+			return err
+		}
+		if b <= block {
+			break
+		}
+		if dropped != nil {
+			if err := dropped(v); err != nil {
+				return err
+			}
+		}
+		stale = append(stale, k)
+	}
+	for _, k := range stale {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// count the transactions of the address in the search index, or uncount
+// them with a negative delta when they are dropped
+func indexTransactions(tx *bolt.Tx, address string, txs []*types.Transaction, delta int) error {
+	bucket := tx.Bucket(searchBucket)
+	for _, t := range txs {
+		for _, key := range searchKeys(t) {
+			k := []byte(key + "/" + address)
+			count := int64(delta)
+			if v := bucket.Get(k); v != nil {
+				count += int64(binary.BigEndian.Uint64(v))
+			}
+			var err error
+			if count > 0 {
+				err = bucket.Put(k, itob(uint64(count)))
+			} else {
+				err = bucket.Delete(k)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// uncount the dropped entries of an address bucket nested in parent in the
+// search index, nil unless they are transactions
+func unindexDropped(tx *bolt.Tx, parent, address []byte) func(v []byte) error {
+	if !bytes.Equal(parent, transactionsBucket) {
+		return nil
+	}
+	return func(v []byte) error {
+		var t types.Transaction
+		if err := json.Unmarshal(v, &t); err != nil {
+			return err
+		}
+		return indexTransactions(tx, string(address), []*types.Transaction{&t}, -1)
+	}
+}
+
+// index every stored transaction, once for a file written before searches
+func indexStoredTransactions(tx *bolt.Tx) error {
+	buckets := tx.Bucket(transactionsBucket)
+	return buckets.ForEachBucket(func(address []byte) error {
+		return buckets.Bucket(address).ForEach(func(_, v []byte) error {
+			var t types.Transaction
+			if err := json.Unmarshal(v, &t); err != nil {
+				return err
+			}
+			return indexTransactions(tx, string(address), []*types.Transaction{&t}, 1)
+		})
+	})
+}
+
+// the entries of the address bucket nested in parent recorded in the block or
+// later, in block order
+func entriesSince[E any](tx *bolt.Tx, parent []byte, address string, block int, entry func(*E) (string, int)) ([]*E, error) {
+	bucket := tx.Bucket(parent).Bucket([]byte(address))
+	if bucket == nil {
+		return nil, nil
+	}
+	var entries []*E
+	c := bucket.Cursor()
+	for k, v := c.Last(); k != nil; k, v = c.Prev() {
+		e := new(E)
+		if err := json.Unmarshal(v, e); err != nil {
+			return nil, err
+		}
+		if _, b := entry(e); b < block {
+			break
+		}
+		entries = append(entries, e)
+	}
+	slices.Reverse(entries)
+	return entries, nil
+}
+
+// the block of a stored transaction or transfer
+func entryBlock(v []byte) (int, error) {
+	var entry struct{ BlockNumber string }
+	if err := json.Unmarshal(v, &entry); err != nil {
+		return 0, err
+	}
+	return types.BlockNumber(entry.BlockNumber), nil
+}
+
+// append the value as json to the per-address bucket nested in parent
+func appendJson(tx *bolt.Tx, parent []byte, address string, v interface{}) error {
+	bucket, err := tx.Bucket(parent).CreateBucketIfNotExists([]byte(address))
+	if err != nil {
+		return err
+	}
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(itob(seq), data)
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
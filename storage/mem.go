@@ -0,0 +1,812 @@
+package storage
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// how many shards the address data is spread over, enough for the readers
+// and the parser to rarely wait on each other
+const memShards = 64
+
+// The mem storage, its calls never fail; the data of the addresses is
+// sharded by address so that api reads and the parser saving a block only
+// contend on the same few addresses, the rest is behind the storage lock,
+// always taken before a shard lock
+type MemStorage struct {
+	currentBlock int
+	shards       [memShards]*memShard
+	blockHashes  map[int]string
+	// in block order
+	blocks []*types.BlockMetadata
+	// the addresses of every tenant with the label it attached, nil without
+	// one; the target addresses of the shards are the union of them
+	subscriptions map[string]map[string]*types.AddressLabel
+	// the groups of every tenant with their addresses
+	groups map[string]map[string]map[string]bool
+	// watched contract events by key, their logs by contract
+	eventSubscriptions map[string]bool
+	events             map[string][]*types.Event
+	// the holders of the leases by name, until their expiry
+	leases map[string]memLease
+	sync.RWMutex
+}
+
+type memLease struct {
+	holder  string
+	expires time.Time
+}
+
+// The target addresses of a shard, with their transactions and transfers
+type memShard struct {
+	txs            map[string][]*types.Transaction
+	tokenTransfers map[string][]*types.TokenTransfer
+	nftTransfers   map[string][]*types.NftTransfer
+	// how many transactions of each address of the shard have a search key,
+	// by key
+	search map[string]map[string]int
+	sync.RWMutex
+}
+
+func NewMemStorage() *MemStorage {
+	ms := &MemStorage{
+		eventSubscriptions: make(map[string]bool),
+		events:             make(map[string][]*types.Event),
+		blockHashes:        make(map[int]string),
+		subscriptions:      make(map[string]map[string]*types.AddressLabel),
+		groups:             make(map[string]map[string]map[string]bool),
+		leases:             make(map[string]memLease),
+	}
+	for i := range ms.shards {
+		ms.shards[i] = newMemShard()
+	}
+	return ms
+}
+
+func newMemShard() *memShard {
+	return &memShard{
+		txs:            make(map[string][]*types.Transaction),
+		tokenTransfers: make(map[string][]*types.TokenTransfer),
+		nftTransfers:   make(map[string][]*types.NftTransfer),
+		search:         make(map[string]map[string]int),
+	}
+}
+
+// count the transactions of the address in the search index, or uncount
+// them with a negative delta when they are dropped
+func (shard *memShard) index(address string, txs []*types.Transaction, delta int) {
+	for _, tx := range txs {
+		for _, key := range searchKeys(tx) {
+			addresses := shard.search[key]
+			if addresses == nil {
+				addresses = make(map[string]int)
+				shard.search[key] = addresses
+			}
+			if addresses[address] += delta; addresses[address] <= 0 {
+				delete(addresses, address)
+			}
+			if len(addresses) == 0 {
+				delete(shard.search, key)
+			}
+		}
+	}
+}
+
+// the index of the shard of the lowercase address
+func shardIndex(address string) int {
+	h := fnv.New32a()
+	h.Write([]byte(address))
+	return int(h.Sum32() % memShards)
+}
+
+// the shard of the lowercase address
+func (ms *MemStorage) shard(address string) *memShard {
+	return ms.shards[shardIndex(address)]
+}
+
+// lock the shards of both lowercase addresses, in shard order so two callers
+// can't wait on each other; returns the unlock
+func (ms *MemStorage) lockShards(a, b string) func() {
+	first, second := shardIndex(a), shardIndex(b)
+	if first == second {
+		ms.shards[first].Lock()
+		return ms.shards[first].Unlock
+	}
+	first, second = min(first, second), max(first, second)
+	ms.shards[first].Lock()
+	ms.shards[second].Lock()
+	return func() {
+		ms.shards[second].Unlock()
+		ms.shards[first].Unlock()
+	}
+}
+
+// lock every shard for reading, for a consistent view of all the addresses;
+// returns the unlock
+func (ms *MemStorage) rlockAllShards() func() {
+	for _, shard := range ms.shards {
+		shard.RLock()
+	}
+	return func() {
+		for _, shard := range ms.shards {
+			shard.RUnlock()
+		}
+	}
+}
+
+// lock every shard, e.g. to roll them all back at once; returns the unlock
+func (ms *MemStorage) lockAllShards() func() {
+	for _, shard := range ms.shards {
+		shard.Lock()
+	}
+	return func() {
+		for _, shard := range ms.shards {
+			shard.Unlock()
+		}
+	}
+}
+
+func (ms *MemStorage) GetCurrentBlock(ctx context.Context) (int, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	return ms.currentBlock, nil
+}
+
+func (ms *MemStorage) SetCurrentBlock(ctx context.Context, block int) error {
+	ms.Lock()
+	defer ms.Unlock()
+	ms.currentBlock = block
+	return nil
+}
+
+// the processes sharing a mem storage are the parsers of one process, e.g. in
+// tests
+func (ms *MemStorage) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	if lease, ok := ms.leases[name]; ok && lease.holder != holder && time.Now().Before(lease.expires) {
+		return false, nil
+	}
+	ms.leases[name] = memLease{holder: holder, expires: time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (ms *MemStorage) ReleaseLease(ctx context.Context, name, holder string) error {
+	ms.Lock()
+	defer ms.Unlock()
+	if ms.leases[name].holder == holder {
+		delete(ms.leases, name)
+	}
+	return nil
+}
+
+func (ms *MemStorage) AddTargetAddress(ctx context.Context, tenant, address string) (bool, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	address = strings.ToLower(address)
+	addresses := ms.subscriptions[tenant]
+	if _, ok := addresses[address]; ok {
+		return false, nil
+	}
+	if addresses == nil {
+		addresses = make(map[string]*types.AddressLabel)
+		ms.subscriptions[tenant] = addresses
+	}
+	addresses[address] = nil
+	shard := ms.shard(address)
+	shard.Lock()
+	defer shard.Unlock()
+	if _, ok := shard.txs[address]; !ok {
+		shard.txs[address] = nil
+	}
+	return true, nil
+}
+
+func (ms *MemStorage) RemoveTargetAddress(ctx context.Context, tenant, address string) (bool, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	address = strings.ToLower(address)
+	addresses := ms.subscriptions[tenant]
+	if _, ok := addresses[address]; !ok {
+		return false, nil
+	}
+	delete(addresses, address)
+	if len(addresses) == 0 {
+		delete(ms.subscriptions, tenant)
+	}
+	for _, addresses := range ms.subscriptions {
+		if _, ok := addresses[address]; ok {
+			return true, nil
+		}
+	}
+	shard := ms.shard(address)
+	shard.Lock()
+	defer shard.Unlock()
+	shard.index(address, shard.txs[address], -1)
+	delete(shard.txs, address)
+	delete(shard.tokenTransfers, address)
+	delete(shard.nftTransfers, address)
+	return true, nil
+}
+
+func (ms *MemStorage) SetAddressLabel(ctx context.Context, tenant, address string, label *types.AddressLabel) (bool, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	address = strings.ToLower(address)
+	addresses := ms.subscriptions[tenant]
+	if _, ok := addresses[address]; !ok {
+		return false, nil
+	}
+	addresses[address] = label
+	return true, nil
+}
+
+func (ms *MemStorage) GetAddressLabel(ctx context.Context, tenant, address string) (*types.AddressLabel, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	return ms.subscriptions[tenant][strings.ToLower(address)], nil
+}
+
+func (ms *MemStorage) HasTargetAddress(ctx context.Context, address string) (bool, error) {
+	address = strings.ToLower(address)
+	shard := ms.shard(address)
+	shard.RLock()
+	defer shard.RUnlock()
+	_, ok := shard.txs[address]
+	return ok, nil
+}
+
+func (ms *MemStorage) IsSubscribed(ctx context.Context, tenant, address string) (bool, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	_, ok := ms.subscriptions[tenant][strings.ToLower(address)]
+	return ok, nil
+}
+
+func (ms *MemStorage) GetTenants(ctx context.Context) ([]string, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	tenants := make([]string, 0, len(ms.subscriptions))
+	for tenant := range ms.subscriptions {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	return tenants, nil
+}
+
+func (ms *MemStorage) GetSubscriptions(ctx context.Context, tenant string) ([]*types.Subscription, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	subscriptions := []*types.Subscription{}
+	for address, label := range ms.subscriptions[tenant] {
+		shard := ms.shard(address)
+		shard.RLock()
+		txs := shard.txs[address]
+		shard.RUnlock()
+		subscription := &types.Subscription{Address: address, TransactionCount: len(txs)}
+		if label != nil {
+			subscription.Label, subscription.Metadata, subscription.Rule, subscription.Expires = label.Label, label.Metadata, label.Rule, label.Expires
+		}
+		if len(txs) > 0 {
+			subscription.FirstBlock = types.BlockNumber(txs[0].BlockNumber)
+			subscription.LastBlock = types.BlockNumber(txs[len(txs)-1].BlockNumber)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].Address < subscriptions[j].Address
+	})
+	return subscriptions, nil
+}
+
+func (ms *MemStorage) CreateGroup(ctx context.Context, tenant, group string) (bool, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	groups := ms.groups[tenant]
+	if _, ok := groups[group]; ok {
+		return false, nil
+	}
+	if groups == nil {
+		groups = make(map[string]map[string]bool)
+		ms.groups[tenant] = groups
+	}
+	groups[group] = make(map[string]bool)
+	return true, nil
+}
+
+func (ms *MemStorage) DeleteGroup(ctx context.Context, tenant, group string) (bool, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	groups := ms.groups[tenant]
+	if _, ok := groups[group]; !ok {
+		return false, nil
+	}
+	delete(groups, group)
+	if len(groups) == 0 {
+		delete(ms.groups, tenant)
+	}
+	return true, nil
+}
+
+func (ms *MemStorage) AddGroupAddress(ctx context.Context, tenant, group, address string) (bool, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	addresses, ok := ms.groups[tenant][group]
+	address = strings.ToLower(address)
+	if !ok || addresses[address] {
+		return false, nil
+	}
+	addresses[address] = true
+	return true, nil
+}
+
+func (ms *MemStorage) RemoveGroupAddress(ctx context.Context, tenant, group, address string) (bool, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	addresses := ms.groups[tenant][group]
+	address = strings.ToLower(address)
+	if !addresses[address] {
+		return false, nil
+	}
+	delete(addresses, address)
+	return true, nil
+}
+
+func (ms *MemStorage) GetGroup(ctx context.Context, tenant, group string) (*types.Group, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	addresses, ok := ms.groups[tenant][group]
+	if !ok {
+		return nil, nil
+	}
+	return memGroup(group, addresses), nil
+}
+
+func (ms *MemStorage) GetGroups(ctx context.Context, tenant string) ([]*types.Group, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	return memGroups(ms.groups[tenant]), nil
+}
+
+// the groups ordered by name
+func memGroups(groups map[string]map[string]bool) []*types.Group {
+	sorted := make([]*types.Group, 0, len(groups))
+	for group, addresses := range groups {
+		sorted = append(sorted, memGroup(group, addresses))
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+func memGroup(group string, addresses map[string]bool) *types.Group {
+	g := &types.Group{Name: group, Addresses: make([]string, 0, len(addresses))}
+	for address := range addresses {
+		g.Addresses = append(g.Addresses, address)
+	}
+	sort.Strings(g.Addresses)
+	return g
+}
+
+// only the shards of the two sides of a transaction are locked while it is
+// saved
+func (ms *MemStorage) SaveTransactions(ctx context.Context, block int, txs []*types.Transaction) (matches []*types.MatchedTransaction, err error) {
+	for _, tx := range txs {
+		from, to := strings.ToLower(tx.From), strings.ToLower(tx.To)
+		fromShard, toShard := ms.shard(from), ms.shard(to)
+		unlock := ms.lockShards(from, to)
+		// both checked before appending, a self transfer is stored twice
+		fromStored, toStored := isStored(fromShard.txs[from], tx, transactionEntry), isStored(toShard.txs[to], tx, transactionEntry)
+		if _, ok := fromShard.txs[from]; ok && !fromStored {
+			slog.Info("New outgoing transaction", "address", from, "block", block, "hash", tx.Hash)
+			fromShard.txs[from] = appendInOrder(fromShard.txs[from], tx, transactionEntry)
+			fromShard.index(from, []*types.Transaction{tx}, 1)
+			matches = append(matches, &types.MatchedTransaction{Address: from, Direction: types.DirectionOut, Block: block, Transaction: tx})
+		}
+		if _, ok := toShard.txs[to]; ok && !toStored {
+			slog.Info("New incoming transaction", "address", to, "block", block, "hash", tx.Hash)
+			toShard.txs[to] = appendInOrder(toShard.txs[to], tx, transactionEntry)
+			toShard.index(to, []*types.Transaction{tx}, 1)
+			matches = append(matches, &types.MatchedTransaction{Address: to, Direction: types.DirectionIn, Block: block, Transaction: tx})
+		}
+		unlock()
+	}
+	return
+}
+
+func (ms *MemStorage) BackfillTransactions(ctx context.Context, address string, matches []*types.MatchedTransaction) (int, error) {
+	address = strings.ToLower(address)
+	shard := ms.shard(address)
+	shard.Lock()
+	defer shard.Unlock()
+	stored, ok := shard.txs[address]
+	if !ok {
+		return 0, nil
+	}
+	fresh := newMatches(stored, matches)
+	if len(fresh) > 0 {
+		// a new slice, readers may still hold the stored one
+		shard.txs[address] = mergeTransactions(stored, fresh)
+		for _, m := range fresh {
+			shard.index(address, []*types.Transaction{m.Transaction}, 1)
+		}
+	}
+	return len(fresh), nil
+}
+
+func (ms *MemStorage) GetTransactions(ctx context.Context, address string) ([]*types.Transaction, error) {
+	address = strings.ToLower(address)
+	shard := ms.shard(address)
+	shard.RLock()
+	defer shard.RUnlock()
+	return shard.txs[address], nil
+}
+
+func (ms *MemStorage) QueryTransactions(ctx context.Context, address string, filter types.TransactionFilter) ([]*types.Transaction, error) {
+	address = strings.ToLower(address)
+	shard := ms.shard(address)
+	shard.RLock()
+	defer shard.RUnlock()
+	return types.FilterTransactions(address, shard.txs[address], filter), nil
+}
+
+func (ms *MemStorage) GetAddressStats(ctx context.Context, address string) (*types.AddressStats, error) {
+	address = strings.ToLower(address)
+	shard := ms.shard(address)
+	shard.RLock()
+	defer shard.RUnlock()
+	return addressStats(address, shard.txs[address]), nil
+}
+
+// a scan of every shard, one at a time
+func (ms *MemStorage) GetTransaction(ctx context.Context, hash string) (*types.Transaction, error) {
+	var found *types.Transaction
+	for _, shard := range ms.shards {
+		shard.RLock()
+		for _, txs := range shard.txs {
+			for _, tx := range txs {
+				if tx.Hash == hash && (found == nil || tx.Kind == "") {
+					found = tx
+				}
+			}
+		}
+		shard.RUnlock()
+	}
+	return found, nil
+}
+
+// the shards are looked up one at a time, the others stay writable
+func (ms *MemStorage) SearchTransactions(ctx context.Context, search *types.TransactionSearch) ([]*types.Transaction, error) {
+	keys := searchQueryKeys(search)
+	if len(keys) == 0 {
+		return nil, ErrUnindexedSearch
+	}
+	var found []*types.Transaction
+	for _, shard := range ms.shards {
+		shard.RLock()
+		sets := make([]map[string]int, len(keys))
+		for i, key := range keys {
+			sets[i] = shard.search[key]
+		}
+		for _, address := range intersectAddresses(sets) {
+			found = append(found, shard.txs[address]...)
+		}
+		shard.RUnlock()
+	}
+	return searchTransactions(found, search), nil
+}
+
+func (ms *MemStorage) SaveTokenTransfers(ctx context.Context, transfers []*types.TokenTransfer) error {
+	for _, transfer := range transfers {
+		from, to := strings.ToLower(transfer.From), strings.ToLower(transfer.To)
+		fromShard, toShard := ms.shard(from), ms.shard(to)
+		unlock := ms.lockShards(from, to)
+		fromStored, toStored := isStored(fromShard.tokenTransfers[from], transfer, tokenTransferEntry), isStored(toShard.tokenTransfers[to], transfer, tokenTransferEntry)
+		if _, ok := fromShard.txs[from]; ok && !fromStored {
+			slog.Info("New outgoing token transfer", "address", from, "block", types.BlockNumber(transfer.BlockNumber), "hash", transfer.TransactionHash, "token", transfer.Token)
+			fromShard.tokenTransfers[from] = appendInOrder(fromShard.tokenTransfers[from], transfer, tokenTransferEntry)
+		}
+		if _, ok := toShard.txs[to]; ok && !toStored {
+			slog.Info("New incoming token transfer", "address", to, "block", types.BlockNumber(transfer.BlockNumber), "hash", transfer.TransactionHash, "token", transfer.Token)
+			toShard.tokenTransfers[to] = appendInOrder(toShard.tokenTransfers[to], transfer, tokenTransferEntry)
+		}
+		unlock()
+	}
+	return nil
+}
+
+func (ms *MemStorage) GetTokenTransfers(ctx context.Context, address string) ([]*types.TokenTransfer, error) {
+	address = strings.ToLower(address)
+	shard := ms.shard(address)
+	shard.RLock()
+	defer shard.RUnlock()
+	return shard.tokenTransfers[address], nil
+}
+
+func (ms *MemStorage) SaveNftTransfers(ctx context.Context, transfers []*types.NftTransfer) error {
+	for _, transfer := range transfers {
+		from, to := strings.ToLower(transfer.From), strings.ToLower(transfer.To)
+		fromShard, toShard := ms.shard(from), ms.shard(to)
+		unlock := ms.lockShards(from, to)
+		fromStored, toStored := isStored(fromShard.nftTransfers[from], transfer, nftTransferEntry), isStored(toShard.nftTransfers[to], transfer, nftTransferEntry)
+		if _, ok := fromShard.txs[from]; ok && !fromStored {
+			slog.Info("New outgoing NFT transfer", "address", from, "block", types.BlockNumber(transfer.BlockNumber), "hash", transfer.TransactionHash, "contract", transfer.Contract, "tokenId", transfer.TokenId)
+			fromShard.nftTransfers[from] = appendInOrder(fromShard.nftTransfers[from], transfer, nftTransferEntry)
+		}
+		if _, ok := toShard.txs[to]; ok && !toStored {
+			slog.Info("New incoming NFT transfer", "address", to, "block", types.BlockNumber(transfer.BlockNumber), "hash", transfer.TransactionHash, "contract", transfer.Contract, "tokenId", transfer.TokenId)
+			toShard.nftTransfers[to] = appendInOrder(toShard.nftTransfers[to], transfer, nftTransferEntry)
+		}
+		unlock()
+	}
+	return nil
+}
+
+func (ms *MemStorage) GetNftTransfers(ctx context.Context, address string) ([]*types.NftTransfer, error) {
+	address = strings.ToLower(address)
+	shard := ms.shard(address)
+	shard.RLock()
+	defer shard.RUnlock()
+	return shard.nftTransfers[address], nil
+}
+
+func (ms *MemStorage) AddEventSubscription(ctx context.Context, contract, topic string) (bool, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	key := eventSubscriptionKey(contract, topic)
+	if ms.eventSubscriptions[key] {
+		return false, nil
+	}
+	ms.eventSubscriptions[key] = true
+	return true, nil
+}
+
+func (ms *MemStorage) RemoveEventSubscription(ctx context.Context, contract, topic string) (bool, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	key := eventSubscriptionKey(contract, topic)
+	if !ms.eventSubscriptions[key] {
+		return false, nil
+	}
+	delete(ms.eventSubscriptions, key)
+	contract = strings.ToLower(contract)
+	if kept := withoutTopic(ms.events[contract], strings.ToLower(topic)); kept != nil {
+		ms.events[contract] = kept
+	} else {
+		delete(ms.events, contract)
+	}
+	return true, nil
+}
+
+func (ms *MemStorage) GetEventSubscriptions(ctx context.Context) ([]*types.EventSubscription, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	keys := make([]string, 0, len(ms.eventSubscriptions))
+	for key := range ms.eventSubscriptions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	subscriptions := make([]*types.EventSubscription, len(keys))
+	for i, key := range keys {
+		subscriptions[i] = parseEventSubscriptionKey(key)
+	}
+	return subscriptions, nil
+}
+
+func (ms *MemStorage) SaveEvents(ctx context.Context, events []*types.Event) error {
+	ms.Lock()
+	defer ms.Unlock()
+	for _, e := range events {
+		contract := strings.ToLower(e.Address)
+		if len(e.Topics) == 0 || !ms.eventSubscriptions[eventSubscriptionKey(contract, e.Topics[0])] || isStored(ms.events[contract], e, eventEntry) {
+			continue
+		}
+		slog.Info("New contract event", "contract", contract, "block", types.BlockNumber(e.BlockNumber), "hash", e.TransactionHash, "topic", e.Topics[0])
+		ms.events[contract] = appendInOrder(ms.events[contract], e, eventEntry)
+	}
+	return nil
+}
+
+func (ms *MemStorage) GetEvents(ctx context.Context, contract string) ([]*types.Event, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	return ms.events[strings.ToLower(contract)], nil
+}
+
+func (ms *MemStorage) SaveBlockHash(ctx context.Context, block int, hash string) error {
+	ms.Lock()
+	defer ms.Unlock()
+	ms.blockHashes[block] = hash
+	return nil
+}
+
+func (ms *MemStorage) GetBlockHash(ctx context.Context, block int) (string, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	return ms.blockHashes[block], nil
+}
+
+func (ms *MemStorage) PruneBlockHashes(ctx context.Context, before int) error {
+	ms.Lock()
+	defer ms.Unlock()
+	for block := range ms.blockHashes {
+		if block < before {
+			delete(ms.blockHashes, block)
+		}
+	}
+	return nil
+}
+
+func (ms *MemStorage) SaveBlock(ctx context.Context, block *types.BlockMetadata) error {
+	ms.Lock()
+	defer ms.Unlock()
+	i, found := ms.findBlock(types.BlockNumber(block.Number))
+	if found {
+		ms.blocks[i] = block
+	} else {
+		ms.blocks = slices.Insert(ms.blocks, i, block)
+	}
+	return nil
+}
+
+func (ms *MemStorage) GetBlock(ctx context.Context, block int) (*types.BlockMetadata, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	if i, found := ms.findBlock(block); found {
+		return ms.blocks[i], nil
+	}
+	return nil, nil
+}
+
+func (ms *MemStorage) GetBlocks(ctx context.Context, from, to int) ([]*types.BlockMetadata, error) {
+	ms.RLock()
+	defer ms.RUnlock()
+	start, _ := ms.findBlock(from)
+	end, found := ms.findBlock(to)
+	if found {
+		end++
+	}
+	if start >= end {
+		return nil, nil
+	}
+	return slices.Clone(ms.blocks[start:end]), nil
+}
+
+// the index of the block in the stored ones, or where it would be inserted
+func (ms *MemStorage) findBlock(block int) (int, bool) {
+	return slices.BinarySearchFunc(ms.blocks, block, func(b *types.BlockMetadata, block int) int {
+		return types.BlockNumber(b.Number) - block
+	})
+}
+
+func (ms *MemStorage) Close() error {
+	return nil
+}
+
+// every shard is locked at once, readers never see half a rollback
+func (ms *MemStorage) Rollback(ctx context.Context, block int) error {
+	ms.Lock()
+	defer ms.Unlock()
+	unlock := ms.lockAllShards()
+	defer unlock()
+	for _, shard := range ms.shards {
+		for address, txs := range shard.txs {
+			n := len(txs)
+			for n > 0 && types.BlockNumber(txs[n-1].BlockNumber) > block {
+				n--
+			}
+			if n < len(txs) {
+				shard.index(address, txs[n:], -1)
+				// clipped so the next save reallocates instead of writing over
+				// the rolled back ones the readers may still hold
+				shard.txs[address] = slices.Clip(txs[:n])
+			}
+		}
+		for address, transfers := range shard.tokenTransfers {
+			n := len(transfers)
+			for n > 0 && types.BlockNumber(transfers[n-1].BlockNumber) > block {
+				n--
+			}
+			shard.tokenTransfers[address] = slices.Clip(transfers[:n])
+		}
+		for address, transfers := range shard.nftTransfers {
+			n := len(transfers)
+			for n > 0 && types.BlockNumber(transfers[n-1].BlockNumber) > block {
+				n--
+			}
+			shard.nftTransfers[address] = slices.Clip(transfers[:n])
+		}
+	}
+	for contract, events := range ms.events {
+		n := len(events)
+		for n > 0 && types.BlockNumber(events[n-1].BlockNumber) > block {
+			n--
+		}
+		ms.events[contract] = slices.Clip(events[:n])
+	}
+	for b := range ms.blockHashes {
+		if b > block {
+			delete(ms.blockHashes, b)
+		}
+	}
+	i, found := ms.findBlock(block)
+	if found {
+		i++
+	}
+	ms.blocks = ms.blocks[:i]
+	ms.currentBlock = block
+	return nil
+}
+
+// the shards are pruned one at a time, the others stay readable, then the
+// events and blocks
+func (ms *MemStorage) Prune(ctx context.Context, before, max int) (pruned int, err error) {
+	for _, shard := range ms.shards {
+		pruned += shard.prune(before, max)
+	}
+	ms.Lock()
+	defer ms.Unlock()
+	for contract, events := range ms.events {
+		start := retainedFrom(len(events), before, max, func(i int) int { return types.BlockNumber(events[i].BlockNumber) })
+		if start > 0 {
+			ms.events[contract] = append([]*types.Event(nil), events[start:]...)
+			pruned += start
+		}
+	}
+	if before > 0 {
+		if start, _ := ms.findBlock(before); start > 0 {
+			ms.blocks = append([]*types.BlockMetadata(nil), ms.blocks[start:]...)
+		}
+	}
+	return
+}
+
+func (shard *memShard) prune(before, max int) (pruned int) {
+	shard.Lock()
+	defer shard.Unlock()
+	for address, txs := range shard.txs {
+		start := retainedFrom(len(txs), before, max, func(i int) int { return types.BlockNumber(txs[i].BlockNumber) })
+		if start > 0 {
+			shard.index(address, txs[:start], -1)
+			// copy so the dropped entries are freed
+			shard.txs[address] = append([]*types.Transaction(nil), txs[start:]...)
+			pruned += start
+		}
+	}
+	for address, transfers := range shard.tokenTransfers {
+		start := retainedFrom(len(transfers), before, max, func(i int) int { return types.BlockNumber(transfers[i].BlockNumber) })
+		if start > 0 {
+			shard.tokenTransfers[address] = append([]*types.TokenTransfer(nil), transfers[start:]...)
+			pruned += start
+		}
+	}
+	for address, transfers := range shard.nftTransfers {
+		start := retainedFrom(len(transfers), before, max, func(i int) int { return types.BlockNumber(transfers[i].BlockNumber) })
+		if start > 0 {
+			shard.nftTransfers[address] = append([]*types.NftTransfer(nil), transfers[start:]...)
+			pruned += start
+		}
+	}
+	return
+}
+
+// index of the first of n entries in block order kept by the retention
+func retainedFrom(n, before, max int, block func(i int) int) int {
+	start := 0
+	for start < n && block(start) < before {
+		start++
+	}
+	if max > 0 && n-start > max {
+		start = n - max
+	}
+	return start
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// subscribed addresses of the benchmarks, spread over every shard
+const benchAddresses = 1024
+
+// the parser saving blocks while the api reads the transactions of other
+// addresses, the load the shards of the mem storage are for
+func BenchmarkMemStorageParallel(b *testing.B) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ctx := context.Background()
+	ms := NewMemStorage()
+	addresses := make([]string, benchAddresses)
+	for i := range addresses {
+		addresses[i] = fmt.Sprintf("0x%040x", i+1)
+		if _, err := ms.AddTargetAddress(ctx, "", addresses[i]); err != nil {
+			b.Fatal(err)
+		}
+	}
+	var next atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			n := int(next.Add(1))
+			from, to := addresses[n%benchAddresses], addresses[(n*7+1)%benchAddresses]
+			if n%4 == 0 {
+				// blocks only grow, like the parser saves them
+				tx := &types.Transaction{Hash: fmt.Sprintf("0x%064x", n), From: from, To: to, BlockNumber: fmt.Sprintf("0x%x", n)}
+				if _, err := ms.SaveTransactions(ctx, n, []*types.Transaction{tx}); err != nil {
+					b.Fatal(err)
+				}
+				continue
+			}
+			if _, err := ms.GetTransactions(ctx, to); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,1222 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+	"github.com/redis/go-redis/v9"
+)
+
+const redisKeyPrefix = "ethparser:"
+
+// take or extend a lease key unless another holder has it, and delete it only
+// for its holder, atomically
+var (
+	acquireLeaseScript = redis.NewScript(`
+		local holder = redis.call('GET', KEYS[1])
+		if holder and holder ~= ARGV[1] then
+			return 0
+		end
+		redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+		return 1`)
+	releaseLeaseScript = redis.NewScript(`
+		if redis.call('GET', KEYS[1]) == ARGV[1] then
+			redis.call('DEL', KEYS[1])
+		end
+		return 0`)
+)
+
+// The redis storage, shares subscribed addresses, the current block and
+// per-address transactions between parser instances and api replicas
+type RedisStorage struct {
+	client *redis.Client
+	// redisKeyPrefix, followed by the namespace when there is one
+	prefix string
+	// how long the history of an address is kept after its last activity,
+	// 0 keeps it forever
+	ttl time.Duration
+}
+
+// connect to the redis url, e.g. redis://localhost:6379/0, keeping the keys
+// apart from other namespaces sharing the database, e.g. other chains
+func NewRedisStorage(url, namespace string, ttl time.Duration) (*RedisStorage, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	rs := &RedisStorage{
+		client: redis.NewClient(opts),
+		prefix: redisKeyPrefix,
+		ttl:    ttl,
+	}
+	if namespace != "" {
+		rs.prefix += namespace + ":"
+	}
+	if err := rs.client.Ping(context.Background()).Err(); err != nil {
+		rs.client.Close()
+		return nil, err
+	}
+	if err := rs.migrateTenants(context.Background()); err != nil {
+		rs.client.Close()
+		return nil, err
+	}
+	if err := rs.migrateSearch(context.Background()); err != nil {
+		rs.client.Close()
+		return nil, err
+	}
+	return rs, nil
+}
+
+// move the addresses and labels of a database written before tenants to the
+// default tenant, once
+func (rs *RedisStorage) migrateTenants(ctx context.Context) error {
+	migrated, err := rs.client.Exists(ctx, rs.key("tenants")).Result()
+	if err != nil || migrated == 1 {
+		return err
+	}
+	addresses, err := rs.client.SMembers(ctx, rs.key("addresses")).Result()
+	if err != nil || len(addresses) == 0 {
+		return err
+	}
+	labels, err := rs.client.HGetAll(ctx, rs.key("labels")).Result()
+	if err != nil {
+		return err
+	}
+	_, err = rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, address := range addresses {
+			pipe.HSetNX(ctx, rs.key("subscriptions", ""), address, labels[address])
+		}
+		pipe.SAdd(ctx, rs.key("tenants"), "")
+		pipe.Del(ctx, rs.key("labels"))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to migrate subscriptions to tenants, err %v", err)
+	}
+	return nil
+}
+
+// index the transactions of a database written before searches, once
+func (rs *RedisStorage) migrateSearch(ctx context.Context) error {
+	migrated, err := rs.client.Exists(ctx, rs.key("searchIndexed")).Result()
+	if err != nil || migrated == 1 {
+		return err
+	}
+	addresses, err := rs.client.SMembers(ctx, rs.key("addresses")).Result()
+	if err != nil {
+		return err
+	}
+	for _, address := range addresses {
+		var txs []*types.Transaction
+		if err := rs.readJson(ctx, rs.key("txs", address), &txs); err != nil {
+			return fmt.Errorf("failed to index transactions of %s, err %v", address, err)
+		}
+		_, err := rs.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			rs.indexTransactions(ctx, pipe, address, txs)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to index transactions of %s, err %v", address, err)
+		}
+	}
+	return rs.client.Set(ctx, rs.key("searchIndexed"), 1, 0).Err()
+}
+
+func (rs *RedisStorage) key(parts ...string) string {
+	return rs.prefix + strings.Join(parts, ":")
+}
+
+func (rs *RedisStorage) Close() error {
+	return rs.client.Close()
+}
+
+func (rs *RedisStorage) GetCurrentBlock(ctx context.Context) (int, error) {
+	block, err := rs.client.Get(ctx, rs.key("currentBlock")).Int()
+	if err != nil && err != redis.Nil {
+		return 0, fmt.Errorf("failed to read current block, err %v", err)
+	}
+	return block, nil
+}
+
+func (rs *RedisStorage) SetCurrentBlock(ctx context.Context, block int) error {
+	if err := rs.client.Set(ctx, rs.key("currentBlock"), block, 0).Err(); err != nil {
+		return fmt.Errorf("failed to set current block %d, err %v", block, err)
+	}
+	return nil
+}
+
+// the lease keys expire in redis, the clocks of its holders don't matter
+func (rs *RedisStorage) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	acquired, err := acquireLeaseScript.Run(ctx, rs.client, []string{rs.key("lease", name)}, holder, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lease %s, err %v", name, err)
+	}
+	return acquired == 1, nil
+}
+
+func (rs *RedisStorage) ReleaseLease(ctx context.Context, name, holder string) error {
+	if err := releaseLeaseScript.Run(ctx, rs.client, []string{rs.key("lease", name)}, holder).Err(); err != nil {
+		return fmt.Errorf("failed to release lease %s, err %v", name, err)
+	}
+	return nil
+}
+
+// the subscriptions of a tenant are a hash of its addresses to their label
+// json, empty without one; the addresses set holds the union of them and the
+// tenants set every tenant that ever subscribed
+func (rs *RedisStorage) AddTargetAddress(ctx context.Context, tenant, address string) (bool, error) {
+	address = strings.ToLower(address)
+	var added *redis.BoolCmd
+	_, err := rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		added = pipe.HSetNX(ctx, rs.key("subscriptions", tenant), address, "")
+		pipe.SAdd(ctx, rs.key("addresses"), address)
+		pipe.SAdd(ctx, rs.key("tenants"), tenant)
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to add target address %s, err %v", address, err)
+	}
+	return added.Val(), nil
+}
+
+// the activity of the address goes with its last tenant, watched so a tenant
+// subscribing meanwhile makes the check start over
+func (rs *RedisStorage) RemoveTargetAddress(ctx context.Context, tenant, address string) (bool, error) {
+	address = strings.ToLower(address)
+	removed, err := rs.client.HDel(ctx, rs.key("subscriptions", tenant), address).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to remove target address %s, err %v", address, err)
+	}
+	if removed == 0 {
+		return false, nil
+	}
+	tenants, err := rs.client.SMembers(ctx, rs.key("tenants")).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to remove target address %s, err %v", address, err)
+	}
+	keys := make([]string, len(tenants))
+	for i, tenant := range tenants {
+		keys[i] = rs.key("subscriptions", tenant)
+	}
+	var dropped []string
+	drop := func(tx *redis.Tx) error {
+		dropped = nil
+		for _, key := range keys {
+			if subscribed, err := tx.HExists(ctx, key, address).Result(); err != nil || subscribed {
+				return err
+			}
+		}
+		entries, err := tx.LRange(ctx, rs.key("txs", address), 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SRem(ctx, rs.key("addresses"), address)
+			pipe.Del(ctx, rs.key("txs", address), rs.key("tokens", address), rs.key("nfts", address))
+			return nil
+		})
+		if err == nil {
+			dropped = entries
+		}
+		return err
+	}
+	for attempt := 0; attempt < 5; attempt++ {
+		if err = rs.client.Watch(ctx, drop, keys...); err != redis.TxFailedErr {
+			break
+		}
+	}
+	if err == nil {
+		err = rs.unindexTransactions(ctx, address, dropped)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to remove target address %s, err %v", address, err)
+	}
+	return true, nil
+}
+
+func (rs *RedisStorage) SetAddressLabel(ctx context.Context, tenant, address string, label *types.AddressLabel) (bool, error) {
+	address = strings.ToLower(address)
+	subscribed, err := rs.IsSubscribed(ctx, tenant, address)
+	if err != nil || !subscribed {
+		return false, err
+	}
+	data, err := json.Marshal(label)
+	if err == nil {
+		err = rs.client.HSet(ctx, rs.key("subscriptions", tenant), address, data).Err()
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to set address label of %s, err %v", address, err)
+	}
+	return true, nil
+}
+
+func (rs *RedisStorage) GetAddressLabel(ctx context.Context, tenant, address string) (*types.AddressLabel, error) {
+	address = strings.ToLower(address)
+	data, err := rs.client.HGet(ctx, rs.key("subscriptions", tenant), address).Bytes()
+	if err == redis.Nil || err == nil && len(data) == 0 {
+		return nil, nil
+	}
+	var label *types.AddressLabel
+	if err == nil {
+		err = json.Unmarshal(data, &label)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read address label of %s, err %v", address, err)
+	}
+	return label, nil
+}
+
+func (rs *RedisStorage) HasTargetAddress(ctx context.Context, address string) (bool, error) {
+	found, err := rs.client.SIsMember(ctx, rs.key("addresses"), strings.ToLower(address)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to read target address %s, err %v", address, err)
+	}
+	return found, nil
+}
+
+func (rs *RedisStorage) IsSubscribed(ctx context.Context, tenant, address string) (bool, error) {
+	found, err := rs.client.HExists(ctx, rs.key("subscriptions", tenant), strings.ToLower(address)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to read subscription of %s, err %v", address, err)
+	}
+	return found, nil
+}
+
+// the tenants set keeps the ones that unsubscribed from everything since,
+// left out by their empty hash
+func (rs *RedisStorage) GetTenants(ctx context.Context) ([]string, error) {
+	tenants, err := rs.client.SMembers(ctx, rs.key("tenants")).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenants, err %v", err)
+	}
+	pipe := rs.client.Pipeline()
+	counts := make([]*redis.IntCmd, len(tenants))
+	for i, tenant := range tenants {
+		counts[i] = pipe.HLen(ctx, rs.key("subscriptions", tenant))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to read tenants, err %v", err)
+	}
+	subscribed := []string{}
+	for i, tenant := range tenants {
+		if counts[i].Val() > 0 {
+			subscribed = append(subscribed, tenant)
+		}
+	}
+	sort.Strings(subscribed)
+	return subscribed, nil
+}
+
+// the groups of a tenant are a set of their names, the addresses of each a
+// set of its own
+func (rs *RedisStorage) CreateGroup(ctx context.Context, tenant, group string) (bool, error) {
+	added, err := rs.client.SAdd(ctx, rs.key("groups", tenant), group).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to create group %s, err %v", group, err)
+	}
+	return added == 1, nil
+}
+
+func (rs *RedisStorage) DeleteGroup(ctx context.Context, tenant, group string) (bool, error) {
+	var removed *redis.IntCmd
+	_, err := rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		removed = pipe.SRem(ctx, rs.key("groups", tenant), group)
+		pipe.Del(ctx, rs.key("group", tenant, group))
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete group %s, err %v", group, err)
+	}
+	return removed.Val() == 1, nil
+}
+
+// watched so a group deleted meanwhile isn't left with the address
+func (rs *RedisStorage) AddGroupAddress(ctx context.Context, tenant, group, address string) (bool, error) {
+	address = strings.ToLower(address)
+	added := false
+	add := func(tx *redis.Tx) error {
+		exists, err := tx.SIsMember(ctx, rs.key("groups", tenant), group).Result()
+		if err != nil || !exists {
+			return err
+		}
+		var cmd *redis.IntCmd
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			cmd = pipe.SAdd(ctx, rs.key("group", tenant, group), address)
+			return nil
+		})
+		added = err == nil && cmd.Val() == 1
+		return err
+	}
+	var err error
+	for attempt := 0; attempt < 5; attempt++ {
+		if err = rs.client.Watch(ctx, add, rs.key("groups", tenant)); err != redis.TxFailedErr {
+			break
+		}
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to add %s to group %s, err %v", address, group, err)
+	}
+	return added, nil
+}
+
+func (rs *RedisStorage) RemoveGroupAddress(ctx context.Context, tenant, group, address string) (bool, error) {
+	address = strings.ToLower(address)
+	removed, err := rs.client.SRem(ctx, rs.key("group", tenant, group), address).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to remove %s from group %s, err %v", address, group, err)
+	}
+	return removed == 1, nil
+}
+
+func (rs *RedisStorage) GetGroup(ctx context.Context, tenant, group string) (*types.Group, error) {
+	exists, err := rs.client.SIsMember(ctx, rs.key("groups", tenant), group).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read group %s, err %v", group, err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	addresses, err := rs.client.SMembers(ctx, rs.key("group", tenant, group)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read group %s, err %v", group, err)
+	}
+	return redisGroup(group, addresses), nil
+}
+
+func (rs *RedisStorage) GetGroups(ctx context.Context, tenant string) ([]*types.Group, error) {
+	names, err := rs.client.SMembers(ctx, rs.key("groups", tenant)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read groups, err %v", err)
+	}
+	sort.Strings(names)
+	pipe := rs.client.Pipeline()
+	members := make([]*redis.StringSliceCmd, len(names))
+	for i, group := range names {
+		members[i] = pipe.SMembers(ctx, rs.key("group", tenant, group))
+	}
+	if len(names) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, fmt.Errorf("failed to read groups, err %v", err)
+		}
+	}
+	groups := make([]*types.Group, len(names))
+	for i, group := range names {
+		groups[i] = redisGroup(group, members[i].Val())
+	}
+	return groups, nil
+}
+
+// the group with its members sorted, a missing set being an empty group
+func redisGroup(group string, addresses []string) *types.Group {
+	if addresses == nil {
+		addresses = []string{}
+	}
+	sort.Strings(addresses)
+	return &types.Group{Name: group, Addresses: addresses}
+}
+
+func (rs *RedisStorage) GetSubscriptions(ctx context.Context, tenant string) ([]*types.Subscription, error) {
+	subscriptions := []*types.Subscription{}
+	labels, err := rs.client.HGetAll(ctx, rs.key("subscriptions", tenant)).Result()
+	if err == nil {
+		addresses := make([]string, 0, len(labels))
+		for address := range labels {
+			addresses = append(addresses, address)
+		}
+		sort.Strings(addresses)
+		pipe := rs.client.Pipeline()
+		counts := make([]*redis.IntCmd, len(addresses))
+		firsts := make([]*redis.StringCmd, len(addresses))
+		lasts := make([]*redis.StringCmd, len(addresses))
+		for i, address := range addresses {
+			counts[i] = pipe.LLen(ctx, rs.key("txs", address))
+			firsts[i] = pipe.LIndex(ctx, rs.key("txs", address), 0)
+			lasts[i] = pipe.LIndex(ctx, rs.key("txs", address), -1)
+		}
+		// LIndex of an empty list fails with redis.Nil, checked per address
+		if _, err = pipe.Exec(ctx); err == redis.Nil {
+			err = nil
+		}
+		for i, address := range addresses {
+			if err != nil {
+				break
+			}
+			subscription := &types.Subscription{Address: address, TransactionCount: int(counts[i].Val())}
+			if subscription.TransactionCount > 0 {
+				if subscription.FirstBlock, err = entryBlock([]byte(firsts[i].Val())); err == nil {
+					subscription.LastBlock, err = entryBlock([]byte(lasts[i].Val()))
+				}
+			}
+			if data := labels[address]; data != "" && err == nil {
+				var label types.AddressLabel
+				if err = json.Unmarshal([]byte(data), &label); err == nil {
+					subscription.Label, subscription.Metadata, subscription.Rule, subscription.Expires = label.Label, label.Metadata, label.Rule, label.Expires
+				}
+			}
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subscriptions, err %v", err)
+	}
+	return subscriptions, nil
+}
+
+// which of the addresses are subscribed, in one round trip
+func (rs *RedisStorage) targets(ctx context.Context, addresses []string) (map[string]bool, error) {
+	targets := make(map[string]bool)
+	if len(addresses) == 0 {
+		return targets, nil
+	}
+	members := make([]interface{}, len(addresses))
+	for i, address := range addresses {
+		members[i] = address
+	}
+	found, err := rs.client.SMIsMember(ctx, rs.key("addresses"), members...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, ok := range found {
+		if ok {
+			targets[addresses[i]] = true
+		}
+	}
+	return targets, nil
+}
+
+func (rs *RedisStorage) SaveTransactions(ctx context.Context, block int, txs []*types.Transaction) (matches []*types.MatchedTransaction, err error) {
+	var addresses []string
+	for _, tx := range txs {
+		addresses = append(addresses, strings.ToLower(tx.From), strings.ToLower(tx.To))
+	}
+	targets, err := rs.targets(ctx, addresses)
+	if err == nil {
+		stored := newStoredEntries(transactionEntry, func(address string) ([]*types.Transaction, error) {
+			return listEntriesSince(ctx, rs, rs.key("txs", address), block, transactionEntry)
+		})
+		_, err = rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, tx := range txs {
+				from, to := strings.ToLower(tx.From), strings.ToLower(tx.To)
+				saveFrom, err := stored.isNew(targets[from], from, tx)
+				if err != nil {
+					return err
+				}
+				saveTo, err := stored.isNew(targets[to], to, tx)
+				if err != nil {
+					return err
+				}
+				if saveFrom {
+					slog.Info("New outgoing transaction", "address", from, "block", block, "hash", tx.Hash)
+					if err := rs.appendJson(ctx, pipe, rs.key("txs", from), tx); err != nil {
+						return err
+					}
+					rs.indexTransactions(ctx, pipe, from, []*types.Transaction{tx})
+					matches = append(matches, &types.MatchedTransaction{Address: from, Direction: types.DirectionOut, Block: block, Transaction: tx})
+				}
+				if saveTo {
+					slog.Info("New incoming transaction", "address", to, "block", block, "hash", tx.Hash)
+					if err := rs.appendJson(ctx, pipe, rs.key("txs", to), tx); err != nil {
+						return err
+					}
+					rs.indexTransactions(ctx, pipe, to, []*types.Transaction{tx})
+					matches = append(matches, &types.MatchedTransaction{Address: to, Direction: types.DirectionIn, Block: block, Transaction: tx})
+				}
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save transactions, block %d, err %v", block, err)
+	}
+	return
+}
+
+// replaces the list of the address, watched so an instance appending to it
+// meanwhile makes the merge start over
+func (rs *RedisStorage) BackfillTransactions(ctx context.Context, address string, matches []*types.MatchedTransaction) (added int, err error) {
+	address = strings.ToLower(address)
+	key := rs.key("txs", address)
+	merge := func(tx *redis.Tx) error {
+		added = 0
+		target, err := tx.SIsMember(ctx, rs.key("addresses"), address).Result()
+		if err != nil || !target {
+			return err
+		}
+		entries, err := tx.LRange(ctx, key, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		var stored []*types.Transaction
+		if err := json.Unmarshal([]byte("["+strings.Join(entries, ",")+"]"), &stored); err != nil {
+			return err
+		}
+		fresh := newMatches(stored, matches)
+		if len(fresh) == 0 {
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			for _, t := range mergeTransactions(stored, fresh) {
+				if err := rs.appendJson(ctx, pipe, key, t); err != nil {
+					return err
+				}
+			}
+			for _, m := range fresh {
+				rs.indexTransactions(ctx, pipe, address, []*types.Transaction{m.Transaction})
+			}
+			return nil
+		})
+		if err == nil {
+			added = len(fresh)
+		}
+		return err
+	}
+	for attempt := 0; attempt < 5; attempt++ {
+		if err = rs.client.Watch(ctx, merge, key); err != redis.TxFailedErr {
+			break
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to backfill transactions, address %s, err %v", address, err)
+	}
+	return
+}
+
+func (rs *RedisStorage) GetTransactions(ctx context.Context, address string) ([]*types.Transaction, error) {
+	address = strings.ToLower(address)
+	var txs []*types.Transaction
+	if err := rs.readJson(ctx, rs.key("txs", address), &txs); err != nil {
+		return nil, fmt.Errorf("failed to read transactions of %s, err %v", address, err)
+	}
+	return txs, nil
+}
+
+func (rs *RedisStorage) QueryTransactions(ctx context.Context, address string, filter types.TransactionFilter) ([]*types.Transaction, error) {
+	txs, err := rs.GetTransactions(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	return types.FilterTransactions(address, txs, filter), nil
+}
+
+func (rs *RedisStorage) GetAddressStats(ctx context.Context, address string) (*types.AddressStats, error) {
+	txs, err := rs.GetTransactions(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	return addressStats(address, txs), nil
+}
+
+// scans the transactions of every address, meant for occasional lookups
+func (rs *RedisStorage) GetTransaction(ctx context.Context, hash string) (*types.Transaction, error) {
+	addresses, err := rs.client.SMembers(ctx, rs.key("addresses")).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transaction %s, err %v", hash, err)
+	}
+	var found *types.Transaction
+	for _, address := range addresses {
+		var txs []*types.Transaction
+		if err := rs.readJson(ctx, rs.key("txs", address), &txs); err != nil {
+			return nil, fmt.Errorf("failed to read transaction %s, err %v", hash, err)
+		}
+		for _, tx := range txs {
+			if tx.Hash == hash && (found == nil || tx.Kind == "") {
+				found = tx
+			}
+		}
+		if found != nil && found.Kind == "" {
+			break
+		}
+	}
+	return found, nil
+}
+
+func (rs *RedisStorage) SearchTransactions(ctx context.Context, search *types.TransactionSearch) ([]*types.Transaction, error) {
+	keys := searchQueryKeys(search)
+	if len(keys) == 0 {
+		return nil, ErrUnindexedSearch
+	}
+	sets := make([]map[string]bool, len(keys))
+	for i, key := range keys {
+		counts, err := rs.client.HGetAll(ctx, rs.key("search", key)).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to search transactions, err %v", err)
+		}
+		sets[i] = make(map[string]bool, len(counts))
+		for address, count := range counts {
+			if n, _ := strconv.Atoi(count); n > 0 {
+				sets[i][address] = true
+			}
+		}
+	}
+	var found []*types.Transaction
+	for _, address := range intersectAddresses(sets) {
+		txs, err := rs.GetTransactions(ctx, address)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, txs...)
+	}
+	return searchTransactions(found, search), nil
+}
+
+func (rs *RedisStorage) SaveTokenTransfers(ctx context.Context, transfers []*types.TokenTransfer) error {
+	var addresses []string
+	for _, t := range transfers {
+		addresses = append(addresses, strings.ToLower(t.From), strings.ToLower(t.To))
+	}
+	targets, err := rs.targets(ctx, addresses)
+	if err == nil {
+		since := firstBlock(transfers, tokenTransferEntry)
+		stored := newStoredEntries(tokenTransferEntry, func(address string) ([]*types.TokenTransfer, error) {
+			return listEntriesSince(ctx, rs, rs.key("tokens", address), since, tokenTransferEntry)
+		})
+		_, err = rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, t := range transfers {
+				from, to := strings.ToLower(t.From), strings.ToLower(t.To)
+				saveFrom, err := stored.isNew(targets[from], from, t)
+				if err != nil {
+					return err
+				}
+				saveTo, err := stored.isNew(targets[to], to, t)
+				if err != nil {
+					return err
+				}
+				if saveFrom {
+					slog.Info("New outgoing token transfer", "address", from, "block", types.BlockNumber(t.BlockNumber), "hash", t.TransactionHash, "token", t.Token)
+					if err := rs.appendJson(ctx, pipe, rs.key("tokens", from), t); err != nil {
+						return err
+					}
+				}
+				if saveTo {
+					slog.Info("New incoming token transfer", "address", to, "block", types.BlockNumber(t.BlockNumber), "hash", t.TransactionHash, "token", t.Token)
+					if err := rs.appendJson(ctx, pipe, rs.key("tokens", to), t); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save token transfers, err %v", err)
+	}
+	return nil
+}
+
+func (rs *RedisStorage) GetTokenTransfers(ctx context.Context, address string) ([]*types.TokenTransfer, error) {
+	address = strings.ToLower(address)
+	var transfers []*types.TokenTransfer
+	if err := rs.readJson(ctx, rs.key("tokens", address), &transfers); err != nil {
+		return nil, fmt.Errorf("failed to read token transfers of %s, err %v", address, err)
+	}
+	return transfers, nil
+}
+
+func (rs *RedisStorage) SaveNftTransfers(ctx context.Context, transfers []*types.NftTransfer) error {
+	var addresses []string
+	for _, t := range transfers {
+		addresses = append(addresses, strings.ToLower(t.From), strings.ToLower(t.To))
+	}
+	targets, err := rs.targets(ctx, addresses)
+	if err == nil {
+		since := firstBlock(transfers, nftTransferEntry)
+		stored := newStoredEntries(nftTransferEntry, func(address string) ([]*types.NftTransfer, error) {
+			return listEntriesSince(ctx, rs, rs.key("nfts", address), since, nftTransferEntry)
+		})
+		_, err = rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, t := range transfers {
+				from, to := strings.ToLower(t.From), strings.ToLower(t.To)
+				saveFrom, err := stored.isNew(targets[from], from, t)
+				if err != nil {
+					return err
+				}
+				saveTo, err := stored.isNew(targets[to], to, t)
+				if err != nil {
+					return err
+				}
+				if saveFrom {
+					slog.Info("New outgoing NFT transfer", "address", from, "block", types.BlockNumber(t.BlockNumber), "hash", t.TransactionHash, "contract", t.Contract, "tokenId", t.TokenId)
+					if err := rs.appendJson(ctx, pipe, rs.key("nfts", from), t); err != nil {
+						return err
+					}
+				}
+				if saveTo {
+					slog.Info("New incoming NFT transfer", "address", to, "block", types.BlockNumber(t.BlockNumber), "hash", t.TransactionHash, "contract", t.Contract, "tokenId", t.TokenId)
+					if err := rs.appendJson(ctx, pipe, rs.key("nfts", to), t); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save NFT transfers, err %v", err)
+	}
+	return nil
+}
+
+func (rs *RedisStorage) GetNftTransfers(ctx context.Context, address string) ([]*types.NftTransfer, error) {
+	address = strings.ToLower(address)
+	var transfers []*types.NftTransfer
+	if err := rs.readJson(ctx, rs.key("nfts", address), &transfers); err != nil {
+		return nil, fmt.Errorf("failed to read NFT transfers of %s, err %v", address, err)
+	}
+	return transfers, nil
+}
+
+// the watched contract events are members of a set, their logs lists by
+// contract
+func (rs *RedisStorage) AddEventSubscription(ctx context.Context, contract, topic string) (bool, error) {
+	key := eventSubscriptionKey(contract, topic)
+	added, err := rs.client.SAdd(ctx, rs.key("eventSubscriptions"), key).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to add event subscription %s, err %v", key, err)
+	}
+	return added == 1, nil
+}
+
+func (rs *RedisStorage) RemoveEventSubscription(ctx context.Context, contract, topic string) (bool, error) {
+	contract, topic = strings.ToLower(contract), strings.ToLower(topic)
+	key := eventSubscriptionKey(contract, topic)
+	removed, err := rs.client.SRem(ctx, rs.key("eventSubscriptions"), key).Result()
+	if err == nil && removed == 1 {
+		var events []*types.Event
+		if err = rs.readJson(ctx, rs.key("events", contract), &events); err == nil {
+			kept := withoutTopic(events, topic)
+			_, err = rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Del(ctx, rs.key("events", contract))
+				for _, e := range kept {
+					if err := rs.appendJson(ctx, pipe, rs.key("events", contract), e); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to remove event subscription %s, err %v", key, err)
+	}
+	return removed == 1, nil
+}
+
+func (rs *RedisStorage) GetEventSubscriptions(ctx context.Context) ([]*types.EventSubscription, error) {
+	keys, err := rs.client.SMembers(ctx, rs.key("eventSubscriptions")).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event subscriptions, err %v", err)
+	}
+	sort.Strings(keys)
+	subscriptions := make([]*types.EventSubscription, len(keys))
+	for i, key := range keys {
+		subscriptions[i] = parseEventSubscriptionKey(key)
+	}
+	return subscriptions, nil
+}
+
+// the contracts with watched events
+func (rs *RedisStorage) eventContracts(ctx context.Context) ([]string, error) {
+	subscriptions, err := rs.GetEventSubscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var contracts []string
+	for _, subscription := range subscriptions {
+		if !slices.Contains(contracts, subscription.Contract) {
+			contracts = append(contracts, subscription.Contract)
+		}
+	}
+	return contracts, nil
+}
+
+func (rs *RedisStorage) SaveEvents(ctx context.Context, events []*types.Event) error {
+	keys, err := rs.client.SMembers(ctx, rs.key("eventSubscriptions")).Result()
+	if err == nil {
+		watched := make(map[string]bool, len(keys))
+		for _, key := range keys {
+			watched[key] = true
+		}
+		since := firstBlock(events, eventEntry)
+		stored := newStoredEntries(eventEntry, func(contract string) ([]*types.Event, error) {
+			return listEntriesSince(ctx, rs, rs.key("events", contract), since, eventEntry)
+		})
+		_, err = rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, e := range events {
+				contract := strings.ToLower(e.Address)
+				save, err := stored.isNew(len(e.Topics) > 0 && watched[eventSubscriptionKey(contract, e.Topics[0])], contract, e)
+				if err != nil {
+					return err
+				}
+				if !save {
+					continue
+				}
+				slog.Info("New contract event", "contract", contract, "block", types.BlockNumber(e.BlockNumber), "hash", e.TransactionHash, "topic", e.Topics[0])
+				if err := rs.appendJson(ctx, pipe, rs.key("events", contract), e); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save events, err %v", err)
+	}
+	return nil
+}
+
+func (rs *RedisStorage) GetEvents(ctx context.Context, contract string) ([]*types.Event, error) {
+	contract = strings.ToLower(contract)
+	var events []*types.Event
+	if err := rs.readJson(ctx, rs.key("events", contract), &events); err != nil {
+		return nil, fmt.Errorf("failed to read events of %s, err %v", contract, err)
+	}
+	return events, nil
+}
+
+func (rs *RedisStorage) SaveBlockHash(ctx context.Context, block int, hash string) error {
+	err := rs.client.HSet(ctx, rs.key("blockHashes"), strconv.Itoa(block), hash).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save block hash, block %d, err %v", block, err)
+	}
+	return nil
+}
+
+func (rs *RedisStorage) GetBlockHash(ctx context.Context, block int) (string, error) {
+	hash, err := rs.client.HGet(ctx, rs.key("blockHashes"), strconv.Itoa(block)).Result()
+	if err != nil && err != redis.Nil {
+		return "", fmt.Errorf("failed to read block hash, block %d, err %v", block, err)
+	}
+	return hash, nil
+}
+
+func (rs *RedisStorage) PruneBlockHashes(ctx context.Context, before int) error {
+	if err := rs.deleteBlockHashes(ctx, func(block int) bool { return block < before }); err != nil {
+		return fmt.Errorf("failed to prune block hashes, err %v", err)
+	}
+	return nil
+}
+
+// the metadata are json members of the blocks sorted set, scored by block
+func (rs *RedisStorage) SaveBlock(ctx context.Context, block *types.BlockMetadata) error {
+	number := types.BlockNumber(block.Number)
+	data, err := json.Marshal(block)
+	if err == nil {
+		_, err = rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			score := strconv.Itoa(number)
+			pipe.ZRemRangeByScore(ctx, rs.key("blocks"), score, score)
+			pipe.ZAdd(ctx, rs.key("blocks"), redis.Z{Score: float64(number), Member: data})
+			return nil
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save block, block %d, err %v", number, err)
+	}
+	return nil
+}
+
+func (rs *RedisStorage) GetBlock(ctx context.Context, block int) (*types.BlockMetadata, error) {
+	blocks, err := rs.GetBlocks(ctx, block, block)
+	if err != nil || len(blocks) == 0 {
+		return nil, err
+	}
+	return blocks[0], nil
+}
+
+func (rs *RedisStorage) GetBlocks(ctx context.Context, from, to int) ([]*types.BlockMetadata, error) {
+	members, err := rs.client.ZRangeByScore(ctx, rs.key("blocks"), &redis.ZRangeBy{Min: strconv.Itoa(from), Max: strconv.Itoa(to)}).Result()
+	var blocks []*types.BlockMetadata
+	for _, member := range members {
+		if err != nil {
+			break
+		}
+		var block types.BlockMetadata
+		err = json.Unmarshal([]byte(member), &block)
+		blocks = append(blocks, &block)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blocks %d to %d, err %v", from, to, err)
+	}
+	return blocks, nil
+}
+
+func (rs *RedisStorage) Rollback(ctx context.Context, block int) error {
+	err := rs.deleteBlockHashes(ctx, func(b int) bool { return b > block })
+	if err == nil {
+		err = rs.client.ZRemRangeByScore(ctx, rs.key("blocks"), "("+strconv.Itoa(block), "+inf").Err()
+	}
+	if err == nil {
+		var addresses []string
+		addresses, err = rs.client.SMembers(ctx, rs.key("addresses")).Result()
+		for _, address := range addresses {
+			if err != nil {
+				break
+			}
+			for _, key := range []string{rs.key("txs", address), rs.key("tokens", address), rs.key("nfts", address)} {
+				if err = rs.truncateAfter(ctx, key, block, rs.unindexDropped(ctx, key, address)); err != nil {
+					break
+				}
+			}
+		}
+	}
+	if err == nil {
+		var contracts []string
+		contracts, err = rs.eventContracts(ctx)
+		for _, contract := range contracts {
+			if err = rs.truncateAfter(ctx, rs.key("events", contract), block, nil); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = rs.client.Set(ctx, rs.key("currentBlock"), block, 0).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to roll back to block %d, err %v", block, err)
+	}
+	return nil
+}
+
+func (rs *RedisStorage) deleteBlockHashes(ctx context.Context, stale func(block int) bool) error {
+	blocks, err := rs.client.HKeys(ctx, rs.key("blockHashes")).Result()
+	if err != nil {
+		return err
+	}
+	var fields []string
+	for _, b := range blocks {
+		if n, err := strconv.Atoi(b); err == nil && stale(n) {
+			fields = append(fields, b)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return rs.client.HDel(ctx, rs.key("blockHashes"), fields...).Err()
+}
+
+func (rs *RedisStorage) Prune(ctx context.Context, before, max int) (pruned int, err error) {
+	if before > 0 {
+		if err := rs.client.ZRemRangeByScore(ctx, rs.key("blocks"), "-inf", "("+strconv.Itoa(before)).Err(); err != nil {
+			return 0, fmt.Errorf("failed to prune storage, err %v", err)
+		}
+	}
+	addresses, err := rs.client.SMembers(ctx, rs.key("addresses")).Result()
+	for _, address := range addresses {
+		for _, key := range []string{rs.key("txs", address), rs.key("tokens", address), rs.key("nfts", address)} {
+			var n int
+			if n, err = rs.truncateBefore(ctx, key, before, max, rs.unindexDropped(ctx, key, address)); err != nil {
+				break
+			}
+			pruned += n
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		var contracts []string
+		contracts, err = rs.eventContracts(ctx)
+		for _, contract := range contracts {
+			var n int
+			if n, err = rs.truncateBefore(ctx, rs.key("events", contract), before, max, nil); err != nil {
+				break
+			}
+			pruned += n
+		}
+	}
+	if err != nil {
+		return pruned, fmt.Errorf("failed to prune storage, err %v", err)
+	}
+	return
+}
+
+// pop the leading entries of an address list recorded before the block and
+// all but the last max ones, passing them to dropped unless nil
+func (rs *RedisStorage) truncateBefore(ctx context.Context, key string, before, max int, dropped func(entries []string) error) (int, error) {
+	pruned := 0
+	if max > 0 {
+		length, err := rs.client.LLen(ctx, key).Result()
+		if err != nil {
+			return 0, err
+		}
+		if int(length) > max {
+			if dropped != nil {
+				entries, err := rs.client.LRange(ctx, key, 0, length-int64(max)-1).Result()
+				if err == nil {
+					err = dropped(entries)
+				}
+				if err != nil {
+					return 0, err
+				}
+			}
+			if err := rs.client.LTrim(ctx, key, int64(-max), -1).Err(); err != nil {
+				return 0, err
+			}
+			pruned = int(length) - max
+		}
+	}
+	for before > 0 {
+		first, err := rs.client.LIndex(ctx, key, 0).Result()
+		if err == redis.Nil {
+			break
+		}
+		if err != nil {
+			return pruned, err
+		}
+		b, err := entryBlock([]byte(first))
+		if err != nil {
+			return pruned, err
+		}
+		if b >= before {
+			break
+		}
+		if dropped != nil {
+			if err := dropped([]string{first}); err != nil {
+				return pruned, err
+			}
+		}
+		if err := rs.client.LPop(ctx, key).Err(); err != nil {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// pop the trailing entries of an address list recorded after the block,
+// passing them to dropped unless nil
+func (rs *RedisStorage) truncateAfter(ctx context.Context, key string, block int, dropped func(entries []string) error) error {
+	for {
+		last, err := rs.client.LIndex(ctx, key, -1).Result()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		b, err := entryBlock([]byte(last))
+		if err != nil {
+			return err
+		}
+		if b <= block {
+			return nil
+		}
+		if dropped != nil {
+			if err := dropped([]string{last}); err != nil {
+				return err
+			}
+		}
+		if err := rs.client.RPop(ctx, key).Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// count the transactions of the address in the search index, which expires
+// with the history of the address
+func (rs *RedisStorage) indexTransactions(ctx context.Context, pipe redis.Pipeliner, address string, txs []*types.Transaction) {
+	for _, tx := range txs {
+		for _, key := range searchKeys(tx) {
+			pipe.HIncrBy(ctx, rs.key("search", key), address, 1)
+			if rs.ttl > 0 {
+				pipe.Expire(ctx, rs.key("search", key), rs.ttl)
+			}
+		}
+	}
+}
+
+// uncount the dropped json transactions of the address in the search index,
+// the address goes from the keys none of its transactions has left
+func (rs *RedisStorage) unindexTransactions(ctx context.Context, address string, entries []string) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	var txs []*types.Transaction
+	if err := json.Unmarshal([]byte("["+strings.Join(entries, ",")+"]"), &txs); err != nil {
+		return err
+	}
+	var keys []string
+	counts, err := rs.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, tx := range txs {
+			for _, key := range searchKeys(tx) {
+				keys = append(keys, rs.key("search", key))
+				pipe.HIncrBy(ctx, rs.key("search", key), address, -1)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	_, err = rs.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, count := range counts {
+			if count.(*redis.IntCmd).Val() <= 0 {
+				pipe.HDel(ctx, keys[i], address)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// uncount the entries dropped from the list in the search index, nil unless
+// it is the transactions list of the address
+func (rs *RedisStorage) unindexDropped(ctx context.Context, key, address string) func(entries []string) error {
+	if key != rs.key("txs", address) {
+		return nil
+	}
+	return func(entries []string) error {
+		return rs.unindexTransactions(ctx, address, entries)
+	}
+}
+
+// the entries of the address list recorded in the block or later, read from
+// its tail in growing windows, earlier ones may lead them
+func listEntriesSince[E any](ctx context.Context, rs *RedisStorage, key string, block int, entry func(*E) (string, int)) ([]*E, error) {
+	for n := int64(16); ; n *= 2 {
+		raw, err := rs.client.LRange(ctx, key, -n, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		var entries []*E
+		if err := json.Unmarshal([]byte("["+strings.Join(raw, ",")+"]"), &entries); err != nil {
+			return nil, err
+		}
+		if int64(len(entries)) < n {
+			return entries, nil
+		}
+		if _, b := entry(entries[0]); b < block {
+			return entries, nil
+		}
+	}
+}
+
+// append the value as json to the list, refreshing its retention
+func (rs *RedisStorage) appendJson(ctx context.Context, pipe redis.Pipeliner, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	pipe.RPush(ctx, key, data)
+	if rs.ttl > 0 {
+		pipe.Expire(ctx, key, rs.ttl)
+	}
+	return nil
+}
+
+// decode the json entries of the list into the slice pointed to by v
+func (rs *RedisStorage) readJson(ctx context.Context, key string, v interface{}) error {
+	entries, err := rs.client.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte("["+strings.Join(entries, ",")+"]"), v)
+}
@@ -0,0 +1,347 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// version of the snapshot format, bumped on incompatible changes
+const snapshotVersion = 1
+
+// The json snapshot of a MemStorage, addresses without transactions keep a
+// null list
+type memSnapshot struct {
+	Version        int                               `json:"version"`
+	CurrentBlock   int                               `json:"currentBlock"`
+	Transactions   map[string][]*types.Transaction   `json:"transactions"`
+	TokenTransfers map[string][]*types.TokenTransfer `json:"tokenTransfers"`
+	NftTransfers   map[string][]*types.NftTransfer   `json:"nftTransfers"`
+	BlockHashes    map[int]string                    `json:"blockHashes"`
+	Blocks         []*types.BlockMetadata            `json:"blocks,omitempty"`
+	// the addresses of every tenant with its labels
+	Subscriptions map[string]map[string]*types.AddressLabel `json:"subscriptions,omitempty"`
+	// the labels of snapshots taken before tenants, every address was the
+	// default tenant's then
+	Labels map[string]*types.AddressLabel `json:"labels,omitempty"`
+	// watched contract events and their logs by contract
+	EventSubscriptions []*types.EventSubscription `json:"eventSubscriptions,omitempty"`
+	Events             map[string][]*types.Event  `json:"events,omitempty"`
+	// the groups of every tenant
+	Groups map[string][]*types.Group `json:"groups,omitempty"`
+}
+
+// write the whole storage as json, the parser keeps going meanwhile
+func (ms *MemStorage) Export(w io.Writer) error {
+	ms.RLock()
+	unlockShards := ms.rlockAllShards()
+	// saved entries never change, cloning the lists is enough to write them
+	// without holding the lock, rollbacks reuse their arrays
+	snapshot := &memSnapshot{
+		Version:        snapshotVersion,
+		CurrentBlock:   ms.currentBlock,
+		Transactions:   make(map[string][]*types.Transaction),
+		TokenTransfers: make(map[string][]*types.TokenTransfer),
+		NftTransfers:   make(map[string][]*types.NftTransfer),
+		BlockHashes:    make(map[int]string, len(ms.blockHashes)),
+		Blocks:         slices.Clone(ms.blocks),
+		Subscriptions:  make(map[string]map[string]*types.AddressLabel, len(ms.subscriptions)),
+		Events:         make(map[string][]*types.Event, len(ms.events)),
+		Groups:         make(map[string][]*types.Group, len(ms.groups)),
+	}
+	for _, shard := range ms.shards {
+		for address, txs := range shard.txs {
+			snapshot.Transactions[address] = slices.Clone(txs)
+		}
+		for address, transfers := range shard.tokenTransfers {
+			snapshot.TokenTransfers[address] = slices.Clone(transfers)
+		}
+		for address, transfers := range shard.nftTransfers {
+			snapshot.NftTransfers[address] = slices.Clone(transfers)
+		}
+	}
+	for block, hash := range ms.blockHashes {
+		snapshot.BlockHashes[block] = hash
+	}
+	for key := range ms.eventSubscriptions {
+		snapshot.EventSubscriptions = append(snapshot.EventSubscriptions, parseEventSubscriptionKey(key))
+	}
+	for contract, events := range ms.events {
+		snapshot.Events[contract] = slices.Clone(events)
+	}
+	// labels are replaced whole on every change, never edited
+	for tenant, addresses := range ms.subscriptions {
+		snapshot.Subscriptions[tenant] = maps.Clone(addresses)
+	}
+	for tenant, groups := range ms.groups {
+		snapshot.Groups[tenant] = memGroups(groups)
+	}
+	unlockShards()
+	ms.RUnlock()
+	return json.NewEncoder(w).Encode(snapshot)
+}
+
+// replace the whole storage with an exported snapshot, nothing changes when
+// it fails to decode
+func (ms *MemStorage) Import(r io.Reader) error {
+	var snapshot memSnapshot
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return fmt.Errorf("failed to decode snapshot, err %v", err)
+	}
+	if snapshot.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d, expected %d", snapshot.Version, snapshotVersion)
+	}
+	restored := NewMemStorage()
+	restored.currentBlock = snapshot.CurrentBlock
+	for address, txs := range snapshot.Transactions {
+		restored.shard(address).txs[address] = txs
+		restored.shard(address).index(address, txs, 1)
+	}
+	for address, transfers := range snapshot.TokenTransfers {
+		restored.shard(address).tokenTransfers[address] = transfers
+	}
+	for address, transfers := range snapshot.NftTransfers {
+		restored.shard(address).nftTransfers[address] = transfers
+	}
+	for block, hash := range snapshot.BlockHashes {
+		restored.blockHashes[block] = hash
+	}
+	for tenant, addresses := range snapshot.Subscriptions {
+		restored.subscriptions[tenant] = addresses
+	}
+	if snapshot.Subscriptions == nil && len(snapshot.Transactions) > 0 {
+		addresses := make(map[string]*types.AddressLabel, len(snapshot.Transactions))
+		for address := range snapshot.Transactions {
+			addresses[address] = snapshot.Labels[address]
+		}
+		restored.subscriptions[""] = addresses
+	}
+	for _, subscription := range snapshot.EventSubscriptions {
+		restored.eventSubscriptions[eventSubscriptionKey(subscription.Contract, subscription.Topic)] = true
+	}
+	for contract, events := range snapshot.Events {
+		restored.events[contract] = events
+	}
+	for tenant, groups := range snapshot.Groups {
+		restored.groups[tenant] = make(map[string]map[string]bool, len(groups))
+		for _, group := range groups {
+			addresses := make(map[string]bool, len(group.Addresses))
+			for _, address := range group.Addresses {
+				addresses[address] = true
+			}
+			restored.groups[tenant][group.Name] = addresses
+		}
+	}
+	restored.blocks = snapshot.Blocks
+	ms.Lock()
+	defer ms.Unlock()
+	unlockShards := ms.lockAllShards()
+	defer unlockShards()
+	// the shards stay, readers find them without the storage lock
+	for i, shard := range ms.shards {
+		shard.txs, shard.tokenTransfers, shard.nftTransfers = restored.shards[i].txs, restored.shards[i].tokenTransfers, restored.shards[i].nftTransfers
+		shard.search = restored.shards[i].search
+	}
+	ms.currentBlock, ms.blockHashes = restored.currentBlock, restored.blockHashes
+	ms.blocks, ms.subscriptions = restored.blocks, restored.subscriptions
+	ms.eventSubscriptions, ms.events = restored.eventSubscriptions, restored.events
+	ms.groups = restored.groups
+	return nil
+}
+
+// copy the subscriptions of every tenant with their labels and groups, the
+// transactions and transfers of the target addresses, the watched contract
+// events with their logs, the block metadata, the hashes of the last blocks
+// and the current block of src into dst, e.g. to export any backend as a mem
+// snapshot or import one into it, stops at the first failure of either
+func Copy(ctx context.Context, dst, src StorageProvider, blocks int) error {
+	addresses, err := copySubscriptions(ctx, dst, src)
+	if err != nil {
+		return err
+	}
+	tokens := make(map[string]*types.TokenTransfer)
+	nfts := make(map[string]*types.NftTransfer)
+	for _, address := range addresses {
+		txs, err := src.GetTransactions(ctx, address)
+		if err != nil {
+			return err
+		}
+		var matches []*types.MatchedTransaction
+		// a self transfer is stored twice, outgoing then incoming
+		self := make(map[string]bool)
+		for _, tx := range txs {
+			direction := types.DirectionIn
+			if strings.ToLower(tx.From) == address && (strings.ToLower(tx.To) != address || !self[tx.Key()]) {
+				direction = types.DirectionOut
+				self[tx.Key()] = true
+			}
+			matches = append(matches, &types.MatchedTransaction{Address: address, Direction: direction, Block: types.BlockNumber(tx.BlockNumber), Transaction: tx})
+		}
+		if len(matches) > 0 {
+			if _, err := dst.BackfillTransactions(ctx, address, matches); err != nil {
+				return err
+			}
+		}
+		// transfers between two target addresses are listed under both
+		transfers, err := src.GetTokenTransfers(ctx, address)
+		if err != nil {
+			return err
+		}
+		for _, transfer := range transfers {
+			key, _ := tokenTransferEntry(transfer)
+			tokens[key] = transfer
+		}
+		nftTransfers, err := src.GetNftTransfers(ctx, address)
+		if err != nil {
+			return err
+		}
+		for _, transfer := range nftTransfers {
+			key, _ := nftTransferEntry(transfer)
+			nfts[key] = transfer
+		}
+	}
+	if err := dst.SaveTokenTransfers(ctx, sortedTransfers(tokens, func(t *types.TokenTransfer) string { return t.BlockNumber })); err != nil {
+		return err
+	}
+	if err := dst.SaveNftTransfers(ctx, sortedTransfers(nfts, func(t *types.NftTransfer) string { return t.BlockNumber })); err != nil {
+		return err
+	}
+	if err := copyEvents(ctx, dst, src); err != nil {
+		return err
+	}
+	if err := copyGroups(ctx, dst, src); err != nil {
+		return err
+	}
+	current, err := src.GetCurrentBlock(ctx)
+	if err != nil {
+		return err
+	}
+	metadata, err := src.GetBlocks(ctx, 0, current)
+	if err != nil {
+		return err
+	}
+	for _, block := range metadata {
+		if err := dst.SaveBlock(ctx, block); err != nil {
+			return err
+		}
+	}
+	for block := max(current-blocks+1, 1); block <= current; block++ {
+		hash, err := src.GetBlockHash(ctx, block)
+		if err != nil {
+			return err
+		}
+		if hash != "" {
+			if err := dst.SaveBlockHash(ctx, block, hash); err != nil {
+				return err
+			}
+		}
+	}
+	return dst.SetCurrentBlock(ctx, current)
+}
+
+// copy the subscriptions and labels of every tenant, returns the target
+// addresses, sorted
+func copySubscriptions(ctx context.Context, dst, src StorageProvider) ([]string, error) {
+	tenants, err := src.GetTenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var addresses []string
+	seen := make(map[string]bool)
+	for _, tenant := range tenants {
+		subscriptions, err := src.GetSubscriptions(ctx, tenant)
+		if err != nil {
+			return nil, err
+		}
+		for _, subscription := range subscriptions {
+			address := subscription.Address
+			if _, err := dst.AddTargetAddress(ctx, tenant, address); err != nil {
+				return nil, err
+			}
+			label, err := src.GetAddressLabel(ctx, tenant, address)
+			if err != nil {
+				return nil, err
+			}
+			if label != nil {
+				if _, err := dst.SetAddressLabel(ctx, tenant, address, label); err != nil {
+					return nil, err
+				}
+			}
+			if !seen[address] {
+				seen[address] = true
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+// copy the watched contract events, then the logs of every contract
+func copyEvents(ctx context.Context, dst, src StorageProvider) error {
+	subscriptions, err := src.GetEventSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	contracts := make(map[string]bool)
+	for _, subscription := range subscriptions {
+		if _, err := dst.AddEventSubscription(ctx, subscription.Contract, subscription.Topic); err != nil {
+			return err
+		}
+		contracts[subscription.Contract] = true
+	}
+	for contract := range contracts {
+		events, err := src.GetEvents(ctx, contract)
+		if err != nil {
+			return err
+		}
+		if err := dst.SaveEvents(ctx, events); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copy the groups of every tenant subscribed to an address
+func copyGroups(ctx context.Context, dst, src StorageProvider) error {
+	tenants, err := src.GetTenants(ctx)
+	if err != nil {
+		return err
+	}
+	for _, tenant := range tenants {
+		groups, err := src.GetGroups(ctx, tenant)
+		if err != nil {
+			return err
+		}
+		for _, group := range groups {
+			if _, err := dst.CreateGroup(ctx, tenant, group.Name); err != nil {
+				return err
+			}
+			for _, address := range group.Addresses {
+				if _, err := dst.AddGroupAddress(ctx, tenant, group.Name, address); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// the transfers ordered by block
+func sortedTransfers[T any](transfers map[string]T, blockNumber func(T) string) []T {
+	sorted := make([]T, 0, len(transfers))
+	for _, transfer := range transfers {
+		sorted = append(sorted, transfer)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return types.BlockNumber(blockNumber(sorted[i])) < types.BlockNumber(blockNumber(sorted[j]))
+	})
+	return sorted
+}
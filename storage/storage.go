@@ -0,0 +1,432 @@
+// Package storage holds the StorageProvider implementations of the parser
+package storage
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// The storage of a parser, every call takes the context of its caller and
+// reports the failures of the backend, e.g. a lost database connection
+type StorageProvider interface {
+	// subscribes the tenant to the address, false when it is already; an
+	// address is a target while any tenant is subscribed to it, the empty
+	// tenant is the default one
+	AddTargetAddress(ctx context.Context, tenant, address string) (bool, error)
+	// unsubscribes the tenant with its label, the stored activity of the
+	// address is dropped with the last tenant, false when not subscribed
+	RemoveTargetAddress(ctx context.Context, tenant, address string) (bool, error)
+	// whether any tenant is subscribed to the address
+	HasTargetAddress(ctx context.Context, address string) (bool, error)
+	// whether the tenant is subscribed to the address
+	IsSubscribed(ctx context.Context, tenant, address string) (bool, error)
+	// attaches the label of the tenant to the address, replacing the
+	// previous one, false when the tenant is not subscribed to it
+	SetAddressLabel(ctx context.Context, tenant, address string, label *types.AddressLabel) (bool, error)
+	// the label the tenant attached to the address, nil without one
+	GetAddressLabel(ctx context.Context, tenant, address string) (*types.AddressLabel, error)
+	// the addresses of the tenant with their stats, ordered by address
+	GetSubscriptions(ctx context.Context, tenant string) ([]*types.Subscription, error)
+	// the tenants subscribed to any address, sorted
+	GetTenants(ctx context.Context) ([]string, error)
+	// creates the empty group of the tenant, false when it exists already
+	CreateGroup(ctx context.Context, tenant, group string) (bool, error)
+	// deletes the group of the tenant, its addresses stay subscribed, false
+	// when it doesn't exist
+	DeleteGroup(ctx context.Context, tenant, group string) (bool, error)
+	// adds the address to the group of the tenant, false when it is in it
+	// already or the group doesn't exist
+	AddGroupAddress(ctx context.Context, tenant, group, address string) (bool, error)
+	// removes the address from the group of the tenant, false when it isn't
+	// in it
+	RemoveGroupAddress(ctx context.Context, tenant, group, address string) (bool, error)
+	// the group of the tenant with its addresses, nil when it doesn't exist
+	GetGroup(ctx context.Context, tenant, group string) (*types.Group, error)
+	// the groups of the tenant with their addresses, ordered by name
+	GetGroups(ctx context.Context, tenant string) ([]*types.Group, error)
+	// saves the transactions touching target addresses, returns the matches;
+	// those already stored, e.g. of a block parsed again after a restart, are
+	// skipped and not matched again; the current block is left alone
+	SaveTransactions(ctx context.Context, block int, txs []*types.Transaction) ([]*types.MatchedTransaction, error)
+	// merges historical transactions of the target address in block order,
+	// skipping those already stored, the current block is left alone, returns
+	// how many were added
+	BackfillTransactions(ctx context.Context, address string, matches []*types.MatchedTransaction) (int, error)
+	GetTransactions(ctx context.Context, address string) ([]*types.Transaction, error)
+	QueryTransactions(ctx context.Context, address string, filter types.TransactionFilter) ([]*types.Transaction, error)
+	// the aggregates of the stored transactions of the address, zero when it
+	// has none
+	GetAddressStats(ctx context.Context, address string) (*types.AddressStats, error)
+	// the stored transaction with the lowercase hash, nil when no target
+	// address has it; internal transactions sharing the hash come second
+	GetTransaction(ctx context.Context, hash string) (*types.Transaction, error)
+	// the stored transactions of every address passing the search, each
+	// once, in block order; looked up in indexes by hash, addresses and
+	// block, ErrUnindexedSearch without any of them
+	SearchTransactions(ctx context.Context, search *types.TransactionSearch) ([]*types.Transaction, error)
+	// save the transfers touching target addresses, skipping those already
+	// stored by transaction hash and log index
+	SaveTokenTransfers(ctx context.Context, transfers []*types.TokenTransfer) error
+	GetTokenTransfers(ctx context.Context, address string) ([]*types.TokenTransfer, error)
+	SaveNftTransfers(ctx context.Context, transfers []*types.NftTransfer) error
+	GetNftTransfers(ctx context.Context, address string) ([]*types.NftTransfer, error)
+	// watches the logs of the contract with the lowercase topic0, false when
+	// already watched
+	AddEventSubscription(ctx context.Context, contract, topic string) (bool, error)
+	// stops watching them and drops their stored events, false when they
+	// weren't watched
+	RemoveEventSubscription(ctx context.Context, contract, topic string) (bool, error)
+	// the watched contract events, ordered by contract and topic
+	GetEventSubscriptions(ctx context.Context) ([]*types.EventSubscription, error)
+	// save the logs of watched contract events, skipping those already stored
+	// by transaction hash and log index
+	SaveEvents(ctx context.Context, events []*types.Event) error
+	// the stored events of the contract, in block order
+	GetEvents(ctx context.Context, contract string) ([]*types.Event, error)
+	GetCurrentBlock(ctx context.Context) (int, error)
+	// moves the current block without touching what is stored, in a single
+	// atomic write; the parser checkpoints a block with it once everything of
+	// the block is stored, so a block cut short by a crash is parsed again
+	SetCurrentBlock(ctx context.Context, block int) error
+	// hashes of recently parsed blocks, used to detect reorgs
+	SaveBlockHash(ctx context.Context, block int, hash string) error
+	GetBlockHash(ctx context.Context, block int) (string, error)
+	PruneBlockHashes(ctx context.Context, before int) error
+	// the metadata of every parsed block, replacing the one of a block parsed
+	// again, kept until pruned or rolled back
+	SaveBlock(ctx context.Context, block *types.BlockMetadata) error
+	// the metadata of the block, nil when it isn't stored
+	GetBlock(ctx context.Context, block int) (*types.BlockMetadata, error)
+	// the metadata of the stored blocks from..to, in block order
+	GetBlocks(ctx context.Context, from, to int) ([]*types.BlockMetadata, error)
+	// drops everything stored after the block and rewinds the current block to it
+	Rollback(ctx context.Context, block int) error
+	// drops the transactions and transfers of each address, and the events of
+	// each contract, recorded before the block and all but the last max ones, 0
+	// disables either limit, returns how many were dropped; the metadata of the
+	// blocks before it goes too
+	Prune(ctx context.Context, before, max int) (int, error)
+	// flushes and releases the storage, called once on shutdown
+	Close() error
+}
+
+// A storage several processes share, with the leases electing the one of
+// them writing to it; the mem, redis and postgres storages have them
+type Leases interface {
+	// takes the lease for the holder until ttl from now, or extends it when
+	// the holder has it already; false while another holder has it
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	// gives up the lease when the holder has it, for another one to take it
+	// right away
+	ReleaseLease(ctx context.Context, name, holder string) error
+}
+
+// the search has neither a hash, an address nor a block to look up
+var ErrUnindexedSearch = errors.New("search needs a hash, from, to or block")
+
+// the key and block of a stored transaction
+func transactionEntry(tx *types.Transaction) (string, int) {
+	return tx.Key(), types.BlockNumber(tx.BlockNumber)
+}
+
+// the keys of the search indexes a stored transaction is found by
+func searchKeys(tx *types.Transaction) []string {
+	keys := []string{"hash:" + tx.Hash, "block:" + strconv.Itoa(types.BlockNumber(tx.BlockNumber))}
+	if tx.From != "" {
+		keys = append(keys, "from:"+strings.ToLower(tx.From))
+	}
+	if tx.To != "" {
+		keys = append(keys, "to:"+strings.ToLower(tx.To))
+	}
+	return keys
+}
+
+// the index keys a transaction passing the search has, none when it can't
+// be looked up
+func searchQueryKeys(search *types.TransactionSearch) []string {
+	var keys []string
+	if search.Hash != "" {
+		keys = append(keys, "hash:"+search.Hash)
+	}
+	if search.Block != 0 {
+		keys = append(keys, "block:"+strconv.Itoa(search.Block))
+	}
+	if search.From != "" {
+		keys = append(keys, "from:"+search.From)
+	}
+	if search.To != "" {
+		keys = append(keys, "to:"+search.To)
+	}
+	return keys
+}
+
+// the addresses in every one of the sets, sorted
+func intersectAddresses[V any](sets []map[string]V) []string {
+	var addresses []string
+	if len(sets) == 0 {
+		return addresses
+	}
+	for address := range sets[0] {
+		in := true
+		for _, set := range sets[1:] {
+			_, ok := set[address]
+			in = in && ok
+		}
+		if in {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// the transactions the index found passing the search, each once in block
+// order, a transaction between two target addresses is stored for both
+func searchTransactions(txs []*types.Transaction, search *types.TransactionSearch) []*types.Transaction {
+	matched := []*types.Transaction{}
+	seen := make(map[string]bool)
+	for _, tx := range txs {
+		if key := tx.Key(); search.Match(tx) && !seen[key] {
+			seen[key] = true
+			matched = append(matched, tx)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if types.BlockNumber(a.BlockNumber) != types.BlockNumber(b.BlockNumber) {
+			return types.BlockNumber(a.BlockNumber) < types.BlockNumber(b.BlockNumber)
+		}
+		return types.BlockNumber(a.TransactionIndex) < types.BlockNumber(b.TransactionIndex)
+	})
+	if search.Limit > 0 && len(matched) > search.Limit {
+		matched = matched[:search.Limit]
+	}
+	return matched
+}
+
+// the key and block of a stored token transfer, a log moves one token
+func tokenTransferEntry(t *types.TokenTransfer) (string, int) {
+	return t.TransactionHash + "/" + t.LogIndex, types.BlockNumber(t.BlockNumber)
+}
+
+// the key and block of a stored NFT transfer, a TransferBatch log moves
+// several tokens
+func nftTransferEntry(t *types.NftTransfer) (string, int) {
+	return t.TransactionHash + "/" + t.LogIndex + "/" + t.TokenId, types.BlockNumber(t.BlockNumber)
+}
+
+// the key and block of a stored contract event
+func eventEntry(e *types.Event) (string, int) {
+	return e.TransactionHash + "/" + e.LogIndex, types.BlockNumber(e.BlockNumber)
+}
+
+// the key of a watched contract event
+func eventSubscriptionKey(contract, topic string) string {
+	return strings.ToLower(contract) + "/" + strings.ToLower(topic)
+}
+
+// the watched contract event of a key
+func parseEventSubscriptionKey(key string) *types.EventSubscription {
+	contract, topic, _ := strings.Cut(key, "/")
+	return &types.EventSubscription{Contract: contract, Topic: topic}
+}
+
+// the events without those of the topic
+func withoutTopic(events []*types.Event, topic string) []*types.Event {
+	var kept []*types.Event
+	for _, e := range events {
+		if len(e.Topics) == 0 || strings.ToLower(e.Topics[0]) != topic {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// whether the entry is among the stored ones of its address, e.g. when a
+// block is parsed again after a restart, they are in block order so the
+// search stops at the first of an earlier block
+func isStored[E any](stored []*E, e *E, entry func(*E) (string, int)) bool {
+	key, block := entry(e)
+	for i := len(stored) - 1; i >= 0; i-- {
+		k, b := entry(stored[i])
+		if b < block {
+			break
+		}
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// the stored entries with the new one, kept in block order when it is of an
+// earlier block than the last, e.g. a dead-lettered block retried after the
+// ones past it; a new slice then, readers may still hold the stored one
+func appendInOrder[E any](stored []*E, e *E, entry func(*E) (string, int)) []*E {
+	_, block := entry(e)
+	i := len(stored)
+	for i > 0 {
+		if _, b := entry(stored[i-1]); b <= block {
+			break
+		}
+		i--
+	}
+	if i == len(stored) {
+		return append(stored, e)
+	}
+	return slices.Insert(slices.Clip(stored), i, e)
+}
+
+// the lowest block of the entries
+func firstBlock[E any](entries []*E, entry func(*E) (string, int)) int {
+	first := 0
+	for i, e := range entries {
+		if _, block := entry(e); i == 0 || block < first {
+			first = block
+		}
+	}
+	return first
+}
+
+// The entries stored per address from the first block of a save on, loaded
+// once per address before the save appends to it, so a self transfer is
+// still stored both ways
+type storedEntries[E any] struct {
+	entry  func(*E) (string, int)
+	load   func(address string) ([]*E, error)
+	loaded map[string][]*E
+}
+
+func newStoredEntries[E any](entry func(*E) (string, int), load func(address string) ([]*E, error)) *storedEntries[E] {
+	return &storedEntries[E]{entry: entry, load: load, loaded: make(map[string][]*E)}
+}
+
+// whether the entry is new to the address, false when the address isn't a
+// target or had it stored before the save
+func (s *storedEntries[E]) isNew(target bool, address string, e *E) (bool, error) {
+	if !target {
+		return false, nil
+	}
+	stored, ok := s.loaded[address]
+	if !ok {
+		var err error
+		if stored, err = s.load(address); err != nil {
+			return false, err
+		}
+		s.loaded[address] = stored
+	}
+	return !isStored(stored, e, s.entry), nil
+}
+
+// the matches not in the stored transactions, a self transfer matched twice is
+// added twice like SaveTransactions does
+func newMatches(stored []*types.Transaction, matches []*types.MatchedTransaction) []*types.MatchedTransaction {
+	keys := make(map[string]bool, len(stored))
+	for _, tx := range stored {
+		keys[tx.Key()] = true
+	}
+	var fresh []*types.MatchedTransaction
+	for _, m := range matches {
+		if !keys[m.Transaction.Key()] {
+			fresh = append(fresh, m)
+		}
+	}
+	return fresh
+}
+
+// the stored transactions with the new ones, ordered by block
+func mergeTransactions(stored []*types.Transaction, fresh []*types.MatchedTransaction) []*types.Transaction {
+	merged := make([]*types.Transaction, 0, len(stored)+len(fresh))
+	merged = append(merged, stored...)
+	for _, m := range fresh {
+		merged = append(merged, m.Transaction)
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return types.BlockNumber(merged[i].BlockNumber) < types.BlockNumber(merged[j].BlockNumber)
+	})
+	return merged
+}
+
+// The aggregates of the transactions of an address as they are added
+type statsAccumulator struct {
+	stats          types.AddressStats
+	received, sent big.Int
+	gasPrices      big.Int
+	priced         int
+}
+
+// count the transaction in the direction, a self transfer is added once per
+// direction
+func (a *statsAccumulator) add(direction string, block int, tx *types.Transaction) {
+	if a.stats.IncomingCount+a.stats.OutgoingCount == 0 || block < a.stats.FirstBlock {
+		a.stats.FirstBlock = block
+	}
+	a.stats.LastBlock = max(a.stats.LastBlock, block)
+	// a failed transaction moved no value but still paid for its gas
+	value := tx.ValueWei()
+	if tx.Status == "0x0" || value == nil {
+		value = new(big.Int)
+	}
+	if direction == types.DirectionIn {
+		a.stats.IncomingCount++
+		a.received.Add(&a.received, value)
+		return
+	}
+	a.stats.OutgoingCount++
+	a.sent.Add(&a.sent, value)
+	price := tx.GasPriceWei()
+	if tx.EffectiveGasPrice != "" {
+		price = types.ParseQuantity(tx.EffectiveGasPrice)
+	}
+	// internal transactions pay no gas of their own
+	if tx.Kind == "" && price != nil {
+		a.gasPrices.Add(&a.gasPrices, price)
+		a.priced++
+	}
+}
+
+func (a *statsAccumulator) result() *types.AddressStats {
+	stats := a.stats
+	stats.TotalReceived = "0x" + a.received.Text(16)
+	stats.TotalSent = "0x" + a.sent.Text(16)
+	if a.priced > 0 {
+		average := new(big.Int).Div(&a.gasPrices, big.NewInt(int64(a.priced)))
+		stats.AverageGasPrice = "0x" + average.Text(16)
+	}
+	return &stats
+}
+
+// the aggregates of the stored transactions of the address, a self transfer
+// is stored twice and counted once each way
+func addressStats(address string, txs []*types.Transaction) *types.AddressStats {
+	address = strings.ToLower(address)
+	var a statsAccumulator
+	self := make(map[string]bool)
+	for _, tx := range txs {
+		block := types.BlockNumber(tx.BlockNumber)
+		switch tx.RelativeTo(address).Direction {
+		case types.DirectionIn:
+			a.add(types.DirectionIn, block, tx)
+		case types.DirectionOut:
+			a.add(types.DirectionOut, block, tx)
+		case types.DirectionSelf:
+			// the second copy is the inbound one
+			direction := types.DirectionOut
+			if key := tx.Key(); self[key] {
+				direction = types.DirectionIn
+			} else {
+				self[key] = true
+			}
+			a.add(direction, block, tx)
+		}
+	}
+	return a.result()
+}
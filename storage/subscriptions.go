@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/passwizards/eth-parser/types"
+)
+
+// formats of the subscriptions files
+const (
+	SubscriptionsJson = "json"
+	SubscriptionsCsv  = "csv"
+)
+
+// the columns of the csv format, metadata and rule hold json
+var subscriptionColumns = []string{"tenant", "address", "label", "metadata", "rule", "expires"}
+
+// The json format of the subscriptions files
+type subscriptionsFile struct {
+	Subscriptions []*types.SubscriptionRecord `json:"subscriptions"`
+}
+
+// the format of a subscriptions file by its extension or content type, json
+// unless it names csv
+func SubscriptionsFormat(name string) string {
+	if strings.HasSuffix(strings.ToLower(name), ".csv") || strings.HasPrefix(name, "text/csv") {
+		return SubscriptionsCsv
+	}
+	return SubscriptionsJson
+}
+
+// the subscriptions of every tenant with their labels, ordered by tenant then
+// address
+func ExportSubscriptions(ctx context.Context, s StorageProvider) ([]*types.SubscriptionRecord, error) {
+	tenants, err := s.GetTenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(tenants)
+	records := []*types.SubscriptionRecord{}
+	for _, tenant := range tenants {
+		subscriptions, err := s.GetSubscriptions(ctx, tenant)
+		if err != nil {
+			return nil, err
+		}
+		slices.SortFunc(subscriptions, func(a, b *types.Subscription) int { return strings.Compare(a.Address, b.Address) })
+		for _, subscription := range subscriptions {
+			records = append(records, &types.SubscriptionRecord{
+				Tenant:   tenant,
+				Address:  types.ChecksumAddress(subscription.Address),
+				Label:    subscription.Label,
+				Metadata: subscription.Metadata,
+				Rule:     subscription.Rule,
+				Expires:  subscription.Expires,
+			})
+		}
+	}
+	return records, nil
+}
+
+// subscribe the tenants of the records to their addresses, replacing the
+// labels of the ones with a label, metadata, rule or expiry; returns how many
+// were not subscribed before
+func ImportSubscriptions(ctx context.Context, s StorageProvider, records []*types.SubscriptionRecord) (int, error) {
+	added := 0
+	for _, record := range records {
+		address, err := types.NormalizeAddress(record.Address)
+		if err != nil {
+			return added, err
+		}
+		ok, err := s.AddTargetAddress(ctx, record.Tenant, address)
+		if err != nil {
+			return added, err
+		}
+		if ok {
+			added++
+		}
+		if record.Label == "" && record.Metadata == nil && record.Rule == nil && record.Expires == nil {
+			continue
+		}
+		label := &types.AddressLabel{Label: record.Label, Metadata: record.Metadata, Rule: record.Rule, Expires: record.Expires}
+		if _, err := s.SetAddressLabel(ctx, record.Tenant, address, label); err != nil {
+			return added, err
+		}
+	}
+	return added, nil
+}
+
+// write the records as json or csv
+func WriteSubscriptions(w io.Writer, format string, records []*types.SubscriptionRecord) error {
+	if format != SubscriptionsCsv {
+		return json.NewEncoder(w).Encode(&subscriptionsFile{Subscriptions: records})
+	}
+	cw := csv.NewWriter(w)
+	cw.Write(subscriptionColumns)
+	for _, record := range records {
+		var metadata, rule, expires string
+		if record.Metadata != nil {
+			b, err := json.Marshal(record.Metadata)
+			if err != nil {
+				return fmt.Errorf("failed to encode metadata of %s, err %v", record.Address, err)
+			}
+			metadata = string(b)
+		}
+		if record.Rule != nil {
+			b, _ := json.Marshal(record.Rule)
+			rule = string(b)
+		}
+		if record.Expires != nil {
+			expires = record.Expires.UTC().Format(time.RFC3339)
+		}
+		cw.Write([]string{record.Tenant, record.Address, record.Label, metadata, rule, expires})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// read and check the records of a json or csv file; a csv one needs a header
+// row with at least the address column, the other ones are optional
+func ReadSubscriptions(r io.Reader, format string) ([]*types.SubscriptionRecord, error) {
+	var records []*types.SubscriptionRecord
+	if format != SubscriptionsCsv {
+		var file subscriptionsFile
+		if err := json.NewDecoder(r).Decode(&file); err != nil {
+			return nil, fmt.Errorf("invalid subscriptions, err %v", err)
+		}
+		records = file.Subscriptions
+	} else {
+		var err error
+		if records, err = readSubscriptionsCsv(r); err != nil {
+			return nil, err
+		}
+	}
+	for i, record := range records {
+		if record == nil {
+			return nil, fmt.Errorf("invalid subscription %d, null", i+1)
+		}
+		address, err := types.NormalizeAddress(record.Address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subscription %d, err %v", i+1, err)
+		}
+		record.Address = address
+		if record.Rule != nil {
+			if err := record.Rule.Normalize(); err != nil {
+				return nil, fmt.Errorf("invalid rule of subscription %d, err %v", i+1, err)
+			}
+		}
+	}
+	return records, nil
+}
+
+func readSubscriptionsCsv(r io.Reader) ([]*types.SubscriptionRecord, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid subscriptions, missing header, err %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+	if _, ok := columns["address"]; !ok {
+		return nil, errors.New("invalid subscriptions, missing address column")
+	}
+	records := []*types.SubscriptionRecord{}
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid subscriptions, err %v", err)
+		}
+		column := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		record := &types.SubscriptionRecord{Tenant: column("tenant"), Address: column("address"), Label: column("label")}
+		if metadata := column("metadata"); metadata != "" {
+			if err := json.Unmarshal([]byte(metadata), &record.Metadata); err != nil {
+				return nil, fmt.Errorf("invalid metadata on line %d, err %v", line, err)
+			}
+		}
+		if rule := column("rule"); rule != "" {
+			if err := json.Unmarshal([]byte(rule), &record.Rule); err != nil {
+				return nil, fmt.Errorf("invalid rule on line %d, err %v", line, err)
+			}
+		}
+		if expires := column("expires"); expires != "" {
+			t, err := time.Parse(time.RFC3339, expires)
+			if err != nil {
+				return nil, fmt.Errorf("invalid expires %q on line %d, expected an RFC 3339 time", expires, line)
+			}
+			record.Expires = &t
+		}
+		records = append(records, record)
+	}
+}
@@ -0,0 +1,104 @@
+package types
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// lowercase form of a 20-byte hex address, mixed case input must carry a
+// valid EIP-55 checksum
+func NormalizeAddress(address string) (string, error) {
+	if len(address) != 42 || !strings.HasPrefix(address, "0x") && !strings.HasPrefix(address, "0X") {
+		return "", fmt.Errorf("invalid address %q, expected 0x and 40 hex digits", address)
+	}
+	digits := address[2:]
+	if _, err := hex.DecodeString(digits); err != nil {
+		return "", fmt.Errorf("invalid address %q, expected 0x and 40 hex digits", address)
+	}
+	lower := "0x" + strings.ToLower(digits)
+	if digits != strings.ToLower(digits) && digits != strings.ToUpper(digits) && ChecksumAddress(lower) != "0x"+digits {
+		return "", fmt.Errorf("invalid address %q, bad EIP-55 checksum", address)
+	}
+	return lower, nil
+}
+
+// the EIP-55 mixed case form of a lowercase address
+func ChecksumAddress(address string) string {
+	digits := strings.ToLower(strings.TrimPrefix(address, "0x"))
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write([]byte(digits))
+	hash := hasher.Sum(nil)
+	checksummed := []byte(digits)
+	for i, c := range checksummed {
+		// uppercase the letters whose hash nibble is 8 or more
+		nibble := hash[i/2] >> 4
+		if i%2 == 1 {
+			nibble = hash[i/2] & 0x0f
+		}
+		if c >= 'a' && c <= 'f' && nibble >= 8 {
+			checksummed[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(checksummed)
+}
+
+// lowercase form of an ENS name like vitalik.eth, the labels between its dots
+// can't be empty or hold spaces or slashes
+func NormalizeEnsName(name string) (string, error) {
+	name = strings.ToLower(name)
+	labels := strings.Split(name, ".")
+	if len(labels) < 2 {
+		return "", fmt.Errorf("invalid ens name %q, expected a name like vitalik.eth", name)
+	}
+	for _, label := range labels {
+		if label == "" || strings.ContainsAny(label, " \t\n/") {
+			return "", fmt.Errorf("invalid ens name %q, expected a name like vitalik.eth", name)
+		}
+	}
+	return name, nil
+}
+
+// whether the text is an ENS name rather than an address, which has no dots
+func IsEnsName(text string) bool {
+	return strings.Contains(text, ".")
+}
+
+// lowercase form of a 32-byte hex transaction or block hash
+func NormalizeHash(hash string) (string, error) {
+	if len(hash) != 66 || !strings.HasPrefix(hash, "0x") && !strings.HasPrefix(hash, "0X") {
+		return "", fmt.Errorf("invalid hash %q, expected 0x and 64 hex digits", hash)
+	}
+	if _, err := hex.DecodeString(hash[2:]); err != nil {
+		return "", fmt.Errorf("invalid hash %q, expected 0x and 64 hex digits", hash)
+	}
+	return "0x" + strings.ToLower(hash[2:]), nil
+}
+
+// the address of the contract the deployer creates with the nonce, the last
+// 20 bytes of the keccak256 of the rlp of [deployer, nonce]
+func CreateAddress(deployer string, nonce uint64) string {
+	sender, _ := hex.DecodeString(strings.TrimPrefix(strings.ToLower(deployer), "0x"))
+	// the rlp of the nonce, the big-endian bytes without leading zeros
+	var encoded []byte
+	switch {
+	case nonce == 0:
+		encoded = []byte{0x80}
+	case nonce < 0x80:
+		encoded = []byte{byte(nonce)}
+	default:
+		var digits []byte
+		for n := nonce; n > 0; n >>= 8 {
+			digits = append([]byte{byte(n)}, digits...)
+		}
+		encoded = append([]byte{0x80 + byte(len(digits))}, digits...)
+	}
+	// a list shorter than 56 bytes of a 20-byte string and the nonce
+	list := append([]byte{0xc0 + byte(1+len(sender)+len(encoded)), 0x80 + byte(len(sender))}, sender...)
+	list = append(list, encoded...)
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write(list)
+	return "0x" + hex.EncodeToString(hasher.Sum(nil)[12:])
+}
@@ -0,0 +1,784 @@
+// Package types holds the chain data shared by the parser, its storage and
+// its apis
+package types
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Block struct {
+	Number        string
+	Hash          string
+	ParentHash    string
+	Timestamp     string
+	BaseFeePerGas string
+	Transactions  []*Transaction
+}
+
+// What is stored of a parsed block, quantities are hex strings
+type BlockMetadata struct {
+	Number     string
+	Hash       string
+	ParentHash string
+	// unix seconds
+	Timestamp        string
+	TransactionCount int
+	// empty before the London fork
+	BaseFeePerGas string `json:",omitempty"`
+}
+
+// the metadata of the block, its internal transactions aren't counted
+func (b *Block) Metadata() *BlockMetadata {
+	count := 0
+	for _, tx := range b.Transactions {
+		if tx.Kind != TransactionKindInternal {
+			count++
+		}
+	}
+	return &BlockMetadata{
+		Number:           b.Number,
+		Hash:             b.Hash,
+		ParentHash:       b.ParentHash,
+		Timestamp:        b.Timestamp,
+		TransactionCount: count,
+		BaseFeePerGas:    b.BaseFeePerGas,
+	}
+}
+
+type Transaction struct {
+	BlockHash   string
+	BlockNumber string
+	// unix seconds and base fee per gas of the block, empty for pending
+	// transactions and those found without their block, e.g. backfilled from
+	// logs, the base fee before London too
+	BlockTimestamp       string `json:",omitempty"`
+	BaseFeePerGas        string `json:",omitempty"`
+	From                 string
+	Gas                  string
+	GasPrice             string
+	MaxFeePerGas         string
+	MaxPriorityFeePerGas string
+	Hash                 string
+	Input                string
+	Nonce                string
+	To                   string
+	TransactionIndex     string
+	Value                string
+	Type                 string
+	AccessList           []interface{}
+	ChainId              string
+	V, R, S              string
+	YParity              string
+	// the blobs of a TransactionTypeBlob transaction, e.g. the batches an L2
+	// posts, and the most it pays per blob gas
+	BlobVersionedHashes []string `json:",omitempty"`
+	MaxFeePerBlobGas    string   `json:",omitempty"`
+	// from the receipt, only set when receipts are fetched
+	Status            string `json:",omitempty"`
+	GasUsed           string `json:",omitempty"`
+	EffectiveGasPrice string `json:",omitempty"`
+	Logs              []*Log `json:",omitempty"`
+	// blob transactions only
+	BlobGasUsed  string `json:",omitempty"`
+	BlobGasPrice string `json:",omitempty"`
+	// TransactionKindInternal for value transfers made by contracts, found
+	// in the traces of the transaction with the Hash, empty otherwise
+	Kind string `json:",omitempty"`
+	// position of the internal call in the call tree of the transaction
+	TraceAddress []int `json:",omitempty"`
+	// the contract deployed by a contract creation, which has no To,
+	// computed from From and Nonce
+	ContractAddress string `json:",omitempty"`
+	// the method and arguments of the Input, set when the ABI of the called
+	// contract is registered
+	Decoded *DecodedCall `json:",omitempty"`
+	// sent by a subscribed deposit address into a hot wallet, moving nearly
+	// its whole balance
+	Sweep bool `json:",omitempty"`
+	// DirectionIn, DirectionOut or DirectionSelf and the other side relative
+	// to the address queried, set on the copies the http api replies with
+	// and never stored
+	Direction    string `json:",omitempty"`
+	Counterparty string `json:",omitempty"`
+	// the fees paid, computed on the copies the http api replies with when
+	// the receipt is known
+	Fees *Fees `json:",omitempty"`
+}
+
+// The fees of a mined transaction in wei, from its receipt and the base fee
+// of its block
+type Fees struct {
+	EffectiveGasPrice string
+	// per gas and in total, what the block producer got on top of the base
+	// fee, empty without the base fee
+	PriorityFeePerGas string `json:",omitempty"`
+	PriorityFee       string `json:",omitempty"`
+	// the base fee times the gas used, empty without the base fee
+	BurnedFee string `json:",omitempty"`
+	// the blob gas price times the blob gas used, burned too, blob
+	// transactions only
+	BlobFee string `json:",omitempty"`
+	// the effective gas price times the gas used, plus the BlobFee
+	TotalFee string
+}
+
+// A contract call decoded with the ABI of the contract
+type DecodedCall struct {
+	Method    string
+	Signature string
+	Args      []*DecodedArg
+}
+
+// A contract event decoded with the ABI of the contract
+type DecodedEvent struct {
+	Name      string
+	Signature string
+	Args      []*DecodedArg
+}
+
+// An argument of a decoded call or event, numbers are decimal strings, bytes and
+// addresses 0x prefixed hex, arrays lists and tuples objects
+type DecodedArg struct {
+	Name  string
+	Type  string
+	Value interface{}
+}
+
+const TransactionKindInternal = "internal"
+
+// the type of the EIP-4844 transactions carrying blobs
+const TransactionTypeBlob = "0x3"
+
+const (
+	DirectionIn  = "in"
+	DirectionOut = "out"
+	// sent by the address to itself
+	DirectionSelf = "self"
+)
+
+// the receipt statuses a TransactionFilter passes
+const (
+	TransactionStatusSuccess = "success"
+	TransactionStatusFailed  = "failed"
+)
+
+// The transaction query filter, zero values match everything
+type TransactionFilter struct {
+	// DirectionIn, DirectionOut or empty for both
+	Direction string
+	// inclusive block range, a zero ToBlock has no upper bound
+	FromBlock int
+	ToBlock   int
+	// page of the matches, a zero Limit returns all of them
+	Offset int
+	Limit  int
+	// TransactionStatusSuccess or TransactionStatusFailed, empty for both
+	Status string
+	// only the sweeps into the hot wallets
+	Sweeps bool
+	// only the transactions carrying blobs
+	Blobs bool
+}
+
+// whether the transaction of the lowercase address passes the filter
+func (f TransactionFilter) Match(address string, tx *Transaction) bool {
+	if f.Status != "" && (f.Status == TransactionStatusFailed) != tx.Failed() || f.Sweeps && !tx.Sweep || f.Blobs && !tx.HasBlobs() {
+		return false
+	}
+	switch f.Direction {
+	case DirectionIn:
+		if strings.ToLower(tx.To) != address {
+			return false
+		}
+	case DirectionOut:
+		if strings.ToLower(tx.From) != address {
+			return false
+		}
+	}
+	block := BlockNumber(tx.BlockNumber)
+	return block >= f.FromBlock && (f.ToBlock == 0 || block <= f.ToBlock)
+}
+
+// The search of the stored transactions of every address, zero values match
+// everything; the storage looks the transactions up by the hash, addresses
+// or block so one of them is needed
+type TransactionSearch struct {
+	// lowercase
+	Hash string
+	From string
+	To   string
+	// at least the value in wei
+	MinValue *big.Int
+	Block    int
+	// the first matches by block, a zero Limit returns all of them
+	Limit int
+}
+
+// whether the transaction passes the search
+func (s *TransactionSearch) Match(tx *Transaction) bool {
+	if s.Hash != "" && tx.Hash != s.Hash ||
+		s.From != "" && strings.ToLower(tx.From) != s.From ||
+		s.To != "" && strings.ToLower(tx.To) != s.To ||
+		s.Block != 0 && BlockNumber(tx.BlockNumber) != s.Block {
+		return false
+	}
+	if s.MinValue != nil {
+		value := ParseQuantity(tx.Value)
+		return value != nil && value.Cmp(s.MinValue) >= 0
+	}
+	return true
+}
+
+// the page of the address transactions matching the filter; a self transfer
+// is stored twice, the outbound copy then the inbound one, and passes a
+// direction once like a row of the postgres storage
+func FilterTransactions(address string, txs []*Transaction, filter TransactionFilter) []*Transaction {
+	address = strings.ToLower(address)
+	matched := []*Transaction{}
+	skipped := 0
+	self := make(map[string]bool)
+	for _, tx := range txs {
+		if filter.Limit > 0 && len(matched) == filter.Limit {
+			break
+		}
+		if filter.Direction != "" && strings.ToLower(tx.From) == address && strings.ToLower(tx.To) == address {
+			key := tx.Key()
+			direction := DirectionOut
+			if self[key] {
+				direction = DirectionIn
+			}
+			self[key] = true
+			if direction != filter.Direction {
+				continue
+			}
+		}
+		if !filter.Match(address, tx) {
+			continue
+		}
+		if skipped < filter.Offset {
+			skipped++
+			continue
+		}
+		matched = append(matched, tx)
+	}
+	return matched
+}
+
+// identifies a stored transaction of an address, internal transactions share
+// the hash of their parent
+func (tx *Transaction) Key() string {
+	return fmt.Sprintf("%s/%s/%v", tx.Hash, tx.Kind, tx.TraceAddress)
+}
+
+// a copy of the transaction with its Direction and Counterparty relative to
+// the lowercase address, left empty when it touches neither side; the
+// counterparty of a contract creation is the created contract
+func (tx *Transaction) RelativeTo(address string) *Transaction {
+	relative := *tx
+	from, to := strings.ToLower(tx.From) == address, strings.ToLower(tx.To) == address
+	switch {
+	case from && to:
+		relative.Direction, relative.Counterparty = DirectionSelf, tx.To
+	case from && tx.IsCreation():
+		relative.Direction, relative.Counterparty = DirectionOut, tx.ContractAddress
+	case from:
+		relative.Direction, relative.Counterparty = DirectionOut, tx.To
+	case to:
+		relative.Direction, relative.Counterparty = DirectionIn, tx.From
+	}
+	return &relative
+}
+
+// whether the transaction deploys a contract, a plain one without a To
+func (tx *Transaction) IsCreation() bool {
+	return tx.To == "" && tx.Kind == ""
+}
+
+// whether the receipt says the transaction reverted, false without one
+func (tx *Transaction) Failed() bool {
+	return tx.Status == ReceiptStatusFailed
+}
+
+// whether the transaction carries blobs, of TransactionTypeBlob
+func (tx *Transaction) HasBlobs() bool {
+	return len(tx.BlobVersionedHashes) > 0
+}
+
+// set the ContractAddress of a contract creation from its sender and nonce,
+// other transactions are left as they are
+func (tx *Transaction) SetContractAddress() {
+	nonce, err := strconv.ParseUint(tx.Nonce, 0, 64)
+	if !tx.IsCreation() || tx.ContractAddress != "" || err != nil {
+		return
+	}
+	tx.ContractAddress = CreateAddress(tx.From, nonce)
+}
+
+// A transaction touching a subscribed address
+type MatchedTransaction struct {
+	Address     string
+	Direction   string
+	Block       int
+	Transaction *Transaction
+	// seen in the mempool and not in a block yet, Block is then 0
+	Pending bool
+}
+
+// The event pushed to webhooks and live streams
+type TransactionEvent struct {
+	Address     string       `json:"address"`
+	Direction   string       `json:"direction"`
+	Block       int          `json:"block"`
+	Transaction *Transaction `json:"transaction"`
+	// not in a block yet, the confirmed transaction follows in another event
+	Pending bool `json:"pending,omitempty"`
+	// TransactionEventSweep for the sweeps of deposit addresses into the hot
+	// wallets, TransactionEventBlob for the transactions carrying blobs, empty
+	// for the other transactions
+	Type string `json:"type,omitempty"`
+}
+
+const (
+	TransactionEventSweep = "sweep"
+	TransactionEventBlob  = "blob"
+)
+
+func NewTransactionEvent(m *MatchedTransaction) *TransactionEvent {
+	event := &TransactionEvent{
+		Address:     ChecksumAddress(m.Address),
+		Direction:   m.Direction,
+		Block:       m.Block,
+		Transaction: m.Transaction,
+		Pending:     m.Pending,
+	}
+	switch {
+	case m.Transaction.Sweep:
+		event.Type = TransactionEventSweep
+	case m.Transaction.HasBlobs():
+		event.Type = TransactionEventBlob
+	}
+	return event
+}
+
+// A parsed transaction of any address, watched or not, moving at least the
+// whale threshold
+type WhaleEvent struct {
+	Block int `json:"block"`
+	// the threshold it reached in decimal wei
+	Threshold   string       `json:"threshold"`
+	Transaction *Transaction `json:"transaction"`
+}
+
+// The summary posted to the digest webhooks at the end of every period, of
+// the mined transactions the webhooks were or would have been posted
+type DigestEvent struct {
+	Tenant string `json:"tenant,omitempty"`
+	// daily or weekly, from..to is the period
+	Period string    `json:"period"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	// one line, e.g. for the subject of an email
+	Summary      string           `json:"summary"`
+	Transactions int              `json:"transactions"`
+	Addresses    []*AddressDigest `json:"addresses"`
+}
+
+// The transactions of an address in a digest
+type AddressDigest struct {
+	Address string `json:"address"`
+	Label   string `json:"label,omitempty"`
+	// sent to itself counts as both
+	Incoming int `json:"incoming"`
+	Outgoing int `json:"outgoing"`
+	Failed   int `json:"failed,omitempty"`
+	// the ether it received and sent, in wei, reverted transactions left out
+	ValueIn    string `json:"valueIn"`
+	ValueOut   string `json:"valueOut"`
+	FirstBlock int    `json:"firstBlock"`
+	LastBlock  int    `json:"lastBlock"`
+	// the first ones of the period, Truncated when there were more
+	Transactions []*DigestTransaction `json:"transactions"`
+	Truncated    bool                 `json:"truncated,omitempty"`
+}
+
+// A transaction listed in a digest, the value in wei
+type DigestTransaction struct {
+	Hash         string `json:"hash"`
+	Block        int    `json:"block"`
+	Direction    string `json:"direction"`
+	Counterparty string `json:"counterparty,omitempty"`
+	Value        string `json:"value"`
+	Failed       bool   `json:"failed,omitempty"`
+}
+
+// parse a hex block number, 0 when malformed
+func BlockNumber(hex string) int {
+	block, _ := strconv.ParseInt(hex, 0, 0)
+	return int(block)
+}
+
+type Log struct {
+	Address          string
+	Topics           []string
+	Data             string
+	BlockNumber      string
+	BlockHash        string
+	TransactionHash  string
+	TransactionIndex string
+	LogIndex         string
+	Removed          bool
+}
+
+// A contract event watched by the parser, Topic is its topic0, the keccak256
+// of the event signature, both lowercase
+type EventSubscription struct {
+	Contract string
+	Topic    string
+}
+
+// A log of a watched contract event, with its arguments when the ABI of the
+// contract is registered
+type Event struct {
+	Log
+	Decoded *DecodedEvent `json:",omitempty"`
+}
+
+// An ERC-20 transfer decoded from a Transfer log
+type TokenTransfer struct {
+	BlockHash       string
+	BlockNumber     string
+	TransactionHash string
+	LogIndex        string
+	Token           string
+	Symbol          string
+	From            string
+	To              string
+	// decimal, in the smallest unit of the token
+	Amount string
+	// of the token, set when read back rather than stored
+	Name     string `json:",omitempty"`
+	Decimals *int   `json:",omitempty"`
+	// Amount in whole tokens, set when the decimals are known
+	FormattedAmount string `json:",omitempty"`
+}
+
+// The ERC-20 metadata of a token contract, empty when the contract doesn't
+// expose it
+type TokenMetadata struct {
+	Token  string
+	Symbol string
+	Name   string
+	// nil when the contract has no decimals()
+	Decimals *int
+}
+
+const (
+	NftStandardErc721  = "ERC-721"
+	NftStandardErc1155 = "ERC-1155"
+)
+
+// An NFT transfer decoded from an ERC-721 Transfer log or an ERC-1155
+// TransferSingle or TransferBatch log, a batch yields one transfer per token
+type NftTransfer struct {
+	BlockHash       string
+	BlockNumber     string
+	TransactionHash string
+	LogIndex        string
+	Contract        string
+	// NftStandardErc721 or NftStandardErc1155
+	Standard string
+	// the account moving the tokens of From, only set for ERC-1155
+	Operator string `json:",omitempty"`
+	From     string
+	To       string
+	// decimal, 1 for ERC-721
+	TokenId string
+	Amount  string
+}
+
+const (
+	ReceiptStatusSuccess = "0x1"
+	ReceiptStatusFailed  = "0x0"
+)
+
+// The transaction receipt, the fields copied onto matched transactions
+type Receipt struct {
+	TransactionHash   string
+	Status            string
+	GasUsed           string
+	EffectiveGasPrice string
+	Logs              []*Log
+	// blob transactions only
+	BlobGasUsed  string
+	BlobGasPrice string
+}
+
+// The live state of an address at the chain head, quantities are hex strings
+type Balance struct {
+	// in wei
+	Balance string
+	// transactions sent by the address, its nonce
+	TransactionCount string
+}
+
+// A subscribed address and the activity stored for it
+type Subscription struct {
+	Address          string
+	TransactionCount int
+	// blocks of the first and the last stored transaction, 0 without any
+	FirstBlock int
+	LastBlock  int
+	// from the AddressLabel, empty without one
+	Label    string
+	Metadata map[string]interface{}
+	Rule     *NotificationRule
+	// nil when it never expires
+	Expires *time.Time
+}
+
+// A subscription of any tenant as it is exported and imported, to move the
+// watched addresses between environments or storage backends
+type SubscriptionRecord struct {
+	// empty for the default tenant
+	Tenant   string                 `json:"tenant,omitempty"`
+	Address  string                 `json:"address"`
+	Label    string                 `json:"label,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	Rule     *NotificationRule      `json:"rule,omitempty"`
+	Expires  *time.Time             `json:"expires,omitempty"`
+}
+
+// A named set of addresses of a tenant queried together, e.g. the deposit
+// addresses of a customer
+type Group struct {
+	Name string `json:"name"`
+	// lowercase, sorted
+	Addresses []string `json:"addresses"`
+}
+
+var groupNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// whether the name can name a group, up to 64 letters, digits, '_', '.' and '-'
+func ValidateGroupName(name string) error {
+	if !groupNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid group %q, expected up to 64 letters, digits, '_', '.' or '-'", name)
+	}
+	return nil
+}
+
+// Aggregates of the stored transactions of an address, quantities are hex
+// strings; a self transfer counts both ways
+type AddressStats struct {
+	// wei of the inbound and outbound transactions, failed ones left out
+	TotalReceived string
+	TotalSent     string
+	IncomingCount int
+	OutgoingCount int
+	// blocks of the first and the last stored transaction, 0 without any
+	FirstBlock int
+	LastBlock  int
+	// wei per gas of the outbound transactions averaged, the effective price
+	// when the receipts were fetched, empty without any
+	AverageGasPrice string
+}
+
+// How the stored transactions of an address in a block moved its balance,
+// quantities are hex strings
+type BalanceChange struct {
+	Block int `json:"block"`
+	// unix seconds, empty when the transactions were stored without it
+	Timestamp string `json:"timestamp,omitempty"`
+	// wei moved in and out, failed transactions left out, and the fees paid
+	// by the outbound ones whose receipt was fetched
+	Received string `json:"received"`
+	Sent     string `json:"sent"`
+	Fees     string `json:"fees"`
+	// received minus sent and fees, "-0x.." when negative
+	Change string `json:"change"`
+	// the balance after the block summing the changes of every stored
+	// transaction since the first one, a negative one is signed like Change
+	Balance      string `json:"balance"`
+	Transactions int    `json:"transactions"`
+}
+
+// The gas prices paid in the last parsed blocks, in wei per gas as hex
+// quantities
+type GasPrice struct {
+	FromBlock    int `json:"fromBlock"`
+	ToBlock      int `json:"toBlock"`
+	Transactions int `json:"transactions"`
+	// nil without transactions
+	EffectiveGasPrice *GasPercentiles `json:"effectiveGasPrice,omitempty"`
+	// of what was paid on top of the base fee, nil before London
+	PriorityFee *GasPercentiles `json:"priorityFee,omitempty"`
+	// of every block, oldest first, and of the last one; empty before London
+	BaseFees []string `json:"baseFees,omitempty"`
+	BaseFee  string   `json:"baseFee,omitempty"`
+	// GasTrendRising or GasTrendFalling when the last base fee is 10% off
+	// the average of the blocks, else GasTrendSteady
+	BaseFeeTrend string `json:"baseFeeTrend,omitempty"`
+}
+
+const (
+	GasTrendRising  = "rising"
+	GasTrendFalling = "falling"
+	GasTrendSteady  = "steady"
+)
+
+type GasPercentiles struct {
+	P10 string `json:"p10"`
+	P25 string `json:"p25"`
+	P50 string `json:"p50"`
+	P75 string `json:"p75"`
+	P90 string `json:"p90"`
+}
+
+// What an operator attached to a subscribed address, e.g. the customer or
+// purpose it is watched for, with any json metadata and the rule its webhook
+// notifications pass
+type AddressLabel struct {
+	Label    string                 `json:"label"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	Rule     *NotificationRule      `json:"rule,omitempty"`
+	// when the address is unwatched and its data dropped, nil keeps it
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Which matched transactions of an address are posted to the webhooks, zero
+// values match everything
+type NotificationRule struct {
+	// decimal wei the value must reach
+	MinValue string `json:"minValue,omitempty"`
+	// DirectionIn, DirectionOut or empty for both
+	Direction string `json:"direction,omitempty"`
+	// token contracts the transaction must call or have a log of, plain
+	// ether transfers never match once set
+	Tokens []string `json:"tokens,omitempty"`
+	// only the contract creations the address deploys
+	Creations bool `json:"creations,omitempty"`
+}
+
+// check the rule, lowercasing its tokens
+func (r *NotificationRule) Normalize() error {
+	if r.MinValue != "" {
+		if v, ok := new(big.Int).SetString(r.MinValue, 10); !ok || v.Sign() < 0 {
+			return fmt.Errorf("invalid min value %q, expected decimal wei", r.MinValue)
+		}
+	}
+	if r.Direction != "" && r.Direction != DirectionIn && r.Direction != DirectionOut {
+		return fmt.Errorf("invalid direction %q, expected %s or %s", r.Direction, DirectionIn, DirectionOut)
+	}
+	for i, token := range r.Tokens {
+		address, err := NormalizeAddress(token)
+		if err != nil {
+			return fmt.Errorf("invalid token %q, err %v", token, err)
+		}
+		r.Tokens[i] = address
+	}
+	return nil
+}
+
+// whether the transaction matched in the direction passes the rule, a nil
+// rule passes everything
+func (r *NotificationRule) Match(direction string, tx *Transaction) bool {
+	if r == nil {
+		return true
+	}
+	if r.Direction != "" && r.Direction != direction {
+		return false
+	}
+	if r.Creations && (direction != DirectionOut || !tx.IsCreation()) {
+		return false
+	}
+	if r.MinValue != "" {
+		min, _ := new(big.Int).SetString(r.MinValue, 10)
+		value := tx.ValueWei()
+		if min == nil || value == nil || value.Cmp(min) < 0 {
+			return false
+		}
+	}
+	if len(r.Tokens) == 0 {
+		return true
+	}
+	if slices.Contains(r.Tokens, strings.ToLower(tx.To)) {
+		return true
+	}
+	for _, log := range tx.Logs {
+		if slices.Contains(r.Tokens, strings.ToLower(log.Address)) {
+			return true
+		}
+	}
+	return false
+}
+
+// The progress of the parser, zero times until the event first happened
+type Status struct {
+	CurrentBlock int `json:"currentBlock"`
+	LatestBlock  int `json:"latestBlock"`
+	// blocks behind the chain head
+	Lag int `json:"lag"`
+	// last time the parser loop went round
+	Heartbeat     time.Time `json:"heartbeat"`
+	LastBlockTime time.Time `json:"lastBlockTime"`
+	LastHeadTime  time.Time `json:"lastHeadTime"`
+	// error of the last rpc call, empty when it succeeded
+	LastError string `json:"lastError,omitempty"`
+	// rpc endpoints not failing their calls, out of all of them
+	EndpointsUp int `json:"endpointsUp"`
+	Endpoints   int `json:"endpoints"`
+	// when an operator paused the parsing, nil while it runs
+	Paused *time.Time `json:"paused,omitempty"`
+	// whether the parser writes to the storage or follows another one, empty
+	// when it is the only one
+	Role string `json:"role,omitempty"`
+}
+
+// The roles of the parsers sharing a storage
+const (
+	// parses, holding the lease
+	RoleLeader = "leader"
+	// waits for the lease, serving what the leader parses
+	RoleFollower = "follower"
+	// never parses
+	RoleReplica = "replica"
+)
+
+// A block the parser gave up on after it kept failing to fetch or save it
+type FailedBlock struct {
+	Block int `json:"block"`
+	// empty when the block failed to fetch
+	Hash     string    `json:"hash,omitempty"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	Time     time.Time `json:"time"`
+}
+
+// The states of a reprocess job
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+)
+
+// A parsed block range fetched and saved again in the background, e.g. once a
+// provider served bad data; what was stored already is kept
+type ReprocessJob struct {
+	Id     int    `json:"id"`
+	From   int    `json:"from"`
+	To     int    `json:"to"`
+	Status string `json:"status"`
+	// the last block saved again, from-1 before the first one
+	Block int `json:"block"`
+	// transactions of subscribed addresses found missing and added
+	Transactions int `json:"transactions"`
+	// the last failure, retried until the job is done
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
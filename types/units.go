@@ -0,0 +1,111 @@
+package types
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// decimals of the eth denominations, in wei
+const (
+	GweiDecimals  = 9
+	EtherDecimals = 18
+)
+
+// parse a hex quantity, nil when malformed
+func ParseQuantity(hex string) *big.Int {
+	v, ok := new(big.Int).SetString(strings.TrimPrefix(hex, "0x"), 16)
+	if !ok {
+		return nil
+	}
+	return v
+}
+
+// the integer as a decimal number of the unit with the given decimals,
+// e.g. 1500000000000000000 wei is "1.5" ether, trailing zeros dropped
+func FormatUnits(v *big.Int, decimals int) string {
+	if v == nil {
+		return ""
+	}
+	digits := new(big.Int).Abs(v).String()
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+	whole, fraction := digits[:len(digits)-decimals], strings.TrimRight(digits[len(digits)-decimals:], "0")
+	sign := ""
+	if v.Sign() < 0 {
+		sign = "-"
+	}
+	if fraction == "" {
+		return sign + whole
+	}
+	return sign + whole + "." + fraction
+}
+
+// the decimal number of the unit with the given decimals as an integer, e.g.
+// "1.5" ether is 1500000000000000000 wei; fails on more fraction digits than
+// decimals
+func ParseUnits(s string, decimals int) (*big.Int, error) {
+	whole, fraction, _ := strings.Cut(s, ".")
+	if len(fraction) > decimals || strings.ContainsAny(fraction, "+-") {
+		return nil, fmt.Errorf("invalid amount %q, expected a number with up to %d decimals", s, decimals)
+	}
+	v, ok := new(big.Int).SetString(whole+fraction+strings.Repeat("0", decimals-len(fraction)), 10)
+	if !ok || whole == "" && fraction == "" {
+		return nil, fmt.Errorf("invalid amount %q, expected a number with up to %d decimals", s, decimals)
+	}
+	return v, nil
+}
+
+// the hex quantity in decimal units, "" when malformed
+func FormatQuantity(hex string, decimals int) string {
+	return FormatUnits(ParseQuantity(hex), decimals)
+}
+
+func (tx *Transaction) ValueWei() *big.Int {
+	return ParseQuantity(tx.Value)
+}
+
+// the gas price paid, nodes report the effective one for mined dynamic fee
+// transactions
+func (tx *Transaction) GasPriceWei() *big.Int {
+	return ParseQuantity(tx.GasPrice)
+}
+
+// the fees paid by the transaction, nil without its receipt, e.g. pending,
+// internal or fetched without receipts
+func (tx *Transaction) ComputeFees() *Fees {
+	gasUsed, price := ParseQuantity(tx.GasUsed), ParseQuantity(tx.EffectiveGasPrice)
+	if price == nil {
+		// legacy transactions pay their gas price
+		price = tx.GasPriceWei()
+	}
+	if gasUsed == nil || price == nil || tx.Kind == TransactionKindInternal {
+		return nil
+	}
+	fees := &Fees{
+		EffectiveGasPrice: hexQuantity(price),
+		TotalFee:          hexQuantity(new(big.Int).Mul(gasUsed, price)),
+	}
+	if baseFee := ParseQuantity(tx.BaseFeePerGas); baseFee != nil {
+		priority := new(big.Int).Sub(price, baseFee)
+		fees.PriorityFeePerGas = hexQuantity(priority)
+		fees.PriorityFee = hexQuantity(priority.Mul(priority, gasUsed))
+		fees.BurnedFee = hexQuantity(new(big.Int).Mul(gasUsed, baseFee))
+	}
+	blobGasUsed, blobGasPrice := ParseQuantity(tx.BlobGasUsed), ParseQuantity(tx.BlobGasPrice)
+	if blobGasUsed != nil && blobGasPrice != nil {
+		blobFee := new(big.Int).Mul(blobGasUsed, blobGasPrice)
+		fees.BlobFee = hexQuantity(blobFee)
+		fees.TotalFee = hexQuantity(blobFee.Add(blobFee, new(big.Int).Mul(gasUsed, price)))
+	}
+	return fees
+}
+
+func hexQuantity(v *big.Int) string {
+	return "0x" + v.Text(16)
+}
+
+func (tx *Transaction) BlockNumberInt() int {
+	return BlockNumber(tx.BlockNumber)
+}